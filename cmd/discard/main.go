@@ -43,6 +43,11 @@ func main() {
 
 	srv := server.NewServer(db, hub)
 	srv.SetupRoutes()
+	go srv.RunWebhookWorker()
+	go srv.RunTrashWorker()
+	go srv.RunScheduler()
+	go srv.RunCrosspostWorker()
+	go srv.RunTemporaryMemberWorker()
 
 	// Serve embedded frontend with SPA fallback
 	frontendFS, err := frontend.FS()
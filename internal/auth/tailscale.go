@@ -19,6 +19,7 @@ import (
 type UserRepo interface {
 	GetByTailscaleID(ctx context.Context, tailscaleID string) (*models.User, error)
 	Create(ctx context.Context, user *models.User) error
+	GetByBotTokenHash(ctx context.Context, tokenHash string) (*models.User, error)
 }
 
 // tailscaleWhoisResponse is the subset of the Tailscale localapi whois response we care about.
@@ -36,6 +37,8 @@ var devUserID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
 
 // Middleware returns an http.Handler that authenticates every request via
 // the Tailscale local API (or a hardcoded dev user when DISCARD_DEV=true).
+// Requests carrying an "Authorization: Bot <token>" header authenticate as
+// that bot instead.
 func Middleware(repo UserRepo) func(http.Handler) http.Handler {
 	devMode := strings.EqualFold(os.Getenv("DISCARD_DEV"), "true")
 	if devMode {
@@ -60,7 +63,9 @@ func Middleware(repo UserRepo) func(http.Handler) http.Handler {
 			var user *models.User
 			var err error
 
-			if devMode {
+			if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bot "); ok {
+				user, err = repo.GetByBotTokenHash(ctx, HashToken(token))
+			} else if devMode {
 				user, err = devUser(ctx, repo)
 			} else {
 				user, err = tailscaleAuth(ctx, repo, client, r.RemoteAddr, tsAPIURL, tsAPIToken)
@@ -46,3 +46,41 @@ func (r *AttachmentRepo) ListByMessage(ctx context.Context, messageID uuid.UUID)
 	}
 	return attachments, rows.Err()
 }
+
+// Copy records the attachments of one message against another. The copies
+// point at the same upload files, so nothing is uploaded again.
+func (r *AttachmentRepo) Copy(ctx context.Context, fromMessageID, toMessageID uuid.UUID) ([]models.Attachment, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`INSERT INTO attachments (id, message_id, file_path, original_name, mime_type, file_size, width, height, created_at)
+		 SELECT gen_random_uuid(), $2, file_path, original_name, mime_type, file_size, width, height, created_at
+		 FROM attachments WHERE message_id = $1
+		 RETURNING id, message_id, file_path, original_name, mime_type, file_size, width, height, created_at`,
+		fromMessageID, toMessageID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []models.Attachment
+	for rows.Next() {
+		var a models.Attachment
+		if err := rows.Scan(&a.ID, &a.MessageID, &a.FilePath, &a.OriginalName, &a.MimeType, &a.FileSize, &a.Width, &a.Height, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+func (r *AttachmentRepo) GetByID(ctx context.Context, id uuid.UUID) (*models.Attachment, error) {
+	a := &models.Attachment{}
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT id, message_id, file_path, original_name, mime_type, file_size, width, height, created_at
+		 FROM attachments WHERE id = $1`, id,
+	).Scan(&a.ID, &a.MessageID, &a.FilePath, &a.OriginalName, &a.MimeType, &a.FileSize, &a.Width, &a.Height, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
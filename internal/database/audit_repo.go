@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// AuditLogRepo handles the per-server audit log.
+type AuditLogRepo struct {
+	DB *sql.DB
+}
+
+// AuditLogFilter narrows an audit log listing. Zero values are ignored.
+type AuditLogFilter struct {
+	ActorID  *uuid.UUID
+	TargetID *uuid.UUID
+	Action   string
+	Before   *uuid.UUID
+	Limit    int
+}
+
+func (r *AuditLogRepo) Create(ctx context.Context, e *models.AuditLogEntry) error {
+	e.ID = uuid.New()
+	e.CreatedAt = time.Now()
+	var changes any
+	if len(e.Changes) > 0 {
+		changes = []byte(e.Changes)
+	}
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO audit_log (id, server_id, actor_id, action, target_type, target_id, changes, reason, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		e.ID, e.ServerID, e.ActorID, e.Action, e.TargetType, e.TargetID, changes, e.Reason, e.CreatedAt,
+	)
+	return err
+}
+
+// List returns audit log entries for a server, newest first.
+func (r *AuditLogRepo) List(ctx context.Context, serverID uuid.UUID, f AuditLogFilter) ([]models.AuditLogEntry, error) {
+	where := []string{"a.server_id = $1"}
+	args := []any{serverID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.ActorID != nil {
+		where = append(where, "a.actor_id = "+arg(*f.ActorID))
+	}
+	if f.TargetID != nil {
+		where = append(where, "a.target_id = "+arg(*f.TargetID))
+	}
+	if f.Action != "" {
+		where = append(where, "a.action = "+arg(f.Action))
+	}
+	if f.Before != nil {
+		where = append(where, "a.created_at < (SELECT created_at FROM audit_log WHERE id = "+arg(*f.Before)+")")
+	}
+	limit := arg(f.Limit)
+
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT a.id, a.server_id, a.actor_id, a.action, a.target_type, a.target_id, a.changes, a.reason, a.created_at, u.username
+		 FROM audit_log a
+		 JOIN users u ON u.id = a.actor_id
+		 WHERE `+strings.Join(where, " AND ")+`
+		 ORDER BY a.created_at DESC
+		 LIMIT `+limit, args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditLogEntry
+	for rows.Next() {
+		var e models.AuditLogEntry
+		var changes []byte
+		if err := rows.Scan(&e.ID, &e.ServerID, &e.ActorID, &e.Action, &e.TargetType, &e.TargetID, &changes, &e.Reason, &e.CreatedAt, &e.ActorUsername); err != nil {
+			return nil, err
+		}
+		e.Changes = changes
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// BanRepo handles the per-server ban list.
+type BanRepo struct {
+	DB *sql.DB
+}
+
+// Create bans a user from a server and removes their membership in one transaction.
+func (r *BanRepo) Create(ctx context.Context, b *models.ServerBan) error {
+	b.CreatedAt = time.Now()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO server_bans (server_id, user_id, tailscale_id, banned_by, reason, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (server_id, user_id) DO UPDATE
+		 SET tailscale_id = EXCLUDED.tailscale_id, banned_by = EXCLUDED.banned_by, reason = EXCLUDED.reason, created_at = EXCLUDED.created_at`,
+		b.ServerID, b.UserID, b.TailscaleID, b.BannedBy, b.Reason, b.CreatedAt,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM server_members WHERE user_id = $1 AND server_id = $2`,
+		b.UserID, b.ServerID,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *BanRepo) Delete(ctx context.Context, serverID, userID uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx,
+		`DELETE FROM server_bans WHERE server_id = $1 AND user_id = $2`,
+		serverID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *BanRepo) ListByServer(ctx context.Context, serverID uuid.UUID) ([]models.ServerBan, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT b.server_id, b.user_id, b.tailscale_id, b.banned_by, b.reason, b.created_at, u.username
+		 FROM server_bans b
+		 JOIN users u ON u.id = b.user_id
+		 WHERE b.server_id = $1
+		 ORDER BY b.created_at DESC`, serverID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []models.ServerBan
+	for rows.Next() {
+		var b models.ServerBan
+		if err := rows.Scan(&b.ServerID, &b.UserID, &b.TailscaleID, &b.BannedBy, &b.Reason, &b.CreatedAt, &b.Username); err != nil {
+			return nil, err
+		}
+		bans = append(bans, b)
+	}
+	return bans, rows.Err()
+}
+
+// IsBanned reports whether the user is banned from the server, either directly
+// or through a ban recorded against the same Tailscale identity.
+func (r *BanRepo) IsBanned(ctx context.Context, serverID, userID uuid.UUID, tailscaleID *string) (bool, error) {
+	var banned bool
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT EXISTS(
+			SELECT 1 FROM server_bans
+			WHERE server_id = $1 AND (user_id = $2 OR ($3::text IS NOT NULL AND tailscale_id = $3))
+		)`,
+		serverID, userID, tailscaleID,
+	).Scan(&banned)
+	return banned, err
+}
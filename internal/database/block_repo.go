@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// BlockRepo handles user-to-user blocks.
+type BlockRepo struct {
+	DB *sql.DB
+}
+
+// Block records that blocker has blocked blocked and removes any friendship
+// or pending request between them, in one transaction.
+func (r *BlockRepo) Block(ctx context.Context, blockerID, blockedID uuid.UUID) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO user_blocks (blocker_id, blocked_id, created_at) VALUES ($1, $2, $3)
+		 ON CONFLICT DO NOTHING`,
+		blockerID, blockedID, time.Now(),
+	); err != nil {
+		return err
+	}
+
+	userA, userB := orderedPair(blockerID, blockedID)
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM friendships WHERE user_a = $1 AND user_b = $2`,
+		userA, userB,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *BlockRepo) Unblock(ctx context.Context, blockerID, blockedID uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx,
+		`DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2`,
+		blockerID, blockedID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListBlocked returns the users blockerID has blocked, most recent first.
+func (r *BlockRepo) ListBlocked(ctx context.Context, blockerID uuid.UUID) ([]models.Relationship, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT b.created_at, u.id, u.username, u.display_name, u.avatar_path
+		 FROM user_blocks b
+		 JOIN users u ON u.id = b.blocked_id
+		 WHERE b.blocker_id = $1
+		 ORDER BY b.created_at DESC`, blockerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rels []models.Relationship
+	for rows.Next() {
+		rel := models.Relationship{Type: "blocked"}
+		if err := rows.Scan(&rel.Since, &rel.User.ID, &rel.User.Username, &rel.User.DisplayName, &rel.User.AvatarURL); err != nil {
+			return nil, err
+		}
+		rels = append(rels, rel)
+	}
+	return rels, rows.Err()
+}
+
+// HasBlocked reports whether blockerID has blocked blockedID.
+func (r *BlockRepo) HasBlocked(ctx context.Context, blockerID, blockedID uuid.UUID) (bool, error) {
+	var exists bool
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2)`,
+		blockerID, blockedID,
+	).Scan(&exists)
+	return exists, err
+}
+
+// IsBlockedEitherWay reports whether either user has blocked the other.
+func (r *BlockRepo) IsBlockedEitherWay(ctx context.Context, a, b uuid.UUID) (bool, error) {
+	var exists bool
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT EXISTS(
+			SELECT 1 FROM user_blocks
+			WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+		)`,
+		a, b,
+	).Scan(&exists)
+	return exists, err
+}
+
+// IsBlockedInDM reports whether userID and the other participant of a 1:1 DM
+// channel have blocked each other in either direction.
+func (r *BlockRepo) IsBlockedInDM(ctx context.Context, channelID, userID uuid.UUID) (bool, error) {
+	var exists bool
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT EXISTS(
+			SELECT 1 FROM dm_members o
+			JOIN user_blocks b
+			  ON (b.blocker_id = o.user_id AND b.blocked_id = $2)
+			  OR (b.blocker_id = $2 AND b.blocked_id = o.user_id)
+			WHERE o.channel_id = $1 AND o.user_id <> $2
+		)`,
+		channelID, userID,
+	).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,311 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// SystemUserID is the bot user that posts built-in command responses. It is
+// created by migration 014.
+var SystemUserID = uuid.MustParse("00000000-0000-0000-0000-00000000d15c")
+
+// GetByBotTokenHash looks up the bot user a token belongs to.
+// Implements auth.UserRepo.
+func (r *UserRepo) GetByBotTokenHash(ctx context.Context, tokenHash string) (*models.User, error) {
+	u := &models.User{}
+	err := scanUser(r.DB.QueryRowContext(ctx,
+		`SELECT `+userColumns+`
+		 FROM bots b JOIN users u ON u.id = b.user_id
+		 WHERE b.token_hash = $1`, tokenHash,
+	), u)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// BotRepo handles bot accounts.
+type BotRepo struct {
+	DB *sql.DB
+}
+
+// Create inserts the bot's user and its bot row together. The caller fills
+// in b.User.Username and b.User.DisplayName.
+func (r *BotRepo) Create(ctx context.Context, b *models.Bot, tokenHash string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	b.User.ID = uuid.New()
+	b.User.Bot = true
+	b.User.Status = "offline"
+	b.User.CreatedAt = now
+	b.User.UpdatedAt = now
+	b.CreatedAt = now
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO users (id, username, display_name, status, bot, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, TRUE, $5, $6)`,
+		b.User.ID, b.User.Username, b.User.DisplayName, b.User.Status, now, now,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO bots (user_id, owner_id, token_hash, interactions_url, interactions_secret, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		b.User.ID, b.OwnerID, tokenHash, b.InteractionsURL, b.InteractionsSecret, now,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const botColumns = `u.id, u.username, u.display_name, u.avatar_path, u.status, u.created_at, u.updated_at, u.bot,
+	b.owner_id, b.interactions_url, b.interactions_secret, b.created_at`
+
+func scanBot(row interface{ Scan(...any) error }, b *models.Bot) error {
+	return row.Scan(&b.User.ID, &b.User.Username, &b.User.DisplayName, &b.User.AvatarPath, &b.User.Status, &b.User.CreatedAt, &b.User.UpdatedAt, &b.User.Bot,
+		&b.OwnerID, &b.InteractionsURL, &b.InteractionsSecret, &b.CreatedAt)
+}
+
+// Get returns a bot including its interactions secret.
+func (r *BotRepo) Get(ctx context.Context, userID uuid.UUID) (*models.Bot, error) {
+	b := &models.Bot{}
+	err := scanBot(r.DB.QueryRowContext(ctx,
+		`SELECT `+botColumns+` FROM bots b JOIN users u ON u.id = b.user_id WHERE b.user_id = $1`, userID,
+	), b)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (r *BotRepo) ListByOwner(ctx context.Context, ownerID uuid.UUID) ([]models.Bot, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT `+botColumns+` FROM bots b JOIN users u ON u.id = b.user_id
+		 WHERE b.owner_id = $1 ORDER BY b.created_at`, ownerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bots []models.Bot
+	for rows.Next() {
+		var b models.Bot
+		if err := scanBot(rows, &b); err != nil {
+			return nil, err
+		}
+		bots = append(bots, b)
+	}
+	return bots, rows.Err()
+}
+
+// Update saves the bot's interactions URL and secret, and its token hash
+// when tokenHash is not empty.
+func (r *BotRepo) Update(ctx context.Context, b *models.Bot, tokenHash string) error {
+	_, err := r.DB.ExecContext(ctx,
+		`UPDATE bots SET interactions_url = $2, interactions_secret = $3, token_hash = COALESCE(NULLIF($4, ''), token_hash)
+		 WHERE user_id = $1`,
+		b.User.ID, b.InteractionsURL, b.InteractionsSecret, tokenHash,
+	)
+	return err
+}
+
+// Delete revokes a bot: its token stops working and it leaves every server,
+// taking its commands with it. The user row stays so its messages keep an
+// author.
+func (r *BotRepo) Delete(ctx context.Context, userID uuid.UUID) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM bots WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	for _, q := range []string{
+		`DELETE FROM slash_commands WHERE bot_id = $1`,
+		`DELETE FROM server_members WHERE user_id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, q, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CommandRepo handles slash commands registered by bots.
+type CommandRepo struct {
+	DB *sql.DB
+}
+
+const commandColumns = `id, server_id, bot_id, name, description, options, created_at`
+
+func scanCommand(row interface{ Scan(...any) error }, c *models.SlashCommand) error {
+	var options []byte
+	if err := row.Scan(&c.ID, &c.ServerID, &c.BotID, &c.Name, &c.Description, &options, &c.CreatedAt); err != nil {
+		return err
+	}
+	return json.Unmarshal(options, &c.Options)
+}
+
+func (r *CommandRepo) ListByServer(ctx context.Context, serverID uuid.UUID) ([]models.SlashCommand, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT `+commandColumns+` FROM slash_commands WHERE server_id = $1 ORDER BY name`, serverID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cmds []models.SlashCommand
+	for rows.Next() {
+		var c models.SlashCommand
+		if err := scanCommand(rows, &c); err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, c)
+	}
+	return cmds, rows.Err()
+}
+
+func (r *CommandRepo) GetByName(ctx context.Context, serverID uuid.UUID, name string) (*models.SlashCommand, error) {
+	c := &models.SlashCommand{}
+	err := scanCommand(r.DB.QueryRowContext(ctx,
+		`SELECT `+commandColumns+` FROM slash_commands WHERE server_id = $1 AND name = $2`, serverID, name,
+	), c)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ErrCommandTaken is returned by Replace when another bot already has a
+// command of the same name in the server.
+var ErrCommandTaken = errors.New("command name taken")
+
+// Replace overwrites the bot's commands in a server with cmds, keeping the
+// IDs of commands whose names are unchanged.
+func (r *CommandRepo) Replace(ctx context.Context, serverID, botID uuid.UUID, cmds []models.SlashCommand) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	names := make([]string, len(cmds))
+	for i, c := range cmds {
+		names[i] = c.Name
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM slash_commands WHERE server_id = $1 AND bot_id = $2 AND NOT (name = ANY($3))`,
+		serverID, botID, names,
+	); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range cmds {
+		c := &cmds[i]
+		options, err := json.Marshal(c.Options)
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRowContext(ctx,
+			`INSERT INTO slash_commands (id, server_id, bot_id, name, description, options, created_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7)
+			 ON CONFLICT (server_id, name) DO UPDATE
+			 SET description = EXCLUDED.description, options = EXCLUDED.options
+			 WHERE slash_commands.bot_id = EXCLUDED.bot_id
+			 RETURNING id, created_at`,
+			uuid.New(), serverID, botID, c.Name, c.Description, options, now,
+		).Scan(&c.ID, &c.CreatedAt)
+		if err == sql.ErrNoRows {
+			return ErrCommandTaken
+		}
+		if err != nil {
+			return err
+		}
+		c.ServerID = &serverID
+		c.BotID = botID
+	}
+	return tx.Commit()
+}
+
+// InteractionRepo handles slash command invocations of bots.
+type InteractionRepo struct {
+	DB *sql.DB
+}
+
+// Create stores an interaction, dropping expired ones as it goes.
+func (r *InteractionRepo) Create(ctx context.Context, in *models.Interaction) error {
+	if _, err := r.DB.ExecContext(ctx, `DELETE FROM interactions WHERE expires_at < NOW()`); err != nil {
+		return err
+	}
+	options, err := json.Marshal(in.Options)
+	if err != nil {
+		return err
+	}
+	in.CreatedAt = time.Now()
+	_, err = r.DB.ExecContext(ctx,
+		`INSERT INTO interactions (id, token_hash, bot_id, command_id, command_name, server_id, channel_id, user_id, options, status, created_at, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		in.ID, in.TokenHash, in.BotID, in.CommandID, in.CommandName, in.ServerID, in.ChannelID, in.UserID, options, in.Status, in.CreatedAt, in.ExpiresAt,
+	)
+	return err
+}
+
+// Get returns an interaction that has not yet expired.
+func (r *InteractionRepo) Get(ctx context.Context, id uuid.UUID) (*models.Interaction, error) {
+	in := &models.Interaction{}
+	var options []byte
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT id, token_hash, bot_id, command_id, command_name, server_id, channel_id, user_id, options, status, created_at, expires_at
+		 FROM interactions WHERE id = $1 AND expires_at > NOW()`, id,
+	).Scan(&in.ID, &in.TokenHash, &in.BotID, &in.CommandID, &in.CommandName, &in.ServerID, &in.ChannelID, &in.UserID, &options, &in.Status, &in.CreatedAt, &in.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(options, &in.Options); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// SetStatus moves an interaction from one status to another. It returns
+// sql.ErrNoRows if the interaction was no longer in status from, so two
+// initial responses cannot both succeed.
+func (r *InteractionRepo) SetStatus(ctx context.Context, id uuid.UUID, from, to string) error {
+	result, err := r.DB.ExecContext(ctx,
+		`UPDATE interactions SET status = $3 WHERE id = $1 AND status = $2`, id, from, to,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// ChannelOverwriteRepo handles per-channel permission overwrites.
+type ChannelOverwriteRepo struct {
+	DB *sql.DB
+}
+
+// ListByChannel returns the channel's own overwrites.
+func (r *ChannelOverwriteRepo) ListByChannel(ctx context.Context, channelID uuid.UUID) ([]models.ChannelOverwrite, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT channel_id, role_id, allow, deny FROM channel_overwrites
+		 WHERE channel_id = $1`, channelID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var overwrites []models.ChannelOverwrite
+	for rows.Next() {
+		var o models.ChannelOverwrite
+		if err := rows.Scan(&o.ChannelID, &o.RoleID, &o.Allow, &o.Deny); err != nil {
+			return nil, err
+		}
+		overwrites = append(overwrites, o)
+	}
+	return overwrites, rows.Err()
+}
+
+// Set creates or replaces a role's overwrite in a channel.
+func (r *ChannelOverwriteRepo) Set(ctx context.Context, o *models.ChannelOverwrite) error {
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO channel_overwrites (channel_id, role_id, allow, deny)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (channel_id, role_id) DO UPDATE SET allow = $3, deny = $4`,
+		o.ChannelID, o.RoleID, o.Allow, o.Deny,
+	)
+	return err
+}
+
+// Delete removes a role's overwrite from a channel.
+func (r *ChannelOverwriteRepo) Delete(ctx context.Context, channelID, roleID uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx,
+		`DELETE FROM channel_overwrites WHERE channel_id = $1 AND role_id = $2`,
+		channelID, roleID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MemberOverwrites returns the union of the allowed and of the denied bits
+// of the overwrites for the roles a user holds in a channel. A channel with
+// no overwrites of its own uses its category's.
+func (r *ChannelOverwriteRepo) MemberOverwrites(ctx context.Context, userID uuid.UUID, ch *models.Channel) (allow, deny int64, err error) {
+	err = r.DB.QueryRowContext(ctx,
+		`SELECT COALESCE(BIT_OR(o.allow), 0), COALESCE(BIT_OR(o.deny), 0)
+		 FROM channel_overwrites o
+		 JOIN member_roles mr ON mr.role_id = o.role_id
+		 WHERE mr.user_id = $1 AND mr.server_id = $2
+		   AND o.channel_id = CASE
+		       WHEN $4::uuid IS NULL OR EXISTS (SELECT 1 FROM channel_overwrites WHERE channel_id = $3::uuid) THEN $3::uuid
+		       ELSE $4::uuid
+		   END`,
+		userID, ch.ServerID, ch.ID, ch.ParentID,
+	).Scan(&allow, &deny)
+	return allow, deny, err
+}
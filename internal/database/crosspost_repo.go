@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CrosspostRepo handles the queue of cross-posts of published announcements.
+type CrosspostRepo struct {
+	DB *sql.DB
+}
+
+// Crosspost is a queued copy of a published message, to be posted through
+// one of its channel's follow webhooks with the ID CopyID.
+type Crosspost struct {
+	MessageID uuid.UUID
+	WebhookID uuid.UUID
+	CopyID    uuid.UUID
+	Attempts  int
+}
+
+// ClaimDue locks up to limit queued cross-posts that are not already claimed
+// and counts an attempt at each. Another worker (or this one after a crash)
+// only claims them again once lease runs out.
+func (r *CrosspostRepo) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]Crosspost, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`WITH due AS (
+			SELECT message_id, webhook_id FROM crossposts
+			WHERE locked_until IS NULL OR locked_until < NOW()
+			ORDER BY created_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE crossposts c
+		SET locked_until = NOW() + $2 * INTERVAL '1 second', attempts = c.attempts + 1
+		FROM due
+		WHERE c.message_id = due.message_id AND c.webhook_id = due.webhook_id
+		RETURNING c.message_id, c.webhook_id, c.copy_id, c.attempts`,
+		limit, lease.Seconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []Crosspost
+	for rows.Next() {
+		var c Crosspost
+		if err := rows.Scan(&c.MessageID, &c.WebhookID, &c.CopyID, &c.Attempts); err != nil {
+			return nil, err
+		}
+		due = append(due, c)
+	}
+	return due, rows.Err()
+}
+
+// Finish removes a cross-post that has been made or can't be.
+func (r *CrosspostRepo) Finish(ctx context.Context, c *Crosspost) error {
+	_, err := r.DB.ExecContext(ctx,
+		`DELETE FROM crossposts WHERE message_id = $1 AND webhook_id = $2`,
+		c.MessageID, c.WebhookID,
+	)
+	return err
+}
+
+// MarkRetry records why an attempt failed. The cross-post is tried again
+// once its lease runs out.
+func (r *CrosspostRepo) MarkRetry(ctx context.Context, c *Crosspost, reason string) error {
+	_, err := r.DB.ExecContext(ctx,
+		`UPDATE crossposts SET last_error = $3 WHERE message_id = $1 AND webhook_id = $2`,
+		c.MessageID, c.WebhookID, reason,
+	)
+	return err
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// FavoriteGIFRepo handles users' libraries of favorite GIFs.
+type FavoriteGIFRepo struct {
+	DB *sql.DB
+}
+
+const favoriteGIFColumns = `id, user_id, file_path, mime_type, file_size, width, height, created_at`
+
+func scanFavoriteGIF(row interface{ Scan(...any) error }, g *models.FavoriteGIF) error {
+	return row.Scan(&g.ID, &g.UserID, &g.FilePath, &g.MimeType, &g.FileSize, &g.Width, &g.Height, &g.CreatedAt)
+}
+
+// Create adds a GIF to the user's library. Saving a file that is already
+// there returns the existing favorite.
+func (r *FavoriteGIFRepo) Create(ctx context.Context, g *models.FavoriteGIF) error {
+	return scanFavoriteGIF(r.DB.QueryRowContext(ctx,
+		`INSERT INTO favorite_gifs (id, user_id, file_path, mime_type, file_size, width, height, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 ON CONFLICT (user_id, file_path) DO UPDATE SET file_path = EXCLUDED.file_path
+		 RETURNING `+favoriteGIFColumns,
+		uuid.New(), g.UserID, g.FilePath, g.MimeType, g.FileSize, g.Width, g.Height, time.Now(),
+	), g)
+}
+
+func (r *FavoriteGIFRepo) Count(ctx context.Context, userID uuid.UUID) (int, error) {
+	var n int
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM favorite_gifs WHERE user_id = $1`, userID,
+	).Scan(&n)
+	return n, err
+}
+
+// ListByUser returns the user's library, most recently saved first.
+func (r *FavoriteGIFRepo) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.FavoriteGIF, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT `+favoriteGIFColumns+` FROM favorite_gifs WHERE user_id = $1 ORDER BY created_at DESC`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var gifs []models.FavoriteGIF
+	for rows.Next() {
+		var g models.FavoriteGIF
+		if err := scanFavoriteGIF(rows, &g); err != nil {
+			return nil, err
+		}
+		gifs = append(gifs, g)
+	}
+	return gifs, rows.Err()
+}
+
+// Get returns one of the user's favorites.
+func (r *FavoriteGIFRepo) Get(ctx context.Context, id, userID uuid.UUID) (*models.FavoriteGIF, error) {
+	g := &models.FavoriteGIF{}
+	err := scanFavoriteGIF(r.DB.QueryRowContext(ctx,
+		`SELECT `+favoriteGIFColumns+` FROM favorite_gifs WHERE id = $1 AND user_id = $2`, id, userID,
+	), g)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Delete removes one of the user's favorites. It returns the upload path of
+// its file if nothing else uses the file any more, so the caller can remove
+// it, or "" otherwise.
+func (r *FavoriteGIFRepo) Delete(ctx context.Context, id, userID uuid.UUID) (string, error) {
+	var path string
+	var orphaned bool
+	err := r.DB.QueryRowContext(ctx,
+		`WITH d AS (
+			DELETE FROM favorite_gifs WHERE id = $1 AND user_id = $2 RETURNING file_path
+		)
+		SELECT d.file_path,
+			NOT EXISTS (SELECT 1 FROM attachments a WHERE a.file_path = d.file_path)
+			AND NOT EXISTS (SELECT 1 FROM favorite_gifs f WHERE f.file_path = d.file_path AND f.id <> $1)
+		FROM d`,
+		id, userID,
+	).Scan(&path, &orphaned)
+	if err != nil {
+		return "", err
+	}
+	if !orphaned {
+		return "", nil
+	}
+	return path, nil
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// IncomingWebhookRepo handles incoming webhooks.
+type IncomingWebhookRepo struct {
+	DB *sql.DB
+}
+
+const incomingWebhookColumns = `id, server_id, channel_id, name, avatar_url, token_hash, created_by, created_at, last_used_at, source_channel_id`
+
+func scanIncomingWebhook(row interface{ Scan(...any) error }, h *models.IncomingWebhook) error {
+	return row.Scan(&h.ID, &h.ServerID, &h.ChannelID, &h.Name, &h.AvatarURL, &h.TokenHash, &h.CreatedBy, &h.CreatedAt, &h.LastUsedAt, &h.SourceChannelID)
+}
+
+// ErrAlreadyFollowing is returned by Create when the webhook's channel
+// already follows its source channel.
+var ErrAlreadyFollowing = errors.New("already following")
+
+// Create inserts a webhook. The caller sets ID, since the webhook's URL is
+// built from it when its token is issued.
+func (r *IncomingWebhookRepo) Create(ctx context.Context, h *models.IncomingWebhook) error {
+	h.CreatedAt = time.Now()
+	result, err := r.DB.ExecContext(ctx,
+		`INSERT INTO incoming_webhooks (id, server_id, channel_id, name, avatar_url, token_hash, created_by, created_at, source_channel_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		 ON CONFLICT DO NOTHING`,
+		h.ID, h.ServerID, h.ChannelID, h.Name, h.AvatarURL, h.TokenHash, h.CreatedBy, h.CreatedAt, h.SourceChannelID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAlreadyFollowing
+	}
+	return nil
+}
+
+func (r *IncomingWebhookRepo) GetByID(ctx context.Context, id uuid.UUID) (*models.IncomingWebhook, error) {
+	h := &models.IncomingWebhook{}
+	err := scanIncomingWebhook(r.DB.QueryRowContext(ctx,
+		`SELECT `+incomingWebhookColumns+` FROM incoming_webhooks WHERE id = $1`, id,
+	), h)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (r *IncomingWebhookRepo) ListByChannel(ctx context.Context, channelID uuid.UUID) ([]models.IncomingWebhook, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT `+incomingWebhookColumns+` FROM incoming_webhooks WHERE channel_id = $1 ORDER BY created_at`, channelID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []models.IncomingWebhook
+	for rows.Next() {
+		var h models.IncomingWebhook
+		if err := scanIncomingWebhook(rows, &h); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+// GetFollower returns the webhook through which a channel follows an
+// announcement channel, or sql.ErrNoRows if it doesn't.
+func (r *IncomingWebhookRepo) GetFollower(ctx context.Context, sourceChannelID, channelID uuid.UUID) (*models.IncomingWebhook, error) {
+	h := &models.IncomingWebhook{}
+	err := scanIncomingWebhook(r.DB.QueryRowContext(ctx,
+		`SELECT `+incomingWebhookColumns+` FROM incoming_webhooks
+		 WHERE source_channel_id = $1 AND channel_id = $2
+		 LIMIT 1`, sourceChannelID, channelID,
+	), h)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Update saves a webhook's name, avatar, channel and token hash.
+func (r *IncomingWebhookRepo) Update(ctx context.Context, h *models.IncomingWebhook) error {
+	_, err := r.DB.ExecContext(ctx,
+		`UPDATE incoming_webhooks SET channel_id = $2, name = $3, avatar_url = $4, token_hash = $5 WHERE id = $1`,
+		h.ID, h.ChannelID, h.Name, h.AvatarURL, h.TokenHash,
+	)
+	return err
+}
+
+func (r *IncomingWebhookRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx, `DELETE FROM incoming_webhooks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *IncomingWebhookRepo) MarkUsed(ctx context.Context, id uuid.UUID) error {
+	_, err := r.DB.ExecContext(ctx,
+		`UPDATE incoming_webhooks SET last_used_at = NOW() WHERE id = $1`, id,
+	)
+	return err
+}
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// ErrAlreadyMember is returned by InviteRepo.Redeem when the user already belongs to the server.
+var ErrAlreadyMember = errors.New("already a member")
+
+// InviteRepo handles server invites.
+type InviteRepo struct {
+	DB *sql.DB
+}
+
+func (r *InviteRepo) Create(ctx context.Context, inv *models.Invite) error {
+	inv.CreatedAt = time.Now()
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO invites (code, server_id, creator_id, expires_at, max_uses, uses, temporary, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		inv.Code, inv.ServerID, inv.CreatorID, inv.ExpiresAt, inv.MaxUses, inv.Uses, inv.Temporary, inv.CreatedAt,
+	)
+	return err
+}
+
+// GetByCode returns an invite whether or not it is still usable; Redeem and
+// Preview apply the expiry and use limits.
+func (r *InviteRepo) GetByCode(ctx context.Context, code string) (*models.Invite, error) {
+	inv := &models.Invite{}
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT code, server_id, creator_id, expires_at, max_uses, uses, temporary, created_at
+		 FROM invites WHERE code = $1`, code,
+	).Scan(&inv.Code, &inv.ServerID, &inv.CreatorID, &inv.ExpiresAt, &inv.MaxUses, &inv.Uses, &inv.Temporary, &inv.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func (r *InviteRepo) ListByServer(ctx context.Context, serverID uuid.UUID) ([]models.Invite, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT code, server_id, creator_id, expires_at, max_uses, uses, temporary, created_at
+		 FROM invites WHERE server_id = $1
+		 ORDER BY created_at DESC`, serverID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []models.Invite
+	for rows.Next() {
+		var inv models.Invite
+		if err := rows.Scan(&inv.Code, &inv.ServerID, &inv.CreatorID, &inv.ExpiresAt, &inv.MaxUses, &inv.Uses, &inv.Temporary, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		invites = append(invites, inv)
+	}
+	return invites, rows.Err()
+}
+
+// Delete revokes an invite. If it was the server's legacy invite_code, that is cleared too.
+func (r *InviteRepo) Delete(ctx context.Context, code string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM invites WHERE code = $1`, code)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE servers SET invite_code = NULL WHERE invite_code = $1`, code); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Redeem atomically consumes one use of an invite and adds the user to its
+// server. Returns sql.ErrNoRows if the invite is unknown, expired or used up,
+// and ErrAlreadyMember if the user is already in the server (no use is consumed).
+func (r *InviteRepo) Redeem(ctx context.Context, code string, userID uuid.UUID) (*models.Invite, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	inv := &models.Invite{}
+	err = tx.QueryRowContext(ctx,
+		`UPDATE invites SET uses = uses + 1
+		 WHERE code = $1
+		   AND (expires_at IS NULL OR expires_at > NOW())
+		   AND (max_uses = 0 OR uses < max_uses)
+		 RETURNING code, server_id, creator_id, expires_at, max_uses, uses, temporary, created_at`, code,
+	).Scan(&inv.Code, &inv.ServerID, &inv.CreatorID, &inv.ExpiresAt, &inv.MaxUses, &inv.Uses, &inv.Temporary, &inv.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.ExecContext(ctx,
+		`INSERT INTO server_members (user_id, server_id, joined_at, temporary)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT DO NOTHING`,
+		userID, inv.ServerID, time.Now(), inv.Temporary,
+	)
+	if err != nil {
+		return nil, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrAlreadyMember
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Preview returns the public details of a usable invite.
+func (r *InviteRepo) Preview(ctx context.Context, code string) (*models.InvitePreview, error) {
+	p := &models.InvitePreview{}
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT i.code, s.id, s.name, s.icon_path,
+		        (SELECT COUNT(*) FROM server_members sm WHERE sm.server_id = s.id),
+		        i.expires_at
+		 FROM invites i
+		 JOIN servers s ON s.id = i.server_id
+		 WHERE i.code = $1
+		   AND (i.expires_at IS NULL OR i.expires_at > NOW())
+		   AND (i.max_uses = 0 OR i.uses < i.max_uses)`, code,
+	).Scan(&p.Code, &p.ServerID, &p.ServerName, &p.ServerIcon, &p.MemberCount, &p.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/Stocist/discard/internal/models"
+)
+
+// LinkPreviewRepo caches unfurled link previews by URL.
+type LinkPreviewRepo struct {
+	DB *sql.DB
+}
+
+// Get returns the cached preview for url and when it was fetched. The embed
+// is nil if the URL had no preview. It returns sql.ErrNoRows if the URL has
+// not been fetched.
+func (r *LinkPreviewRepo) Get(ctx context.Context, url string) (*models.Embed, time.Time, error) {
+	var raw []byte
+	var fetchedAt time.Time
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT embed, fetched_at FROM link_previews WHERE url = $1`, url,
+	).Scan(&raw, &fetchedAt)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if raw == nil {
+		return nil, fetchedAt, nil
+	}
+	e := &models.Embed{}
+	if err := json.Unmarshal(raw, e); err != nil {
+		return nil, time.Time{}, err
+	}
+	return e, fetchedAt, nil
+}
+
+// Put stores the preview for url, or records that it has none if e is nil.
+func (r *LinkPreviewRepo) Put(ctx context.Context, url string, e *models.Embed) error {
+	var raw []byte
+	if e != nil {
+		var err error
+		if raw, err = json.Marshal(e); err != nil {
+			return err
+		}
+	}
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO link_previews (url, embed, fetched_at) VALUES ($1, $2, NOW())
+		 ON CONFLICT (url) DO UPDATE SET embed = EXCLUDED.embed, fetched_at = EXCLUDED.fetched_at`,
+		url, raw,
+	)
+	return err
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// MentionRepo handles message mentions and the per-user mentions inbox.
+type MentionRepo struct {
+	DB *sql.DB
+}
+
+// Replace stores the mentions of msg (its Mentions, MentionRoles and
+// MentionEveryone fields) and the users they notified, replacing any
+// previous rows for the message, in one transaction.
+func (r *MentionRepo) Replace(ctx context.Context, msg *models.Message, notified []uuid.UUID) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM message_mentions WHERE message_id = $1`, msg.ID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_mentions WHERE message_id = $1`, msg.ID); err != nil {
+		return err
+	}
+
+	for _, id := range msg.Mentions {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO message_mentions (message_id, user_id) VALUES ($1, $2)`, msg.ID, id,
+		); err != nil {
+			return err
+		}
+	}
+	for _, id := range msg.MentionRoles {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO message_mentions (message_id, role_id) VALUES ($1, $2)`, msg.ID, id,
+		); err != nil {
+			return err
+		}
+	}
+	if msg.MentionEveryone {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO message_mentions (message_id) VALUES ($1)`, msg.ID,
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range notified {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO user_mentions (user_id, message_id, channel_id, created_at) VALUES ($1, $2, $3, $4)
+			 ON CONFLICT DO NOTHING`,
+			id, msg.ID, msg.ChannelID, msg.CreatedAt,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Load fills in the Mentions, MentionRoles and MentionEveryone fields of msg.
+func (r *MentionRepo) Load(ctx context.Context, msg *models.Message) error {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT user_id, role_id FROM message_mentions WHERE message_id = $1`, msg.ID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	msg.Mentions, msg.MentionRoles, msg.MentionEveryone = nil, nil, false
+	for rows.Next() {
+		var userID, roleID *uuid.UUID
+		if err := rows.Scan(&userID, &roleID); err != nil {
+			return err
+		}
+		switch {
+		case userID != nil:
+			msg.Mentions = append(msg.Mentions, *userID)
+		case roleID != nil:
+			msg.MentionRoles = append(msg.MentionRoles, *roleID)
+		default:
+			msg.MentionEveryone = true
+		}
+	}
+	return rows.Err()
+}
+
+// ListNotified returns the users a message notified.
+func (r *MentionRepo) ListNotified(ctx context.Context, messageID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT user_id FROM user_mentions WHERE message_id = $1`, messageID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ListForUser returns the messages that mentioned the user, newest first,
+// optionally before the given message. Messages in channels the user can no
+// longer see are left out.
+func (r *MentionRepo) ListForUser(ctx context.Context, userID uuid.UUID, before *uuid.UUID, limit int) ([]models.MentionEntry, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT `+messageColumns+`, c.server_id
+		 FROM user_mentions um
+		 JOIN messages m ON m.id = um.message_id
+		 JOIN users u ON u.id = m.author_id
+		 JOIN channels c ON c.id = um.channel_id
+		 WHERE um.user_id = $1 AND m.deleted_at IS NULL
+		   AND ($2::uuid IS NULL OR um.created_at < (SELECT created_at FROM user_mentions WHERE user_id = $1 AND message_id = $2))
+		   AND (
+		     EXISTS (SELECT 1 FROM server_members sm WHERE sm.server_id = c.server_id AND sm.user_id = $1)
+		     OR EXISTS (SELECT 1 FROM dm_members dm WHERE dm.channel_id = c.id AND dm.user_id = $1)
+		   )
+		 ORDER BY um.created_at DESC
+		 LIMIT $3`,
+		userID, before, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.MentionEntry
+	for rows.Next() {
+		var e models.MentionEntry
+		m := &e.Message
+		if err := scanMessage(rows, m, &e.ServerID); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,16 @@
+-- 003_moderation.sql
+-- Server bans and member timeouts.
+
+CREATE TABLE server_bans (
+    server_id       UUID NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+    user_id         UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tailscale_id    VARCHAR(256),
+    banned_by       UUID NOT NULL REFERENCES users(id),
+    reason          TEXT,
+    created_at      TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (server_id, user_id)
+);
+
+CREATE INDEX idx_server_bans_tailscale ON server_bans(server_id, tailscale_id) WHERE tailscale_id IS NOT NULL;
+
+ALTER TABLE server_members ADD COLUMN timeout_until TIMESTAMPTZ;
@@ -0,0 +1,16 @@
+-- 004_audit_log.sql
+-- Record of moderation and configuration changes per server.
+
+CREATE TABLE audit_log (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    server_id       UUID NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+    actor_id        UUID NOT NULL REFERENCES users(id),
+    action          VARCHAR(32) NOT NULL,
+    target_type     VARCHAR(16),
+    target_id       UUID,
+    changes         JSONB,
+    reason          TEXT,
+    created_at      TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_audit_log_server_created ON audit_log(server_id, created_at DESC);
@@ -0,0 +1,21 @@
+-- 005_invites.sql
+-- Multiple invites per server with expiry, use limits and temporary membership.
+
+CREATE TABLE invites (
+    code            VARCHAR(16) PRIMARY KEY,
+    server_id       UUID NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+    creator_id      UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at      TIMESTAMPTZ,
+    max_uses        INTEGER NOT NULL DEFAULT 0,
+    uses            INTEGER NOT NULL DEFAULT 0,
+    temporary       BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at      TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_invites_server ON invites(server_id);
+
+-- Carry over each server's original permanent invite.
+INSERT INTO invites (code, server_id, creator_id, created_at)
+SELECT invite_code, id, owner_id, created_at FROM servers WHERE invite_code IS NOT NULL;
+
+ALTER TABLE server_members ADD COLUMN temporary BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,7 @@
+-- 006_group_dms.sql
+-- Group DM channels: an owner, an optional name and icon, more than two members.
+
+ALTER TABLE channels ADD COLUMN icon_path VARCHAR(512);
+ALTER TABLE channels ADD COLUMN owner_id UUID REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_dm_members_user ON dm_members(user_id);
@@ -0,0 +1,12 @@
+-- 007_user_blocks.sql
+-- One-directional user blocks. Blocking removes any friendship between the pair.
+
+CREATE TABLE user_blocks (
+    blocker_id      UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    blocked_id      UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at      TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (blocker_id, blocked_id),
+    CHECK (blocker_id <> blocked_id)
+);
+
+CREATE INDEX idx_user_blocks_blocked ON user_blocks(blocked_id);
@@ -0,0 +1,12 @@
+-- 008_privacy.sql
+-- Per-user privacy settings and message requests for DMs from non-friends.
+
+CREATE TABLE user_privacy_settings (
+    user_id                 UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    dm_policy               VARCHAR(16) NOT NULL DEFAULT 'server_members',
+    friend_request_policy   VARCHAR(16) NOT NULL DEFAULT 'everyone',
+    updated_at              TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- TRUE while a DM opened by a non-friend sits in this member's message request inbox.
+ALTER TABLE dm_members ADD COLUMN message_request BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,24 @@
+-- 009_mentions.sql
+-- Parsed @user, @role and @everyone/@here mentions, and who they notified.
+
+-- What a message mentions explicitly. A row with neither user_id nor role_id
+-- means @everyone or @here.
+CREATE TABLE message_mentions (
+    message_id  UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id     UUID REFERENCES users(id) ON DELETE CASCADE,
+    role_id     UUID REFERENCES roles(id) ON DELETE CASCADE,
+    CHECK (user_id IS NULL OR role_id IS NULL)
+);
+
+CREATE INDEX idx_message_mentions_message ON message_mentions(message_id);
+
+-- One row per user a message notified, directly, through a role or through @everyone/@here.
+CREATE TABLE user_mentions (
+    user_id     UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message_id  UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    channel_id  UUID NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    created_at  TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (user_id, message_id)
+);
+
+CREATE INDEX idx_user_mentions_user_created ON user_mentions(user_id, created_at DESC);
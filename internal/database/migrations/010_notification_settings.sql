@@ -0,0 +1,22 @@
+-- 010_notification_settings.sql
+-- Per-user notification preferences, shared by all of a user's devices.
+
+-- Overrides for one server or channel (including DMs). A NULL level
+-- inherits from the server, or from the default of all messages.
+CREATE TABLE notification_overrides (
+    user_id             UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    target_id           UUID NOT NULL,
+    target_type         VARCHAR(8) NOT NULL,
+    level               VARCHAR(16),
+    muted               BOOLEAN NOT NULL DEFAULT FALSE,
+    muted_until         TIMESTAMPTZ,
+    suppress_everyone   BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at          TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (user_id, target_id)
+);
+
+CREATE TABLE user_notification_settings (
+    user_id     UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    mute_dms    BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at  TIMESTAMPTZ DEFAULT NOW()
+);
@@ -0,0 +1,39 @@
+-- 012_outgoing_webhooks.sql
+-- Outgoing webhooks: signed HTTP callbacks for server events, delivered from
+-- a queue with retries.
+
+CREATE TABLE outgoing_webhooks (
+    id                  UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    server_id           UUID NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+    channel_id          UUID REFERENCES channels(id) ON DELETE CASCADE,
+    name                VARCHAR(100) NOT NULL,
+    url                 TEXT NOT NULL,
+    secret              VARCHAR(64) NOT NULL,
+    events              JSONB NOT NULL DEFAULT '[]',
+    enabled             BOOLEAN NOT NULL DEFAULT TRUE,
+    failure_count       INT NOT NULL DEFAULT 0,
+    disabled_reason     TEXT,
+    last_success_at     TIMESTAMPTZ,
+    created_by          UUID NOT NULL REFERENCES users(id),
+    created_at          TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_outgoing_webhooks_server ON outgoing_webhooks(server_id);
+
+-- status is 'pending' until delivered ('succeeded') or out of retries ('failed').
+CREATE TABLE webhook_deliveries (
+    id                  UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    webhook_id          UUID NOT NULL REFERENCES outgoing_webhooks(id) ON DELETE CASCADE,
+    event               VARCHAR(32) NOT NULL,
+    payload             JSONB NOT NULL,
+    status              VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts            INT NOT NULL DEFAULT 0,
+    next_attempt_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    response_status     INT,
+    last_error          TEXT,
+    created_at          TIMESTAMPTZ DEFAULT NOW(),
+    delivered_at        TIMESTAMPTZ
+);
+
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_webhook_deliveries_webhook_created ON webhook_deliveries(webhook_id, created_at DESC);
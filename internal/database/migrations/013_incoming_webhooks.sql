@@ -0,0 +1,24 @@
+-- 013_incoming_webhooks.sql
+-- Incoming webhooks: per-channel URLs that external services POST messages
+-- to, and the message columns those messages need.
+
+CREATE TABLE incoming_webhooks (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    server_id       UUID NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+    channel_id      UUID NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    name            VARCHAR(80) NOT NULL,
+    avatar_url      TEXT,
+    token_hash      VARCHAR(64) NOT NULL,
+    created_by      UUID NOT NULL REFERENCES users(id),
+    created_at      TIMESTAMPTZ DEFAULT NOW(),
+    last_used_at    TIMESTAMPTZ
+);
+
+CREATE INDEX idx_incoming_webhooks_channel ON incoming_webhooks(channel_id);
+
+-- Webhook messages are authored by the webhook's creator but shown under the
+-- name and avatar they were posted with.
+ALTER TABLE messages
+    ADD COLUMN webhook_id           UUID REFERENCES incoming_webhooks(id) ON DELETE SET NULL,
+    ADD COLUMN webhook_username     VARCHAR(80),
+    ADD COLUMN webhook_avatar_url   TEXT;
@@ -0,0 +1,52 @@
+-- 014_bots_and_commands.sql
+-- Bot accounts, the slash commands they register per server, and the
+-- interactions created when members invoke them.
+
+ALTER TABLE users ADD COLUMN bot BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- The system user posts built-in command responses.
+INSERT INTO users (id, username, display_name, status, bot)
+VALUES ('00000000-0000-0000-0000-00000000d15c', 'discard-system', 'Discard', 'online', TRUE)
+ON CONFLICT DO NOTHING;
+
+CREATE TABLE bots (
+    user_id             UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    owner_id            UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash          VARCHAR(64) NOT NULL UNIQUE,
+    interactions_url    TEXT,
+    interactions_secret VARCHAR(64) NOT NULL,
+    created_at          TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_bots_owner ON bots(owner_id);
+
+CREATE TABLE slash_commands (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    server_id   UUID NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+    bot_id      UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name        VARCHAR(32) NOT NULL,
+    description VARCHAR(100) NOT NULL,
+    options     JSONB NOT NULL DEFAULT '[]',
+    created_at  TIMESTAMPTZ DEFAULT NOW(),
+    UNIQUE (server_id, name)
+);
+
+CREATE TABLE interactions (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    token_hash      VARCHAR(64) NOT NULL,
+    bot_id          UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    command_id      UUID REFERENCES slash_commands(id) ON DELETE SET NULL,
+    command_name    VARCHAR(32) NOT NULL,
+    server_id       UUID NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+    channel_id      UUID NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    user_id         UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    options         JSONB NOT NULL DEFAULT '{}',
+    status          VARCHAR(16) NOT NULL DEFAULT 'pending',
+    created_at      TIMESTAMPTZ DEFAULT NOW(),
+    expires_at      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_interactions_expires ON interactions(expires_at);
+
+-- Responses to slash commands record which interaction they answer.
+ALTER TABLE messages ADD COLUMN interaction JSONB;
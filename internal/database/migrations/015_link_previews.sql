@@ -0,0 +1,12 @@
+-- 015_link_previews.sql
+-- Cache of unfurled link previews. A NULL embed records a URL that had no
+-- preview, so it is not refetched for every message that links it.
+
+CREATE TABLE link_previews (
+    url         TEXT PRIMARY KEY,
+    embed       JSONB,
+    fetched_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Link previews are stored on the message they were unfurled from.
+ALTER TABLE messages ADD COLUMN embeds JSONB;
@@ -0,0 +1,16 @@
+-- 016_message_revisions.sql
+-- Edit history: every edit archives the version of the message it replaces.
+
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMPTZ;
+UPDATE messages SET edited_at = updated_at WHERE edited;
+
+CREATE TABLE message_revisions (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id  UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    content     TEXT NOT NULL,
+    embeds      JSONB,
+    created_at  TIMESTAMPTZ NOT NULL,
+    replaced_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_message_revisions_message ON message_revisions(message_id, replaced_at);
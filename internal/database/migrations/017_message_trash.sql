@@ -0,0 +1,8 @@
+-- 017_message_trash.sql
+-- Soft deletion: deleted messages stay in the trash, where moderators can
+-- restore them, until the retention job removes them for good.
+
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN deleted_by UUID REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_messages_trash ON messages(channel_id, deleted_at DESC) WHERE deleted_at IS NOT NULL;
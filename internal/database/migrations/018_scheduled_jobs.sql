@@ -0,0 +1,22 @@
+-- 018_scheduled_jobs.sql
+-- Scheduled jobs: messages to post into a channel later, and reminders sent
+-- to their owner as a DM from the system user. A job is removed once it has
+-- run; status becomes 'failed' if it cannot be.
+
+CREATE TABLE scheduled_jobs (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    kind            VARCHAR(16) NOT NULL,
+    user_id         UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    channel_id      UUID REFERENCES channels(id) ON DELETE CASCADE,
+    message_id      UUID REFERENCES messages(id) ON DELETE SET NULL,
+    content         TEXT NOT NULL DEFAULT '',
+    run_at          TIMESTAMPTZ NOT NULL,
+    status          VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts        INT NOT NULL DEFAULT 0,
+    locked_until    TIMESTAMPTZ,
+    last_error      TEXT,
+    created_at      TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_scheduled_jobs_due ON scheduled_jobs(run_at) WHERE status = 'pending';
+CREATE INDEX idx_scheduled_jobs_user ON scheduled_jobs(user_id, run_at);
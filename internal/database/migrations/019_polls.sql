@@ -0,0 +1,31 @@
+-- 019_polls.sql
+-- Polls: a question attached to a message, with numbered options and one
+-- row per vote.
+
+CREATE TABLE polls (
+    message_id      UUID PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+    question        VARCHAR(300) NOT NULL,
+    multiple        BOOLEAN NOT NULL DEFAULT FALSE,
+    anonymous       BOOLEAN NOT NULL DEFAULT FALSE,
+    created_by      UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at      TIMESTAMPTZ,
+    closed_at       TIMESTAMPTZ
+);
+
+CREATE INDEX idx_polls_expiring ON polls(expires_at) WHERE closed_at IS NULL AND expires_at IS NOT NULL;
+
+CREATE TABLE poll_options (
+    message_id      UUID NOT NULL REFERENCES polls(message_id) ON DELETE CASCADE,
+    id              SMALLINT NOT NULL,
+    text            VARCHAR(100) NOT NULL,
+    PRIMARY KEY (message_id, id)
+);
+
+CREATE TABLE poll_votes (
+    message_id      UUID NOT NULL,
+    option_id       SMALLINT NOT NULL,
+    user_id         UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at      TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (message_id, user_id, option_id),
+    FOREIGN KEY (message_id, option_id) REFERENCES poll_options(message_id, id) ON DELETE CASCADE
+);
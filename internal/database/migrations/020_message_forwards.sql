@@ -0,0 +1,8 @@
+-- 020_message_forwards.sql
+-- Forwarded messages keep a reference to the message they copy, and share
+-- its attachment files rather than uploading them again.
+
+ALTER TABLE messages ADD COLUMN forwarded_from JSONB;
+
+-- Purging a message only removes files no other attachment still points at.
+CREATE INDEX idx_attachments_file_path ON attachments(file_path);
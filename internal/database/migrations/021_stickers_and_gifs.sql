@@ -0,0 +1,34 @@
+-- 021_stickers_and_gifs.sql
+-- Each server's sticker pack, which members can send as messages of their
+-- own, and each user's library of favorite GIFs.
+
+CREATE TABLE stickers (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    server_id       UUID NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+    name            VARCHAR(32) NOT NULL,
+    description     VARCHAR(100),
+    format          VARCHAR(16) NOT NULL,
+    file_path       VARCHAR(512) NOT NULL,
+    file_size       BIGINT NOT NULL,
+    created_by      UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (server_id, name)
+);
+
+ALTER TABLE messages ADD COLUMN sticker_id UUID REFERENCES stickers(id) ON DELETE SET NULL;
+
+-- Favorites share the upload file of the attachment they were saved from,
+-- like forwarded attachments do.
+CREATE TABLE favorite_gifs (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id         UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    file_path       VARCHAR(512) NOT NULL,
+    mime_type       VARCHAR(128) NOT NULL,
+    file_size       BIGINT NOT NULL,
+    width           INTEGER,
+    height          INTEGER,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, file_path)
+);
+
+CREATE INDEX idx_favorite_gifs_file_path ON favorite_gifs(file_path);
@@ -0,0 +1,16 @@
+-- 022_channel_categories.sql
+-- Server channels can sit under a category, itself a channel of type
+-- "category", and can override the permissions of roles. A channel with no
+-- overrides of its own takes its category's.
+
+ALTER TABLE channels ADD COLUMN parent_id UUID REFERENCES channels(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_channels_parent ON channels(parent_id);
+
+CREATE TABLE channel_overwrites (
+    channel_id      UUID NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    role_id         UUID NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    allow           BIGINT NOT NULL DEFAULT 0,
+    deny            BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (channel_id, role_id)
+);
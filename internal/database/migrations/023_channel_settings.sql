@@ -0,0 +1,11 @@
+-- 023_channel_settings.sql
+-- Per-channel settings beyond the name: an NSFW flag, which members must
+-- acknowledge their age once to see past, a slowmode interval, and how long
+-- threads stay open by default.
+
+ALTER TABLE channels
+    ADD COLUMN nsfw                 BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN slowmode_seconds     INT NOT NULL DEFAULT 0,
+    ADD COLUMN default_auto_archive INT NOT NULL DEFAULT 1440;
+
+ALTER TABLE users ADD COLUMN nsfw_acknowledged_at TIMESTAMPTZ;
@@ -0,0 +1,11 @@
+-- 024_announcement_channels.sql
+-- Announcement channels can be followed from other servers. A follow is an
+-- incoming webhook in the following channel that points back at the
+-- announcement channel; publishing a message posts a copy through each one.
+
+ALTER TABLE incoming_webhooks ADD COLUMN source_channel_id UUID REFERENCES channels(id) ON DELETE CASCADE;
+
+-- A channel follows another at most once.
+CREATE UNIQUE INDEX idx_incoming_webhooks_follow ON incoming_webhooks(source_channel_id, channel_id) WHERE source_channel_id IS NOT NULL;
+
+ALTER TABLE messages ADD COLUMN published_at TIMESTAMPTZ;
@@ -0,0 +1,18 @@
+-- 025_crossposts.sql
+-- Cross-posts of published announcements still to be made, one per follow.
+-- They are queued in the same transaction that publishes the message and
+-- removed once the copy is posted. copy_id is the ID the copy is created
+-- with, so a retry can tell whether an earlier attempt already made it.
+
+CREATE TABLE crossposts (
+    message_id      UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    webhook_id      UUID NOT NULL REFERENCES incoming_webhooks(id) ON DELETE CASCADE,
+    copy_id         UUID NOT NULL,
+    attempts        INT NOT NULL DEFAULT 0,
+    locked_until    TIMESTAMPTZ,
+    last_error      TEXT,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (message_id, webhook_id)
+);
+
+CREATE INDEX idx_crossposts_created ON crossposts(created_at);
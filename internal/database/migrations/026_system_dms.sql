@@ -0,0 +1,17 @@
+-- 026_system_dms.sql
+-- Each user's DM with the system user, which reminders are sent to. The key
+-- on user_id makes concurrent reminders agree on a single channel.
+
+CREATE TABLE system_dms (
+    user_id     UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    channel_id  UUID NOT NULL REFERENCES channels(id) ON DELETE CASCADE
+);
+
+-- Keep the oldest DM each user already has with the system user.
+INSERT INTO system_dms (user_id, channel_id)
+SELECT DISTINCT ON (other.user_id) other.user_id, c.id
+FROM channels c
+JOIN dm_members sys ON sys.channel_id = c.id AND sys.user_id = '00000000-0000-0000-0000-00000000d15c'
+JOIN dm_members other ON other.channel_id = c.id AND other.user_id <> sys.user_id
+WHERE c.type = 'dm'
+ORDER BY other.user_id, c.created_at;
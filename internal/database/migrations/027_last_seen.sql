@@ -0,0 +1,6 @@
+-- 027_last_seen.sql
+-- When a user was last seen connected. Every instance refreshes it for the
+-- users connected to it, so a stale value means no instance has a live
+-- session for them.
+
+ALTER TABLE users ADD COLUMN last_seen_at TIMESTAMPTZ;
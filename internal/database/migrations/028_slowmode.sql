@@ -0,0 +1,10 @@
+-- 028_slowmode.sql
+-- When each user last posted in each slowmode channel. Keeping it here
+-- rather than in memory makes slowmode hold across restarts and instances.
+
+CREATE TABLE slowmode_posts (
+    channel_id  UUID NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    user_id     UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    posted_at   TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (channel_id, user_id)
+);
@@ -0,0 +1,6 @@
+-- 029_message_ast.sql
+-- Each message's parsed Markdown, written with its content so it is parsed
+-- once rather than every time the message is sent. Messages from before
+-- this have none and are parsed when read.
+
+ALTER TABLE messages ADD COLUMN ast JSONB;
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// NotificationRepo handles per-user notification settings.
+type NotificationRepo struct {
+	DB *sql.DB
+}
+
+// GetSettings returns all of the user's notification settings.
+func (r *NotificationRepo) GetSettings(ctx context.Context, userID uuid.UUID) (*models.NotificationSettings, error) {
+	settings := &models.NotificationSettings{Overrides: []models.NotificationOverride{}}
+
+	muteDMs, err := r.GetMuteDMs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	settings.MuteDMs = muteDMs
+
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT target_id, target_type, level, muted, muted_until, suppress_everyone
+		 FROM notification_overrides WHERE user_id = $1
+		 ORDER BY updated_at`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o models.NotificationOverride
+		if err := rows.Scan(&o.TargetID, &o.TargetType, &o.Level, &o.Muted, &o.MutedUntil, &o.SuppressEveryone); err != nil {
+			return nil, err
+		}
+		settings.Overrides = append(settings.Overrides, o)
+	}
+	return settings, rows.Err()
+}
+
+// GetMuteDMs reports whether the user has muted DM notifications.
+func (r *NotificationRepo) GetMuteDMs(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var muted bool
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT mute_dms FROM user_notification_settings WHERE user_id = $1`, userID,
+	).Scan(&muted)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return muted, err
+}
+
+// ListMutingDMs returns which of the users have muted DM notifications.
+func (r *NotificationRepo) ListMutingDMs(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT user_id FROM user_notification_settings WHERE user_id = ANY($1) AND mute_dms`, userIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	muted := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		muted[id] = true
+	}
+	return muted, rows.Err()
+}
+
+func (r *NotificationRepo) SetMuteDMs(ctx context.Context, userID uuid.UUID, muted bool) error {
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO user_notification_settings (user_id, mute_dms, updated_at)
+		 VALUES ($1, $2, NOW())
+		 ON CONFLICT (user_id) DO UPDATE
+		 SET mute_dms = EXCLUDED.mute_dms, updated_at = EXCLUDED.updated_at`,
+		userID, muted,
+	)
+	return err
+}
+
+// ListOverridesFor returns the overrides the users have for a server or
+// channel, keyed by user. Users without one are left out.
+func (r *NotificationRepo) ListOverridesFor(ctx context.Context, userIDs []uuid.UUID, targetID uuid.UUID) (map[uuid.UUID]*models.NotificationOverride, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT user_id, target_id, target_type, level, muted, muted_until, suppress_everyone
+		 FROM notification_overrides WHERE user_id = ANY($1) AND target_id = $2`,
+		userIDs, targetID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := make(map[uuid.UUID]*models.NotificationOverride)
+	for rows.Next() {
+		var userID uuid.UUID
+		o := &models.NotificationOverride{}
+		if err := rows.Scan(&userID, &o.TargetID, &o.TargetType, &o.Level, &o.Muted, &o.MutedUntil, &o.SuppressEveryone); err != nil {
+			return nil, err
+		}
+		overrides[userID] = o
+	}
+	return overrides, rows.Err()
+}
+
+func (r *NotificationRepo) UpsertOverride(ctx context.Context, userID uuid.UUID, o *models.NotificationOverride) error {
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO notification_overrides (user_id, target_id, target_type, level, muted, muted_until, suppress_everyone, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		 ON CONFLICT (user_id, target_id) DO UPDATE
+		 SET level = EXCLUDED.level, muted = EXCLUDED.muted, muted_until = EXCLUDED.muted_until,
+		     suppress_everyone = EXCLUDED.suppress_everyone, updated_at = EXCLUDED.updated_at`,
+		userID, o.TargetID, o.TargetType, o.Level, o.Muted, o.MutedUntil, o.SuppressEveryone,
+	)
+	return err
+}
+
+func (r *NotificationRepo) DeleteOverride(ctx context.Context, userID, targetID uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx,
+		`DELETE FROM notification_overrides WHERE user_id = $1 AND target_id = $2`,
+		userID, targetID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
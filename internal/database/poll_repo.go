@@ -0,0 +1,245 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// ErrPollClosed is returned when voting on a poll that has closed.
+var ErrPollClosed = errors.New("poll is closed")
+
+// PollRepo handles polls and their votes.
+type PollRepo struct {
+	DB *sql.DB
+}
+
+// Create attaches a poll to a message. The options are numbered from 1 in
+// the order given; vote tallies in p are ignored.
+func (r *PollRepo) Create(ctx context.Context, messageID uuid.UUID, p *models.Poll) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO polls (message_id, question, multiple, anonymous, created_by, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		messageID, p.Question, p.Multiple, p.Anonymous, p.CreatedBy, p.ExpiresAt,
+	); err != nil {
+		return err
+	}
+	for i := range p.Options {
+		p.Options[i].ID = i + 1
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO poll_options (message_id, id, text) VALUES ($1, $2, $3)`,
+			messageID, p.Options[i].ID, p.Options[i].Text,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Get returns a message's poll with its tallies, and viewerID's votes.
+func (r *PollRepo) Get(ctx context.Context, messageID, viewerID uuid.UUID) (*models.Poll, error) {
+	polls, err := r.ListByMessages(ctx, []uuid.UUID{messageID}, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := polls[messageID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return p, nil
+}
+
+// ListByMessages returns the polls attached to any of the messages, keyed by
+// message ID, with their tallies and viewerID's votes. Pass uuid.Nil as the
+// viewer to leave MyVotes out.
+func (r *PollRepo) ListByMessages(ctx context.Context, messageIDs []uuid.UUID, viewerID uuid.UUID) (map[uuid.UUID]*models.Poll, error) {
+	polls := make(map[uuid.UUID]*models.Poll)
+	if len(messageIDs) == 0 {
+		return polls, nil
+	}
+	ids := make([]string, len(messageIDs))
+	for i, id := range messageIDs {
+		ids[i] = id.String()
+	}
+
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT p.message_id, p.question, p.multiple, p.anonymous, p.created_by, p.expires_at, p.closed_at,
+			(SELECT COUNT(DISTINCT v.user_id) FROM poll_votes v WHERE v.message_id = p.message_id)
+		 FROM polls p WHERE p.message_id = ANY($1::uuid[])`, ids,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id uuid.UUID
+		p := &models.Poll{Options: []models.PollOption{}}
+		if err := rows.Scan(&id, &p.Question, &p.Multiple, &p.Anonymous, &p.CreatedBy, &p.ExpiresAt, &p.ClosedAt, &p.VoterCount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		polls[id] = p
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(polls) == 0 {
+		return polls, nil
+	}
+
+	rows, err = r.DB.QueryContext(ctx,
+		`SELECT message_id, id, text FROM poll_options
+		 WHERE message_id = ANY($1::uuid[])
+		 ORDER BY message_id, id`, ids,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id uuid.UUID
+		var o models.PollOption
+		if err := rows.Scan(&id, &o.ID, &o.Text); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if p := polls[id]; p != nil {
+			p.Options = append(p.Options, o)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.DB.QueryContext(ctx,
+		`SELECT message_id, option_id, user_id FROM poll_votes
+		 WHERE message_id = ANY($1::uuid[])
+		 ORDER BY created_at`, ids,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, userID uuid.UUID
+		var optionID int
+		if err := rows.Scan(&id, &optionID, &userID); err != nil {
+			return nil, err
+		}
+		p := polls[id]
+		if p == nil || optionID < 1 || optionID > len(p.Options) {
+			continue
+		}
+		o := &p.Options[optionID-1]
+		o.Votes++
+		if !p.Anonymous {
+			o.Voters = append(o.Voters, userID)
+		}
+		if viewerID != uuid.Nil && userID == viewerID {
+			p.MyVotes = append(p.MyVotes, optionID)
+		}
+	}
+	for _, p := range polls {
+		sort.Ints(p.MyVotes)
+	}
+	return polls, rows.Err()
+}
+
+// SetVotes replaces the user's votes on a poll; no options retracts them. It
+// returns sql.ErrNoRows if the message has no poll, and ErrPollClosed if the
+// poll has closed.
+func (r *PollRepo) SetVotes(ctx context.Context, messageID, userID uuid.UUID, optionIDs []int) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the poll row keeps a vote from landing after it closes.
+	var closed bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT closed_at IS NOT NULL OR COALESCE(expires_at <= NOW(), FALSE)
+		 FROM polls WHERE message_id = $1 FOR SHARE`, messageID,
+	).Scan(&closed)
+	if err != nil {
+		return err
+	}
+	if closed {
+		return ErrPollClosed
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM poll_votes WHERE message_id = $1 AND user_id = $2`, messageID, userID,
+	); err != nil {
+		return err
+	}
+	for _, id := range optionIDs {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO poll_votes (message_id, option_id, user_id) VALUES ($1, $2, $3)`,
+			messageID, id, userID,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Close closes a poll. It returns sql.ErrNoRows if the message has no open
+// poll, so only one caller gets to announce the results.
+func (r *PollRepo) Close(ctx context.Context, messageID uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx,
+		`UPDATE polls SET closed_at = NOW() WHERE message_id = $1 AND closed_at IS NULL`, messageID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CloseExpired closes up to limit polls whose time is up and returns their
+// message IDs. Rows are claimed with SKIP LOCKED, so each poll is closed by
+// exactly one caller even with several instances running.
+func (r *PollRepo) CloseExpired(ctx context.Context, limit int) ([]uuid.UUID, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`UPDATE polls SET closed_at = NOW()
+		 WHERE message_id IN (
+			SELECT message_id FROM polls
+			WHERE closed_at IS NULL AND expires_at <= NOW()
+			ORDER BY expires_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING message_id`, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// DefaultPrivacySettings apply to users who have never changed their settings.
+var DefaultPrivacySettings = models.PrivacySettings{
+	DMPolicy:            "server_members",
+	FriendRequestPolicy: "everyone",
+}
+
+// PrivacyRepo handles per-user privacy settings.
+type PrivacyRepo struct {
+	DB *sql.DB
+}
+
+// Get returns the user's privacy settings, or the defaults if none are stored.
+func (r *PrivacyRepo) Get(ctx context.Context, userID uuid.UUID) (*models.PrivacySettings, error) {
+	p := &models.PrivacySettings{}
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT dm_policy, friend_request_policy FROM user_privacy_settings WHERE user_id = $1`, userID,
+	).Scan(&p.DMPolicy, &p.FriendRequestPolicy)
+	if err == sql.ErrNoRows {
+		defaults := DefaultPrivacySettings
+		return &defaults, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (r *PrivacyRepo) Upsert(ctx context.Context, userID uuid.UUID, p *models.PrivacySettings) error {
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO user_privacy_settings (user_id, dm_policy, friend_request_policy, updated_at)
+		 VALUES ($1, $2, $3, NOW())
+		 ON CONFLICT (user_id) DO UPDATE
+		 SET dm_policy = EXCLUDED.dm_policy, friend_request_policy = EXCLUDED.friend_request_policy, updated_at = EXCLUDED.updated_at`,
+		userID, p.DMPolicy, p.FriendRequestPolicy,
+	)
+	return err
+}
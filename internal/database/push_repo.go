@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// PushSubscriptionRepo handles Web Push subscriptions.
+type PushSubscriptionRepo struct {
+	DB *sql.DB
+}
+
+// Upsert stores a subscription. Browsers reuse an endpoint when they
+// resubscribe, so an existing row for the endpoint is taken over with the
+// new keys and owner rather than duplicated.
+func (r *PushSubscriptionRepo) Upsert(ctx context.Context, sub *models.PushSubscription) error {
+	sub.CreatedAt = time.Now()
+	return r.DB.QueryRowContext(ctx,
+		`INSERT INTO push_subscriptions (id, user_id, endpoint, p256dh, auth, user_agent, expires_at, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 ON CONFLICT (endpoint) DO UPDATE
+		 SET user_id = EXCLUDED.user_id, p256dh = EXCLUDED.p256dh, auth = EXCLUDED.auth,
+		     user_agent = EXCLUDED.user_agent, expires_at = EXCLUDED.expires_at
+		 RETURNING id, created_at`,
+		uuid.New(), sub.UserID, sub.Endpoint, sub.P256dh, sub.Auth, sub.UserAgent, sub.ExpiresAt, sub.CreatedAt,
+	).Scan(&sub.ID, &sub.CreatedAt)
+}
+
+func (r *PushSubscriptionRepo) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.PushSubscription, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT id, user_id, endpoint, p256dh, auth, user_agent, expires_at, created_at, last_used_at
+		 FROM push_subscriptions WHERE user_id = $1
+		 ORDER BY created_at`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []models.PushSubscription
+	for rows.Next() {
+		var sub models.PushSubscription
+		if err := rows.Scan(&sub.ID, &sub.UserID, &sub.Endpoint, &sub.P256dh, &sub.Auth, &sub.UserAgent, &sub.ExpiresAt, &sub.CreatedAt, &sub.LastUsedAt); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// Delete removes one of the user's subscriptions.
+func (r *PushSubscriptionRepo) Delete(ctx context.Context, id, userID uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx,
+		`DELETE FROM push_subscriptions WHERE id = $1 AND user_id = $2`, id, userID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteByID removes a subscription the push service reported gone or that expired.
+func (r *PushSubscriptionRepo) DeleteByID(ctx context.Context, id uuid.UUID) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM push_subscriptions WHERE id = $1`, id)
+	return err
+}
+
+func (r *PushSubscriptionRepo) MarkUsed(ctx context.Context, id uuid.UUID) error {
+	_, err := r.DB.ExecContext(ctx,
+		`UPDATE push_subscriptions SET last_used_at = NOW() WHERE id = $1`, id,
+	)
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 
+	"github.com/Stocist/discard/internal/models"
 	"github.com/google/uuid"
 )
 
@@ -23,14 +24,16 @@ func (r *ReadStateRepo) UpdateReadState(ctx context.Context, userID, channelID u
 	return err
 }
 
-// GetUnreadCounts returns a map of channel_id -> unread count for all channels in a server.
-func (r *ReadStateRepo) GetUnreadCounts(ctx context.Context, userID, serverID uuid.UUID) (map[string]int, error) {
+// GetUnreadCounts returns, for every channel in a server with unread
+// messages, how many there are and how many of them mention the user.
+func (r *ReadStateRepo) GetUnreadCounts(ctx context.Context, userID, serverID uuid.UUID) (map[string]models.UnreadCount, error) {
 	rows, err := r.DB.QueryContext(ctx,
-		`SELECT c.id, COUNT(m.id)
+		`SELECT c.id, COUNT(m.id), COUNT(um.message_id)
 		 FROM channels c
 		 LEFT JOIN channel_read_state rs ON rs.channel_id = c.id AND rs.user_id = $1
-		 LEFT JOIN messages m ON m.channel_id = c.id
+		 LEFT JOIN messages m ON m.channel_id = c.id AND m.deleted_at IS NULL
 		   AND (rs.last_read_message_id IS NULL OR m.created_at > (SELECT created_at FROM messages WHERE id = rs.last_read_message_id))
+		 LEFT JOIN user_mentions um ON um.message_id = m.id AND um.user_id = $1
 		 WHERE c.server_id = $2
 		 GROUP BY c.id`,
 		userID, serverID,
@@ -40,15 +43,15 @@ func (r *ReadStateRepo) GetUnreadCounts(ctx context.Context, userID, serverID uu
 	}
 	defer rows.Close()
 
-	counts := make(map[string]int)
+	counts := make(map[string]models.UnreadCount)
 	for rows.Next() {
 		var channelID uuid.UUID
-		var count int
-		if err := rows.Scan(&channelID, &count); err != nil {
+		var uc models.UnreadCount
+		if err := rows.Scan(&channelID, &uc.Count, &uc.Mentions); err != nil {
 			return nil, err
 		}
-		if count > 0 {
-			counts[channelID.String()] = count
+		if uc.Count > 0 {
+			counts[channelID.String()] = uc
 		}
 	}
 	return counts, rows.Err()
@@ -58,7 +61,7 @@ func (r *ReadStateRepo) GetUnreadCounts(ctx context.Context, userID, serverID uu
 func (r *ReadStateRepo) GetLatestMessageID(ctx context.Context, channelID uuid.UUID) (*uuid.UUID, error) {
 	var id uuid.UUID
 	err := r.DB.QueryRowContext(ctx,
-		`SELECT id FROM messages WHERE channel_id = $1 ORDER BY created_at DESC LIMIT 1`, channelID,
+		`SELECT id FROM messages WHERE channel_id = $1 AND deleted_at IS NULL ORDER BY created_at DESC LIMIT 1`, channelID,
 	).Scan(&id)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -1,10 +1,13 @@
 package database
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"time"
 
+	"github.com/Stocist/discard/internal/markdown"
 	"github.com/Stocist/discard/internal/models"
 	"github.com/google/uuid"
 )
@@ -14,6 +17,12 @@ type UserRepo struct {
 	DB *sql.DB
 }
 
+const userColumns = `u.id, u.username, u.display_name, u.avatar_path, u.tailscale_id, u.password_hash, u.status, u.created_at, u.updated_at, u.bot, u.nsfw_acknowledged_at`
+
+func scanUser(row interface{ Scan(...any) error }, u *models.User) error {
+	return row.Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarPath, &u.TailscaleID, &u.PasswordHash, &u.Status, &u.CreatedAt, &u.UpdatedAt, &u.Bot, &u.NSFWAcknowledgedAt)
+}
+
 // Create inserts a new user into the database.
 // Implements auth.UserRepo.
 func (r *UserRepo) Create(ctx context.Context, u *models.User) error {
@@ -31,10 +40,9 @@ func (r *UserRepo) Create(ctx context.Context, u *models.User) error {
 
 func (r *UserRepo) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
 	u := &models.User{}
-	err := r.DB.QueryRowContext(ctx,
-		`SELECT id, username, display_name, avatar_path, tailscale_id, password_hash, status, created_at, updated_at
-		 FROM users WHERE id = $1`, id,
-	).Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarPath, &u.TailscaleID, &u.PasswordHash, &u.Status, &u.CreatedAt, &u.UpdatedAt)
+	err := scanUser(r.DB.QueryRowContext(ctx,
+		`SELECT `+userColumns+` FROM users u WHERE u.id = $1`, id,
+	), u)
 	if err != nil {
 		return nil, err
 	}
@@ -45,16 +53,57 @@ func (r *UserRepo) GetByID(ctx context.Context, id uuid.UUID) (*models.User, err
 // Implements auth.UserRepo.
 func (r *UserRepo) GetByTailscaleID(ctx context.Context, tsID string) (*models.User, error) {
 	u := &models.User{}
-	err := r.DB.QueryRowContext(ctx,
-		`SELECT id, username, display_name, avatar_path, tailscale_id, password_hash, status, created_at, updated_at
-		 FROM users WHERE tailscale_id = $1`, tsID,
-	).Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarPath, &u.TailscaleID, &u.PasswordHash, &u.Status, &u.CreatedAt, &u.UpdatedAt)
+	err := scanUser(r.DB.QueryRowContext(ctx,
+		`SELECT `+userColumns+` FROM users u WHERE u.tailscale_id = $1`, tsID,
+	), u)
 	if err != nil {
 		return nil, err
 	}
 	return u, nil
 }
 
+// AcknowledgeNSFW records that the user confirmed they are old enough to
+// view NSFW channels. Acknowledging again keeps the first time.
+func (r *UserRepo) AcknowledgeNSFW(ctx context.Context, id uuid.UUID) (time.Time, error) {
+	var at time.Time
+	err := r.DB.QueryRowContext(ctx,
+		`UPDATE users SET nsfw_acknowledged_at = COALESCE(nsfw_acknowledged_at, NOW())
+		 WHERE id = $1 RETURNING nsfw_acknowledged_at`, id,
+	).Scan(&at)
+	return at, err
+}
+
+// NSFWAcknowledged reports whether the user has acknowledged NSFW content.
+func (r *UserRepo) NSFWAcknowledged(ctx context.Context, id uuid.UUID) (bool, error) {
+	var ok bool
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT nsfw_acknowledged_at IS NOT NULL FROM users WHERE id = $1`, id,
+	).Scan(&ok)
+	return ok, err
+}
+
+// NSFWAcknowledgedAmong returns which of the users have confirmed their age
+// for NSFW channels.
+func (r *UserRepo) NSFWAcknowledgedAmong(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]bool, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT id FROM users WHERE id = ANY($1) AND nsfw_acknowledged_at IS NOT NULL`, ids,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ok := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ok[id] = true
+	}
+	return ok, rows.Err()
+}
+
 func (r *UserRepo) UpdateUserStatus(ctx context.Context, id uuid.UUID, status string) error {
 	_, err := r.DB.ExecContext(ctx,
 		`UPDATE users SET status = $1, updated_at = $2 WHERE id = $3`,
@@ -63,14 +112,22 @@ func (r *UserRepo) UpdateUserStatus(ctx context.Context, id uuid.UUID, status st
 	return err
 }
 
+// TouchLastSeen records that the users are connected now.
+func (r *UserRepo) TouchLastSeen(ctx context.Context, ids []uuid.UUID) error {
+	_, err := r.DB.ExecContext(ctx,
+		`UPDATE users SET last_seen_at = NOW() WHERE id = ANY($1)`, ids,
+	)
+	return err
+}
+
 func (r *UserRepo) UpdateProfile(ctx context.Context, id uuid.UUID, displayName *string, avatarPath *string) (*models.User, error) {
 	u := &models.User{}
-	err := r.DB.QueryRowContext(ctx,
-		`UPDATE users SET display_name = COALESCE($2, display_name), avatar_path = COALESCE($3, avatar_path), updated_at = $4
+	err := scanUser(r.DB.QueryRowContext(ctx,
+		`UPDATE users u SET display_name = COALESCE($2, display_name), avatar_path = COALESCE($3, avatar_path), updated_at = $4
 		 WHERE id = $1
-		 RETURNING id, username, display_name, avatar_path, tailscale_id, password_hash, status, created_at, updated_at`,
+		 RETURNING `+userColumns,
 		id, displayName, avatarPath, time.Now(),
-	).Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarPath, &u.TailscaleID, &u.PasswordHash, &u.Status, &u.CreatedAt, &u.UpdatedAt)
+	), u)
 	if err != nil {
 		return nil, err
 	}
@@ -162,42 +219,87 @@ type ChannelRepo struct {
 	DB *sql.DB
 }
 
+const channelColumns = `id, server_id, name, topic, type, position, parent_id, icon_path, owner_id, created_at,
+	nsfw, slowmode_seconds, default_auto_archive`
+
+func scanChannel(row interface{ Scan(...any) error }, c *models.Channel) error {
+	return row.Scan(&c.ID, &c.ServerID, &c.Name, &c.Topic, &c.Type, &c.Position, &c.ParentID, &c.IconPath, &c.OwnerID, &c.CreatedAt,
+		&c.NSFW, &c.SlowmodeSeconds, &c.DefaultAutoArchive)
+}
+
+// CreateChannel inserts a channel. Its settings start at the column
+// defaults, which are read back into c.
 func (r *ChannelRepo) CreateChannel(ctx context.Context, c *models.Channel) error {
 	c.ID = uuid.New()
 	c.CreatedAt = time.Now()
-	_, err := r.DB.ExecContext(ctx,
-		`INSERT INTO channels (id, server_id, name, topic, type, position, created_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-		c.ID, c.ServerID, c.Name, c.Topic, c.Type, c.Position, c.CreatedAt,
-	)
-	return err
+	return r.DB.QueryRowContext(ctx,
+		`INSERT INTO channels (id, server_id, name, topic, type, position, parent_id, icon_path, owner_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		 RETURNING nsfw, slowmode_seconds, default_auto_archive`,
+		c.ID, c.ServerID, c.Name, c.Topic, c.Type, c.Position, c.ParentID, c.IconPath, c.OwnerID, c.CreatedAt,
+	).Scan(&c.NSFW, &c.SlowmodeSeconds, &c.DefaultAutoArchive)
+}
+
+// NextPosition returns the position after the last channel under parentID,
+// or among the server's top-level channels if it is nil.
+func (r *ChannelRepo) NextPosition(ctx context.Context, serverID uuid.UUID, parentID *uuid.UUID) (int, error) {
+	var next int
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(position) + 1, 0) FROM channels
+		 WHERE server_id = $1 AND parent_id IS NOT DISTINCT FROM $2`, serverID, parentID,
+	).Scan(&next)
+	return next, err
 }
 
 func (r *ChannelRepo) GetChannelByID(ctx context.Context, id uuid.UUID) (*models.Channel, error) {
 	c := &models.Channel{}
-	err := r.DB.QueryRowContext(ctx,
-		`SELECT id, server_id, name, topic, type, position, created_at
-		 FROM channels WHERE id = $1`, id,
-	).Scan(&c.ID, &c.ServerID, &c.Name, &c.Topic, &c.Type, &c.Position, &c.CreatedAt)
+	err := scanChannel(r.DB.QueryRowContext(ctx,
+		`SELECT `+channelColumns+` FROM channels WHERE id = $1`, id,
+	), c)
 	if err != nil {
 		return nil, err
 	}
 	return c, nil
 }
 
-func (r *ChannelRepo) UpdateChannel(ctx context.Context, channelID uuid.UUID, name string) (*models.Channel, error) {
+// UpdateChannel saves a server channel's name, topic and settings.
+func (r *ChannelRepo) UpdateChannel(ctx context.Context, c *models.Channel) (*models.Channel, error) {
+	updated := &models.Channel{}
+	err := scanChannel(r.DB.QueryRowContext(ctx,
+		`UPDATE channels SET name = $2, topic = $3, nsfw = $4, slowmode_seconds = $5, default_auto_archive = $6
+		 WHERE id = $1
+		 RETURNING `+channelColumns,
+		c.ID, c.Name, c.Topic, c.NSFW, c.SlowmodeSeconds, c.DefaultAutoArchive,
+	), updated)
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// UpdateGroupDM renames a group DM and optionally replaces its icon.
+// A nil name clears it; a nil iconPath keeps the existing icon.
+func (r *ChannelRepo) UpdateGroupDM(ctx context.Context, channelID uuid.UUID, name *string, iconPath *string) (*models.Channel, error) {
 	c := &models.Channel{}
-	err := r.DB.QueryRowContext(ctx,
-		`UPDATE channels SET name = $1 WHERE id = $2
-		 RETURNING id, server_id, name, topic, type, position, created_at`,
-		name, channelID,
-	).Scan(&c.ID, &c.ServerID, &c.Name, &c.Topic, &c.Type, &c.Position, &c.CreatedAt)
+	err := scanChannel(r.DB.QueryRowContext(ctx,
+		`UPDATE channels SET name = $1, icon_path = COALESCE($3, icon_path) WHERE id = $2
+		 RETURNING `+channelColumns,
+		name, channelID, iconPath,
+	), c)
 	if err != nil {
 		return nil, err
 	}
 	return c, nil
 }
 
+func (r *ChannelRepo) SetOwner(ctx context.Context, channelID, ownerID uuid.UUID) error {
+	_, err := r.DB.ExecContext(ctx,
+		`UPDATE channels SET owner_id = $1 WHERE id = $2`,
+		ownerID, channelID,
+	)
+	return err
+}
+
 func (r *ChannelRepo) DeleteChannel(ctx context.Context, channelID uuid.UUID) error {
 	result, err := r.DB.ExecContext(ctx,
 		`DELETE FROM channels WHERE id = $1`, channelID,
@@ -215,10 +317,11 @@ func (r *ChannelRepo) DeleteChannel(ctx context.Context, channelID uuid.UUID) er
 	return nil
 }
 
+// ListServerChannels returns a server's channels in position order. Children
+// come in the same order whatever category they are under.
 func (r *ChannelRepo) ListServerChannels(ctx context.Context, serverID uuid.UUID) ([]models.Channel, error) {
 	rows, err := r.DB.QueryContext(ctx,
-		`SELECT id, server_id, name, topic, type, position, created_at
-		 FROM channels WHERE server_id = $1
+		`SELECT `+channelColumns+` FROM channels WHERE server_id = $1
 		 ORDER BY position, created_at`, serverID,
 	)
 	if err != nil {
@@ -229,7 +332,7 @@ func (r *ChannelRepo) ListServerChannels(ctx context.Context, serverID uuid.UUID
 	var channels []models.Channel
 	for rows.Next() {
 		var c models.Channel
-		if err := rows.Scan(&c.ID, &c.ServerID, &c.Name, &c.Topic, &c.Type, &c.Position, &c.CreatedAt); err != nil {
+		if err := scanChannel(rows, &c); err != nil {
 			return nil, err
 		}
 		channels = append(channels, c)
@@ -237,6 +340,35 @@ func (r *ChannelRepo) ListServerChannels(ctx context.Context, serverID uuid.UUID
 	return channels, rows.Err()
 }
 
+// SetPositions moves a server's channels in one transaction, so clients
+// never see half a reorder. It returns sql.ErrNoRows, changing nothing, if
+// any channel is not in the server.
+func (r *ChannelRepo) SetPositions(ctx context.Context, serverID uuid.UUID, positions []models.ChannelPosition) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, p := range positions {
+		result, err := tx.ExecContext(ctx,
+			`UPDATE channels SET position = $1, parent_id = $2 WHERE id = $3 AND server_id = $4`,
+			p.Position, p.ParentID, p.ID, serverID,
+		)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return sql.ErrNoRows
+		}
+	}
+	return tx.Commit()
+}
+
 // ServerMemberRepo handles server membership operations.
 type ServerMemberRepo struct {
 	DB *sql.DB
@@ -262,7 +394,7 @@ func (r *ServerMemberRepo) RemoveMember(ctx context.Context, userID, serverID uu
 
 func (r *ServerMemberRepo) ListMembers(ctx context.Context, serverID uuid.UUID) ([]models.ServerMember, error) {
 	rows, err := r.DB.QueryContext(ctx,
-		`SELECT sm.user_id, sm.server_id, sm.nickname, sm.joined_at, u.username, u.display_name, u.avatar_path
+		`SELECT sm.user_id, sm.server_id, sm.nickname, sm.joined_at, sm.timeout_until, sm.temporary, u.username, u.display_name, u.avatar_path
 		 FROM server_members sm
 		 JOIN users u ON u.id = sm.user_id
 		 WHERE sm.server_id = $1
@@ -276,7 +408,7 @@ func (r *ServerMemberRepo) ListMembers(ctx context.Context, serverID uuid.UUID)
 	var members []models.ServerMember
 	for rows.Next() {
 		var m models.ServerMember
-		if err := rows.Scan(&m.UserID, &m.ServerID, &m.Nickname, &m.JoinedAt, &m.Username, &m.DisplayName, &m.AvatarURL); err != nil {
+		if err := rows.Scan(&m.UserID, &m.ServerID, &m.Nickname, &m.JoinedAt, &m.TimeoutUntil, &m.Temporary, &m.Username, &m.DisplayName, &m.AvatarURL); err != nil {
 			return nil, err
 		}
 		members = append(members, m)
@@ -293,11 +425,127 @@ func (r *ServerMemberRepo) IsMember(ctx context.Context, userID, serverID uuid.U
 	return exists, err
 }
 
+// GetTimeout returns the member's timeout expiry, or nil if they have never been timed out.
+// Returns sql.ErrNoRows if the user is not a member of the server.
+func (r *ServerMemberRepo) GetTimeout(ctx context.Context, userID, serverID uuid.UUID) (*time.Time, error) {
+	var until *time.Time
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT timeout_until FROM server_members WHERE user_id = $1 AND server_id = $2`,
+		userID, serverID,
+	).Scan(&until)
+	if err != nil {
+		return nil, err
+	}
+	return until, nil
+}
+
+// SetTimeout sets or clears (until == nil) a member's timeout.
+func (r *ServerMemberRepo) SetTimeout(ctx context.Context, userID, serverID uuid.UUID, until *time.Time) error {
+	result, err := r.DB.ExecContext(ctx,
+		`UPDATE server_members SET timeout_until = $1 WHERE user_id = $2 AND server_id = $3`,
+		until, userID, serverID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ClearTemporary makes a temporary membership permanent.
+func (r *ServerMemberRepo) ClearTemporary(ctx context.Context, userID, serverID uuid.UUID) error {
+	_, err := r.DB.ExecContext(ctx,
+		`UPDATE server_members SET temporary = false WHERE user_id = $1 AND server_id = $2`,
+		userID, serverID,
+	)
+	return err
+}
+
+// RemoveTemporary deletes every temporary membership a user holds and
+// returns the IDs of the servers they were removed from.
+func (r *ServerMemberRepo) RemoveTemporary(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`DELETE FROM server_members WHERE user_id = $1 AND temporary RETURNING server_id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RemoveStaleTemporary deletes the temporary memberships, joined before
+// cutoff, of users not seen connected since cutoff. It returns the removed
+// memberships with only UserID and ServerID set.
+func (r *ServerMemberRepo) RemoveStaleTemporary(ctx context.Context, cutoff time.Time) ([]models.ServerMember, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`DELETE FROM server_members sm
+		 USING users u
+		 WHERE u.id = sm.user_id AND sm.temporary AND sm.joined_at < $1
+		   AND (u.last_seen_at IS NULL OR u.last_seen_at < $1)
+		 RETURNING sm.user_id, sm.server_id`,
+		cutoff,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var removed []models.ServerMember
+	for rows.Next() {
+		var m models.ServerMember
+		if err := rows.Scan(&m.UserID, &m.ServerID); err != nil {
+			return nil, err
+		}
+		removed = append(removed, m)
+	}
+	return removed, rows.Err()
+}
+
+// ShareServer reports whether two users are members of at least one common server.
+func (r *ServerMemberRepo) ShareServer(ctx context.Context, a, b uuid.UUID) (bool, error) {
+	var exists bool
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT EXISTS(
+			SELECT 1 FROM server_members x
+			JOIN server_members y ON y.server_id = x.server_id
+			WHERE x.user_id = $1 AND y.user_id = $2
+		)`,
+		a, b,
+	).Scan(&exists)
+	return exists, err
+}
+
 // FriendshipRepo handles friendship-related database operations.
 type FriendshipRepo struct {
 	DB *sql.DB
 }
 
+// orderedPair returns a and b ordered the way PostgreSQL compares UUIDs
+// (bytewise), as required by the friendships CHECK (user_a < user_b).
+func orderedPair(a, b uuid.UUID) (uuid.UUID, uuid.UUID) {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		return b, a
+	}
+	return a, b
+}
+
 func (r *FriendshipRepo) CreateFriendRequest(ctx context.Context, f *models.Friendship) error {
 	f.ID = uuid.New()
 	now := time.Now()
@@ -305,10 +553,7 @@ func (r *FriendshipRepo) CreateFriendRequest(ctx context.Context, f *models.Frie
 	f.UpdatedAt = now
 	f.Status = "pending"
 
-	// Enforce user_a < user_b ordering per CHECK constraint
-	if f.UserA.String() > f.UserB.String() {
-		f.UserA, f.UserB = f.UserB, f.UserA
-	}
+	f.UserA, f.UserB = orderedPair(f.UserA, f.UserB)
 
 	_, err := r.DB.ExecContext(ctx,
 		`INSERT INTO friendships (id, user_a, user_b, status, initiated_by, dm_channel_id, created_at, updated_at)
@@ -327,10 +572,7 @@ func (r *FriendshipRepo) AcceptFriend(ctx context.Context, id uuid.UUID) error {
 }
 
 func (r *FriendshipRepo) GetFriendship(ctx context.Context, userA, userB uuid.UUID) (*models.Friendship, error) {
-	// Enforce ordering to match CHECK constraint
-	if userA.String() > userB.String() {
-		userA, userB = userB, userA
-	}
+	userA, userB = orderedPair(userA, userB)
 
 	f := &models.Friendship{}
 	err := r.DB.QueryRowContext(ctx,
@@ -343,27 +585,56 @@ func (r *FriendshipRepo) GetFriendship(ctx context.Context, userA, userB uuid.UU
 	return f, nil
 }
 
-func (r *FriendshipRepo) ListFriends(ctx context.Context, userID uuid.UUID) ([]models.Friendship, error) {
+// ListRelationships returns the user's friendships with the given status
+// ("accepted" or "pending"), with the other user's profile, newest first.
+// Pending requests are typed "incoming" or "outgoing"; accepted ones "friend".
+func (r *FriendshipRepo) ListRelationships(ctx context.Context, userID uuid.UUID, status string) ([]models.Relationship, error) {
 	rows, err := r.DB.QueryContext(ctx,
-		`SELECT id, user_a, user_b, status, initiated_by, dm_channel_id, created_at, updated_at
-		 FROM friendships
-		 WHERE (user_a = $1 OR user_b = $1) AND status = 'accepted'
-		 ORDER BY updated_at DESC`, userID,
+		`SELECT f.id, f.initiated_by, f.dm_channel_id, f.updated_at, u.id, u.username, u.display_name, u.avatar_path
+		 FROM friendships f
+		 JOIN users u ON u.id = CASE WHEN f.user_a = $1 THEN f.user_b ELSE f.user_a END
+		 WHERE (f.user_a = $1 OR f.user_b = $1) AND f.status = $2
+		 ORDER BY f.updated_at DESC`, userID, status,
 	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var friends []models.Friendship
+	var rels []models.Relationship
 	for rows.Next() {
-		var f models.Friendship
-		if err := rows.Scan(&f.ID, &f.UserA, &f.UserB, &f.Status, &f.InitiatedBy, &f.DMChannelID, &f.CreatedAt, &f.UpdatedAt); err != nil {
+		var rel models.Relationship
+		var id, initiatedBy uuid.UUID
+		if err := rows.Scan(&id, &initiatedBy, &rel.DMChannelID, &rel.Since, &rel.User.ID, &rel.User.Username, &rel.User.DisplayName, &rel.User.AvatarURL); err != nil {
 			return nil, err
 		}
-		friends = append(friends, f)
+		rel.ID = &id
+		switch {
+		case status == "accepted":
+			rel.Type = "friend"
+		case initiatedBy == userID:
+			rel.Type = "outgoing"
+		default:
+			rel.Type = "incoming"
+		}
+		rels = append(rels, rel)
+	}
+	return rels, rows.Err()
+}
+
+func (r *FriendshipRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx, `DELETE FROM friendships WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
 	}
-	return friends, rows.Err()
+	return nil
 }
 
 func (r *FriendshipRepo) GetByID(ctx context.Context, id uuid.UUID) (*models.Friendship, error) {
@@ -389,29 +660,15 @@ func (r *FriendshipRepo) SetDMChannelID(ctx context.Context, id, channelID uuid.
 // UserRepo helper: look up a user by username.
 func (r *UserRepo) GetByUsername(ctx context.Context, username string) (*models.User, error) {
 	u := &models.User{}
-	err := r.DB.QueryRowContext(ctx,
-		`SELECT id, username, display_name, avatar_path, tailscale_id, password_hash, status, created_at, updated_at
-		 FROM users WHERE username = $1`, username,
-	).Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarPath, &u.TailscaleID, &u.PasswordHash, &u.Status, &u.CreatedAt, &u.UpdatedAt)
+	err := scanUser(r.DB.QueryRowContext(ctx,
+		`SELECT `+userColumns+` FROM users u WHERE u.username = $1`, username,
+	), u)
 	if err != nil {
 		return nil, err
 	}
 	return u, nil
 }
 
-// ServerRepo helper: look up a server by invite code.
-func (r *ServerRepo) GetServerByInviteCode(ctx context.Context, code string) (*models.Server, error) {
-	s := &models.Server{}
-	err := r.DB.QueryRowContext(ctx,
-		`SELECT id, name, icon_path, owner_id, invite_code, created_at
-		 FROM servers WHERE invite_code = $1`, code,
-	).Scan(&s.ID, &s.Name, &s.IconPath, &s.OwnerID, &s.InviteCode, &s.CreatedAt)
-	if err != nil {
-		return nil, err
-	}
-	return s, nil
-}
-
 // DMMemberRepo handles DM channel membership.
 type DMMemberRepo struct {
 	DB *sql.DB
@@ -425,6 +682,241 @@ func (r *DMMemberRepo) AddMember(ctx context.Context, channelID, userID uuid.UUI
 	return err
 }
 
+func (r *DMMemberRepo) RemoveMember(ctx context.Context, channelID, userID uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx,
+		`DELETE FROM dm_members WHERE channel_id = $1 AND user_id = $2`,
+		channelID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListMembers returns the participants of a DM channel, longest-standing first.
+func (r *DMMemberRepo) ListMembers(ctx context.Context, channelID uuid.UUID) ([]models.UserSummary, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT u.id, u.username, u.display_name, u.avatar_path
+		 FROM dm_members dm
+		 JOIN users u ON u.id = dm.user_id
+		 WHERE dm.channel_id = $1
+		 ORDER BY dm.joined_at`, channelID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []models.UserSummary
+	for rows.Next() {
+		var u models.UserSummary
+		if err := rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarURL); err != nil {
+			return nil, err
+		}
+		members = append(members, u)
+	}
+	return members, rows.Err()
+}
+
+// FindDirectChannel returns the 1:1 DM channel between two users, if one exists.
+func (r *DMMemberRepo) FindDirectChannel(ctx context.Context, userA, userB uuid.UUID) (*uuid.UUID, error) {
+	var id uuid.UUID
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT c.id
+		 FROM channels c
+		 JOIN dm_members a ON a.channel_id = c.id AND a.user_id = $1
+		 JOIN dm_members b ON b.channel_id = c.id AND b.user_id = $2
+		 WHERE c.type = 'dm'
+		 LIMIT 1`, userA, userB,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// GetOrCreateSystemDM returns the ID of the user's DM with the system user,
+// creating it if there is none yet; created reports whether it was. When two
+// calls race, the system_dms key lets one create the DM and the other
+// return it.
+func (r *DMMemberRepo) GetOrCreateSystemDM(ctx context.Context, userID uuid.UUID) (id uuid.UUID, created bool, err error) {
+	err = r.DB.QueryRowContext(ctx,
+		`SELECT channel_id FROM system_dms WHERE user_id = $1`, userID,
+	).Scan(&id)
+	if err != sql.ErrNoRows {
+		return id, false, err
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	defer tx.Rollback()
+
+	id = uuid.New()
+	now := time.Now()
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO channels (id, type, created_at) VALUES ($1, 'dm', $2)`, id, now,
+	); err != nil {
+		return uuid.Nil, false, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO dm_members (channel_id, user_id, joined_at) VALUES ($1, $2, $4), ($1, $3, $4)`,
+		id, SystemUserID, userID, now,
+	); err != nil {
+		return uuid.Nil, false, err
+	}
+	result, err := tx.ExecContext(ctx,
+		`INSERT INTO system_dms (user_id, channel_id) VALUES ($1, $2) ON CONFLICT (user_id) DO NOTHING`,
+		userID, id,
+	)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	if n == 0 {
+		// Another call got there first; drop this channel and use theirs.
+		tx.Rollback()
+		err = r.DB.QueryRowContext(ctx,
+			`SELECT channel_id FROM system_dms WHERE user_id = $1`, userID,
+		).Scan(&id)
+		return id, false, err
+	}
+	return id, true, tx.Commit()
+}
+
+// ListUserChannels returns the DMs and group DMs the user belongs to, with
+// participants and the latest message, most recently active first. With
+// requests set it returns only the user's pending message requests;
+// otherwise those are left out.
+func (r *DMMemberRepo) ListUserChannels(ctx context.Context, userID uuid.UUID, requests bool) ([]models.DMChannel, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT c.id, c.server_id, c.name, c.topic, c.type, c.position, c.icon_path, c.owner_id, c.created_at,
+		        lm.id, lm.author_id, lm.content, lm.created_at, lm.ast
+		 FROM dm_members dm
+		 JOIN channels c ON c.id = dm.channel_id
+		 LEFT JOIN LATERAL (
+			SELECT id, author_id, content, created_at, ast FROM messages m
+			WHERE m.channel_id = c.id AND m.deleted_at IS NULL
+			ORDER BY m.created_at DESC
+			LIMIT 1
+		 ) lm ON true
+		 WHERE dm.user_id = $1 AND dm.message_request = $2
+		 ORDER BY COALESCE(lm.created_at, c.created_at) DESC`, userID, requests,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var channels []models.DMChannel
+	index := make(map[uuid.UUID]int)
+	for rows.Next() {
+		var dc models.DMChannel
+		c := &dc.Channel
+		var lmID, lmAuthor *uuid.UUID
+		var lmContent *string
+		var lmCreated *time.Time
+		var lmAST []byte
+		if err := rows.Scan(&c.ID, &c.ServerID, &c.Name, &c.Topic, &c.Type, &c.Position, &c.IconPath, &c.OwnerID, &c.CreatedAt,
+			&lmID, &lmAuthor, &lmContent, &lmCreated, &lmAST); err != nil {
+			return nil, err
+		}
+		if lmID != nil {
+			dc.LastMessage = &models.Message{
+				ID:        *lmID,
+				ChannelID: c.ID,
+				AuthorID:  *lmAuthor,
+				Content:   *lmContent,
+				CreatedAt: *lmCreated,
+				AST:       messageAST(lmAST, *lmContent),
+			}
+		}
+		dc.Recipients = []models.UserSummary{}
+		index[c.ID] = len(channels)
+		channels = append(channels, dc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	memberRows, err := r.DB.QueryContext(ctx,
+		`SELECT dm.channel_id, u.id, u.username, u.display_name, u.avatar_path
+		 FROM dm_members dm
+		 JOIN users u ON u.id = dm.user_id
+		 WHERE dm.channel_id IN (SELECT channel_id FROM dm_members WHERE user_id = $1)
+		 ORDER BY dm.joined_at`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer memberRows.Close()
+
+	for memberRows.Next() {
+		var channelID uuid.UUID
+		var u models.UserSummary
+		if err := memberRows.Scan(&channelID, &u.ID, &u.Username, &u.DisplayName, &u.AvatarURL); err != nil {
+			return nil, err
+		}
+		if i, ok := index[channelID]; ok {
+			channels[i].Recipients = append(channels[i].Recipients, u)
+		}
+	}
+	return channels, memberRows.Err()
+}
+
+// SetMessageRequest marks or clears a member's pending message request for a DM.
+func (r *DMMemberRepo) SetMessageRequest(ctx context.Context, channelID, userID uuid.UUID, pending bool) error {
+	result, err := r.DB.ExecContext(ctx,
+		`UPDATE dm_members SET message_request = $3 WHERE channel_id = $1 AND user_id = $2`,
+		channelID, userID, pending,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// IsMessageRequest reports whether the DM is still a pending message request for the user.
+func (r *DMMemberRepo) IsMessageRequest(ctx context.Context, channelID, userID uuid.UUID) (bool, error) {
+	var pending bool
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT message_request FROM dm_members WHERE channel_id = $1 AND user_id = $2`,
+		channelID, userID,
+	).Scan(&pending)
+	return pending, err
+}
+
+// OtherMember returns the other participant of a 1:1 DM channel.
+func (r *DMMemberRepo) OtherMember(ctx context.Context, channelID, userID uuid.UUID) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT user_id FROM dm_members WHERE channel_id = $1 AND user_id <> $2 LIMIT 1`,
+		channelID, userID,
+	).Scan(&id)
+	return id, err
+}
+
 func (r *DMMemberRepo) IsMember(ctx context.Context, channelID, userID uuid.UUID) (bool, error) {
 	var exists bool
 	err := r.DB.QueryRowContext(ctx,
@@ -439,71 +931,462 @@ type MessageRepo struct {
 	DB *sql.DB
 }
 
+// messageColumns selects a message joined to its author as u. Scan it with
+// scanMessage.
+const messageColumns = `m.id, m.channel_id, m.author_id, m.content, m.edited, m.edited_at, m.created_at, m.updated_at, m.embeds,
+	m.webhook_id, m.webhook_username, m.webhook_avatar_url, m.interaction, m.deleted_at, m.deleted_by, m.forwarded_from, m.sticker_id,
+	m.published_at, m.ast, u.username, u.display_name, u.avatar_path, u.bot`
+
+// scanMessage scans messageColumns, followed by any extra columns, into m.
+func scanMessage(row interface{ Scan(...any) error }, m *models.Message, extra ...any) error {
+	var embeds, interaction, forward, ast []byte
+	var webhookUsername, webhookAvatar *string
+	dest := []any{&m.ID, &m.ChannelID, &m.AuthorID, &m.Content, &m.Edited, &m.EditedAt, &m.CreatedAt, &m.UpdatedAt, &embeds,
+		&m.WebhookID, &webhookUsername, &webhookAvatar, &interaction, &m.DeletedAt, &m.DeletedBy, &forward, &m.StickerID,
+		&m.PublishedAt, &ast, &m.AuthorUsername, &m.AuthorDisplayName, &m.AuthorAvatarURL, &m.AuthorBot}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	m.AST = messageAST(ast, m.Content)
+	if embeds != nil {
+		if err := json.Unmarshal(embeds, &m.Embeds); err != nil {
+			return err
+		}
+	}
+	if interaction != nil {
+		m.Interaction = &models.MessageInteraction{}
+		if err := json.Unmarshal(interaction, m.Interaction); err != nil {
+			return err
+		}
+	}
+	if forward != nil {
+		m.ForwardedFrom = &models.MessageForward{}
+		if err := json.Unmarshal(forward, m.ForwardedFrom); err != nil {
+			return err
+		}
+	}
+	if webhookUsername != nil {
+		setWebhookAuthor(m, *webhookUsername, webhookAvatar)
+	}
+	return nil
+}
+
+// setWebhookAuthor shows a webhook message under the name and avatar it was
+// posted with rather than as the user who created the webhook.
+func setWebhookAuthor(m *models.Message, username string, avatarURL *string) {
+	m.AuthorUsername = username
+	m.AuthorDisplayName = nil
+	m.AuthorAvatarURL = avatarURL
+}
+
+// messageAST returns a message's stored AST. Messages written before ASTs
+// were stored have none, so their content is parsed instead.
+func messageAST(stored []byte, content string) json.RawMessage {
+	if stored != nil {
+		return stored
+	}
+	return markdown.Encode(content)
+}
+
+func marshalEmbeds(embeds []models.Embed) ([]byte, error) {
+	if len(embeds) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(embeds)
+}
+
+// Create inserts a message, giving it a new ID unless ID is already set.
+// For a webhook message (WebhookID set) the caller fills in AuthorUsername
+// and AuthorAvatarURL with the name and avatar to post as; otherwise they
+// are loaded from the author.
 func (r *MessageRepo) Create(ctx context.Context, m *models.Message) error {
-	m.ID = uuid.New()
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
 	now := time.Now()
 	m.CreatedAt = now
 	m.UpdatedAt = now
-	err := r.DB.QueryRowContext(ctx,
+	m.AST = markdown.Encode(m.Content)
+	embeds, err := marshalEmbeds(m.Embeds)
+	if err != nil {
+		return err
+	}
+	var interaction []byte
+	if m.Interaction != nil {
+		if interaction, err = json.Marshal(m.Interaction); err != nil {
+			return err
+		}
+	}
+	var forward []byte
+	if m.ForwardedFrom != nil {
+		if forward, err = json.Marshal(m.ForwardedFrom); err != nil {
+			return err
+		}
+	}
+
+	if m.WebhookID != nil {
+		setWebhookAuthor(m, m.AuthorUsername, m.AuthorAvatarURL)
+		_, err := r.DB.ExecContext(ctx,
+			`INSERT INTO messages (id, channel_id, author_id, content, edited, created_at, updated_at, embeds, webhook_id, webhook_username, webhook_avatar_url, forwarded_from, ast)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+			m.ID, m.ChannelID, m.AuthorID, m.Content, m.Edited, m.CreatedAt, m.UpdatedAt, embeds, m.WebhookID, m.AuthorUsername, m.AuthorAvatarURL, forward, []byte(m.AST),
+		)
+		return err
+	}
+
+	return r.DB.QueryRowContext(ctx,
 		`WITH ins AS (
-			INSERT INTO messages (id, channel_id, author_id, content, edited, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			INSERT INTO messages (id, channel_id, author_id, content, edited, created_at, updated_at, embeds, interaction, forwarded_from, sticker_id, ast)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
 			RETURNING author_id
 		)
-		SELECT u.username, u.display_name, u.avatar_path FROM ins JOIN users u ON u.id = ins.author_id`,
-		m.ID, m.ChannelID, m.AuthorID, m.Content, m.Edited, m.CreatedAt, m.UpdatedAt,
-	).Scan(&m.AuthorUsername, &m.AuthorDisplayName, &m.AuthorAvatarURL)
-	return err
+		SELECT u.username, u.display_name, u.avatar_path, u.bot FROM ins JOIN users u ON u.id = ins.author_id`,
+		m.ID, m.ChannelID, m.AuthorID, m.Content, m.Edited, m.CreatedAt, m.UpdatedAt, embeds, interaction, forward, m.StickerID, []byte(m.AST),
+	).Scan(&m.AuthorUsername, &m.AuthorDisplayName, &m.AuthorAvatarURL, &m.AuthorBot)
 }
 
+// GetByID returns a message, or sql.ErrNoRows if it does not exist or has
+// been deleted.
 func (r *MessageRepo) GetByID(ctx context.Context, id uuid.UUID) (*models.Message, error) {
 	m := &models.Message{}
-	err := r.DB.QueryRowContext(ctx,
-		`SELECT m.id, m.channel_id, m.author_id, m.content, m.edited, m.created_at, m.updated_at, u.username, u.display_name, u.avatar_path
+	err := scanMessage(r.DB.QueryRowContext(ctx,
+		`SELECT `+messageColumns+`
 		 FROM messages m
 		 JOIN users u ON u.id = m.author_id
-		 WHERE m.id = $1`, id,
-	).Scan(&m.ID, &m.ChannelID, &m.AuthorID, &m.Content, &m.Edited, &m.CreatedAt, &m.UpdatedAt, &m.AuthorUsername, &m.AuthorDisplayName, &m.AuthorAvatarURL)
+		 WHERE m.id = $1 AND m.deleted_at IS NULL`, id,
+	), m)
 	if err != nil {
 		return nil, err
 	}
 	return m, nil
 }
 
-func (r *MessageRepo) Update(ctx context.Context, messageID, authorID uuid.UUID, content string) (*models.Message, error) {
-	m := &models.Message{}
+// Publish marks a message in an announcement channel as published and, in
+// the same transaction, queues a cross-post of it for every channel that
+// follows its channel. It returns sql.ErrNoRows if the message is gone or
+// already published.
+func (r *MessageRepo) Publish(ctx context.Context, id uuid.UUID) (time.Time, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	var at time.Time
+	var channelID uuid.UUID
+	err = tx.QueryRowContext(ctx,
+		`UPDATE messages SET published_at = NOW()
+		 WHERE id = $1 AND published_at IS NULL AND deleted_at IS NULL
+		 RETURNING published_at, channel_id`, id,
+	).Scan(&at, &channelID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO crossposts (message_id, webhook_id, copy_id)
+		 SELECT $1, id, gen_random_uuid() FROM incoming_webhooks WHERE source_channel_id = $2`,
+		id, channelID,
+	); err != nil {
+		return time.Time{}, err
+	}
+	return at, tx.Commit()
+}
+
+// Exists reports whether a message with the ID exists, even in the trash.
+func (r *MessageRepo) Exists(ctx context.Context, id uuid.UUID) (bool, error) {
+	var exists bool
 	err := r.DB.QueryRowContext(ctx,
-		`WITH upd AS (
-			UPDATE messages SET content = $1, edited = true, updated_at = $2
-			WHERE id = $3 AND author_id = $4
-			RETURNING id, channel_id, author_id, content, edited, created_at, updated_at
-		)
-		SELECT upd.id, upd.channel_id, upd.author_id, upd.content, upd.edited, upd.created_at, upd.updated_at, u.username, u.display_name, u.avatar_path
-		FROM upd JOIN users u ON u.id = upd.author_id`,
-		content, time.Now(), messageID, authorID,
-	).Scan(&m.ID, &m.ChannelID, &m.AuthorID, &m.Content, &m.Edited, &m.CreatedAt, &m.UpdatedAt, &m.AuthorUsername, &m.AuthorDisplayName, &m.AuthorAvatarURL)
+		`SELECT EXISTS (SELECT 1 FROM messages WHERE id = $1)`, id,
+	).Scan(&exists)
+	return exists, err
+}
+
+// GetDeleted returns a message in the trash, or sql.ErrNoRows if it is not
+// there.
+func (r *MessageRepo) GetDeleted(ctx context.Context, id uuid.UUID) (*models.Message, error) {
+	m := &models.Message{}
+	err := scanMessage(r.DB.QueryRowContext(ctx,
+		`SELECT `+messageColumns+`
+		 FROM messages m
+		 JOIN users u ON u.id = m.author_id
+		 WHERE m.id = $1 AND m.deleted_at IS NOT NULL`, id,
+	), m)
 	if err != nil {
 		return nil, err
 	}
 	return m, nil
 }
 
-func (r *MessageRepo) Delete(ctx context.Context, messageID, authorID uuid.UUID) error {
-	result, err := r.DB.ExecContext(ctx,
-		`DELETE FROM messages WHERE id = $1 AND author_id = $2`,
-		messageID, authorID,
+// Update edits the content of one of the author's messages, archiving the
+// version it replaces as a revision. If embeds is not nil it replaces the
+// message's rich embeds, keeping its link previews. Webhook messages cannot
+// be edited, and neither can forwards, which quote another message, or
+// stickers.
+func (r *MessageRepo) Update(ctx context.Context, messageID, authorID uuid.UUID, content string, embeds *[]models.Embed) (*models.Message, error) {
+	var replace []byte
+	if embeds != nil {
+		var err error
+		if replace, err = json.Marshal(*embeds); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx,
+		`INSERT INTO message_revisions (message_id, content, embeds, created_at, replaced_at)
+		 SELECT id, content, embeds, COALESCE(edited_at, created_at), $3
+		 FROM messages
+		 WHERE id = $1 AND author_id = $2 AND webhook_id IS NULL AND forwarded_from IS NULL AND sticker_id IS NULL AND deleted_at IS NULL
+		 FOR UPDATE`,
+		messageID, authorID, now,
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	n, err := result.RowsAffected()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if n == 0 {
-		return sql.ErrNoRows
+		return nil, sql.ErrNoRows
 	}
-	return nil
+
+	m := &models.Message{}
+	err = scanMessage(tx.QueryRowContext(ctx,
+		`WITH m AS (
+			UPDATE messages SET content = $1, edited = true, edited_at = $2, updated_at = $2, ast = $5,
+				embeds = CASE WHEN $4::jsonb IS NULL THEN embeds ELSE (
+					SELECT NULLIF(COALESCE(jsonb_agg(e), '[]'::jsonb) || $4::jsonb, '[]'::jsonb)
+					FROM jsonb_array_elements(COALESCE(embeds, '[]'::jsonb)) e
+					WHERE e->>'type' IS DISTINCT FROM 'rich'
+				) END
+			WHERE id = $3
+			RETURNING *
+		)
+		SELECT `+messageColumns+`
+		FROM m JOIN users u ON u.id = m.author_id`,
+		content, now, messageID, replace, []byte(markdown.Encode(content)),
+	), m)
+	if err != nil {
+		return nil, err
+	}
+	return m, tx.Commit()
+}
+
+// ListRevisions returns a message's earlier versions, oldest first.
+func (r *MessageRepo) ListRevisions(ctx context.Context, messageID uuid.UUID) ([]models.MessageRevision, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT id, message_id, content, embeds, created_at, replaced_at
+		 FROM message_revisions WHERE message_id = $1
+		 ORDER BY replaced_at`, messageID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []models.MessageRevision
+	for rows.Next() {
+		var rev models.MessageRevision
+		var embeds []byte
+		if err := rows.Scan(&rev.ID, &rev.MessageID, &rev.Content, &embeds, &rev.CreatedAt, &rev.ReplacedAt); err != nil {
+			return nil, err
+		}
+		if embeds != nil {
+			if err := json.Unmarshal(embeds, &rev.Embeds); err != nil {
+				return nil, err
+			}
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// AppendEmbeds adds embeds to a message, returning all of its embeds. It
+// returns sql.ErrNoRows if the message has been deleted.
+func (r *MessageRepo) AppendEmbeds(ctx context.Context, messageID uuid.UUID, embeds []models.Embed) ([]models.Embed, error) {
+	add, err := json.Marshal(embeds)
+	if err != nil {
+		return nil, err
+	}
+	var raw []byte
+	err = r.DB.QueryRowContext(ctx,
+		`UPDATE messages SET embeds = COALESCE(embeds, '[]'::jsonb) || $2::jsonb
+		 WHERE id = $1 AND deleted_at IS NULL
+		 RETURNING embeds`,
+		messageID, add,
+	).Scan(&raw)
+	if err != nil {
+		return nil, err
+	}
+	var all []models.Embed
+	if err := json.Unmarshal(raw, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// DeleteMessages moves the listed messages of a channel to the trash,
+// ignoring IDs that are not in it or already deleted. It returns the IDs it
+// deleted.
+func (r *MessageRepo) DeleteMessages(ctx context.Context, channelID uuid.UUID, ids []uuid.UUID, deletedBy uuid.UUID) ([]uuid.UUID, error) {
+	strIDs := make([]string, len(ids))
+	for i, id := range ids {
+		strIDs[i] = id.String()
+	}
+	rows, err := r.DB.QueryContext(ctx,
+		`UPDATE messages SET deleted_at = NOW(), deleted_by = $3
+		 WHERE channel_id = $1 AND id = ANY($2::uuid[]) AND deleted_at IS NULL
+		 RETURNING id`,
+		channelID, strIDs, deletedBy,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deleted []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, id)
+	}
+	return deleted, rows.Err()
+}
+
+// Restore takes a message out of the trash. It returns sql.ErrNoRows if the
+// message is not there.
+func (r *MessageRepo) Restore(ctx context.Context, id uuid.UUID) (*models.Message, error) {
+	m := &models.Message{}
+	err := scanMessage(r.DB.QueryRowContext(ctx,
+		`WITH m AS (
+			UPDATE messages SET deleted_at = NULL, deleted_by = NULL
+			WHERE id = $1 AND deleted_at IS NOT NULL
+			RETURNING *
+		)
+		SELECT `+messageColumns+`
+		FROM m JOIN users u ON u.id = m.author_id`, id,
+	), m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ListDeleted returns a channel's trash, most recently deleted first,
+// optionally continuing after the given message.
+func (r *MessageRepo) ListDeleted(ctx context.Context, channelID uuid.UUID, before *uuid.UUID, limit int) ([]models.Message, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT `+messageColumns+`
+		 FROM messages m
+		 JOIN users u ON u.id = m.author_id
+		 WHERE m.channel_id = $1 AND m.deleted_at IS NOT NULL
+		   AND ($2::uuid IS NULL OR (m.deleted_at, m.id) < (SELECT deleted_at, id FROM messages WHERE id = $2))
+		 ORDER BY m.deleted_at DESC, m.id DESC
+		 LIMIT $3`, channelID, before, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		var m models.Message
+		if err := scanMessage(rows, &m); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// PurgeTrash permanently deletes up to limit messages deleted before the
+// cutoff. It returns how many it deleted and the upload paths of their
+// attachments that no forwarded copy or favorite GIF still uses, whose
+// files the caller removes.
+func (r *MessageRepo) PurgeTrash(ctx context.Context, cutoff time.Time, limit int) (int, []string, error) {
+	// The SELECT sees attachments as they were before the cascade.
+	rows, err := r.DB.QueryContext(ctx,
+		`WITH d AS (
+			DELETE FROM messages WHERE id IN (
+				SELECT id FROM messages
+				WHERE deleted_at < $1
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id
+		)
+		SELECT d.id, a.file_path FROM d
+		LEFT JOIN attachments a ON a.message_id = d.id
+			AND NOT EXISTS (
+				SELECT 1 FROM attachments o
+				WHERE o.file_path = a.file_path AND o.message_id NOT IN (SELECT id FROM d)
+			)
+			AND NOT EXISTS (SELECT 1 FROM favorite_gifs f WHERE f.file_path = a.file_path)`,
+		cutoff, limit,
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var files []string
+	seen := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var id uuid.UUID
+		var path *string
+		if err := rows.Scan(&id, &path); err != nil {
+			return 0, nil, err
+		}
+		seen[id] = true
+		if path != nil {
+			files = append(files, *path)
+		}
+	}
+	return len(seen), files, rows.Err()
+}
+
+// PurgeCandidate is what purge filters look at in a message.
+type PurgeCandidate struct {
+	ID             uuid.UUID
+	AuthorID       uuid.UUID
+	Content        string
+	HasAttachments bool
+}
+
+// ListPurgeCandidates returns a channel's last limit messages, newest first.
+func (r *MessageRepo) ListPurgeCandidates(ctx context.Context, channelID uuid.UUID, limit int) ([]PurgeCandidate, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT m.id, m.author_id, m.content,
+			EXISTS (SELECT 1 FROM attachments a WHERE a.message_id = m.id)
+		 FROM messages m
+		 WHERE m.channel_id = $1 AND m.deleted_at IS NULL
+		 ORDER BY m.created_at DESC
+		 LIMIT $2`, channelID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []PurgeCandidate
+	for rows.Next() {
+		var c PurgeCandidate
+		if err := rows.Scan(&c.ID, &c.AuthorID, &c.Content, &c.HasAttachments); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
 }
 
 func (r *MessageRepo) ListByChannel(ctx context.Context, channelID uuid.UUID, before *uuid.UUID, limit int) ([]models.Message, error) {
@@ -512,20 +1395,20 @@ func (r *MessageRepo) ListByChannel(ctx context.Context, channelID uuid.UUID, be
 
 	if before != nil {
 		rows, err = r.DB.QueryContext(ctx,
-			`SELECT m.id, m.channel_id, m.author_id, m.content, m.edited, m.created_at, m.updated_at, u.username, u.display_name, u.avatar_path
+			`SELECT `+messageColumns+`
 			 FROM messages m
 			 JOIN users u ON u.id = m.author_id
-			 WHERE m.channel_id = $1
+			 WHERE m.channel_id = $1 AND m.deleted_at IS NULL
 			   AND m.created_at < (SELECT created_at FROM messages WHERE id = $2)
 			 ORDER BY m.created_at DESC
 			 LIMIT $3`, channelID, *before, limit,
 		)
 	} else {
 		rows, err = r.DB.QueryContext(ctx,
-			`SELECT m.id, m.channel_id, m.author_id, m.content, m.edited, m.created_at, m.updated_at, u.username, u.display_name, u.avatar_path
+			`SELECT `+messageColumns+`
 			 FROM messages m
 			 JOIN users u ON u.id = m.author_id
-			 WHERE m.channel_id = $1
+			 WHERE m.channel_id = $1 AND m.deleted_at IS NULL
 			 ORDER BY m.created_at DESC
 			 LIMIT $2`, channelID, limit,
 		)
@@ -538,7 +1421,7 @@ func (r *MessageRepo) ListByChannel(ctx context.Context, channelID uuid.UUID, be
 	var messages []models.Message
 	for rows.Next() {
 		var m models.Message
-		if err := rows.Scan(&m.ID, &m.ChannelID, &m.AuthorID, &m.Content, &m.Edited, &m.CreatedAt, &m.UpdatedAt, &m.AuthorUsername, &m.AuthorDisplayName, &m.AuthorAvatarURL); err != nil {
+		if err := scanMessage(rows, &m); err != nil {
 			return nil, err
 		}
 		messages = append(messages, m)
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// RoleRepo handles server roles and role assignments.
+type RoleRepo struct {
+	DB *sql.DB
+}
+
+func (r *RoleRepo) Create(ctx context.Context, role *models.Role) error {
+	role.ID = uuid.New()
+	role.CreatedAt = time.Now()
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO roles (id, server_id, name, color, permissions, position, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		role.ID, role.ServerID, role.Name, role.Color, role.Permissions, role.Position, role.CreatedAt,
+	)
+	return err
+}
+
+func (r *RoleRepo) GetByID(ctx context.Context, id uuid.UUID) (*models.Role, error) {
+	role := &models.Role{}
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT id, server_id, name, color, permissions, position, created_at
+		 FROM roles WHERE id = $1`, id,
+	).Scan(&role.ID, &role.ServerID, &role.Name, &role.Color, &role.Permissions, &role.Position, &role.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (r *RoleRepo) ListByServer(ctx context.Context, serverID uuid.UUID) ([]models.Role, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT id, server_id, name, color, permissions, position, created_at
+		 FROM roles WHERE server_id = $1
+		 ORDER BY position DESC, created_at`, serverID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var role models.Role
+		if err := rows.Scan(&role.ID, &role.ServerID, &role.Name, &role.Color, &role.Permissions, &role.Position, &role.CreatedAt); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+func (r *RoleRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx, `DELETE FROM roles WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AssignRole gives a member a role. Assigning a role the member already holds is a no-op.
+func (r *RoleRepo) AssignRole(ctx context.Context, userID, serverID, roleID uuid.UUID) error {
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO member_roles (user_id, server_id, role_id) VALUES ($1, $2, $3)
+		 ON CONFLICT DO NOTHING`,
+		userID, serverID, roleID,
+	)
+	return err
+}
+
+func (r *RoleRepo) RemoveRole(ctx context.Context, userID, serverID, roleID uuid.UUID) error {
+	_, err := r.DB.ExecContext(ctx,
+		`DELETE FROM member_roles WHERE user_id = $1 AND server_id = $2 AND role_id = $3`,
+		userID, serverID, roleID,
+	)
+	return err
+}
+
+// GetMemberPermissions returns the union of permission bits of every role the user holds in the server.
+func (r *RoleRepo) GetMemberPermissions(ctx context.Context, userID, serverID uuid.UUID) (int64, error) {
+	var perms int64
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT COALESCE(BIT_OR(r.permissions), 0)
+		 FROM member_roles mr
+		 JOIN roles r ON r.id = mr.role_id
+		 WHERE mr.user_id = $1 AND mr.server_id = $2`,
+		userID, serverID,
+	).Scan(&perms)
+	return perms, err
+}
+
+// ListRoleMembers returns the IDs of the users holding a role.
+func (r *RoleRepo) ListRoleMembers(ctx context.Context, roleID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT user_id FROM member_roles WHERE role_id = $1`, roleID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
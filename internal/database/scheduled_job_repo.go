@@ -0,0 +1,178 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// ScheduledJobRepo handles scheduled messages and reminders.
+type ScheduledJobRepo struct {
+	DB *sql.DB
+}
+
+const scheduledJobColumns = `id, kind, user_id, channel_id, message_id, content, run_at, status, attempts, last_error, created_at`
+
+func scanScheduledJob(row interface{ Scan(...any) error }, j *models.ScheduledJob) error {
+	return row.Scan(&j.ID, &j.Kind, &j.UserID, &j.ChannelID, &j.MessageID, &j.Content, &j.RunAt, &j.Status, &j.Attempts, &j.LastError, &j.CreatedAt)
+}
+
+func (r *ScheduledJobRepo) Create(ctx context.Context, j *models.ScheduledJob) error {
+	j.ID = uuid.New()
+	j.Status = "pending"
+	j.CreatedAt = time.Now()
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO scheduled_jobs (id, kind, user_id, channel_id, message_id, content, run_at, status, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		j.ID, j.Kind, j.UserID, j.ChannelID, j.MessageID, j.Content, j.RunAt, j.Status, j.CreatedAt,
+	)
+	return err
+}
+
+// CountPending returns how many of the user's jobs have yet to run.
+func (r *ScheduledJobRepo) CountPending(ctx context.Context, userID uuid.UUID) (int, error) {
+	var n int
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM scheduled_jobs WHERE user_id = $1 AND status = 'pending'`, userID,
+	).Scan(&n)
+	return n, err
+}
+
+// ListByUser returns the user's jobs, soonest first, optionally only those of
+// one kind.
+func (r *ScheduledJobRepo) ListByUser(ctx context.Context, userID uuid.UUID, kind string) ([]models.ScheduledJob, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT `+scheduledJobColumns+`
+		 FROM scheduled_jobs
+		 WHERE user_id = $1 AND ($2 = '' OR kind = $2)
+		 ORDER BY run_at`, userID, kind,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []models.ScheduledJob
+	for rows.Next() {
+		var j models.ScheduledJob
+		if err := scanScheduledJob(rows, &j); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// Get returns one of the user's jobs.
+func (r *ScheduledJobRepo) Get(ctx context.Context, id, userID uuid.UUID) (*models.ScheduledJob, error) {
+	j := &models.ScheduledJob{}
+	err := scanScheduledJob(r.DB.QueryRowContext(ctx,
+		`SELECT `+scheduledJobColumns+` FROM scheduled_jobs WHERE id = $1 AND user_id = $2`, id, userID,
+	), j)
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Update changes one of the user's jobs and sets it pending again, so
+// editing a failed job retries it. It returns sql.ErrNoRows if the job does
+// not exist or is running right now.
+func (r *ScheduledJobRepo) Update(ctx context.Context, id, userID uuid.UUID, content string, runAt time.Time) (*models.ScheduledJob, error) {
+	j := &models.ScheduledJob{}
+	err := scanScheduledJob(r.DB.QueryRowContext(ctx,
+		`UPDATE scheduled_jobs
+		 SET content = $3, run_at = $4, status = 'pending', attempts = 0, last_error = NULL, locked_until = NULL
+		 WHERE id = $1 AND user_id = $2 AND (locked_until IS NULL OR locked_until < NOW())
+		 RETURNING `+scheduledJobColumns,
+		id, userID, content, runAt,
+	), j)
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Delete cancels one of the user's jobs. It returns sql.ErrNoRows if the job
+// does not exist or is running right now.
+func (r *ScheduledJobRepo) Delete(ctx context.Context, id, userID uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx,
+		`DELETE FROM scheduled_jobs
+		 WHERE id = $1 AND user_id = $2 AND (locked_until IS NULL OR locked_until < NOW())`,
+		id, userID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ClaimDue locks up to limit pending jobs that are due for lease, so another
+// scheduler (or this one after a crash) only picks them up again once the
+// lease runs out.
+func (r *ScheduledJobRepo) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]models.ScheduledJob, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`WITH due AS (
+			SELECT id FROM scheduled_jobs
+			WHERE status = 'pending' AND run_at <= NOW()
+			  AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY run_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE scheduled_jobs j
+		SET locked_until = NOW() + $2 * INTERVAL '1 second', attempts = j.attempts + 1
+		FROM due
+		WHERE j.id = due.id
+		RETURNING j.id, j.kind, j.user_id, j.channel_id, j.message_id, j.content, j.run_at, j.status, j.attempts, j.last_error, j.created_at`,
+		limit, lease.Seconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []models.ScheduledJob
+	for rows.Next() {
+		var j models.ScheduledJob
+		if err := scanScheduledJob(rows, &j); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// Finish removes a job that has run.
+func (r *ScheduledJobRepo) Finish(ctx context.Context, id uuid.UUID) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM scheduled_jobs WHERE id = $1`, id)
+	return err
+}
+
+// MarkFailed records why a job could not run and stops it being retried.
+func (r *ScheduledJobRepo) MarkFailed(ctx context.Context, id uuid.UUID, reason string) error {
+	_, err := r.DB.ExecContext(ctx,
+		`UPDATE scheduled_jobs SET status = 'failed', last_error = $2, locked_until = NULL WHERE id = $1`,
+		id, reason,
+	)
+	return err
+}
+
+// MarkRetry records an error from an attempt that will be retried once the
+// job's lease runs out.
+func (r *ScheduledJobRepo) MarkRetry(ctx context.Context, id uuid.UUID, reason string) error {
+	_, err := r.DB.ExecContext(ctx,
+		`UPDATE scheduled_jobs SET last_error = $2 WHERE id = $1`, id, reason,
+	)
+	return err
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SlowmodeRepo tracks when users last posted in slowmode channels.
+type SlowmodeRepo struct {
+	DB *sql.DB
+}
+
+// Take records a post by the user in the channel now if interval has passed
+// since their last one, returning the time recorded. Otherwise it records
+// nothing and returns how long is left. The check and the update are one
+// statement, so concurrent posts can't both get through.
+func (r *SlowmodeRepo) Take(ctx context.Context, channelID, userID uuid.UUID, interval time.Duration) (time.Time, time.Duration, error) {
+	var postedAt time.Time
+	err := r.DB.QueryRowContext(ctx,
+		`INSERT INTO slowmode_posts (channel_id, user_id, posted_at) VALUES ($1, $2, NOW())
+		 ON CONFLICT (channel_id, user_id) DO UPDATE SET posted_at = EXCLUDED.posted_at
+		 WHERE slowmode_posts.posted_at <= NOW() - $3 * INTERVAL '1 second'
+		 RETURNING posted_at`,
+		channelID, userID, interval.Seconds(),
+	).Scan(&postedAt)
+	if err == nil {
+		return postedAt, 0, nil
+	}
+	if err != sql.ErrNoRows {
+		return time.Time{}, 0, err
+	}
+
+	var wait float64
+	err = r.DB.QueryRowContext(ctx,
+		`SELECT EXTRACT(EPOCH FROM posted_at + $3 * INTERVAL '1 second' - NOW())
+		 FROM slowmode_posts WHERE channel_id = $1 AND user_id = $2`,
+		channelID, userID, interval.Seconds(),
+	).Scan(&wait)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	// The last post can age out between the two statements; never report a
+	// wait of nothing for a post that was refused.
+	return time.Time{}, max(time.Duration(wait*float64(time.Second)), time.Millisecond), nil
+}
+
+// Release forgets a post recorded by Take that was never made, so it doesn't
+// hold the user back. A later post recorded since is kept.
+func (r *SlowmodeRepo) Release(ctx context.Context, channelID, userID uuid.UUID, postedAt time.Time) error {
+	_, err := r.DB.ExecContext(ctx,
+		`DELETE FROM slowmode_posts WHERE channel_id = $1 AND user_id = $2 AND posted_at = $3`,
+		channelID, userID, postedAt,
+	)
+	return err
+}
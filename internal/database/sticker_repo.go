@@ -0,0 +1,142 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// StickerRepo handles server sticker packs.
+type StickerRepo struct {
+	DB *sql.DB
+}
+
+const stickerColumns = `id, server_id, name, description, format, file_path, file_size, created_by, created_at`
+
+func scanSticker(row interface{ Scan(...any) error }, st *models.Sticker) error {
+	return row.Scan(&st.ID, &st.ServerID, &st.Name, &st.Description, &st.Format, &st.FilePath, &st.FileSize, &st.CreatedBy, &st.CreatedAt)
+}
+
+func (r *StickerRepo) Create(ctx context.Context, st *models.Sticker) error {
+	st.ID = uuid.New()
+	st.CreatedAt = time.Now()
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO stickers (id, server_id, name, description, format, file_path, file_size, created_by, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		st.ID, st.ServerID, st.Name, st.Description, st.Format, st.FilePath, st.FileSize, st.CreatedBy, st.CreatedAt,
+	)
+	return err
+}
+
+// Usage returns how many stickers a server has and how many bytes they take.
+func (r *StickerRepo) Usage(ctx context.Context, serverID uuid.UUID) (int, int64, error) {
+	var n int
+	var size int64
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*), COALESCE(SUM(file_size), 0) FROM stickers WHERE server_id = $1`, serverID,
+	).Scan(&n, &size)
+	return n, size, err
+}
+
+// NameTaken reports whether another of the server's stickers has the name.
+func (r *StickerRepo) NameTaken(ctx context.Context, serverID uuid.UUID, name string, except uuid.UUID) (bool, error) {
+	var taken bool
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM stickers WHERE server_id = $1 AND LOWER(name) = LOWER($2) AND id <> $3)`,
+		serverID, name, except,
+	).Scan(&taken)
+	return taken, err
+}
+
+func (r *StickerRepo) ListByServer(ctx context.Context, serverID uuid.UUID) ([]models.Sticker, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT `+stickerColumns+` FROM stickers WHERE server_id = $1 ORDER BY created_at`, serverID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stickers []models.Sticker
+	for rows.Next() {
+		var st models.Sticker
+		if err := scanSticker(rows, &st); err != nil {
+			return nil, err
+		}
+		stickers = append(stickers, st)
+	}
+	return stickers, rows.Err()
+}
+
+// ListByIDs returns the stickers with the given IDs, keyed by ID. Stickers
+// that have been deleted are left out.
+func (r *StickerRepo) ListByIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*models.Sticker, error) {
+	stickers := make(map[uuid.UUID]*models.Sticker)
+	if len(ids) == 0 {
+		return stickers, nil
+	}
+	strs := make([]string, len(ids))
+	for i, id := range ids {
+		strs[i] = id.String()
+	}
+
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT `+stickerColumns+` FROM stickers WHERE id = ANY($1::uuid[])`, strs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		st := &models.Sticker{}
+		if err := scanSticker(rows, st); err != nil {
+			return nil, err
+		}
+		stickers[st.ID] = st
+	}
+	return stickers, rows.Err()
+}
+
+func (r *StickerRepo) GetByID(ctx context.Context, id uuid.UUID) (*models.Sticker, error) {
+	st := &models.Sticker{}
+	err := scanSticker(r.DB.QueryRowContext(ctx,
+		`SELECT `+stickerColumns+` FROM stickers WHERE id = $1`, id,
+	), st)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Update renames one of a server's stickers and sets its description.
+func (r *StickerRepo) Update(ctx context.Context, serverID, id uuid.UUID, name string, description *string) (*models.Sticker, error) {
+	st := &models.Sticker{}
+	err := scanSticker(r.DB.QueryRowContext(ctx,
+		`UPDATE stickers SET name = $3, description = $4
+		 WHERE id = $1 AND server_id = $2
+		 RETURNING `+stickerColumns,
+		id, serverID, name, description,
+	), st)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Delete removes one of a server's stickers and returns it, so the caller
+// can remove its file. Messages that sent it are left without one.
+func (r *StickerRepo) Delete(ctx context.Context, serverID, id uuid.UUID) (*models.Sticker, error) {
+	st := &models.Sticker{}
+	err := scanSticker(r.DB.QueryRowContext(ctx,
+		`DELETE FROM stickers WHERE id = $1 AND server_id = $2 RETURNING `+stickerColumns,
+		id, serverID,
+	), st)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
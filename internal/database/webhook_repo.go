@@ -0,0 +1,328 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// OutgoingWebhookRepo handles outgoing webhooks and their delivery queue.
+type OutgoingWebhookRepo struct {
+	DB *sql.DB
+}
+
+const webhookColumns = `id, server_id, channel_id, name, url, secret, events, enabled, failure_count, disabled_reason, last_success_at, created_by, created_at`
+
+func scanWebhook(row interface{ Scan(...any) error }) (*models.OutgoingWebhook, error) {
+	h := &models.OutgoingWebhook{}
+	var events []byte
+	if err := row.Scan(&h.ID, &h.ServerID, &h.ChannelID, &h.Name, &h.URL, &h.Secret, &events, &h.Enabled, &h.FailureCount, &h.DisabledReason, &h.LastSuccessAt, &h.CreatedBy, &h.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(events, &h.Events); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (r *OutgoingWebhookRepo) Create(ctx context.Context, h *models.OutgoingWebhook) error {
+	h.ID = uuid.New()
+	h.CreatedAt = time.Now()
+	h.Enabled = true
+	events, err := json.Marshal(h.Events)
+	if err != nil {
+		return err
+	}
+	_, err = r.DB.ExecContext(ctx,
+		`INSERT INTO outgoing_webhooks (id, server_id, channel_id, name, url, secret, events, enabled, created_by, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		h.ID, h.ServerID, h.ChannelID, h.Name, h.URL, h.Secret, events, h.Enabled, h.CreatedBy, h.CreatedAt,
+	)
+	return err
+}
+
+func (r *OutgoingWebhookRepo) GetByID(ctx context.Context, id uuid.UUID) (*models.OutgoingWebhook, error) {
+	return scanWebhook(r.DB.QueryRowContext(ctx,
+		`SELECT `+webhookColumns+` FROM outgoing_webhooks WHERE id = $1`, id,
+	))
+}
+
+func (r *OutgoingWebhookRepo) ListByServer(ctx context.Context, serverID uuid.UUID) ([]models.OutgoingWebhook, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT `+webhookColumns+` FROM outgoing_webhooks WHERE server_id = $1 ORDER BY created_at`, serverID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []models.OutgoingWebhook
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, *h)
+	}
+	return hooks, rows.Err()
+}
+
+// Update saves a webhook's editable fields. Re-enabling clears its failure
+// count and disabled reason.
+func (r *OutgoingWebhookRepo) Update(ctx context.Context, h *models.OutgoingWebhook) error {
+	events, err := json.Marshal(h.Events)
+	if err != nil {
+		return err
+	}
+	if h.Enabled {
+		h.FailureCount = 0
+		h.DisabledReason = nil
+	}
+	_, err = r.DB.ExecContext(ctx,
+		`UPDATE outgoing_webhooks
+		 SET channel_id = $2, name = $3, url = $4, secret = $5, events = $6, enabled = $7,
+		     failure_count = $8, disabled_reason = $9
+		 WHERE id = $1`,
+		h.ID, h.ChannelID, h.Name, h.URL, h.Secret, events, h.Enabled, h.FailureCount, h.DisabledReason,
+	)
+	return err
+}
+
+func (r *OutgoingWebhookRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx, `DELETE FROM outgoing_webhooks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Enqueue queues event for every enabled webhook of the server subscribed to
+// it, scoped to channelID when the webhook has a channel. payloadFn builds
+// each delivery's body from its ID. It returns how many deliveries were queued.
+func (r *OutgoingWebhookRepo) Enqueue(ctx context.Context, serverID uuid.UUID, channelID *uuid.UUID, event string, payloadFn func(deliveryID uuid.UUID) ([]byte, error)) (int, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT id FROM outgoing_webhooks
+		 WHERE server_id = $1 AND enabled
+		   AND (channel_id IS NULL OR channel_id = $2)
+		   AND events @> jsonb_build_array($3::text)`,
+		serverID, channelID, event,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var hookIDs []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		hookIDs = append(hookIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, hookID := range hookIDs {
+		id := uuid.New()
+		payload, err := payloadFn(id)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := r.DB.ExecContext(ctx,
+			`INSERT INTO webhook_deliveries (id, webhook_id, event, payload, created_at, next_attempt_at)
+			 VALUES ($1, $2, $3, $4, NOW(), NOW())`,
+			id, hookID, event, payload,
+		); err != nil {
+			return 0, err
+		}
+	}
+	return len(hookIDs), nil
+}
+
+// DueDelivery is a claimed delivery with what is needed to send it.
+type DueDelivery struct {
+	models.WebhookDelivery
+	URL    string
+	Secret string
+}
+
+// ClaimDue locks up to limit pending deliveries that are due and pushes their
+// next attempt back by lease, so another worker (or this one after a crash)
+// only retries them once the lease runs out.
+func (r *OutgoingWebhookRepo) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]DueDelivery, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`WITH due AS (
+			SELECT d.id FROM webhook_deliveries d
+			JOIN outgoing_webhooks h ON h.id = d.webhook_id
+			WHERE d.status = 'pending' AND d.next_attempt_at <= NOW() AND h.enabled
+			ORDER BY d.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		FROM due, outgoing_webhooks h
+		WHERE d.id = due.id AND h.id = d.webhook_id
+		RETURNING d.id, d.webhook_id, d.event, d.payload, d.attempts, d.created_at, h.url, h.secret`,
+		limit, lease.Seconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []DueDelivery
+	for rows.Next() {
+		var d DueDelivery
+		var payload []byte
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Attempts, &d.CreatedAt, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		d.Payload = payload
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+// ExtendLease pushes a claimed delivery's next attempt back to lease from
+// now. It returns sql.ErrNoRows if the delivery is no longer pending, such as
+// when its webhook was disabled.
+func (r *OutgoingWebhookRepo) ExtendLease(ctx context.Context, d *DueDelivery, lease time.Duration) error {
+	result, err := r.DB.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		 WHERE id = $1 AND status = 'pending'`,
+		d.ID, lease.Seconds(),
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MarkSucceeded records a successful delivery and resets the webhook's failure count.
+func (r *OutgoingWebhookRepo) MarkSucceeded(ctx context.Context, d *DueDelivery, status int) error {
+	if _, err := r.DB.ExecContext(ctx,
+		`UPDATE webhook_deliveries
+		 SET status = 'succeeded', attempts = attempts + 1, response_status = $2, last_error = NULL, delivered_at = NOW()
+		 WHERE id = $1`,
+		d.ID, status,
+	); err != nil {
+		return err
+	}
+	_, err := r.DB.ExecContext(ctx,
+		`UPDATE outgoing_webhooks SET failure_count = 0, last_success_at = NOW() WHERE id = $1`,
+		d.WebhookID,
+	)
+	return err
+}
+
+// MarkRetry records a failed attempt and schedules the next one.
+func (r *OutgoingWebhookRepo) MarkRetry(ctx context.Context, d *DueDelivery, status *int, errMsg string, next time.Time) error {
+	_, err := r.DB.ExecContext(ctx,
+		`UPDATE webhook_deliveries
+		 SET attempts = attempts + 1, response_status = $2, last_error = $3, next_attempt_at = $4
+		 WHERE id = $1`,
+		d.ID, status, errMsg, next,
+	)
+	return err
+}
+
+// MarkFailed records a delivery that ran out of attempts and counts it
+// against the webhook. Once the webhook has failed disableAfter deliveries in
+// a row it is disabled and its other pending deliveries are dropped; the
+// return value reports whether that happened.
+func (r *OutgoingWebhookRepo) MarkFailed(ctx context.Context, d *DueDelivery, status *int, errMsg string, disableAfter int) (bool, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE webhook_deliveries
+		 SET status = 'failed', attempts = attempts + 1, response_status = $2, last_error = $3
+		 WHERE id = $1`,
+		d.ID, status, errMsg,
+	); err != nil {
+		return false, err
+	}
+
+	var failures int
+	if err := tx.QueryRowContext(ctx,
+		`UPDATE outgoing_webhooks SET failure_count = failure_count + 1 WHERE id = $1 RETURNING failure_count`,
+		d.WebhookID,
+	).Scan(&failures); err != nil {
+		return false, err
+	}
+
+	disabled := failures >= disableAfter
+	if disabled {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE outgoing_webhooks SET enabled = FALSE, disabled_reason = $2 WHERE id = $1`,
+			d.WebhookID, "disabled after repeated delivery failures",
+		); err != nil {
+			return false, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE webhook_deliveries SET status = 'failed', last_error = 'webhook disabled'
+			 WHERE webhook_id = $1 AND status = 'pending'`,
+			d.WebhookID,
+		); err != nil {
+			return false, err
+		}
+	}
+	return disabled, tx.Commit()
+}
+
+// ListDeliveries returns a webhook's most recent deliveries, newest first.
+func (r *OutgoingWebhookRepo) ListDeliveries(ctx context.Context, webhookID uuid.UUID, limit int) ([]models.WebhookDelivery, error) {
+	rows, err := r.DB.QueryContext(ctx,
+		`SELECT id, webhook_id, event, payload, status, attempts, next_attempt_at, response_status, last_error, created_at, delivered_at
+		 FROM webhook_deliveries WHERE webhook_id = $1
+		 ORDER BY created_at DESC
+		 LIMIT $2`, webhookID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		var d models.WebhookDelivery
+		var payload []byte
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.ResponseStatus, &d.LastError, &d.CreatedAt, &d.DeliveredAt); err != nil {
+			return nil, err
+		}
+		d.Payload = payload
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// PruneDeliveries deletes finished deliveries older than the cutoff.
+func (r *OutgoingWebhookRepo) PruneDeliveries(ctx context.Context, before time.Time) error {
+	_, err := r.DB.ExecContext(ctx,
+		`DELETE FROM webhook_deliveries WHERE status <> 'pending' AND created_at < $1`, before,
+	)
+	return err
+}
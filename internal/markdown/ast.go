@@ -0,0 +1,82 @@
+// Package markdown parses the Discord-flavored Markdown used in messages
+// into an AST that clients render from, so every client agrees on what a
+// message says and the server can pull plain text out of it.
+//
+// Parsing never fails: anything that is not valid markup is kept as text.
+package markdown
+
+// Kind identifies what a node is. The values are part of the API and must
+// not change.
+type Kind string
+
+// Block nodes.
+const (
+	KindParagraph  Kind = "paragraph"
+	KindHeading    Kind = "heading"
+	KindBlockQuote Kind = "block_quote"
+	KindCodeBlock  Kind = "code_block"
+	KindList       Kind = "list"
+	KindListItem   Kind = "list_item"
+)
+
+// Inline nodes.
+const (
+	KindText           Kind = "text"
+	KindStrong         Kind = "strong"
+	KindEmphasis       Kind = "em"
+	KindUnderline      Kind = "underline"
+	KindStrikethrough  Kind = "strike"
+	KindSpoiler        Kind = "spoiler"
+	KindCode           Kind = "code"
+	KindLink           Kind = "link"
+	KindLineBreak      Kind = "br"
+	KindUserMention    Kind = "user_mention"
+	KindRoleMention    Kind = "role_mention"
+	KindChannelMention Kind = "channel_mention"
+	KindEveryone       Kind = "everyone"
+	KindHere           Kind = "here"
+	KindEmoji          Kind = "emoji"
+	KindTimestamp      Kind = "timestamp"
+)
+
+// Node is one element of a parsed message. Which fields are set depends on
+// its Kind:
+//
+//   - text, code and code_block carry Text; code_block also Lang
+//   - heading carries Level (1 to 3)
+//   - list carries Ordered and, for ordered lists, Start
+//   - link carries URL, with its label as children, and NoEmbed when the
+//     sender wrapped the URL in <...> to keep it from getting a preview
+//   - user_mention, role_mention and channel_mention carry ID
+//   - emoji carries ID, Name and Animated
+//   - timestamp carries Timestamp (Unix seconds) and Format, one of the
+//     Discord style letters t, T, d, D, f, F or R; f when none was given
+//
+// Everything else only has children. A list item's children are its inline
+// content followed by any nested list.
+type Node struct {
+	Type      Kind    `json:"type"`
+	Children  []*Node `json:"children,omitempty"`
+	Text      string  `json:"text,omitempty"`
+	Lang      string  `json:"lang,omitempty"`
+	Level     int     `json:"level,omitempty"`
+	Ordered   bool    `json:"ordered,omitempty"`
+	Start     int     `json:"start,omitempty"`
+	URL       string  `json:"url,omitempty"`
+	NoEmbed   bool    `json:"no_embed,omitempty"`
+	ID        string  `json:"id,omitempty"`
+	Name      string  `json:"name,omitempty"`
+	Animated  bool    `json:"animated,omitempty"`
+	Timestamp int64   `json:"timestamp,omitempty"`
+	Format    string  `json:"format,omitempty"`
+}
+
+// Walk calls fn for each node in depth-first order, descending into a
+// node's children only if fn returns true.
+func Walk(nodes []*Node, fn func(*Node) bool) {
+	for _, n := range nodes {
+		if fn(n) {
+			Walk(n.Children, fn)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package markdown
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const uuidPattern = `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`
+
+var (
+	userMentionRe    = regexp.MustCompile(`^<@!?(` + uuidPattern + `)>`)
+	roleMentionRe    = regexp.MustCompile(`^<@&(` + uuidPattern + `)>`)
+	channelMentionRe = regexp.MustCompile(`^<#(` + uuidPattern + `)>`)
+	emojiRe          = regexp.MustCompile(`^<(a?):(\w{2,32}):(` + uuidPattern + `)>`)
+	timestampRe      = regexp.MustCompile(`^<t:(-?\d{1,13})(?::([tTdDfFR]))?>`)
+	angleLinkRe      = regexp.MustCompile(`^<(https?://[^\s<>]+)>`)
+	everyoneRe       = regexp.MustCompile(`^@(everyone|here)\b`)
+	maskedLinkRe     = regexp.MustCompile(`^\[((?:\\.|[^\[\]\\])+)\]\((<?)(https?://[^\s()<>]+)>?\)`)
+	urlRe            = regexp.MustCompile(`^https?://[^\s<>]*[^\s<>.,:;"'!?)\]*_~|]`)
+)
+
+// spans are the paired delimiters, longest first so "**" is tried before
+// "*".
+var spans = []struct {
+	delim string
+	kind  Kind
+}{
+	{"**", KindStrong},
+	{"__", KindUnderline},
+	{"~~", KindStrikethrough},
+	{"||", KindSpoiler},
+	{"*", KindEmphasis},
+	{"_", KindEmphasis},
+}
+
+// parseInline parses the inline markup of s. Inside a link, URLs are not
+// linked again.
+func parseInline(s string, depth int, inLink bool) []*Node {
+	var nodes []*Node
+	var text strings.Builder
+	emit := func(n *Node) {
+		if text.Len() > 0 {
+			nodes = append(nodes, &Node{Type: KindText, Text: text.String()})
+			text.Reset()
+		}
+		nodes = append(nodes, n)
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && isEscapable(s[i+1]):
+			text.WriteByte(s[i+1])
+			i += 2
+			continue
+		case c == '`':
+			// A run of backticks that closes nowhere is text as a whole,
+			// rather than a shorter run opening later.
+			n := backticks(s[i:])
+			end := closeCode(s[i:], n)
+			if end < 0 {
+				text.WriteString(s[i : i+n])
+				i += n
+				continue
+			}
+			emit(&Node{Type: KindCode, Text: trimCode(s[i+n : i+end])})
+			i += end + n
+			continue
+		}
+		if n, width := parseSpan(s, i, depth, inLink); n != nil {
+			emit(n)
+			i += width
+			continue
+		}
+		text.WriteByte(c)
+		i++
+	}
+	if text.Len() > 0 {
+		nodes = append(nodes, &Node{Type: KindText, Text: text.String()})
+	}
+	return nodes
+}
+
+// parseSpan parses the markup, other than code, that starts at s[i]. It
+// returns nil if there is none.
+func parseSpan(s string, i, depth int, inLink bool) (*Node, int) {
+	rest := s[i:]
+	switch s[i] {
+	case '\n':
+		return &Node{Type: KindLineBreak}, 1
+
+	case '<':
+		if m := userMentionRe.FindStringSubmatch(rest); m != nil {
+			return &Node{Type: KindUserMention, ID: strings.ToLower(m[1])}, len(m[0])
+		}
+		if m := roleMentionRe.FindStringSubmatch(rest); m != nil {
+			return &Node{Type: KindRoleMention, ID: strings.ToLower(m[1])}, len(m[0])
+		}
+		if m := channelMentionRe.FindStringSubmatch(rest); m != nil {
+			return &Node{Type: KindChannelMention, ID: strings.ToLower(m[1])}, len(m[0])
+		}
+		if m := emojiRe.FindStringSubmatch(rest); m != nil {
+			return &Node{Type: KindEmoji, Animated: m[1] == "a", Name: m[2], ID: strings.ToLower(m[3])}, len(m[0])
+		}
+		if m := timestampRe.FindStringSubmatch(rest); m != nil {
+			ts, err := strconv.ParseInt(m[1], 10, 64)
+			if err != nil {
+				return nil, 0
+			}
+			format := m[2]
+			if format == "" {
+				format = "f"
+			}
+			return &Node{Type: KindTimestamp, Timestamp: ts, Format: format}, len(m[0])
+		}
+		// <url> links without a preview.
+		if m := angleLinkRe.FindStringSubmatch(rest); m != nil && !inLink {
+			n := autolink(m[1])
+			n.NoEmbed = true
+			return n, len(m[0])
+		}
+
+	case '@':
+		// "bob@everyone.net" is an address, not a mention.
+		if i > 0 && isWord(s[i-1]) {
+			return nil, 0
+		}
+		if m := everyoneRe.FindStringSubmatch(rest); m != nil {
+			if m[1] == "everyone" {
+				return &Node{Type: KindEveryone}, len(m[0])
+			}
+			return &Node{Type: KindHere}, len(m[0])
+		}
+
+	case '[':
+		if inLink || depth >= maxDepth {
+			return nil, 0
+		}
+		if m := maskedLinkRe.FindStringSubmatch(rest); m != nil {
+			return &Node{Type: KindLink, URL: m[3], NoEmbed: m[2] == "<", Children: parseInline(m[1], depth+1, true)}, len(m[0])
+		}
+
+	case 'h':
+		if inLink || (i > 0 && isWord(s[i-1])) {
+			return nil, 0
+		}
+		if url := urlRe.FindString(rest); url != "" {
+			// A closing parenthesis belongs to the link when it closes one
+			// the link opened, as in https://en.wikipedia.org/wiki/Go_(game).
+			for len(url) < len(rest) && rest[len(url)] == ')' && strings.Count(url, "(") > strings.Count(url, ")") {
+				url = rest[:len(url)+1]
+			}
+			return autolink(url), len(url)
+		}
+
+	case '*', '_', '~', '|':
+		if depth >= maxDepth {
+			return nil, 0
+		}
+		for _, sp := range spans {
+			if !strings.HasPrefix(rest, sp.delim) {
+				continue
+			}
+			end := closeDelim(rest, sp.delim)
+			if end < 0 {
+				continue
+			}
+			// A single "_" only marks emphasis on word boundaries, so
+			// snake_case names stay as they are.
+			if sp.delim == "_" && ((i > 0 && isWord(s[i-1])) || (end+1 < len(rest) && isWord(rest[end+1]))) {
+				continue
+			}
+			inner := rest[len(sp.delim):end]
+			return &Node{Type: sp.kind, Children: parseInline(inner, depth+1, inLink)}, end + len(sp.delim)
+		}
+	}
+	return nil, 0
+}
+
+func autolink(url string) *Node {
+	return &Node{Type: KindLink, URL: url, Children: []*Node{{Type: KindText, Text: url}}}
+}
+
+// closeDelim returns where the span that delim opens at the start of s
+// closes, or -1. The closing delimiter is the first one not followed by
+// another of its character, so "***a***" closes at its last pair, and the
+// span must not be empty. Escapes and code spans are skipped over, and so
+// are doubled delimiters inside a single-character span. A single "*" must
+// hug its content.
+func closeDelim(s, delim string) int {
+	c := delim[0]
+	single := len(delim) == 1
+	if single && c == '*' && (len(s) < 2 || s[1] == ' ' || s[1] == '\n') {
+		return -1
+	}
+	for j := len(delim); j < len(s); {
+		switch {
+		case s[j] == '\\':
+			j += 2
+			continue
+		case s[j] == '`':
+			n := backticks(s[j:])
+			if end := closeCode(s[j:], n); end >= 0 {
+				j += end + n
+			} else {
+				j += n
+			}
+			continue
+		case single && j+1 < len(s) && s[j] == c && s[j+1] == c:
+			j += 2
+			continue
+		}
+		if j > len(delim) && strings.HasPrefix(s[j:], delim) &&
+			(j+len(delim) == len(s) || s[j+len(delim)] != c) {
+			if single && c == '*' && (s[j-1] == ' ' || s[j-1] == '\n') {
+				j++
+				continue
+			}
+			return j
+		}
+		j++
+	}
+	return -1
+}
+
+// backticks returns the length of the run of backticks s starts with.
+func backticks(s string) int {
+	n := 0
+	for n < len(s) && s[n] == '`' {
+		n++
+	}
+	return n
+}
+
+// closeCode returns where a code span that opens with n backticks at the
+// start of s closes: at the next run of exactly n backticks. It returns -1
+// if there is none.
+func closeCode(s string, n int) int {
+	for j := n; j < len(s); {
+		if s[j] != '`' {
+			j++
+			continue
+		}
+		run := backticks(s[j:])
+		if run == n {
+			return j
+		}
+		j += run
+	}
+	return -1
+}
+
+// trimCode strips one space from each end of a code span's text that has
+// both, so a span can start or end with a backtick.
+func trimCode(s string) string {
+	if len(s) >= 2 && s[0] == ' ' && s[len(s)-1] == ' ' && strings.TrimSpace(s) != "" {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// isEscapable reports whether a backslash before c makes it literal: any
+// ASCII punctuation or symbol can be escaped.
+func isEscapable(c byte) bool {
+	return c < utf8.RuneSelf && (unicode.IsPunct(rune(c)) || unicode.IsSymbol(rune(c)))
+}
+
+// isWord reports whether c is part of a word. Bytes of multi-byte UTF-8
+// characters count, so words in any script are left alone.
+func isWord(c byte) bool {
+	return c >= utf8.RuneSelf || c == '_' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
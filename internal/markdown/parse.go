@@ -0,0 +1,217 @@
+package markdown
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxDepth bounds how deeply markup may nest. Anything deeper is kept as
+// text, so hostile input can't run the parser out of stack.
+const maxDepth = 16
+
+var (
+	headingRe   = regexp.MustCompile(`^(#{1,3}) +(\S.*)$`)
+	listItemRe  = regexp.MustCompile(`^( *)([-*]|\d{1,9}\.) +(\S.*)$`)
+	fenceLangRe = regexp.MustCompile(`^[A-Za-z0-9_+#.-]+$`)
+)
+
+// Parse parses message content into its blocks. Content that is empty or
+// only whitespace has none.
+func Parse(content string) []*Node {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	if strings.TrimSpace(content) == "" {
+		return nil
+	}
+	return parseBlocks(strings.Split(content, "\n"), 0)
+}
+
+// parseBlocks parses lines into blocks. Lines that start no other block are
+// gathered into paragraphs, which blank lines separate. Quotes don't nest,
+// so they are only recognised at depth 0.
+func parseBlocks(lines []string, depth int) []*Node {
+	var nodes []*Node
+	var para []string
+	flush := func() {
+		if len(para) > 0 {
+			nodes = append(nodes, &Node{Type: KindParagraph, Children: parseInline(strings.Join(para, "\n"), depth+1, false)})
+			para = nil
+		}
+	}
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			flush()
+			i++
+			continue
+		}
+
+		if n, next, ok := parseFence(lines, i); ok {
+			flush()
+			nodes = append(nodes, n)
+			i = next
+			continue
+		}
+
+		if depth == 0 {
+			// ">>> " quotes the rest of the message.
+			if rest, ok := strings.CutPrefix(line, ">>> "); ok {
+				flush()
+				inner := append([]string{rest}, lines[i+1:]...)
+				nodes = append(nodes, &Node{Type: KindBlockQuote, Children: parseBlocks(inner, depth+1)})
+				break
+			}
+			if _, ok := quoteLine(line); ok {
+				flush()
+				var inner []string
+				for ; i < len(lines); i++ {
+					rest, ok := quoteLine(lines[i])
+					if !ok {
+						break
+					}
+					inner = append(inner, rest)
+				}
+				nodes = append(nodes, &Node{Type: KindBlockQuote, Children: parseBlocks(inner, depth+1)})
+				continue
+			}
+		}
+
+		if m := headingRe.FindStringSubmatch(line); m != nil {
+			flush()
+			nodes = append(nodes, &Node{Type: KindHeading, Level: len(m[1]), Children: parseInline(m[2], depth+1, false)})
+			i++
+			continue
+		}
+
+		if listItemRe.MatchString(line) {
+			flush()
+			n, next := parseList(lines, i, depth+1)
+			nodes = append(nodes, n)
+			i = next
+			continue
+		}
+
+		para = append(para, line)
+		i++
+	}
+	flush()
+	return nodes
+}
+
+// quoteLine reports whether line is part of a "> " block quote, and returns
+// it without the marker.
+func quoteLine(line string) (string, bool) {
+	if line == ">" {
+		return "", true
+	}
+	return strings.CutPrefix(line, "> ")
+}
+
+// parseFence parses a fenced code block opening at lines[i]. The opening
+// fence may name a language, and a block may open and close on one line.
+// Text after a closing fence is put back for the caller to parse. An
+// unclosed fence is not a code block.
+func parseFence(lines []string, i int) (*Node, int, bool) {
+	first, ok := strings.CutPrefix(lines[i], "```")
+	if !ok {
+		return nil, 0, false
+	}
+	if j := strings.Index(first, "```"); j >= 0 {
+		// Text after a one-line block makes it inline code instead.
+		if strings.TrimSpace(first[j+3:]) != "" || j == 0 {
+			return nil, 0, false
+		}
+		return &Node{Type: KindCodeBlock, Text: first[:j]}, i + 1, true
+	}
+
+	n := &Node{Type: KindCodeBlock}
+	var body []string
+	if fenceLangRe.MatchString(first) {
+		n.Lang = strings.ToLower(first)
+	} else if strings.TrimSpace(first) != "" {
+		body = append(body, first)
+	}
+	for j := i + 1; j < len(lines); j++ {
+		k := strings.Index(lines[j], "```")
+		if k < 0 {
+			body = append(body, lines[j])
+			continue
+		}
+		if k > 0 {
+			body = append(body, lines[j][:k])
+		}
+		n.Text = strings.Join(body, "\n")
+		if rest := lines[j][k+3:]; strings.TrimSpace(rest) != "" {
+			lines[j] = rest
+			return n, j, true
+		}
+		return n, j + 1, true
+	}
+	return nil, 0, false
+}
+
+// parseList parses a run of list items starting at lines[i]. Items indented
+// deeper than the first one form a list nested under the item before them;
+// an item of the other kind of list ends this one.
+func parseList(lines []string, i, depth int) (*Node, int) {
+	m := listItemRe.FindStringSubmatch(lines[i])
+	indent := len(m[1])
+	list := &Node{Type: KindList, Ordered: isOrdered(m[2])}
+	if list.Ordered {
+		list.Start, _ = strconv.Atoi(strings.TrimSuffix(m[2], "."))
+	}
+
+	var item *Node
+	for i < len(lines) {
+		m := listItemRe.FindStringSubmatch(lines[i])
+		if m == nil || len(m[1]) < indent {
+			break
+		}
+		if len(m[1]) > indent && item != nil && depth < maxDepth {
+			sub, next := parseList(lines, i, depth+1)
+			item.Children = append(item.Children, sub)
+			i = next
+			continue
+		}
+		if isOrdered(m[2]) != list.Ordered {
+			break
+		}
+		item = &Node{Type: KindListItem, Children: parseInline(m[3], depth+1, false)}
+		list.Children = append(list.Children, item)
+		i++
+	}
+	return list, i
+}
+
+func isOrdered(marker string) bool {
+	return strings.HasSuffix(marker, ".")
+}
+
+// Decode reads nodes written by Encode. Empty data has none.
+func Decode(data json.RawMessage) ([]*Node, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var nodes []*Node
+	if err := json.Unmarshal(data, &nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// Encode parses content and returns its blocks as JSON, or nil when it has
+// none. Messages store this so they are parsed once, when they are written,
+// rather than every time they are sent to a client.
+func Encode(content string) json.RawMessage {
+	nodes := Parse(content)
+	if len(nodes) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(nodes)
+	if err != nil {
+		return nil
+	}
+	return data
+}
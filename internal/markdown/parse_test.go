@@ -0,0 +1,199 @@
+package markdown
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// sexp writes nodes compactly, e.g. (paragraph (strong "a")), so the
+// expected trees below stay readable.
+func sexp(nodes []*Node) string {
+	var parts []string
+	for _, n := range nodes {
+		var b strings.Builder
+		b.WriteString("(" + string(n.Type))
+		switch n.Type {
+		case KindText, KindCode:
+			fmt.Fprintf(&b, " %q", n.Text)
+		case KindCodeBlock:
+			if n.Lang != "" {
+				b.WriteString(" " + n.Lang)
+			}
+			fmt.Fprintf(&b, " %q", n.Text)
+		case KindHeading:
+			fmt.Fprintf(&b, " %d", n.Level)
+		case KindList:
+			if n.Ordered {
+				fmt.Fprintf(&b, " %d.", n.Start)
+			}
+		case KindLink:
+			b.WriteString(" " + n.URL)
+			if n.NoEmbed {
+				b.WriteString(" noembed")
+			}
+		case KindUserMention, KindRoleMention, KindChannelMention:
+			b.WriteString(" " + n.ID)
+		case KindEmoji:
+			if n.Animated {
+				b.WriteString(" a")
+			}
+			b.WriteString(" " + n.Name)
+		case KindTimestamp:
+			fmt.Fprintf(&b, " %d %s", n.Timestamp, n.Format)
+		}
+		if len(n.Children) > 0 {
+			b.WriteString(" " + sexp(n.Children))
+		}
+		b.WriteString(")")
+		parts = append(parts, b.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+const testID = "0b3c4a5e-6f70-4182-93a4-b5c6d7e8f901"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"empty", "", ""},
+		{"whitespace", " \n\t\n", ""},
+		{"text", "hello", `(paragraph (text "hello"))`},
+		{"paragraphs", "a\n\nb", `(paragraph (text "a")) (paragraph (text "b"))`},
+		{"line break", "a\r\nb", `(paragraph (text "a") (br) (text "b"))`},
+
+		// Nesting.
+		{"strong", "**a**", `(paragraph (strong (text "a")))`},
+		{"strong em", "***a***", `(paragraph (strong (em (text "a"))))`},
+		{"underline em", "__*a*__", `(paragraph (underline (em (text "a"))))`},
+		{"spoiler strike", "||~~a~~ b||", `(paragraph (spoiler (strike (text "a")) (text " b")))`},
+		{"link in strong", "**[x](https://a.example)**", `(paragraph (strong (link https://a.example (text "x"))))`},
+		{"snake case", "a_b_c", `(paragraph (text "a_b_c"))`},
+		{"loose star", "2 * 3 * 4", `(paragraph (text "2 * 3 * 4"))`},
+		{"heading", "## **hi**", `(heading 2 (strong (text "hi")))`},
+		{"quote", "> a\n> *b*\nc", `(block_quote (paragraph (text "a") (br) (em (text "b")))) (paragraph (text "c"))`},
+		{"quote rest", ">>> a\n\nb", `(block_quote (paragraph (text "a")) (paragraph (text "b")))`},
+		{"quotes don't nest", "> > a", `(block_quote (paragraph (text "> a")))`},
+		{"list", "- a\n  - b\n- c", `(list (list_item (text "a") (list (list_item (text "b")))) (list_item (text "c")))`},
+		{"ordered list", "3. a\n4. b", `(list 3. (list_item (text "a")) (list_item (text "b")))`},
+		{"list kinds", "- a\n1. b", `(list (list_item (text "a"))) (list 1. (list_item (text "b")))`},
+
+		// Code.
+		{"code", "`*a*`", `(paragraph (code "*a*"))`},
+		{"code in strong", "**`**`**", `(paragraph (strong (code "**")))`},
+		{"double backticks", "`` a`b ``", `(paragraph (code "a` + "`" + `b"))`},
+		{"unclosed backticks", "``a`", `(paragraph (text "` + "``" + `a` + "`" + `"))`},
+		{"code hides mentions", "`<@" + testID + ">`", `(paragraph (code "<@` + testID + `>"))`},
+		{"fence", "```go\nx := 1\n```", `(code_block go "x := 1")`},
+		{"fence no lang", "```\n**a**\n```", `(code_block "**a**")`},
+		{"fence one line", "```a b```", `(code_block "a b")`},
+		{"fence then text", "```\na\n```b", `(code_block "a") (paragraph (text "b"))`},
+		{"unclosed fence", "```go\nx", `(paragraph (text "` + "```" + `go") (br) (text "x"))`},
+
+		// Mentions.
+		{"user", "hi <@" + testID + ">", `(paragraph (text "hi ") (user_mention ` + testID + `))`},
+		{"user nick", "<@!" + strings.ToUpper(testID) + ">", `(paragraph (user_mention ` + testID + `))`},
+		{"role", "<@&" + testID + ">", `(paragraph (role_mention ` + testID + `))`},
+		{"channel", "<#" + testID + ">", `(paragraph (channel_mention ` + testID + `))`},
+		{"everyone", "@everyone @here", `(paragraph (everyone) (text " ") (here))`},
+		{"everyone word", "@everyones", `(paragraph (text "@everyones"))`},
+		{"everyone in address", "bob@everyone.net me@here.io", `(paragraph (text "bob@everyone.net me@here.io"))`},
+		{"everyone after markup", "**hi**@here", `(paragraph (strong (text "hi")) (here))`},
+		{"emoji", "<a:party:" + testID + ">", `(paragraph (emoji a party))`},
+		{"timestamp", "<t:1700000000>", `(paragraph (timestamp 1700000000 f))`},
+		{"timestamp style", "<t:-5:R>", `(paragraph (timestamp -5 R))`},
+		{"mention in spoiler", "||<@" + testID + ">||", `(paragraph (spoiler (user_mention ` + testID + `)))`},
+
+		// Links.
+		{"url", "see https://a.example/x.", `(paragraph (text "see ") (link https://a.example/x (text "https://a.example/x")) (text "."))`},
+		{"angle url", "<https://a.example>", `(paragraph (link https://a.example noembed (text "https://a.example")))`},
+		{"masked", "[**a**](https://a.example)", `(paragraph (link https://a.example (strong (text "a"))))`},
+		{"masked angle", "[a](<https://a.example>)", `(paragraph (link https://a.example noembed (text "a")))`},
+		{"url with parens", "(see https://a.example/Go_(game))",
+			`(paragraph (text "(see ") (link https://a.example/Go_(game) (text "https://a.example/Go_(game)")) (text ")"))`},
+		{"url before angle", "https://a.example>", `(paragraph (link https://a.example (text "https://a.example")) (text ">"))`},
+		{"url before paren", "(https://a.example/x)", `(paragraph (text "(") (link https://a.example/x (text "https://a.example/x")) (text ")"))`},
+		{"no link in link", "[https://b.example](https://a.example)", `(paragraph (link https://a.example (text "https://b.example")))`},
+
+		// Malformed input stays text.
+		{"unclosed strong", "**a", `(paragraph (text "**a"))`},
+		{"empty span", "||||", `(paragraph (text "||||"))`},
+		{"escaped", `\*a\*`, `(paragraph (text "*a*"))`},
+		{"bad mention", "<@123>", `(paragraph (text "<@123>"))`},
+		{"bad link", "[a](javascript:alert(1))", `(paragraph (text "[a](javascript:alert(1))"))`},
+		{"bad heading", "####a", `(paragraph (text "####a"))`},
+		{"trailing backslash", `a\`, `(paragraph (text "a\\"))`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sexp(Parse(tt.in)); got != tt.want {
+				t.Errorf("Parse(%q)\n got %s\nwant %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseDepth checks that markup nested past maxDepth is kept as text
+// rather than parsed.
+func TestParseDepth(t *testing.T) {
+	in := strings.Repeat("~~", 40) + "a" + strings.Repeat("~~", 40)
+	nodes := Parse(in)
+	if d := depth(nodes); d > maxDepth+2 {
+		t.Errorf("depth %d, want at most %d", d, maxDepth+2)
+	}
+	if got := Preview(nodes); !strings.Contains(got, "a") {
+		t.Errorf("Preview lost the content: %q", got)
+	}
+}
+
+func depth(nodes []*Node) int {
+	d := 0
+	for _, n := range nodes {
+		d = max(d, 1+depth(n.Children))
+	}
+	return d
+}
+
+func TestEncode(t *testing.T) {
+	if got := Encode("  "); got != nil {
+		t.Errorf("Encode of blank content = %s, want nil", got)
+	}
+	nodes, err := Decode(Encode("**a**"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := sexp(nodes); s != `(paragraph (strong (text "a")))` {
+		t.Errorf("Encode round trip = %s", s)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, s := range []string{
+		"**a** __b__ ~~c~~ ||d|| *e* _f_",
+		"```go\nx\n```",
+		"> a\n>>> b",
+		"- a\n  - b\n1. c",
+		"`` a ` b ``",
+		"<@" + testID + "> <#" + testID + "> <a:x:" + testID + "> <t:1:R>",
+		"[a](https://a.example) <https://b.example> https://c.example",
+		`\*\` + "`",
+		strings.Repeat("**", 100),
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, in string) {
+		nodes := Parse(in)
+		if d := depth(nodes); d > 2*maxDepth+4 {
+			t.Errorf("depth %d", d)
+		}
+		if _, err := json.Marshal(nodes); err != nil {
+			t.Error(err)
+		}
+		if utf8.ValidString(in) && !utf8.ValidString(Preview(nodes)) {
+			t.Errorf("Preview split a character")
+		}
+	})
+}
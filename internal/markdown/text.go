@@ -0,0 +1,79 @@
+package markdown
+
+import (
+	"strings"
+	"time"
+)
+
+// Preview returns a one-line summary of nodes for notifications: their text
+// without markup, with spoilers hidden and runs of whitespace collapsed.
+// Mentions are written as @user, @role and #channel, since only the caller
+// can look up names, and custom emoji as :name:.
+func Preview(nodes []*Node) string {
+	var b strings.Builder
+	writeText(&b, nodes)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func writeText(b *strings.Builder, nodes []*Node) {
+	for _, n := range nodes {
+		switch n.Type {
+		case KindParagraph, KindHeading, KindListItem:
+			newline(b)
+			for _, c := range n.Children {
+				if c.Type == KindList {
+					newline(b)
+				}
+				writeText(b, []*Node{c})
+			}
+			newline(b)
+		case KindCodeBlock:
+			newline(b)
+			b.WriteString(n.Text)
+			newline(b)
+		case KindText, KindCode:
+			b.WriteString(n.Text)
+		case KindLineBreak:
+			b.WriteByte('\n')
+		case KindSpoiler:
+			b.WriteString("[spoiler]")
+		case KindUserMention:
+			b.WriteString("@user")
+		case KindRoleMention:
+			b.WriteString("@role")
+		case KindChannelMention:
+			b.WriteString("#channel")
+		case KindEveryone:
+			b.WriteString("@everyone")
+		case KindHere:
+			b.WriteString("@here")
+		case KindEmoji:
+			b.WriteString(":" + n.Name + ":")
+		case KindTimestamp:
+			b.WriteString(formatTimestamp(n))
+		default:
+			writeText(b, n.Children)
+		}
+	}
+}
+
+// newline ends the current line, if there is one.
+func newline(b *strings.Builder) {
+	if s := b.String(); s != "" && s[len(s)-1] != '\n' {
+		b.WriteByte('\n')
+	}
+}
+
+// formatTimestamp writes a timestamp in UTC, as the server can't know the
+// reader's time zone. Relative ones are written in full.
+func formatTimestamp(n *Node) string {
+	t := time.Unix(n.Timestamp, 0).UTC()
+	switch n.Format {
+	case "t", "T":
+		return t.Format("15:04 UTC")
+	case "d", "D":
+		return t.Format("2006-01-02")
+	default:
+		return t.Format("2006-01-02 15:04 UTC")
+	}
+}
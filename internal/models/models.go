@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,8 +15,13 @@ type User struct {
 	TailscaleID  *string   `json:"tailscale_id"`
 	PasswordHash *string   `json:"-"`
 	Status       string    `json:"status"`
+	Bot          bool      `json:"bot"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
+
+	// NSFWAcknowledgedAt is when the user confirmed they may view NSFW
+	// channels.
+	NSFWAcknowledgedAt *time.Time `json:"nsfw_acknowledged_at"`
 }
 
 type Server struct {
@@ -34,7 +40,48 @@ type Channel struct {
 	Topic     *string    `json:"topic"`
 	Type      string     `json:"type"`
 	Position  int        `json:"position"`
+	ParentID  *uuid.UUID `json:"parent_id"`
+	IconPath  *string    `json:"icon_path,omitempty"`
+	OwnerID   *uuid.UUID `json:"owner_id,omitempty"`
 	CreatedAt time.Time  `json:"created_at"`
+
+	// Server channel settings.
+	NSFW               bool `json:"nsfw"`
+	SlowmodeSeconds    int  `json:"slowmode_seconds"`
+	DefaultAutoArchive int  `json:"default_auto_archive_duration"` // minutes
+}
+
+// ChannelOverwrite changes the permissions a role has in one channel, or in
+// every channel of a category that has no overwrites of its own. Allowed
+// bits win over denied ones when a member has several roles.
+type ChannelOverwrite struct {
+	ChannelID uuid.UUID `json:"channel_id"`
+	RoleID    uuid.UUID `json:"role_id"`
+	Allow     int64     `json:"allow"`
+	Deny      int64     `json:"deny"`
+}
+
+// ChannelPosition places a server channel: its position among its siblings
+// and the category it sits under, if any.
+type ChannelPosition struct {
+	ID       uuid.UUID  `json:"id"`
+	Position int        `json:"position"`
+	ParentID *uuid.UUID `json:"parent_id"`
+}
+
+// DMChannel is a DM or group DM as shown in a user's channel list.
+type DMChannel struct {
+	Channel
+	Recipients  []UserSummary `json:"recipients"`
+	LastMessage *Message      `json:"last_message"`
+}
+
+// UserSummary is the public profile of another user.
+type UserSummary struct {
+	ID          uuid.UUID `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName *string   `json:"display_name"`
+	AvatarURL   *string   `json:"avatar_url"`
 }
 
 type Message struct {
@@ -43,12 +90,143 @@ type Message struct {
 	AuthorID       uuid.UUID    `json:"author_id"`
 	Content        string       `json:"content"`
 	Edited         bool         `json:"edited"`
+	EditedAt       *time.Time   `json:"edited_at"`
 	CreatedAt      time.Time    `json:"created_at"`
 	UpdatedAt      time.Time    `json:"updated_at"`
 	AuthorUsername    string       `json:"author_username,omitempty"`
 	AuthorDisplayName *string    `json:"author_display_name,omitempty"`
 	AuthorAvatarURL  *string     `json:"author_avatar_url,omitempty"`
 	Attachments    []Attachment `json:"attachments,omitempty"`
+	Mentions        []uuid.UUID `json:"mentions,omitempty"`
+	MentionRoles    []uuid.UUID `json:"mention_roles,omitempty"`
+	MentionEveryone bool        `json:"mention_everyone,omitempty"`
+	Embeds          []Embed     `json:"embeds,omitempty"`
+	WebhookID       *uuid.UUID  `json:"webhook_id,omitempty"`
+	AuthorBot       bool        `json:"author_bot,omitempty"`
+	Interaction     *MessageInteraction `json:"interaction,omitempty"`
+	Ephemeral       bool        `json:"ephemeral,omitempty"`
+	DeletedAt       *time.Time  `json:"deleted_at,omitempty"`
+	DeletedBy       *uuid.UUID  `json:"deleted_by,omitempty"`
+	Poll            *Poll       `json:"poll,omitempty"`
+	ForwardedFrom   *MessageForward `json:"forwarded_from,omitempty"`
+	StickerID       *uuid.UUID  `json:"sticker_id,omitempty"`
+	Sticker         *Sticker    `json:"sticker,omitempty"`
+	PublishedAt     *time.Time  `json:"published_at,omitempty"`
+	// AST is Content parsed by the markdown package, stored alongside it.
+	AST             json.RawMessage `json:"ast,omitempty"`
+}
+
+// MessageForward points a forwarded message back at the message it copies.
+// ChannelName is the source channel's name at the time it was forwarded;
+// DMs have none.
+type MessageForward struct {
+	MessageID   uuid.UUID  `json:"message_id"`
+	ChannelID   uuid.UUID  `json:"channel_id"`
+	ServerID    *uuid.UUID `json:"server_id,omitempty"`
+	ChannelName string     `json:"channel_name,omitempty"`
+	AuthorID    uuid.UUID  `json:"author_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Poll is a question attached to a message. Voters is only filled in for
+// polls that are not anonymous, and MyVotes only when the poll is loaded for
+// a particular user.
+type Poll struct {
+	Question   string       `json:"question"`
+	Options    []PollOption `json:"options"`
+	Multiple   bool         `json:"multiple"`
+	Anonymous  bool         `json:"anonymous"`
+	CreatedBy  uuid.UUID    `json:"created_by"`
+	ExpiresAt  *time.Time   `json:"expires_at"`
+	ClosedAt   *time.Time   `json:"closed_at"`
+	VoterCount int          `json:"voter_count"`
+	MyVotes    []int        `json:"my_votes,omitempty"`
+}
+
+type PollOption struct {
+	ID     int         `json:"id"`
+	Text   string      `json:"text"`
+	Votes  int         `json:"votes"`
+	Voters []uuid.UUID `json:"voters,omitempty"`
+}
+
+// MessageRevision is an earlier version of an edited message: its content
+// from CreatedAt until the edit at ReplacedAt.
+type MessageRevision struct {
+	ID         uuid.UUID `json:"id"`
+	MessageID  uuid.UUID `json:"message_id"`
+	Content    string    `json:"content"`
+	Embeds     []Embed   `json:"embeds,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// MessageInteraction marks a message as the response to a slash command.
+type MessageInteraction struct {
+	ID     uuid.UUID `json:"id"`
+	Name   string    `json:"name"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+// Embed is a structured card shown under a message's content. Its shape
+// follows Discord's embed object so webhook payloads can be stored as-is.
+// Type is "rich" for embeds sent with a message; link previews use "link",
+// "image" or the page's OpenGraph type and set Provider.
+type Embed struct {
+	Type        string         `json:"type,omitempty"`
+	Title       string         `json:"title,omitempty"`
+	Description string         `json:"description,omitempty"`
+	URL         string         `json:"url,omitempty"`
+	Color       *int           `json:"color,omitempty"`
+	Timestamp   *time.Time     `json:"timestamp,omitempty"`
+	Author      *EmbedAuthor   `json:"author,omitempty"`
+	Footer      *EmbedFooter   `json:"footer,omitempty"`
+	Image       *EmbedMedia    `json:"image,omitempty"`
+	Thumbnail   *EmbedMedia    `json:"thumbnail,omitempty"`
+	Fields      []EmbedField   `json:"fields,omitempty"`
+	Provider    *EmbedProvider `json:"provider,omitempty"`
+}
+
+type EmbedAuthor struct {
+	Name    string `json:"name"`
+	URL     string `json:"url,omitempty"`
+	IconURL string `json:"icon_url,omitempty"`
+}
+
+type EmbedProvider struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+type EmbedFooter struct {
+	Text    string `json:"text"`
+	IconURL string `json:"icon_url,omitempty"`
+}
+
+type EmbedMedia struct {
+	URL    string `json:"url"`
+	Width  *int   `json:"width,omitempty"`
+	Height *int   `json:"height,omitempty"`
+}
+
+type EmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+// MentionEntry is one item in a user's recent mentions inbox. ServerID is
+// nil for DMs.
+type MentionEntry struct {
+	Message  Message    `json:"message"`
+	ServerID *uuid.UUID `json:"server_id"`
+}
+
+// UnreadCount is a channel's unread message count and how many of those
+// messages mention the user.
+type UnreadCount struct {
+	Count    int `json:"count"`
+	Mentions int `json:"mentions"`
 }
 
 type Attachment struct {
@@ -63,16 +241,98 @@ type Attachment struct {
 	CreatedAt    time.Time `json:"created_at"`
 }
 
+// Sticker is an image or Lottie animation in a server's sticker pack.
+// Format is png, apng, gif, webp or lottie.
+type Sticker struct {
+	ID          uuid.UUID  `json:"id"`
+	ServerID    uuid.UUID  `json:"server_id"`
+	Name        string     `json:"name"`
+	Description *string    `json:"description"`
+	Format      string     `json:"format"`
+	FilePath    string     `json:"file_path"`
+	FileSize    int64      `json:"file_size"`
+	CreatedBy   *uuid.UUID `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// FavoriteGIF is a GIF saved to a user's library for sending again.
+type FavoriteGIF struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	FilePath  string    `json:"file_path"`
+	MimeType  string    `json:"mime_type"`
+	FileSize  int64     `json:"file_size"`
+	Width     *int      `json:"width"`
+	Height    *int      `json:"height"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 type ServerMember struct {
 	UserID    uuid.UUID `json:"user_id"`
 	ServerID  uuid.UUID `json:"server_id"`
 	Nickname  *string   `json:"nickname"`
 	JoinedAt  time.Time `json:"joined_at"`
+	TimeoutUntil *time.Time `json:"timeout_until,omitempty"`
+	Temporary    bool       `json:"temporary"`
 	Username    string    `json:"username,omitempty"`
 	DisplayName *string  `json:"display_name,omitempty"`
 	AvatarURL   *string  `json:"avatar_url,omitempty"`
 }
 
+type Invite struct {
+	Code      string     `json:"code"`
+	ServerID  uuid.UUID  `json:"server_id"`
+	CreatorID uuid.UUID  `json:"creator_id"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	Temporary bool       `json:"temporary"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// InvitePreview is what a user sees about an invite before joining.
+type InvitePreview struct {
+	Code        string     `json:"code"`
+	ServerID    uuid.UUID  `json:"server_id"`
+	ServerName  string     `json:"server_name"`
+	ServerIcon  *string    `json:"server_icon"`
+	MemberCount int        `json:"member_count"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+type Role struct {
+	ID          uuid.UUID `json:"id"`
+	ServerID    uuid.UUID `json:"server_id"`
+	Name        string    `json:"name"`
+	Color       *string   `json:"color"`
+	Permissions int64     `json:"permissions"`
+	Position    int       `json:"position"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ServerBan struct {
+	ServerID    uuid.UUID `json:"server_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	TailscaleID *string   `json:"tailscale_id"`
+	BannedBy    uuid.UUID `json:"banned_by"`
+	Reason      *string   `json:"reason"`
+	CreatedAt   time.Time `json:"created_at"`
+	Username    string    `json:"username,omitempty"`
+}
+
+type AuditLogEntry struct {
+	ID            uuid.UUID       `json:"id"`
+	ServerID      uuid.UUID       `json:"server_id"`
+	ActorID       uuid.UUID       `json:"actor_id"`
+	Action        string          `json:"action"`
+	TargetType    *string         `json:"target_type"`
+	TargetID      *uuid.UUID      `json:"target_id"`
+	Changes       json.RawMessage `json:"changes,omitempty"`
+	Reason        *string         `json:"reason"`
+	CreatedAt     time.Time       `json:"created_at"`
+	ActorUsername string          `json:"actor_username,omitempty"`
+}
+
 type Friendship struct {
 	ID          uuid.UUID  `json:"id"`
 	UserA       uuid.UUID  `json:"user_a"`
@@ -84,6 +344,194 @@ type Friendship struct {
 	UpdatedAt   time.Time  `json:"updated_at"`
 }
 
+// Relationship is a friendship, pending request or block from one user's
+// point of view. Type is "friend", "incoming", "outgoing" or "blocked".
+type Relationship struct {
+	ID          *uuid.UUID  `json:"id"`
+	Type        string      `json:"type"`
+	User        UserSummary `json:"user"`
+	Online      bool        `json:"online"`
+	DMChannelID *uuid.UUID  `json:"dm_channel_id,omitempty"`
+	Since       time.Time   `json:"since"`
+}
+
+// PrivacySettings controls who may start DMs with, or send friend requests
+// to, a user. DMPolicy is "server_members", "friends" or "nobody";
+// FriendRequestPolicy is "everyone", "mutual_servers" or "nobody".
+type PrivacySettings struct {
+	DMPolicy            string `json:"dm_policy"`
+	FriendRequestPolicy string `json:"friend_request_policy"`
+}
+
+// NotificationOverride is a user's notification preference for one server
+// or channel. Level is "all", "mentions" or "nothing", or nil to inherit from
+// the server (channels) or the default of "all". A mute with a nil
+// MutedUntil lasts until it is lifted.
+type NotificationOverride struct {
+	TargetID         uuid.UUID  `json:"target_id"`
+	TargetType       string     `json:"target_type"`
+	Level            *string    `json:"level"`
+	Muted            bool       `json:"muted"`
+	MutedUntil       *time.Time `json:"muted_until"`
+	SuppressEveryone bool       `json:"suppress_everyone"`
+}
+
+// NotificationSettings are all of a user's notification preferences.
+type NotificationSettings struct {
+	MuteDMs   bool                   `json:"mute_dms"`
+	Overrides []NotificationOverride `json:"overrides"`
+}
+
+// PushSubscription is a Web Push subscription registered by one of a
+// user's browsers. The keys are never sent back to clients.
+type PushSubscription struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Endpoint   string     `json:"endpoint"`
+	P256dh     string     `json:"-"`
+	Auth       string     `json:"-"`
+	UserAgent  *string    `json:"user_agent"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// OutgoingWebhook posts signed JSON to URL for the listed events in its
+// server, or only in ChannelID when set. Secret is only returned when the
+// webhook is created or its secret rotated.
+type OutgoingWebhook struct {
+	ID             uuid.UUID  `json:"id"`
+	ServerID       uuid.UUID  `json:"server_id"`
+	ChannelID      *uuid.UUID `json:"channel_id"`
+	Name           string     `json:"name"`
+	URL            string     `json:"url"`
+	Secret         string     `json:"secret,omitempty"`
+	Events         []string   `json:"events"`
+	Enabled        bool       `json:"enabled"`
+	FailureCount   int        `json:"failure_count"`
+	DisabledReason *string    `json:"disabled_reason"`
+	LastSuccessAt  *time.Time `json:"last_success_at"`
+	CreatedBy      uuid.UUID  `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// WebhookDelivery is one queued event for an outgoing webhook and the outcome
+// of its latest attempt.
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	WebhookID      uuid.UUID       `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	ResponseStatus *int            `json:"response_status"`
+	LastError      *string         `json:"last_error"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
+
+// IncomingWebhook lets an external service post messages into a channel by
+// POSTing to its URL. Token and URL are only returned when the webhook is
+// created or its token regenerated; only a hash of the token is stored.
+type IncomingWebhook struct {
+	ID         uuid.UUID  `json:"id"`
+	ServerID   uuid.UUID  `json:"server_id"`
+	ChannelID  uuid.UUID  `json:"channel_id"`
+	Name       string     `json:"name"`
+	AvatarURL  *string    `json:"avatar_url"`
+	Token      string     `json:"token,omitempty"`
+	TokenHash  string     `json:"-"`
+	URL        string     `json:"url,omitempty"`
+	CreatedBy  uuid.UUID  `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+
+	// SourceChannelID is set on the webhook a follow of an announcement
+	// channel posts through.
+	SourceChannelID *uuid.UUID `json:"source_channel_id,omitempty"`
+}
+
+// Bot is a bot account and its configuration. The bot itself is a user with
+// Bot set; Token and InteractionsSecret are only returned when the bot is
+// created or they are regenerated.
+type Bot struct {
+	User               User      `json:"user"`
+	OwnerID            uuid.UUID `json:"owner_id"`
+	Token              string    `json:"token,omitempty"`
+	InteractionsURL    *string   `json:"interactions_url"`
+	InteractionsSecret string    `json:"interactions_secret,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+// SlashCommand is a command a bot has registered in a server, or a built-in
+// command (Builtin set, owned by the system user) available everywhere.
+type SlashCommand struct {
+	ID          uuid.UUID       `json:"id"`
+	ServerID    *uuid.UUID      `json:"server_id"`
+	BotID       uuid.UUID       `json:"bot_id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Options     []CommandOption `json:"options"`
+	Builtin     bool            `json:"builtin,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// CommandOption is one typed argument of a slash command. Type is "string",
+// "integer", "number", "boolean", "user", "channel" or "role". MinValue and
+// MaxValue bound numbers, or the length of strings.
+type CommandOption struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Type        string          `json:"type"`
+	Required    bool            `json:"required,omitempty"`
+	Choices     []CommandChoice `json:"choices,omitempty"`
+	MinValue    *float64        `json:"min_value,omitempty"`
+	MaxValue    *float64        `json:"max_value,omitempty"`
+}
+
+type CommandChoice struct {
+	Name  string `json:"name"`
+	Value any    `json:"value"`
+}
+
+// Interaction is one invocation of a bot's slash command. Status is
+// "pending" until the bot responds, "deferred" if it asked for more time, and
+// "responded" once it has replied.
+type Interaction struct {
+	ID          uuid.UUID      `json:"id"`
+	BotID       uuid.UUID      `json:"bot_id"`
+	CommandID   *uuid.UUID     `json:"command_id"`
+	CommandName string         `json:"command_name"`
+	ServerID    uuid.UUID      `json:"server_id"`
+	ChannelID   uuid.UUID      `json:"channel_id"`
+	UserID      uuid.UUID      `json:"user_id"`
+	Options     map[string]any `json:"options"`
+	Status      string         `json:"status"`
+	TokenHash   string         `json:"-"`
+	CreatedAt   time.Time      `json:"created_at"`
+	ExpiresAt   time.Time      `json:"expires_at"`
+}
+
+// ScheduledJob is a message to post later or a reminder for its owner. Kind
+// is "message", posted into ChannelID as the user, or "reminder", sent to the
+// user as a DM from the system user; a reminder may point at the message it
+// is about (MessageID, in ChannelID). Status is "pending" until the job runs,
+// when it is removed, or "failed" with LastError if it cannot run.
+type ScheduledJob struct {
+	ID        uuid.UUID  `json:"id"`
+	Kind      string     `json:"kind"`
+	UserID    uuid.UUID  `json:"user_id"`
+	ChannelID *uuid.UUID `json:"channel_id"`
+	MessageID *uuid.UUID `json:"message_id"`
+	Content   string     `json:"content"`
+	RunAt     time.Time  `json:"run_at"`
+	Status    string     `json:"status"`
+	Attempts  int        `json:"attempts"`
+	LastError *string    `json:"last_error"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
 type DMMember struct {
 	ChannelID uuid.UUID `json:"channel_id"`
 	UserID    uuid.UUID `json:"user_id"`
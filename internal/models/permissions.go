@@ -0,0 +1,24 @@
+package models
+
+// Permission bits stored in roles.permissions. A member's effective
+// permissions are the union of all roles they hold in a server; the server
+// owner implicitly holds every permission.
+const (
+	PermAdministrator int64 = 1 << iota
+	PermManageServer
+	PermManageChannels
+	PermManageRoles
+	PermKickMembers
+	PermBanMembers
+	PermModerateMembers
+	PermViewAuditLog
+	PermCreateInvites
+	PermMentionEveryone
+	PermManageWebhooks
+	PermManageMessages
+	PermManageStickers
+	PermSendAnnouncements
+)
+
+// PermAll is every permission bit, used for server owners and administrators.
+const PermAll int64 = -1
@@ -0,0 +1,130 @@
+// Package netguard keeps outgoing requests to URLs that users supply away
+// from the server's own network: loopback, private, link-local and other
+// non-public addresses are refused unless explicitly allowed.
+package netguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// ErrBlocked is returned when a host resolves to an address that may not be
+// connected to.
+var ErrBlocked = errors.New("address not allowed")
+
+// blockedPrefixes are special-purpose ranges that IsPrivate, IsLoopback and
+// friends do not cover. 100.64.0.0/10 is carrier-grade NAT, which is also
+// where Tailscale addresses live.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// isPublic reports whether ip is a globally routable unicast address.
+func isPublic(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, p := range blockedPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Guard decides which addresses outgoing requests may connect to: public
+// ones, and any in its allowlist. Anything that fetches URLs users supply
+// should dial through one.
+type Guard struct {
+	allow []netip.Prefix
+}
+
+// New returns a Guard that also allows addresses in allow.
+func New(allow []netip.Prefix) *Guard {
+	return &Guard{allow: allow}
+}
+
+// Allowed reports whether ip may be connected to.
+func (g *Guard) Allowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, p := range g.allow {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return isPublic(ip)
+}
+
+// CheckHost resolves host and returns ErrBlocked if any of its addresses
+// may not be connected to. It is for refusing a URL up front, when it is
+// given; the dial check still applies when it is fetched.
+func (g *Guard) CheckHost(ctx context.Context, host string) error {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if !g.Allowed(ip) {
+			return fmt.Errorf("%w: %s", ErrBlocked, ip)
+		}
+		return nil
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if !g.Allowed(ip) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrBlocked, host, ip)
+		}
+	}
+	return nil
+}
+
+// Transport returns an HTTP transport that only connects where g allows.
+func (g *Guard) Transport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: g.control,
+	}
+	return &http.Transport{
+		// No proxy: a proxy would make the dial checks see its address
+		// instead of the target's.
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+}
+
+// control runs after DNS resolution, just before each connection is made, so
+// it sees the address actually dialed. Checking here rather than the
+// hostname also covers redirects and DNS rebinding.
+func (g *Guard) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !g.Allowed(ip) {
+		return fmt.Errorf("%w: %s", ErrBlocked, ip)
+	}
+	return nil
+}
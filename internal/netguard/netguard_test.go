@@ -0,0 +1,100 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"100.100.100.100", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"198.18.0.1", false},
+		{"64:ff9b::a9fe:a9fe", false},
+	}
+	for _, tt := range tests {
+		if got := isPublic(netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("isPublic(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	g := New([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	if !g.Allowed(netip.MustParseAddr("10.2.3.4")) {
+		t.Error("allowlisted address refused")
+	}
+	if !g.Allowed(netip.MustParseAddr("::ffff:10.2.3.4")) {
+		t.Error("IPv4-mapped allowlisted address refused")
+	}
+	if g.Allowed(netip.MustParseAddr("192.168.1.1")) {
+		t.Error("private address outside the allowlist allowed")
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	g := New(nil)
+	ctx := context.Background()
+	for _, host := range []string{"127.0.0.1", "::1", "169.254.169.254", "10.0.0.1", "localhost"} {
+		if err := g.CheckHost(ctx, host); !errors.Is(err, ErrBlocked) {
+			t.Errorf("CheckHost(%s) = %v, want ErrBlocked", host, err)
+		}
+	}
+	if err := g.CheckHost(ctx, "93.184.216.34"); err != nil {
+		t.Errorf("CheckHost of a public address: %v", err)
+	}
+	if err := New([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}).CheckHost(ctx, "10.0.0.1"); err != nil {
+		t.Errorf("CheckHost of an allowlisted address: %v", err)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer srv.Close()
+
+	c := &http.Client{Transport: New(nil).Transport()}
+	resp, err := c.Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if !errors.Is(err, ErrBlocked) {
+		t.Errorf("err = %v, want ErrBlocked", err)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("loopback server was reached %d times", n)
+	}
+
+	c = &http.Client{Transport: New([]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}).Transport()}
+	resp, err = c.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("allowlisted server: %v", err)
+	}
+	resp.Body.Close()
+	if n := hits.Load(); n != 1 {
+		t.Errorf("allowlisted server was reached %d times, want 1", n)
+	}
+}
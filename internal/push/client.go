@@ -0,0 +1,145 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	maxAttempts  = 3
+	retryBackoff = 2 * time.Second
+	maxRetryWait = 30 * time.Second
+)
+
+// ErrGone is returned when the push service reports the subscription no
+// longer exists; the caller should delete it.
+var ErrGone = errors.New("push subscription gone")
+
+// Client sends encrypted Web Push messages.
+type Client struct {
+	HTTP  *http.Client
+	VAPID *VAPID
+}
+
+// NewClient returns a Client signing requests with v.
+func NewClient(v *VAPID) *Client {
+	return &Client{
+		HTTP:  &http.Client{Timeout: 15 * time.Second},
+		VAPID: v,
+	}
+}
+
+// Send encrypts payload and delivers it to sub, asking the push service to
+// hold it for at most ttl if the device is offline. Rate limiting, server
+// errors and network errors are retried with backoff; 404 and 410 return
+// ErrGone.
+func (c *Client) Send(ctx context.Context, sub Subscription, payload []byte, ttl time.Duration) error {
+	body, err := Encrypt(sub, payload)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			wait := retryBackoff << (attempt - 1)
+			if ra, ok := lastErr.(*retryAfterError); ok && ra.wait > 0 {
+				wait = ra.wait
+			}
+			if wait > maxRetryWait {
+				wait = maxRetryWait
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		lastErr = c.send(ctx, sub.Endpoint, body, ttl)
+		if lastErr == nil || !retryable(lastErr) {
+			return lastErr
+		}
+	}
+	return lastErr
+}
+
+// retryAfterError is a transient push service failure, optionally with the
+// delay the service asked for.
+type retryAfterError struct {
+	status int
+	wait   time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("push service returned %d", e.status)
+}
+
+// networkError wraps a transport failure so it is retried.
+type networkError struct {
+	err error
+}
+
+func (e *networkError) Error() string {
+	return e.err.Error()
+}
+
+func (e *networkError) Unwrap() error {
+	return e.err
+}
+
+func retryable(err error) bool {
+	switch err.(type) {
+	case *retryAfterError, *networkError:
+		return true
+	}
+	return false
+}
+
+func (c *Client) send(ctx context.Context, endpoint string, body []byte, ttl time.Duration) error {
+	auth, err := c.VAPID.authorization(endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(ttl.Seconds())))
+	req.Header.Set("Urgency", "high")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &networkError{err}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		e := &retryAfterError{status: resp.StatusCode}
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			e.wait = time.Duration(secs) * time.Second
+		}
+		return e
+	default:
+		return fmt.Errorf("push service returned %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,163 @@
+package push
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestVAPID(t *testing.T) *VAPID {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := key.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewVAPID(base64.RawURLEncoding.EncodeToString(raw), "mailto:admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// checkVAPID verifies an Authorization header as a push service would
+// (RFC 8292), for a request to origin.
+func checkVAPID(t *testing.T, header string, v *VAPID, origin string) {
+	t.Helper()
+	token, key, ok := strings.Cut(strings.TrimPrefix(header, "vapid t="), ", k=")
+	if !ok || !strings.HasPrefix(header, "vapid ") {
+		t.Fatalf("Authorization = %q", header)
+	}
+	if key != v.PublicKey {
+		t.Errorf("k = %q, want %q", key, v.PublicKey)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token has %d parts", len(parts))
+	}
+	enc := base64.RawURLEncoding
+	var head struct{ Typ, Alg string }
+	var claims struct {
+		Aud string
+		Exp int64
+		Sub string
+	}
+	for i, dst := range []any{&head, &claims} {
+		data, err := enc.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, dst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if head.Alg != "ES256" || head.Typ != "JWT" {
+		t.Errorf("header = %+v", head)
+	}
+	if claims.Aud != origin {
+		t.Errorf("aud = %q, want %q", claims.Aud, origin)
+	}
+	if exp := time.Unix(claims.Exp, 0); exp.Before(time.Now()) || exp.After(time.Now().Add(24*time.Hour)) {
+		t.Errorf("exp %v is not within the next 24 hours", exp)
+	}
+	if claims.Sub != v.Subject {
+		t.Errorf("sub = %q", claims.Sub)
+	}
+
+	rawKey, err := enc.DecodeString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), rawKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		t.Fatalf("signature of %d bytes: %v", len(sig), err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(pub, digest[:], r, s) {
+		t.Error("signature does not verify")
+	}
+}
+
+func TestSend(t *testing.T) {
+	v := newTestVAPID(t)
+	ua := newUserAgent(t)
+	payload := []byte(`{"type":"mention"}`)
+
+	var got []byte
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/push/abc" {
+			t.Errorf("%s %s", r.Method, r.URL.Path)
+		}
+		for name, want := range map[string]string{
+			"Content-Encoding": "aes128gcm",
+			"Content-Type":     "application/octet-stream",
+			"TTL":              "3600",
+			"Urgency":          "high",
+		} {
+			if h := r.Header.Get(name); h != want {
+				t.Errorf("%s = %q, want %q", name, h, want)
+			}
+		}
+		checkVAPID(t, r.Header.Get("Authorization"), v, "https://"+r.Host)
+		got, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	c := NewClient(v)
+	c.HTTP = srv.Client()
+	if err := c.Send(context.Background(), ua.subscription(srv.URL+"/push/abc"), payload, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if plain := ua.decrypt(t, got); string(plain) != string(payload) {
+		t.Errorf("push service got %q", plain)
+	}
+}
+
+func TestSendGone(t *testing.T) {
+	v := newTestVAPID(t)
+	ua := newUserAgent(t)
+
+	for _, status := range []int{http.StatusNotFound, http.StatusGone, http.StatusBadRequest} {
+		var hits atomic.Int32
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			w.WriteHeader(status)
+		}))
+
+		c := NewClient(v)
+		c.HTTP = srv.Client()
+		err := c.Send(context.Background(), ua.subscription(srv.URL), []byte("x"), time.Hour)
+		srv.Close()
+
+		gone := status == http.StatusNotFound || status == http.StatusGone
+		if errors.Is(err, ErrGone) != gone || err == nil {
+			t.Errorf("status %d: err = %v", status, err)
+		}
+		if n := hits.Load(); n != 1 {
+			t.Errorf("status %d was sent %d times, want no retries", status, n)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package push
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// recordSize is the single aes128gcm record every payload is sent in.
+	recordSize = 4096
+	// headerSize is salt (16) + record size (4) + key id length (1) + key id (65).
+	headerSize = 16 + 4 + 1 + 65
+	// MaxPayloadSize is the largest plaintext that fits in one record after
+	// the header, the GCM tag and the padding delimiter.
+	MaxPayloadSize = recordSize - headerSize - 16 - 1
+)
+
+// ErrPayloadTooLarge is returned when a payload does not fit in one push message.
+var ErrPayloadTooLarge = errors.New("push payload too large")
+
+// Subscription is a browser push subscription: the push service endpoint
+// and the user agent's keys, base64url-encoded as the Push API gives them.
+type Subscription struct {
+	Endpoint string
+	P256dh   string
+	Auth     string
+}
+
+// decodeKey accepts base64url with or without padding, which browsers and
+// libraries disagree on.
+func decodeKey(s string) ([]byte, error) {
+	if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
+
+// Encrypt encrypts payload for sub using the aes128gcm content coding of
+// RFC 8188 with the Web Push key derivation of RFC 8291.
+func Encrypt(sub Subscription, payload []byte) ([]byte, error) {
+	if len(payload) > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+
+	uaPublicBytes, err := decodeKey(sub.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("decode p256dh: %w", err)
+	}
+	authSecret, err := decodeKey(sub.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("decode auth: %w", err)
+	}
+	if len(authSecret) != 16 {
+		return nil, fmt.Errorf("auth secret must be 16 bytes, got %d", len(authSecret))
+	}
+
+	curve := ecdh.P256()
+	uaPublic, err := curve.NewPublicKey(uaPublicBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse p256dh: %w", err)
+	}
+	asPrivate, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublicBytes := asPrivate.PublicKey().Bytes()
+
+	ecdhSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	// RFC 8291 section 3.4: combine the shared secret with the auth secret.
+	prkKey, err := hkdf.Extract(sha256.New, ecdhSecret, authSecret)
+	if err != nil {
+		return nil, err
+	}
+	keyInfo := "WebPush: info\x00" + string(uaPublicBytes) + string(asPublicBytes)
+	ikm, err := hkdf.Expand(sha256.New, prkKey, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	// RFC 8188 section 2.2 and 2.3: derive the content key and nonce.
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// A single, final record: the payload followed by the 0x02 delimiter.
+	plaintext := make([]byte, 0, len(payload)+1)
+	plaintext = append(plaintext, payload...)
+	plaintext = append(plaintext, 0x02)
+
+	out := make([]byte, headerSize, headerSize+len(plaintext)+gcm.Overhead())
+	copy(out, salt)
+	binary.BigEndian.PutUint32(out[16:20], recordSize)
+	out[20] = byte(len(asPublicBytes))
+	copy(out[21:], asPublicBytes)
+	return gcm.Seal(out, nonce, plaintext, nil), nil
+}
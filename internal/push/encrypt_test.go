@@ -0,0 +1,149 @@
+package push
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// userAgent is the browser side of a subscription: the keys it would hand
+// out in PushSubscription.toJSON().
+type userAgent struct {
+	key  *ecdh.PrivateKey
+	auth []byte
+}
+
+func newUserAgent(t *testing.T) *userAgent {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	return &userAgent{key: key, auth: auth}
+}
+
+func (ua *userAgent) subscription(endpoint string) Subscription {
+	enc := base64.RawURLEncoding
+	return Subscription{
+		Endpoint: endpoint,
+		P256dh:   enc.EncodeToString(ua.key.PublicKey().Bytes()),
+		Auth:     enc.EncodeToString(ua.auth),
+	}
+}
+
+// decrypt undoes Encrypt as a browser would, following RFC 8291 and the
+// aes128gcm coding of RFC 8188.
+func (ua *userAgent) decrypt(t *testing.T, body []byte) []byte {
+	t.Helper()
+	if len(body) < 21 {
+		t.Fatalf("body of %d bytes has no header", len(body))
+	}
+	salt := body[:16]
+	rs := binary.BigEndian.Uint32(body[16:20])
+	idlen := int(body[20])
+	if rs != recordSize || idlen != 65 {
+		t.Fatalf("header: rs %d, idlen %d", rs, idlen)
+	}
+	keyID := body[21 : 21+idlen]
+	record := body[21+idlen:]
+	if len(record) > int(rs) {
+		t.Fatalf("record of %d bytes exceeds rs %d", len(record), rs)
+	}
+
+	asPublic, err := ecdh.P256().NewPublicKey(keyID)
+	if err != nil {
+		t.Fatalf("key id is not a P-256 point: %v", err)
+	}
+	secret, err := ua.key.ECDH(asPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prkKey, _ := hkdf.Extract(sha256.New, secret, ua.auth)
+	info := "WebPush: info\x00" + string(ua.key.PublicKey().Bytes()) + string(keyID)
+	ikm, _ := hkdf.Expand(sha256.New, prkKey, info, 32)
+	prk, _ := hkdf.Extract(sha256.New, ikm, salt)
+	cek, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	nonce, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := gcm.Open(nil, nonce, record, nil)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	// The last record ends with a 0x02 delimiter, then optional zero padding.
+	plain = bytes.TrimRight(plain, "\x00")
+	if len(plain) == 0 || plain[len(plain)-1] != 0x02 {
+		t.Fatalf("record has no final delimiter")
+	}
+	return plain[:len(plain)-1]
+}
+
+func TestEncrypt(t *testing.T) {
+	ua := newUserAgent(t)
+	sub := ua.subscription("https://push.example/x")
+
+	for _, payload := range [][]byte{
+		nil,
+		[]byte(`{"type":"message","title":"hi"}`),
+		bytes.Repeat([]byte("x"), MaxPayloadSize),
+	} {
+		body, err := Encrypt(sub, payload)
+		if err != nil {
+			t.Fatalf("Encrypt of %d bytes: %v", len(payload), err)
+		}
+		if got := ua.decrypt(t, body); !bytes.Equal(got, payload) {
+			t.Errorf("round trip of %d bytes gave %d bytes", len(payload), len(got))
+		}
+	}
+
+	// Each message gets a fresh salt and server key.
+	a, _ := Encrypt(sub, []byte("same"))
+	b, _ := Encrypt(sub, []byte("same"))
+	if bytes.Equal(a[:86], b[:86]) {
+		t.Error("two messages share a salt and key")
+	}
+}
+
+func TestEncryptErrors(t *testing.T) {
+	ua := newUserAgent(t)
+	sub := ua.subscription("https://push.example/x")
+
+	if _, err := Encrypt(sub, make([]byte, MaxPayloadSize+1)); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("oversized payload: err = %v", err)
+	}
+
+	padded := sub
+	padded.Auth = base64.URLEncoding.EncodeToString(ua.auth)
+	if _, err := Encrypt(padded, nil); err != nil {
+		t.Errorf("padded base64 auth refused: %v", err)
+	}
+
+	for name, bad := range map[string]Subscription{
+		"short auth":   {P256dh: sub.P256dh, Auth: base64.RawURLEncoding.EncodeToString(ua.auth[:8])},
+		"bad auth":     {P256dh: sub.P256dh, Auth: "!!!"},
+		"bad p256dh":   {P256dh: "!!!", Auth: sub.Auth},
+		"not a point":  {P256dh: base64.RawURLEncoding.EncodeToString(make([]byte, 65)), Auth: sub.Auth},
+		"missing keys": {},
+	} {
+		if _, err := Encrypt(bad, nil); err == nil {
+			t.Errorf("%s: Encrypt succeeded", name)
+		}
+	}
+}
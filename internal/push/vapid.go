@@ -0,0 +1,85 @@
+package push
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// vapidTokenLifetime is how long a VAPID JWT is valid; push services reject
+// anything over 24 hours.
+const vapidTokenLifetime = 12 * time.Hour
+
+// VAPID identifies this server to push services (RFC 8292).
+type VAPID struct {
+	key *ecdsa.PrivateKey
+	// PublicKey is the uncompressed P-256 public key, base64url-encoded, as
+	// browsers expect for PushManager.subscribe's applicationServerKey.
+	PublicKey string
+	// Subject is a mailto: or https: contact URL for the push service operator.
+	Subject string
+}
+
+// NewVAPID loads a VAPID key pair from the base64url-encoded raw P-256
+// private key, the format web-push tooling generates.
+func NewVAPID(privateKey, subject string) (*VAPID, error) {
+	raw, err := decodeKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("decode VAPID private key: %w", err)
+	}
+	key, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse VAPID private key: %w", err)
+	}
+	pub, err := key.PublicKey.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &VAPID{
+		key:       key,
+		PublicKey: base64.RawURLEncoding.EncodeToString(pub),
+		Subject:   subject,
+	}, nil
+}
+
+// authorization returns the Authorization header value for a request to endpoint.
+func (v *VAPID) authorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]string{"typ": "JWT", "alg": "ES256"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(vapidTokenLifetime).Unix(),
+		"sub": v.Subject,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, v.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	// JWS wants the fixed-width r || s form, not ASN.1.
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	return fmt.Sprintf("vapid t=%s.%s, k=%s", unsigned, enc.EncodeToString(sig), v.PublicKey), nil
+}
@@ -0,0 +1,478 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+)
+
+const (
+	// crosspostPollInterval is how often the cross-post worker looks for
+	// queued cross-posts when nothing wakes it sooner.
+	crosspostPollInterval = 10 * time.Second
+	// crosspostBatchSize is how many cross-posts the worker claims at once.
+	crosspostBatchSize = 20
+	// crosspostLease keeps a claimed cross-post from being claimed again
+	// while it is made; one that errors is retried once it runs out.
+	crosspostLease = time.Minute
+	// crosspostMaxAttempts is how many times a cross-post is tried before it
+	// is dropped.
+	crosspostMaxAttempts = 5
+)
+
+// followerName is the name a follow's webhook posts under, such as
+// "Discard #releases", cut to fit a webhook name.
+func followerName(srv *models.Server, ch *models.Channel) string {
+	name := []rune(srv.Name + " #" + *ch.Name)
+	if len(name) > maxWebhookName {
+		name = name[:maxWebhookName]
+	}
+	return string(name)
+}
+
+// handleFollowChannel makes a channel follow announcement channel {id}: a
+// webhook is created in it, and every message published from then on is
+// posted through that webhook. The follower must be able to read the
+// announcement channel and manage webhooks in their own.
+func (s *Server) handleFollowChannel(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sourceID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid channel id", http.StatusBadRequest)
+		return
+	}
+
+	var input struct {
+		ChannelID uuid.UUID `json:"channel_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if input.ChannelID == uuid.Nil {
+		jsonError(w, "channel_id is required", http.StatusBadRequest)
+		return
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	source, err := channelRepo.GetChannelByID(r.Context(), sourceID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return
+	}
+	ok, err := s.canViewChannel(r.Context(), user.ID, source)
+	if err != nil {
+		jsonError(w, "failed to check channel access", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		jsonError(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	if source.Type != "announcement" {
+		jsonError(w, "only announcement channels can be followed", http.StatusBadRequest)
+		return
+	}
+
+	target, err := channelRepo.GetChannelByID(r.Context(), input.ChannelID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return
+	}
+	if target.ServerID == nil || (target.Type != "text" && target.Type != "announcement") {
+		jsonError(w, "announcements can only be followed into a server's text or announcement channels", http.StatusBadRequest)
+		return
+	}
+	if target.ID == source.ID {
+		jsonError(w, "a channel cannot follow itself", http.StatusBadRequest)
+		return
+	}
+	if source.NSFW && !target.NSFW {
+		jsonError(w, "an NSFW channel can only be followed into an NSFW channel", http.StatusBadRequest)
+		return
+	}
+	if s.requireChannelPermission(w, r, target, user.ID, models.PermManageWebhooks) == nil {
+		return
+	}
+
+	serverRepo := &database.ServerRepo{DB: s.db}
+	sourceServer, err := serverRepo.GetServerByID(r.Context(), *source.ServerID)
+	if err != nil {
+		jsonError(w, "failed to get server", http.StatusInternalServerError)
+		return
+	}
+
+	h := &models.IncomingWebhook{
+		ID:              uuid.New(),
+		ServerID:        *target.ServerID,
+		ChannelID:       target.ID,
+		Name:            followerName(sourceServer, source),
+		CreatedBy:       user.ID,
+		SourceChannelID: &source.ID,
+	}
+	// Webhook avatars are URLs, not upload paths.
+	if sourceServer.IconPath != nil {
+		avatar := "/uploads/" + *sourceServer.IconPath
+		h.AvatarURL = &avatar
+	}
+	// Nothing posts through the webhook with a token, so it is never handed
+	// out.
+	if err := issueWebhookToken(h); err != nil {
+		jsonError(w, "failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	h.Token, h.URL = "", ""
+	hookRepo := &database.IncomingWebhookRepo{DB: s.db}
+	if err := hookRepo.Create(r.Context(), h); err != nil {
+		if err == database.ErrAlreadyFollowing {
+			jsonError(w, "this channel already follows that channel", http.StatusConflict)
+			return
+		}
+		jsonError(w, "failed to follow channel", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   *target.ServerID,
+		Action:     auditWebhookCreate,
+		TargetType: auditTargetWebhook,
+		TargetID:   &h.ID,
+		After:      h,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(h)
+}
+
+// handleUnfollowChannel stops channel {channelId} following announcement
+// channel {id}, removing the follow's webhook.
+func (s *Server) handleUnfollowChannel(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sourceID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid channel id", http.StatusBadRequest)
+		return
+	}
+	targetID, err := uuid.Parse(r.PathValue("channelId"))
+	if err != nil {
+		jsonError(w, "invalid channel id", http.StatusBadRequest)
+		return
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	target, err := channelRepo.GetChannelByID(r.Context(), targetID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return
+	}
+	if target.ServerID == nil {
+		jsonError(w, "channel is not following that channel", http.StatusNotFound)
+		return
+	}
+	if s.requireChannelPermission(w, r, target, user.ID, models.PermManageWebhooks) == nil {
+		return
+	}
+
+	hookRepo := &database.IncomingWebhookRepo{DB: s.db}
+	h, err := hookRepo.GetFollower(r.Context(), sourceID, target.ID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "channel is not following that channel", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get follow", http.StatusInternalServerError)
+		return
+	}
+	if err := hookRepo.Delete(r.Context(), h.ID); err != nil && err != sql.ErrNoRows {
+		jsonError(w, "failed to unfollow channel", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   *target.ServerID,
+		Action:     auditWebhookDelete,
+		TargetType: auditTargetWebhook,
+		TargetID:   &h.ID,
+		Before:     h,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePublishMessage publishes a message in an announcement channel,
+// queueing a cross-post of it to every channel that follows it for
+// RunCrosspostWorker. Authors can publish their own announcements; anyone
+// else needs PermManageMessages. A message is only published once.
+func (s *Server) handlePublishMessage(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	messageID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	msgRepo := &database.MessageRepo{DB: s.db}
+	msg, err := msgRepo.GetByID(r.Context(), messageID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get message", http.StatusInternalServerError)
+		return
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(r.Context(), msg.ChannelID)
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return
+	}
+	ok, err := s.canViewChannel(r.Context(), user.ID, ch)
+	if err != nil {
+		jsonError(w, "failed to check channel access", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		jsonError(w, "message not found", http.StatusNotFound)
+		return
+	}
+	if ch.Type != "announcement" {
+		jsonError(w, "only messages in announcement channels can be published", http.StatusBadRequest)
+		return
+	}
+
+	perm := models.PermManageMessages
+	if msg.AuthorID == user.ID && msg.WebhookID == nil {
+		perm = models.PermSendAnnouncements
+	}
+	if s.requireChannelPermission(w, r, ch, user.ID, perm) == nil {
+		return
+	}
+
+	attachmentRepo := &database.AttachmentRepo{DB: s.db}
+	msg.Attachments, err = attachmentRepo.ListByMessage(r.Context(), msg.ID)
+	if err != nil {
+		jsonError(w, "failed to get attachments", http.StatusInternalServerError)
+		return
+	}
+	if msg.Content == "" && len(richEmbeds(msg.Embeds)) == 0 && len(msg.Attachments) == 0 {
+		jsonError(w, "message has nothing to publish", http.StatusBadRequest)
+		return
+	}
+
+	at, err := msgRepo.Publish(r.Context(), msg.ID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "message is already published", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to publish message", http.StatusInternalServerError)
+		return
+	}
+	msg.PublishedAt = &at
+
+	out, err := json.Marshal(map[string]any{
+		"type":         "message_publish",
+		"channel_id":   ch.ID,
+		"message_id":   msg.ID,
+		"published_at": at,
+	})
+	if err == nil {
+		s.hub.BroadcastToChannel(ch.ID, out)
+	}
+
+	s.wakeCrossposts()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
+
+// richEmbeds drops link previews, which are unfurled afresh for a copy.
+func richEmbeds(embeds []models.Embed) []models.Embed {
+	var rich []models.Embed
+	for _, e := range embeds {
+		if e.Type == "rich" {
+			rich = append(rich, e)
+		}
+	}
+	return rich
+}
+
+// wakeCrossposts tells the cross-post worker there is new work without
+// waiting for its next poll.
+func (s *Server) wakeCrossposts() {
+	select {
+	case s.crosspostWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunCrosspostWorker posts the copies of published announcements into the
+// channels that follow them. It should be called in its own goroutine. The
+// queue lives in Postgres, filled in the same transaction that publishes a
+// message, so cross-posts survive restarts and several instances can share
+// them.
+func (s *Server) RunCrosspostWorker() {
+	ticker := time.NewTicker(crosspostPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.crosspostWake:
+		}
+
+		ctx := context.Background()
+		crosspostRepo := &database.CrosspostRepo{DB: s.db}
+		for {
+			due, err := crosspostRepo.ClaimDue(ctx, crosspostBatchSize, crosspostLease)
+			if err != nil {
+				log.Printf("failed to claim cross-posts: %v", err)
+				break
+			}
+			for i := range due {
+				c := &due[i]
+				err := s.crosspost(ctx, c)
+				switch {
+				case err == nil:
+					if err := crosspostRepo.Finish(ctx, c); err != nil {
+						log.Printf("failed to finish cross-post of message %s: %v", c.MessageID, err)
+					}
+				case c.Attempts >= crosspostMaxAttempts:
+					log.Printf("giving up cross-posting message %s through webhook %s: %v", c.MessageID, c.WebhookID, err)
+					if err := crosspostRepo.Finish(ctx, c); err != nil {
+						log.Printf("failed to finish cross-post of message %s: %v", c.MessageID, err)
+					}
+				default:
+					log.Printf("failed to cross-post message %s (attempt %d): %v", c.MessageID, c.Attempts, err)
+					if err := crosspostRepo.MarkRetry(ctx, c, err.Error()); err != nil {
+						log.Printf("failed to record cross-post error for message %s: %v", c.MessageID, err)
+					}
+				}
+			}
+			if len(due) < crosspostBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// crosspost posts a copy of a published announcement through one of its
+// channel's follow webhooks, pointing back at the original. It returns nil
+// when there is nothing left to do, such as when the original was deleted,
+// the follow removed or the copy already made by an earlier attempt.
+func (s *Server) crosspost(ctx context.Context, c *database.Crosspost) error {
+	msgRepo := &database.MessageRepo{DB: s.db}
+	msg, err := msgRepo.GetByID(ctx, c.MessageID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	hookRepo := &database.IncomingWebhookRepo{DB: s.db}
+	h, err := hookRepo.GetByID(ctx, c.WebhookID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	made, err := msgRepo.Exists(ctx, c.CopyID)
+	if err != nil || made {
+		return err
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	source, err := channelRepo.GetChannelByID(ctx, msg.ChannelID)
+	if err != nil {
+		return err
+	}
+	ch, err := channelRepo.GetChannelByID(ctx, h.ChannelID)
+	if err != nil {
+		return err
+	}
+	// Either channel's NSFW setting may have changed since the follow.
+	if source.NSFW && !ch.NSFW {
+		return nil
+	}
+
+	attachmentRepo := &database.AttachmentRepo{DB: s.db}
+	attachments, err := attachmentRepo.ListByMessage(ctx, msg.ID)
+	if err != nil {
+		return err
+	}
+
+	copied := &models.Message{
+		ID:              c.CopyID,
+		ChannelID:       ch.ID,
+		AuthorID:        h.CreatedBy,
+		Content:         msg.Content,
+		Embeds:          richEmbeds(msg.Embeds),
+		WebhookID:       &h.ID,
+		AuthorUsername:  h.Name,
+		AuthorAvatarURL: h.AvatarURL,
+		ForwardedFrom: &models.MessageForward{
+			MessageID:   msg.ID,
+			ChannelID:   source.ID,
+			ServerID:    source.ServerID,
+			ChannelName: *source.Name,
+			AuthorID:    msg.AuthorID,
+			CreatedAt:   msg.CreatedAt,
+		},
+	}
+	if err := msgRepo.Create(ctx, copied); err != nil {
+		return err
+	}
+	// From here the copy exists, so errors are only logged: retrying would
+	// find it and stop.
+	if len(attachments) > 0 {
+		copied.Attachments, err = attachmentRepo.Copy(ctx, msg.ID, copied.ID)
+		if err != nil {
+			log.Printf("failed to copy attachments of message %s to %s: %v", msg.ID, copied.ID, err)
+		}
+	}
+	if err := hookRepo.MarkUsed(ctx, h.ID); err != nil {
+		log.Printf("failed to mark webhook %s used: %v", h.ID, err)
+	}
+	s.announceMessage(ctx, ch, copied)
+	return nil
+}
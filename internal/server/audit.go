@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// auditReasonHeader carries an optional, URL-encoded reason for an audited action.
+const auditReasonHeader = "X-Audit-Log-Reason"
+
+// Audit log action types.
+const (
+	auditServerUpdate   = "server_update"
+	auditChannelCreate  = "channel_create"
+	auditChannelUpdate  = "channel_update"
+	auditChannelDelete  = "channel_delete"
+	auditChannelReorder = "channel_reorder"
+	auditOverwriteSet   = "channel_overwrite_update"
+	auditOverwriteDel   = "channel_overwrite_delete"
+	auditRoleCreate     = "role_create"
+	auditRoleDelete     = "role_delete"
+	auditMemberRoleAdd  = "member_role_add"
+	auditMemberRoleDel  = "member_role_remove"
+	auditMemberKick     = "member_kick"
+	auditMemberBan      = "member_ban"
+	auditMemberUnban    = "member_unban"
+	auditMemberTimeout  = "member_timeout"
+	auditMemberJoin     = "member_join"
+	auditInviteCreate   = "invite_create"
+	auditInviteDelete   = "invite_delete"
+	auditWebhookCreate  = "webhook_create"
+	auditWebhookUpdate  = "webhook_update"
+	auditWebhookDelete  = "webhook_delete"
+	auditBotAdd         = "bot_add"
+	auditMessageDelete  = "message_delete"
+	auditMessageBulk    = "message_bulk_delete"
+	auditMessageRestore = "message_restore"
+	auditStickerCreate  = "sticker_create"
+	auditStickerUpdate  = "sticker_update"
+	auditStickerDelete  = "sticker_delete"
+)
+
+// Audit log target types.
+const (
+	auditTargetServer  = "server"
+	auditTargetChannel = "channel"
+	auditTargetRole    = "role"
+	auditTargetUser    = "user"
+	auditTargetInvite  = "invite"
+	auditTargetWebhook = "webhook"
+	auditTargetSticker = "sticker"
+)
+
+// auditEvent describes one audited change. Before and After are marshalled to
+// JSON objects and only the keys whose values differ are stored.
+type auditEvent struct {
+	ServerID   uuid.UUID
+	Action     string
+	TargetType string
+	TargetID   *uuid.UUID
+	Before     any
+	After      any
+}
+
+// audit records an audit log entry for the request's user. Failures are logged
+// rather than surfaced: the audited action has already happened.
+func (s *Server) audit(r *http.Request, e auditEvent) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		return
+	}
+	s.auditAs(r.Context(), user.ID, auditReason(r), e)
+}
+
+// auditAs records an audit log entry for an explicit actor.
+func (s *Server) auditAs(ctx context.Context, actorID uuid.UUID, reason *string, e auditEvent) {
+	entry := &models.AuditLogEntry{
+		ServerID: e.ServerID,
+		ActorID:  actorID,
+		Action:   e.Action,
+		TargetID: e.TargetID,
+		Changes:  auditDiff(e.Before, e.After),
+		Reason:   reason,
+	}
+	if e.TargetType != "" {
+		entry.TargetType = &e.TargetType
+	}
+
+	repo := &database.AuditLogRepo{DB: s.db}
+	if err := repo.Create(ctx, entry); err != nil {
+		log.Printf("audit log write failed (%s on %s): %v", e.Action, e.ServerID, err)
+	}
+}
+
+// auditReason reads the optional reason header.
+func auditReason(r *http.Request) *string {
+	raw := r.Header.Get(auditReasonHeader)
+	if raw == "" {
+		return nil
+	}
+	if decoded, err := url.QueryUnescape(raw); err == nil {
+		raw = decoded
+	}
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil
+	}
+	if r := []rune(raw); len(r) > 512 {
+		raw = string(r[:512])
+	}
+	return &raw
+}
+
+// auditDiff returns {"key": {"old": ..., "new": ...}} for every top-level key
+// that differs between before and after. Either side may be nil.
+func auditDiff(before, after any) json.RawMessage {
+	if before == nil && after == nil {
+		return nil
+	}
+	oldFields := toJSONObject(before)
+	newFields := toJSONObject(after)
+
+	diff := make(map[string]map[string]any)
+	for k, nv := range newFields {
+		ov, ok := oldFields[k]
+		if ok && reflect.DeepEqual(ov, nv) {
+			continue
+		}
+		diff[k] = map[string]any{"old": ov, "new": nv}
+	}
+	for k, ov := range oldFields {
+		if _, ok := newFields[k]; !ok {
+			diff[k] = map[string]any{"old": ov, "new": nil}
+		}
+	}
+	if len(diff) == 0 {
+		return nil
+	}
+	out, err := json.Marshal(diff)
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+func toJSONObject(v any) map[string]any {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+	return m
+}
+
+func (s *Server) handleListAuditLog(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	if s.requirePermission(w, r, serverID, user.ID, models.PermViewAuditLog) == nil {
+		return
+	}
+
+	q := r.URL.Query()
+	filter := database.AuditLogFilter{
+		Action: q.Get("action"),
+		Limit:  50,
+	}
+	if l := q.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
+			filter.Limit = parsed
+		}
+	}
+	if filter.Limit > 100 {
+		filter.Limit = 100
+	}
+	for key, dst := range map[string]**uuid.UUID{
+		"user_id":   &filter.ActorID,
+		"target_id": &filter.TargetID,
+		"before":    &filter.Before,
+	} {
+		v := q.Get(key)
+		if v == "" {
+			continue
+		}
+		parsed, err := uuid.Parse(v)
+		if err != nil {
+			jsonError(w, "invalid "+key, http.StatusBadRequest)
+			return
+		}
+		*dst = &parsed
+	}
+
+	repo := &database.AuditLogRepo{DB: s.db}
+	entries, err := repo.List(r.Context(), serverID, filter)
+	if err != nil {
+		jsonError(w, "failed to list audit log", http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []models.AuditLogEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// loadOwnBot fetches bot {id} if the user owns it. On failure it writes the
+// error response and returns nil.
+func (s *Server) loadOwnBot(w http.ResponseWriter, r *http.Request, userID uuid.UUID) *models.Bot {
+	botID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid bot id", http.StatusBadRequest)
+		return nil
+	}
+
+	botRepo := &database.BotRepo{DB: s.db}
+	b, err := botRepo.Get(r.Context(), botID)
+	if err == sql.ErrNoRows || (err == nil && b.OwnerID != userID) {
+		jsonError(w, "bot not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		jsonError(w, "failed to get bot", http.StatusInternalServerError)
+		return nil
+	}
+	return b
+}
+
+func (s *Server) handleListBots(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	botRepo := &database.BotRepo{DB: s.db}
+	bots, err := botRepo.ListByOwner(r.Context(), user.ID)
+	if err != nil {
+		jsonError(w, "failed to list bots", http.StatusInternalServerError)
+		return
+	}
+	if bots == nil {
+		bots = []models.Bot{}
+	}
+	for i := range bots {
+		bots[i].InteractionsSecret = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bots)
+}
+
+// handleCreateBot creates a bot account owned by the user. The response is
+// the only time the bot's token and interactions secret are shown.
+func (s *Server) handleCreateBot(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if user.Bot {
+		jsonError(w, "bots cannot create bots", http.StatusForbidden)
+		return
+	}
+
+	var input struct {
+		Username        string  `json:"username"`
+		DisplayName     *string `json:"display_name"`
+		InteractionsURL *string `json:"interactions_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	input.Username = strings.TrimSpace(input.Username)
+	if input.Username == "" || len(input.Username) > 32 {
+		jsonError(w, "username is required and must be 32 characters or less", http.StatusBadRequest)
+		return
+	}
+	if input.InteractionsURL != nil && s.checkCallbackURL(r.Context(), *input.InteractionsURL) != nil {
+		jsonError(w, "interactions_url must be an http or https URL on a public host", http.StatusBadRequest)
+		return
+	}
+
+	userRepo := &database.UserRepo{DB: s.db}
+	if _, err := userRepo.GetByUsername(r.Context(), input.Username); err == nil {
+		jsonError(w, "username is taken", http.StatusConflict)
+		return
+	} else if err != sql.ErrNoRows {
+		jsonError(w, "failed to check username", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		jsonError(w, "failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	secret, err := randomToken()
+	if err != nil {
+		jsonError(w, "failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	b := &models.Bot{
+		User: models.User{
+			Username:    input.Username,
+			DisplayName: input.DisplayName,
+		},
+		OwnerID:            user.ID,
+		Token:              token,
+		InteractionsURL:    input.InteractionsURL,
+		InteractionsSecret: secret,
+	}
+	botRepo := &database.BotRepo{DB: s.db}
+	if err := botRepo.Create(r.Context(), b, auth.HashToken(token)); err != nil {
+		jsonError(w, "failed to create bot", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(b)
+}
+
+// handleUpdateBot sets the bot's interactions URL (null to deliver over the
+// gateway only) and can regenerate its token or interactions secret, which
+// are then returned once.
+func (s *Server) handleUpdateBot(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	b := s.loadOwnBot(w, r, user.ID)
+	if b == nil {
+		return
+	}
+
+	var input struct {
+		InteractionsURL  json.RawMessage `json:"interactions_url"`
+		RegenerateToken  bool            `json:"regenerate_token"`
+		RegenerateSecret bool            `json:"regenerate_secret"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(input.InteractionsURL) > 0 {
+		if string(input.InteractionsURL) == "null" {
+			b.InteractionsURL = nil
+		} else {
+			var u string
+			if err := json.Unmarshal(input.InteractionsURL, &u); err != nil || s.checkCallbackURL(r.Context(), u) != nil {
+				jsonError(w, "interactions_url must be an http or https URL on a public host", http.StatusBadRequest)
+				return
+			}
+			b.InteractionsURL = &u
+		}
+	}
+
+	var tokenHash string
+	if input.RegenerateToken {
+		token, err := randomToken()
+		if err != nil {
+			jsonError(w, "failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		b.Token = token
+		tokenHash = auth.HashToken(token)
+	}
+	if input.RegenerateSecret {
+		secret, err := randomToken()
+		if err != nil {
+			jsonError(w, "failed to generate secret", http.StatusInternalServerError)
+			return
+		}
+		b.InteractionsSecret = secret
+	}
+
+	botRepo := &database.BotRepo{DB: s.db}
+	if err := botRepo.Update(r.Context(), b, tokenHash); err != nil {
+		jsonError(w, "failed to update bot", http.StatusInternalServerError)
+		return
+	}
+
+	if !input.RegenerateSecret {
+		b.InteractionsSecret = ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b)
+}
+
+func (s *Server) handleDeleteBot(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	b := s.loadOwnBot(w, r, user.ID)
+	if b == nil {
+		return
+	}
+
+	botRepo := &database.BotRepo{DB: s.db}
+	if err := botRepo.Delete(r.Context(), b.User.ID); err != nil && err != sql.ErrNoRows {
+		jsonError(w, "failed to delete bot", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAddBotToServer adds a bot to a server as a member. It needs the
+// Manage Server permission.
+func (s *Server) handleAddBotToServer(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+	botID, err := uuid.Parse(r.PathValue("botId"))
+	if err != nil {
+		jsonError(w, "invalid bot id", http.StatusBadRequest)
+		return
+	}
+	if s.requirePermission(w, r, serverID, user.ID, models.PermManageServer) == nil {
+		return
+	}
+
+	botRepo := &database.BotRepo{DB: s.db}
+	b, err := botRepo.Get(r.Context(), botID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "bot not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get bot", http.StatusInternalServerError)
+		return
+	}
+
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	isMember, err := memberRepo.IsMember(r.Context(), botID, serverID)
+	if err != nil {
+		jsonError(w, "failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if isMember {
+		jsonError(w, "bot is already a member", http.StatusConflict)
+		return
+	}
+	if err := memberRepo.AddMember(r.Context(), &models.ServerMember{
+		UserID:   botID,
+		ServerID: serverID,
+	}); err != nil {
+		jsonError(w, "failed to add bot", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   serverID,
+		Action:     auditBotAdd,
+		TargetType: auditTargetUser,
+		TargetID:   &botID,
+		After:      map[string]any{"username": b.User.Username},
+	})
+	s.emitWebhookEvent(r.Context(), serverID, nil, hookMemberJoin, map[string]any{
+		"user_id":  botID,
+		"username": b.User.Username,
+		"bot":      true,
+	})
+
+	b.InteractionsSecret = ""
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b)
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+)
+
+// serverChannelTypes are the types a server channel can be created with.
+// Categories only group other channels and hold no messages; only some roles
+// can post in announcement channels, which other servers can follow.
+var serverChannelTypes = map[string]bool{
+	"text":         true,
+	"voice":        true,
+	"category":     true,
+	"announcement": true,
+}
+
+// handleSetChannelPositions moves any number of a server's channels at
+// once, reordering them and moving them in and out of categories. The body
+// is a list of {"id", "position", "parent_id"}; channels left out stay
+// where they are.
+func (s *Server) handleSetChannelPositions(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	if s.requirePermission(w, r, serverID, user.ID, models.PermManageChannels) == nil {
+		return
+	}
+
+	var positions []models.ChannelPosition
+	if err := json.NewDecoder(r.Body).Decode(&positions); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(positions) == 0 {
+		jsonError(w, "at least one channel is required", http.StatusBadRequest)
+		return
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	channels, err := channelRepo.ListServerChannels(r.Context(), serverID)
+	if err != nil {
+		jsonError(w, "failed to list channels", http.StatusInternalServerError)
+		return
+	}
+	byID := make(map[uuid.UUID]*models.Channel, len(channels))
+	for i := range channels {
+		byID[channels[i].ID] = &channels[i]
+	}
+
+	seen := make(map[uuid.UUID]bool, len(positions))
+	for _, p := range positions {
+		ch, ok := byID[p.ID]
+		if !ok {
+			jsonError(w, "channel "+p.ID.String()+" is not in this server", http.StatusBadRequest)
+			return
+		}
+		if seen[p.ID] {
+			jsonError(w, "channel "+p.ID.String()+" is listed more than once", http.StatusBadRequest)
+			return
+		}
+		seen[p.ID] = true
+		if p.Position < 0 {
+			jsonError(w, "position must not be negative", http.StatusBadRequest)
+			return
+		}
+		if p.ParentID == nil {
+			continue
+		}
+		if ch.Type == "category" {
+			jsonError(w, "categories cannot be nested", http.StatusBadRequest)
+			return
+		}
+		if parent, ok := byID[*p.ParentID]; !ok || parent.Type != "category" {
+			jsonError(w, "parent must be a category in this server", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := channelRepo.SetPositions(r.Context(), serverID, positions); err != nil {
+		if err == sql.ErrNoRows {
+			// A channel was deleted since it was listed.
+			jsonError(w, "channel not found", http.StatusNotFound)
+			return
+		}
+		jsonError(w, "failed to move channels", http.StatusInternalServerError)
+		return
+	}
+
+	channels, err = channelRepo.ListServerChannels(r.Context(), serverID)
+	if err != nil {
+		jsonError(w, "failed to list channels", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   serverID,
+		Action:     auditChannelReorder,
+		TargetType: auditTargetServer,
+		TargetID:   &serverID,
+		After:      positions,
+	})
+
+	// One event for the whole move, so clients redraw the list once.
+	out, err := json.Marshal(map[string]any{
+		"type":      "channels_reordered",
+		"server_id": serverID,
+		"channels":  channels,
+	})
+	if err == nil {
+		s.hub.BroadcastAll(out)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channels)
+}
+
+// overwriteChannel loads server channel {id} for a user who may manage its
+// permissions. On failure it writes the error response and returns nil.
+func (s *Server) overwriteChannel(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (*models.Server, *models.Channel) {
+	channelID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid channel id", http.StatusBadRequest)
+		return nil, nil
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(r.Context(), channelID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "channel not found", http.StatusNotFound)
+		return nil, nil
+	}
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return nil, nil
+	}
+	if ch.ServerID == nil {
+		jsonError(w, "permissions can only be set in server channels", http.StatusBadRequest)
+		return nil, nil
+	}
+	srv := s.requirePermission(w, r, *ch.ServerID, userID, models.PermManageChannels|models.PermManageRoles)
+	if srv == nil {
+		return nil, nil
+	}
+	return srv, ch
+}
+
+func (s *Server) handleListChannelOverwrites(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	_, ch := s.overwriteChannel(w, r, user.ID)
+	if ch == nil {
+		return
+	}
+
+	overwriteRepo := &database.ChannelOverwriteRepo{DB: s.db}
+	overwrites, err := overwriteRepo.ListByChannel(r.Context(), ch.ID)
+	if err != nil {
+		jsonError(w, "failed to list overwrites", http.StatusInternalServerError)
+		return
+	}
+	if overwrites == nil {
+		overwrites = []models.ChannelOverwrite{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(overwrites)
+}
+
+func (s *Server) handleSetChannelOverwrite(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	srv, ch := s.overwriteChannel(w, r, user.ID)
+	if ch == nil {
+		return
+	}
+
+	roleID, err := uuid.Parse(r.PathValue("roleId"))
+	if err != nil {
+		jsonError(w, "invalid role id", http.StatusBadRequest)
+		return
+	}
+	roleRepo := &database.RoleRepo{DB: s.db}
+	role, err := roleRepo.GetByID(r.Context(), roleID)
+	if err == sql.ErrNoRows || (err == nil && role.ServerID != srv.ID) {
+		jsonError(w, "role not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get role", http.StatusInternalServerError)
+		return
+	}
+
+	var input struct {
+		Allow int64 `json:"allow"`
+		Deny  int64 `json:"deny"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if (input.Allow|input.Deny)&^channelPermissionMask != 0 {
+		jsonError(w, "only message, mention, webhook and announcement permissions can be set per channel", http.StatusBadRequest)
+		return
+	}
+	if input.Allow&input.Deny != 0 {
+		jsonError(w, "a permission cannot be both allowed and denied", http.StatusBadRequest)
+		return
+	}
+
+	// Nobody can hand out permissions they don't hold themselves.
+	perms, err := s.memberPermissions(r.Context(), srv, user.ID)
+	if err != nil {
+		jsonError(w, "failed to check permissions", http.StatusInternalServerError)
+		return
+	}
+	if input.Allow&^perms != 0 {
+		jsonError(w, "cannot grant permissions you do not have", http.StatusForbidden)
+		return
+	}
+
+	o := &models.ChannelOverwrite{ChannelID: ch.ID, RoleID: roleID, Allow: input.Allow, Deny: input.Deny}
+	overwriteRepo := &database.ChannelOverwriteRepo{DB: s.db}
+	if err := overwriteRepo.Set(r.Context(), o); err != nil {
+		jsonError(w, "failed to set overwrite", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   srv.ID,
+		Action:     auditOverwriteSet,
+		TargetType: auditTargetChannel,
+		TargetID:   &ch.ID,
+		After:      o,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(o)
+}
+
+func (s *Server) handleDeleteChannelOverwrite(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	srv, ch := s.overwriteChannel(w, r, user.ID)
+	if ch == nil {
+		return
+	}
+
+	roleID, err := uuid.Parse(r.PathValue("roleId"))
+	if err != nil {
+		jsonError(w, "invalid role id", http.StatusBadRequest)
+		return
+	}
+
+	overwriteRepo := &database.ChannelOverwriteRepo{DB: s.db}
+	if err := overwriteRepo.Delete(r.Context(), ch.ID, roleID); err != nil {
+		if err == sql.ErrNoRows {
+			jsonError(w, "overwrite not found", http.StatusNotFound)
+			return
+		}
+		jsonError(w, "failed to delete overwrite", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   srv.ID,
+		Action:     auditOverwriteDel,
+		TargetType: auditTargetChannel,
+		TargetID:   &ch.ID,
+		Before:     map[string]any{"role_id": roleID},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+)
+
+const (
+	maxChannelTopicLength = 1024
+	maxSlowmodeSeconds    = 6 * 60 * 60
+)
+
+// autoArchiveDurations are the allowed default auto-archive durations for
+// a channel's threads, in minutes: an hour, a day, three days and a week.
+var autoArchiveDurations = map[int]bool{
+	60:    true,
+	1440:  true,
+	4320:  true,
+	10080: true,
+}
+
+// errNSFWNotAcknowledged is returned to users who haven't confirmed their
+// age when they try to use an NSFW channel.
+var errNSFWNotAcknowledged = &accessError{http.StatusForbidden, "this channel is NSFW; confirm your age to view it"}
+
+// checkNSFW returns errNSFWNotAcknowledged if the channel is NSFW and the
+// user hasn't acknowledged that they are old enough to see it.
+func (s *Server) checkNSFW(ctx context.Context, userID uuid.UUID, ch *models.Channel) error {
+	if !ch.NSFW {
+		return nil
+	}
+	userRepo := &database.UserRepo{DB: s.db}
+	ok, err := userRepo.NSFWAcknowledged(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNSFWNotAcknowledged
+	}
+	return nil
+}
+
+func (s *Server) handleAcknowledgeNSFW(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userRepo := &database.UserRepo{DB: s.db}
+	at, err := userRepo.AcknowledgeNSFW(r.Context(), user.ID)
+	if err != nil {
+		jsonError(w, "failed to save acknowledgement", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"nsfw_acknowledged_at": at})
+}
+
+// slowmodeError is returned by checkSlowmode when the user has to wait
+// before posting in the channel again.
+type slowmodeError struct {
+	retryAfter time.Duration
+}
+
+func (e *slowmodeError) Error() string {
+	return fmt.Sprintf("slowmode is on; you can send another message in %d seconds", e.seconds())
+}
+
+// seconds is the wait rounded up, so clients never retry too early.
+func (e *slowmodeError) seconds() int {
+	return int(math.Ceil(e.retryAfter.Seconds()))
+}
+
+// checkSlowmode enforces the channel's slowmode on a message the user is
+// about to send, counting it as sent if allowed. Members who can manage
+// messages or the channel are exempt. If the message then can't be saved,
+// the caller calls release so the failed send doesn't hold the user back.
+func (s *Server) checkSlowmode(ctx context.Context, userID uuid.UUID, ch *models.Channel) (release func(), err error) {
+	release = func() {}
+	if ch.ServerID == nil || ch.SlowmodeSeconds <= 0 {
+		return release, nil
+	}
+
+	serverRepo := &database.ServerRepo{DB: s.db}
+	srv, err := serverRepo.GetServerByID(ctx, *ch.ServerID)
+	if err != nil {
+		return nil, err
+	}
+	perms, err := s.channelPermissions(ctx, srv, ch, userID)
+	if err != nil {
+		return nil, err
+	}
+	if perms&(models.PermManageMessages|models.PermManageChannels) != 0 {
+		return release, nil
+	}
+
+	slowmodeRepo := &database.SlowmodeRepo{DB: s.db}
+	postedAt, wait, err := slowmodeRepo.Take(ctx, ch.ID, userID, time.Duration(ch.SlowmodeSeconds)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		return nil, &slowmodeError{retryAfter: wait}
+	}
+	return func() {
+		if err := slowmodeRepo.Release(context.Background(), ch.ID, userID, postedAt); err != nil {
+			log.Printf("failed to release slowmode for %s in channel %s: %v", userID, ch.ID, err)
+		}
+	}, nil
+}
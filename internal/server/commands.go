@@ -0,0 +1,584 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// Command definition limits.
+const (
+	maxBotCommands       = 100
+	maxCommandOptions    = 25
+	maxCommandChoices    = 25
+	maxCommandDesc       = 100
+	maxCommandStringOpt  = 6000
+	maxCommandChoiceName = 100
+)
+
+var commandNameRe = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+var validOptionTypes = map[string]bool{
+	"string":  true,
+	"integer": true,
+	"number":  true,
+	"boolean": true,
+	"user":    true,
+	"channel": true,
+	"role":    true,
+}
+
+// builtinCommand is a slash command the server answers itself, posting as
+// the system user.
+type builtinCommand struct {
+	models.SlashCommand
+	run func(ctx context.Context, s *Server, inv *invocation) (*interactionResponse, error)
+}
+
+// builtinCommands are available in every server. Bots cannot register
+// commands with these names.
+var builtinCommands = map[string]*builtinCommand{
+	"roll":   rollCommand,
+	"remind": remindCommand,
+	"poll":   pollCommand,
+}
+
+func floatPtr(f float64) *float64 { return &f }
+
+var rollCommand = &builtinCommand{
+	SlashCommand: models.SlashCommand{
+		BotID:       database.SystemUserID,
+		Name:        "roll",
+		Description: "Roll some dice",
+		Options: []models.CommandOption{
+			{Name: "sides", Description: "Sides on each die (default 6)", Type: "integer", MinValue: floatPtr(2), MaxValue: floatPtr(1000)},
+			{Name: "count", Description: "Number of dice (default 1)", Type: "integer", MinValue: floatPtr(1), MaxValue: floatPtr(20)},
+		},
+		Builtin: true,
+	},
+	run: func(ctx context.Context, s *Server, inv *invocation) (*interactionResponse, error) {
+		sides, count := int64(6), int64(1)
+		if v, ok := inv.Options["sides"].(int64); ok {
+			sides = v
+		}
+		if v, ok := inv.Options["count"].(int64); ok {
+			count = v
+		}
+
+		rolls := make([]string, count)
+		var total int64
+		for i := range rolls {
+			n := rand.Int64N(sides) + 1
+			total += n
+			rolls[i] = strconv.FormatInt(n, 10)
+		}
+		content := fmt.Sprintf("<@%s> rolled %dd%d: %s", inv.UserID, count, sides, strings.Join(rolls, ", "))
+		if count > 1 {
+			content += fmt.Sprintf(" (total %d)", total)
+		}
+		return &interactionResponse{Type: responseMessage, Content: content}, nil
+	},
+}
+
+// invocation is a slash command call whose options have been resolved
+// against the command's definition.
+type invocation struct {
+	Command *models.SlashCommand
+	Channel *models.Channel
+	UserID  uuid.UUID
+	Options map[string]any
+}
+
+// validateCommand checks a command definition submitted by a bot. Its errors
+// are safe to return to the client.
+func validateCommand(c *models.SlashCommand) error {
+	if !commandNameRe.MatchString(c.Name) {
+		return fmt.Errorf("command name %q must be 1-32 lowercase letters, digits, - or _", c.Name)
+	}
+	if _, ok := builtinCommands[c.Name]; ok {
+		return fmt.Errorf("/%s is a built-in command", c.Name)
+	}
+	c.Description = strings.TrimSpace(c.Description)
+	if c.Description == "" || utf8.RuneCountInString(c.Description) > maxCommandDesc {
+		return fmt.Errorf("/%s: description is required and must be %d characters or less", c.Name, maxCommandDesc)
+	}
+	if len(c.Options) > maxCommandOptions {
+		return fmt.Errorf("/%s: a command can have at most %d options", c.Name, maxCommandOptions)
+	}
+
+	seen := make(map[string]bool, len(c.Options))
+	optional := false
+	for i := range c.Options {
+		o := &c.Options[i]
+		if !commandNameRe.MatchString(o.Name) {
+			return fmt.Errorf("/%s: option name %q must be 1-32 lowercase letters, digits, - or _", c.Name, o.Name)
+		}
+		if seen[o.Name] {
+			return fmt.Errorf("/%s: duplicate option %q", c.Name, o.Name)
+		}
+		seen[o.Name] = true
+		o.Description = strings.TrimSpace(o.Description)
+		if o.Description == "" || utf8.RuneCountInString(o.Description) > maxCommandDesc {
+			return fmt.Errorf("/%s %s: description is required and must be %d characters or less", c.Name, o.Name, maxCommandDesc)
+		}
+		if !validOptionTypes[o.Type] {
+			return fmt.Errorf("/%s %s: unknown option type %q", c.Name, o.Name, o.Type)
+		}
+		if o.Required && optional {
+			return fmt.Errorf("/%s %s: required options must come before optional ones", c.Name, o.Name)
+		}
+		optional = optional || !o.Required
+
+		if len(o.Choices) > 0 {
+			if o.Type != "string" && o.Type != "integer" && o.Type != "number" {
+				return fmt.Errorf("/%s %s: only string, integer and number options can have choices", c.Name, o.Name)
+			}
+			if len(o.Choices) > maxCommandChoices {
+				return fmt.Errorf("/%s %s: an option can have at most %d choices", c.Name, o.Name, maxCommandChoices)
+			}
+			for j := range o.Choices {
+				ch := &o.Choices[j]
+				if ch.Name == "" || utf8.RuneCountInString(ch.Name) > maxCommandChoiceName {
+					return fmt.Errorf("/%s %s: choice names are required and must be %d characters or less", c.Name, o.Name, maxCommandChoiceName)
+				}
+				v, err := coerceOption(o, ch.Value)
+				if err != nil {
+					return fmt.Errorf("/%s %s: choice %q: %v", c.Name, o.Name, ch.Name, err)
+				}
+				ch.Value = v
+			}
+		}
+		if (o.MinValue != nil || o.MaxValue != nil) && o.Type != "string" && o.Type != "integer" && o.Type != "number" {
+			return fmt.Errorf("/%s %s: only string, integer and number options can have bounds", c.Name, o.Name)
+		}
+		if o.MinValue != nil && o.MaxValue != nil && *o.MinValue > *o.MaxValue {
+			return fmt.Errorf("/%s %s: min_value is greater than max_value", c.Name, o.Name)
+		}
+	}
+	return nil
+}
+
+// coerceOption converts a JSON value, or the raw text typed after a
+// command, to the option's type. IDs are also accepted in mention form.
+func coerceOption(o *models.CommandOption, v any) (any, error) {
+	s, isString := v.(string)
+	switch o.Type {
+	case "string":
+		if !isString {
+			return nil, errors.New("must be a string")
+		}
+		return s, nil
+	case "integer":
+		var f float64
+		switch v := v.(type) {
+		case float64:
+			f = v
+		case int64:
+			return v, nil
+		case string:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, errors.New("must be a whole number")
+			}
+			return n, nil
+		default:
+			return nil, errors.New("must be a whole number")
+		}
+		if f != math.Trunc(f) || math.Abs(f) > 1<<53 {
+			return nil, errors.New("must be a whole number")
+		}
+		return int64(f), nil
+	case "number":
+		switch v := v.(type) {
+		case float64:
+			return v, nil
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return nil, errors.New("must be a number")
+			}
+			return f, nil
+		}
+		return nil, errors.New("must be a number")
+	case "boolean":
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		case string:
+			switch strings.ToLower(v) {
+			case "true", "yes", "on":
+				return true, nil
+			case "false", "no", "off":
+				return false, nil
+			}
+		}
+		return nil, errors.New("must be true or false")
+	case "user", "channel", "role":
+		if !isString {
+			return nil, fmt.Errorf("must be a %s id", o.Type)
+		}
+		prefix := map[string]string{"user": "<@", "channel": "<#", "role": "<@&"}[o.Type]
+		if strings.HasPrefix(s, prefix) && strings.HasSuffix(s, ">") {
+			s = s[len(prefix) : len(s)-1]
+		}
+		id, err := uuid.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("must be a %s id", o.Type)
+		}
+		return id, nil
+	}
+	return nil, fmt.Errorf("unknown option type %q", o.Type)
+}
+
+// resolveOptions checks invocation options against the command definition:
+// every option must be declared, required options present, values of the
+// right type, within bounds and among the choices if there are any, and
+// users, channels and roles must belong to the server. Its errors are safe to
+// return to the client.
+func (s *Server) resolveOptions(ctx context.Context, cmd *models.SlashCommand, serverID uuid.UUID, raw map[string]any) (map[string]any, error) {
+	declared := make(map[string]*models.CommandOption, len(cmd.Options))
+	for i := range cmd.Options {
+		declared[cmd.Options[i].Name] = &cmd.Options[i]
+	}
+	for name := range raw {
+		if declared[name] == nil {
+			return nil, fmt.Errorf("/%s has no option %q", cmd.Name, name)
+		}
+	}
+
+	resolved := make(map[string]any, len(raw))
+	for i := range cmd.Options {
+		o := &cmd.Options[i]
+		v, ok := raw[o.Name]
+		if !ok || v == nil {
+			if o.Required {
+				return nil, fmt.Errorf("option %q is required", o.Name)
+			}
+			continue
+		}
+		val, err := coerceOption(o, v)
+		if err != nil {
+			return nil, fmt.Errorf("option %q %v", o.Name, err)
+		}
+		if err := s.checkOptionValue(ctx, o, serverID, val); err != nil {
+			return nil, fmt.Errorf("option %q %v", o.Name, err)
+		}
+		resolved[o.Name] = val
+	}
+	return resolved, nil
+}
+
+func (s *Server) checkOptionValue(ctx context.Context, o *models.CommandOption, serverID uuid.UUID, val any) error {
+	var n float64
+	switch v := val.(type) {
+	case string:
+		n = float64(utf8.RuneCountInString(v))
+		if n > maxCommandStringOpt {
+			return fmt.Errorf("must be %d characters or less", maxCommandStringOpt)
+		}
+	case int64:
+		n = float64(v)
+	case float64:
+		n = v
+	case uuid.UUID:
+		return s.checkOptionTarget(ctx, o.Type, serverID, v)
+	}
+	if o.MinValue != nil && n < *o.MinValue {
+		return fmt.Errorf("must be at least %v", *o.MinValue)
+	}
+	if o.MaxValue != nil && n > *o.MaxValue {
+		return fmt.Errorf("must be at most %v", *o.MaxValue)
+	}
+
+	if len(o.Choices) > 0 {
+		for _, c := range o.Choices {
+			if cv, err := coerceOption(o, c.Value); err == nil && cv == val {
+				return nil
+			}
+		}
+		names := make([]string, len(o.Choices))
+		for i, c := range o.Choices {
+			names[i] = fmt.Sprint(c.Value)
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// checkOptionTarget verifies a user, channel or role option refers to
+// something in the server.
+func (s *Server) checkOptionTarget(ctx context.Context, typ string, serverID, id uuid.UUID) error {
+	var found bool
+	switch typ {
+	case "user":
+		memberRepo := &database.ServerMemberRepo{DB: s.db}
+		isMember, err := memberRepo.IsMember(ctx, id, serverID)
+		if err != nil {
+			return err
+		}
+		found = isMember
+	case "channel":
+		channelRepo := &database.ChannelRepo{DB: s.db}
+		ch, err := channelRepo.GetChannelByID(ctx, id)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		found = err == nil && ch.ServerID != nil && *ch.ServerID == serverID
+	case "role":
+		roleRepo := &database.RoleRepo{DB: s.db}
+		role, err := roleRepo.GetByID(ctx, id)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		found = err == nil && role.ServerID == serverID
+	}
+	if !found {
+		return fmt.Errorf("is not a %s in this server", typ)
+	}
+	return nil
+}
+
+// splitCommandText splits typed command text on spaces, keeping "quoted
+// strings" together and dropping the quotes.
+func splitCommandText(text string) []string {
+	var args []string
+	var cur strings.Builder
+	inQuote, started := false, false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			started = true
+		case r == ' ' && !inQuote:
+			if started {
+				args = append(args, cur.String())
+				cur.Reset()
+				started = false
+			}
+		default:
+			cur.WriteRune(r)
+			started = true
+		}
+	}
+	if started {
+		args = append(args, cur.String())
+	}
+	return args
+}
+
+// parseCommandText turns "/name opt:value other" into the command name and
+// raw options. Arguments written as name:value set that option; the rest
+// fill the remaining options in declared order, and the last string option
+// takes whatever is left over.
+func parseCommandText(text string, lookup func(name string) *models.SlashCommand) (*models.SlashCommand, map[string]any, error) {
+	args := splitCommandText(strings.TrimPrefix(strings.TrimSpace(text), "/"))
+	if len(args) == 0 {
+		return nil, nil, errors.New("command name is required")
+	}
+	cmd := lookup(args[0])
+	if cmd == nil {
+		return nil, nil, nil
+	}
+
+	raw := make(map[string]any)
+	var positional []string
+	for _, arg := range args[1:] {
+		if name, value, ok := strings.Cut(arg, ":"); ok {
+			if hasCommandOption(cmd, name) {
+				raw[name] = value
+				continue
+			}
+		}
+		positional = append(positional, arg)
+	}
+
+	for i, o := range cmd.Options {
+		if len(positional) == 0 {
+			break
+		}
+		if _, set := raw[o.Name]; set {
+			continue
+		}
+		rest := true
+		for _, later := range cmd.Options[i+1:] {
+			if _, set := raw[later.Name]; !set {
+				rest = false
+				break
+			}
+		}
+		if rest && o.Type == "string" {
+			raw[o.Name] = strings.Join(positional, " ")
+			positional = nil
+			break
+		}
+		raw[o.Name] = positional[0]
+		positional = positional[1:]
+	}
+	if len(positional) > 0 {
+		return nil, nil, fmt.Errorf("too many arguments for /%s", cmd.Name)
+	}
+	return cmd, raw, nil
+}
+
+func hasCommandOption(cmd *models.SlashCommand, name string) bool {
+	for _, o := range cmd.Options {
+		if o.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupCommand finds a built-in or server command by name. It returns nil
+// if there is no such command.
+func (s *Server) lookupCommand(ctx context.Context, serverID uuid.UUID, name string) (*models.SlashCommand, error) {
+	if b, ok := builtinCommands[name]; ok {
+		cmd := b.SlashCommand
+		return &cmd, nil
+	}
+	cmdRepo := &database.CommandRepo{DB: s.db}
+	cmd, err := cmdRepo.GetByName(ctx, serverID, name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return cmd, err
+}
+
+// handleListCommands lists the commands usable in a server, built-ins
+// first. ?q= filters by name prefix for autocomplete.
+func (s *Server) handleListCommands(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	isMember, err := memberRepo.IsMember(r.Context(), user.ID, serverID)
+	if err != nil {
+		jsonError(w, "failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		jsonError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	cmdRepo := &database.CommandRepo{DB: s.db}
+	registered, err := cmdRepo.ListByServer(r.Context(), serverID)
+	if err != nil {
+		jsonError(w, "failed to list commands", http.StatusInternalServerError)
+		return
+	}
+
+	prefix := strings.TrimPrefix(strings.ToLower(r.URL.Query().Get("q")), "/")
+	cmds := []models.SlashCommand{}
+	for _, b := range builtinCommands {
+		if strings.HasPrefix(b.Name, prefix) {
+			cmds = append(cmds, b.SlashCommand)
+		}
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+	for _, c := range registered {
+		if strings.HasPrefix(c.Name, prefix) {
+			cmds = append(cmds, c)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cmds)
+}
+
+// handleRegisterCommands replaces the calling bot's commands in a server
+// with the submitted list. Only bots that are members of the server can
+// register commands.
+func (s *Server) handleRegisterCommands(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !user.Bot {
+		jsonError(w, "only bots can register commands", http.StatusForbidden)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	isMember, err := memberRepo.IsMember(r.Context(), user.ID, serverID)
+	if err != nil {
+		jsonError(w, "failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		jsonError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var cmds []models.SlashCommand
+	if err := json.NewDecoder(r.Body).Decode(&cmds); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(cmds) > maxBotCommands {
+		jsonError(w, fmt.Sprintf("a bot can register at most %d commands per server", maxBotCommands), http.StatusBadRequest)
+		return
+	}
+	seen := make(map[string]bool, len(cmds))
+	for i := range cmds {
+		if err := validateCommand(&cmds[i]); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if seen[cmds[i].Name] {
+			jsonError(w, fmt.Sprintf("duplicate command /%s", cmds[i].Name), http.StatusBadRequest)
+			return
+		}
+		seen[cmds[i].Name] = true
+		if cmds[i].Options == nil {
+			cmds[i].Options = []models.CommandOption{}
+		}
+	}
+
+	cmdRepo := &database.CommandRepo{DB: s.db}
+	if err := cmdRepo.Replace(r.Context(), serverID, user.ID, cmds); err != nil {
+		if err == database.ErrCommandTaken {
+			jsonError(w, "another bot in this server already has a command with that name", http.StatusConflict)
+			return
+		}
+		jsonError(w, "failed to register commands", http.StatusInternalServerError)
+		return
+	}
+	if cmds == nil {
+		cmds = []models.SlashCommand{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cmds)
+}
@@ -0,0 +1,578 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/Stocist/discard/internal/upload"
+	"github.com/google/uuid"
+)
+
+// maxGroupDMMembers is the largest a group DM can grow, including its owner.
+const maxGroupDMMembers = 10
+
+// areFriends reports whether two users have an accepted friendship.
+func (s *Server) areFriends(ctx context.Context, a, b uuid.UUID) (bool, error) {
+	friendRepo := &database.FriendshipRepo{DB: s.db}
+	f, err := friendRepo.GetFriendship(ctx, a, b)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return f.Status == "accepted", nil
+}
+
+// sendToUsers marshals payload once and sends it to every listed user's connections.
+func (s *Server) sendToUsers(userIDs []uuid.UUID, payload any) {
+	out, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("ws marshal error: %v", err)
+		return
+	}
+	for _, id := range userIDs {
+		s.hub.SendToUser(id, out)
+	}
+}
+
+func recipientIDs(members []models.UserSummary) []uuid.UUID {
+	ids := make([]uuid.UUID, len(members))
+	for i, m := range members {
+		ids[i] = m.ID
+	}
+	return ids
+}
+
+func (s *Server) handleListMyChannels(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	dmRepo := &database.DMMemberRepo{DB: s.db}
+	channels, err := dmRepo.ListUserChannels(r.Context(), user.ID, false)
+	if err != nil {
+		jsonError(w, "failed to list channels", http.StatusInternalServerError)
+		return
+	}
+	if channels == nil {
+		channels = []models.DMChannel{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channels)
+}
+
+// handleCreateDM opens a DM. With one recipient and no name it returns the
+// existing 1:1 channel if there is one, or opens a new one subject to the
+// recipient's privacy settings; otherwise it creates a group DM of friends
+// owned by the caller.
+func (s *Server) handleCreateDM(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		Recipients []uuid.UUID `json:"recipients"`
+		Name       string      `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if len(input.Name) > 100 {
+		jsonError(w, "channel name must be 100 characters or less", http.StatusBadRequest)
+		return
+	}
+
+	seen := map[uuid.UUID]bool{user.ID: true}
+	var recipients []uuid.UUID
+	for _, id := range input.Recipients {
+		if !seen[id] {
+			seen[id] = true
+			recipients = append(recipients, id)
+		}
+	}
+	if len(recipients) == 0 {
+		jsonError(w, "at least one recipient is required", http.StatusBadRequest)
+		return
+	}
+	if len(recipients)+1 > maxGroupDMMembers {
+		jsonError(w, "group DMs are limited to 10 members", http.StatusBadRequest)
+		return
+	}
+
+	dmRepo := &database.DMMemberRepo{DB: s.db}
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	direct := len(recipients) == 1 && input.Name == ""
+
+	blockRepo := &database.BlockRepo{DB: s.db}
+	for _, id := range recipients {
+		blocked, err := blockRepo.IsBlockedEitherWay(r.Context(), user.ID, id)
+		if err != nil {
+			jsonError(w, "failed to check blocks", http.StatusInternalServerError)
+			return
+		}
+		if blocked {
+			jsonError(w, "you cannot message this user", http.StatusForbidden)
+			return
+		}
+		if direct {
+			// Privacy settings are checked below, after looking for an existing DM.
+			continue
+		}
+		ok, err := s.areFriends(r.Context(), user.ID, id)
+		if err != nil {
+			jsonError(w, "failed to check friendship", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			jsonError(w, "you can only add friends to a group DM", http.StatusForbidden)
+			return
+		}
+	}
+
+	if direct {
+		existing, err := dmRepo.FindDirectChannel(r.Context(), user.ID, recipients[0])
+		if err != nil {
+			jsonError(w, "failed to look up DM channel", http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			ch, err := channelRepo.GetChannelByID(r.Context(), *existing)
+			if err != nil {
+				jsonError(w, "failed to get channel", http.StatusInternalServerError)
+				return
+			}
+			members, err := dmRepo.ListMembers(r.Context(), ch.ID)
+			if err != nil {
+				jsonError(w, "failed to list DM members", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(models.DMChannel{Channel: *ch, Recipients: members})
+			return
+		}
+	}
+
+	messageRequest := false
+	if direct {
+		allowed, request, err := s.canStartDM(r.Context(), user.ID, recipients[0])
+		if err != nil {
+			jsonError(w, "failed to check privacy settings", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			jsonError(w, "this user is not accepting direct messages from you", http.StatusForbidden)
+			return
+		}
+		messageRequest = request
+	}
+
+	ch := &models.Channel{Type: "dm"}
+	if !direct {
+		ch.Type = "group_dm"
+		ch.OwnerID = &user.ID
+		if input.Name != "" {
+			ch.Name = &input.Name
+		}
+	}
+	if err := channelRepo.CreateChannel(r.Context(), ch); err != nil {
+		jsonError(w, "failed to create DM channel", http.StatusInternalServerError)
+		return
+	}
+	for _, id := range append([]uuid.UUID{user.ID}, recipients...) {
+		if err := dmRepo.AddMember(r.Context(), ch.ID, id); err != nil {
+			jsonError(w, "failed to add DM member", http.StatusInternalServerError)
+			return
+		}
+	}
+	if messageRequest {
+		if err := dmRepo.SetMessageRequest(r.Context(), ch.ID, recipients[0], true); err != nil {
+			jsonError(w, "failed to create message request", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	members, err := dmRepo.ListMembers(r.Context(), ch.ID)
+	if err != nil {
+		jsonError(w, "failed to list DM members", http.StatusInternalServerError)
+		return
+	}
+	dc := models.DMChannel{Channel: *ch, Recipients: members}
+
+	if messageRequest {
+		// The recipient sees it in their message request inbox, not their DM list.
+		s.sendToUsers([]uuid.UUID{user.ID}, map[string]any{
+			"type":    "channel_create",
+			"channel": dc,
+		})
+		s.sendToUsers(recipients, map[string]any{
+			"type":    "message_request",
+			"channel": dc,
+		})
+	} else {
+		s.sendToUsers(recipientIDs(members), map[string]any{
+			"type":    "channel_create",
+			"channel": dc,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dc)
+}
+
+// loadGroupDM parses {id}, and checks it names a group DM the user belongs to.
+// On failure it writes the error response and returns nil.
+func (s *Server) loadGroupDM(w http.ResponseWriter, r *http.Request, userID uuid.UUID) *models.Channel {
+	channelID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid channel id", http.StatusBadRequest)
+		return nil
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(r.Context(), channelID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "channel not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return nil
+	}
+	if ch.Type != "group_dm" {
+		jsonError(w, "channel is not a group DM", http.StatusBadRequest)
+		return nil
+	}
+
+	dmRepo := &database.DMMemberRepo{DB: s.db}
+	isMember, err := dmRepo.IsMember(r.Context(), channelID, userID)
+	if err != nil {
+		jsonError(w, "failed to check DM membership", http.StatusInternalServerError)
+		return nil
+	}
+	if !isMember {
+		jsonError(w, "forbidden", http.StatusForbidden)
+		return nil
+	}
+	return ch
+}
+
+func (s *Server) handleUpdateGroupDM(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ch := s.loadGroupDM(w, r, user.ID)
+	if ch == nil {
+		return
+	}
+
+	// Only fields present in the request change; an icon upload on its own
+	// keeps the current name. An empty name clears it.
+	name := ch.Name
+	var iconPath *string
+
+	contentType := r.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			jsonError(w, "invalid multipart form", http.StatusBadRequest)
+			return
+		}
+		if values, ok := r.MultipartForm.Value["name"]; ok && len(values) > 0 {
+			name = groupDMName(values[0])
+		}
+
+		if fh, _, err := r.FormFile("icon"); err == nil {
+			fh.Close()
+			fileHeader := r.MultipartForm.File["icon"][0]
+			result, err := upload.ProcessFile(s.uploadDir, fileHeader)
+			if err != nil {
+				log.Printf("group DM icon upload error: %v", err)
+				jsonError(w, "failed to process icon", http.StatusBadRequest)
+				return
+			}
+			iconPath = &result.FilePath
+		}
+	} else {
+		var input struct {
+			Name *string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if input.Name != nil {
+			name = groupDMName(*input.Name)
+		}
+	}
+
+	if name != nil && len(*name) > 100 {
+		jsonError(w, "channel name must be 100 characters or less", http.StatusBadRequest)
+		return
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	updated, err := channelRepo.UpdateGroupDM(r.Context(), ch.ID, name, iconPath)
+	if err != nil {
+		jsonError(w, "failed to update channel", http.StatusInternalServerError)
+		return
+	}
+
+	dmRepo := &database.DMMemberRepo{DB: s.db}
+	members, err := dmRepo.ListMembers(r.Context(), ch.ID)
+	if err == nil {
+		s.sendToUsers(recipientIDs(members), map[string]any{
+			"type":    "channel_update",
+			"channel": updated,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// groupDMName trims a submitted group DM name, returning nil for an empty
+// one so the name is cleared.
+func groupDMName(raw string) *string {
+	name := strings.TrimSpace(raw)
+	if name == "" {
+		return nil
+	}
+	return &name
+}
+
+func (s *Server) handleAddRecipient(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ch := s.loadGroupDM(w, r, user.ID)
+	if ch == nil {
+		return
+	}
+
+	targetID, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		jsonError(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := s.areFriends(r.Context(), user.ID, targetID)
+	if err != nil {
+		jsonError(w, "failed to check friendship", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		jsonError(w, "you can only add friends to a DM", http.StatusForbidden)
+		return
+	}
+
+	dmRepo := &database.DMMemberRepo{DB: s.db}
+	members, err := dmRepo.ListMembers(r.Context(), ch.ID)
+	if err != nil {
+		jsonError(w, "failed to list DM members", http.StatusInternalServerError)
+		return
+	}
+	for _, m := range members {
+		if m.ID == targetID {
+			jsonError(w, "user is already in this DM", http.StatusConflict)
+			return
+		}
+	}
+	if len(members) >= maxGroupDMMembers {
+		jsonError(w, "group DMs are limited to 10 members", http.StatusBadRequest)
+		return
+	}
+
+	if err := dmRepo.AddMember(r.Context(), ch.ID, targetID); err != nil {
+		jsonError(w, "failed to add DM member", http.StatusInternalServerError)
+		return
+	}
+
+	members, err = dmRepo.ListMembers(r.Context(), ch.ID)
+	if err != nil {
+		jsonError(w, "failed to list DM members", http.StatusInternalServerError)
+		return
+	}
+	dc := models.DMChannel{Channel: *ch, Recipients: members}
+
+	s.sendToUsers([]uuid.UUID{targetID}, map[string]any{
+		"type":    "channel_create",
+		"channel": dc,
+	})
+	s.sendToUsers(recipientIDs(members), map[string]any{
+		"type":       "channel_recipient_add",
+		"channel_id": ch.ID.String(),
+		"user_id":    targetID.String(),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dc)
+}
+
+func (s *Server) handleRemoveRecipient(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ch := s.loadGroupDM(w, r, user.ID)
+	if ch == nil {
+		return
+	}
+
+	targetID, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		jsonError(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+	if targetID != user.ID && (ch.OwnerID == nil || *ch.OwnerID != user.ID) {
+		jsonError(w, "only the group owner can remove members", http.StatusForbidden)
+		return
+	}
+
+	s.removeRecipient(w, r, ch, targetID)
+}
+
+func (s *Server) handleLeaveGroupDM(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ch := s.loadGroupDM(w, r, user.ID)
+	if ch == nil {
+		return
+	}
+
+	s.removeRecipient(w, r, ch, user.ID)
+}
+
+// removeRecipient takes a user out of a group DM. If the owner leaves,
+// ownership passes to the longest-standing remaining member; if nobody is
+// left the channel is deleted.
+func (s *Server) removeRecipient(w http.ResponseWriter, r *http.Request, ch *models.Channel, userID uuid.UUID) {
+	dmRepo := &database.DMMemberRepo{DB: s.db}
+	err := dmRepo.RemoveMember(r.Context(), ch.ID, userID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "user is not in this DM", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to remove DM member", http.StatusInternalServerError)
+		return
+	}
+
+	s.hub.UnsubscribeUser(userID, []uuid.UUID{ch.ID})
+	s.sendToUsers([]uuid.UUID{userID}, map[string]string{
+		"type":       "channel_delete",
+		"channel_id": ch.ID.String(),
+	})
+
+	members, err := dmRepo.ListMembers(r.Context(), ch.ID)
+	if err != nil {
+		jsonError(w, "failed to list DM members", http.StatusInternalServerError)
+		return
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	if len(members) == 0 {
+		if err := channelRepo.DeleteChannel(r.Context(), ch.ID); err != nil {
+			log.Printf("failed to delete empty group DM %s: %v", ch.ID, err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	event := map[string]any{
+		"type":       "channel_recipient_remove",
+		"channel_id": ch.ID.String(),
+		"user_id":    userID.String(),
+	}
+	if ch.OwnerID != nil && *ch.OwnerID == userID {
+		newOwner := members[0].ID
+		if err := channelRepo.SetOwner(r.Context(), ch.ID, newOwner); err != nil {
+			jsonError(w, "failed to transfer ownership", http.StatusInternalServerError)
+			return
+		}
+		event["owner_id"] = newOwner.String()
+	}
+	s.sendToUsers(recipientIDs(members), event)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleTransferGroupDMOwner(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ch := s.loadGroupDM(w, r, user.ID)
+	if ch == nil {
+		return
+	}
+	if ch.OwnerID == nil || *ch.OwnerID != user.ID {
+		jsonError(w, "only the group owner can transfer ownership", http.StatusForbidden)
+		return
+	}
+
+	var input struct {
+		UserID uuid.UUID `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	dmRepo := &database.DMMemberRepo{DB: s.db}
+	isMember, err := dmRepo.IsMember(r.Context(), ch.ID, input.UserID)
+	if err != nil {
+		jsonError(w, "failed to check DM membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		jsonError(w, "new owner must be in this DM", http.StatusBadRequest)
+		return
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	if err := channelRepo.SetOwner(r.Context(), ch.ID, input.UserID); err != nil {
+		jsonError(w, "failed to transfer ownership", http.StatusInternalServerError)
+		return
+	}
+	ch.OwnerID = &input.UserID
+
+	members, err := dmRepo.ListMembers(r.Context(), ch.ID)
+	if err == nil {
+		s.sendToUsers(recipientIDs(members), map[string]any{
+			"type":    "channel_update",
+			"channel": ch,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ch)
+}
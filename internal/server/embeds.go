@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Stocist/discard/internal/models"
+)
+
+// Embed limits, matching Discord's so payloads written for it fit here too.
+const (
+	maxEmbeds           = 10
+	maxEmbedTitle       = 256
+	maxEmbedDescription = 4096
+	maxEmbedFields      = 25
+	maxEmbedFieldName   = 256
+	maxEmbedFieldValue  = 1024
+	maxEmbedFooter      = 2048
+	maxEmbedAuthorName  = 256
+	maxEmbedTotal       = 6000
+	maxEmbedColor       = 0xFFFFFF
+)
+
+func embedErrorf(i int, format string, args ...any) error {
+	return fmt.Errorf("embeds[%d]: %s", i, fmt.Sprintf(format, args...))
+}
+
+// validateEmbeds checks embeds against the size limits, trims surrounding
+// whitespace from their text, and requires every link to be http or https.
+// Submitted embeds are always "rich"; only link previews have other types
+// or a provider. Its errors are safe to return to the client.
+func validateEmbeds(embeds []models.Embed) error {
+	if len(embeds) > maxEmbeds {
+		return fmt.Errorf("a message can have at most %d embeds", maxEmbeds)
+	}
+
+	total := 0
+	for i := range embeds {
+		e := &embeds[i]
+		e.Type = "rich"
+		e.Provider = nil
+		e.Title = strings.TrimSpace(e.Title)
+		e.Description = strings.TrimSpace(e.Description)
+
+		title := utf8.RuneCountInString(e.Title)
+		description := utf8.RuneCountInString(e.Description)
+		if title > maxEmbedTitle {
+			return embedErrorf(i, "title must be %d characters or less", maxEmbedTitle)
+		}
+		if description > maxEmbedDescription {
+			return embedErrorf(i, "description must be %d characters or less", maxEmbedDescription)
+		}
+		total += title + description
+		if e.URL != "" && !isHTTPURL(e.URL) {
+			return embedErrorf(i, "url must be an http or https URL")
+		}
+		if e.Color != nil && (*e.Color < 0 || *e.Color > maxEmbedColor) {
+			return embedErrorf(i, "color must be between 0 and %d", maxEmbedColor)
+		}
+
+		if e.Author != nil {
+			e.Author.Name = strings.TrimSpace(e.Author.Name)
+			n := utf8.RuneCountInString(e.Author.Name)
+			if n == 0 || n > maxEmbedAuthorName {
+				return embedErrorf(i, "author name is required and must be %d characters or less", maxEmbedAuthorName)
+			}
+			total += n
+			if (e.Author.URL != "" && !isHTTPURL(e.Author.URL)) || (e.Author.IconURL != "" && !isHTTPURL(e.Author.IconURL)) {
+				return embedErrorf(i, "author links must be http or https URLs")
+			}
+		}
+		if e.Footer != nil {
+			e.Footer.Text = strings.TrimSpace(e.Footer.Text)
+			n := utf8.RuneCountInString(e.Footer.Text)
+			if n == 0 || n > maxEmbedFooter {
+				return embedErrorf(i, "footer text is required and must be %d characters or less", maxEmbedFooter)
+			}
+			total += n
+			if e.Footer.IconURL != "" && !isHTTPURL(e.Footer.IconURL) {
+				return embedErrorf(i, "footer icon must be an http or https URL")
+			}
+		}
+		if e.Image != nil && !isHTTPURL(e.Image.URL) {
+			return embedErrorf(i, "image must be an http or https URL")
+		}
+		if e.Thumbnail != nil && !isHTTPURL(e.Thumbnail.URL) {
+			return embedErrorf(i, "thumbnail must be an http or https URL")
+		}
+
+		if len(e.Fields) > maxEmbedFields {
+			return embedErrorf(i, "an embed can have at most %d fields", maxEmbedFields)
+		}
+		for j := range e.Fields {
+			f := &e.Fields[j]
+			f.Name = strings.TrimSpace(f.Name)
+			f.Value = strings.TrimSpace(f.Value)
+			name := utf8.RuneCountInString(f.Name)
+			value := utf8.RuneCountInString(f.Value)
+			if name == 0 || name > maxEmbedFieldName {
+				return embedErrorf(i, "fields[%d] name is required and must be %d characters or less", j, maxEmbedFieldName)
+			}
+			if value == 0 || value > maxEmbedFieldValue {
+				return embedErrorf(i, "fields[%d] value is required and must be %d characters or less", j, maxEmbedFieldValue)
+			}
+			total += name + value
+		}
+
+		if e.Title == "" && e.Description == "" && e.Author == nil && e.Footer == nil &&
+			e.Image == nil && e.Thumbnail == nil && len(e.Fields) == 0 {
+			return embedErrorf(i, "embed is empty")
+		}
+	}
+
+	if total > maxEmbedTotal {
+		return fmt.Errorf("embeds must total %d characters or less", maxEmbedTotal)
+	}
+	return nil
+}
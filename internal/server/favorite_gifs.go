@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/Stocist/discard/internal/upload"
+)
+
+// maxFavoriteGIFs caps the size of a user's GIF library.
+const maxFavoriteGIFs = 250
+
+// isGIF reports whether a file can go in the GIF library: GIFs, and WebP,
+// which can be animated too.
+func isGIF(mimeType string) bool {
+	return mimeType == "image/gif" || mimeType == "image/webp"
+}
+
+// attachFavoriteGIF records a favorite GIF as an attachment of a message,
+// sharing its file.
+func (s *Server) attachFavoriteGIF(ctx context.Context, messageID uuid.UUID, g *models.FavoriteGIF) (*models.Attachment, error) {
+	att := &models.Attachment{
+		MessageID:    messageID,
+		FilePath:     g.FilePath,
+		OriginalName: filepath.Base(g.FilePath),
+		MimeType:     &g.MimeType,
+		FileSize:     &g.FileSize,
+		Width:        g.Width,
+		Height:       g.Height,
+	}
+	attachmentRepo := &database.AttachmentRepo{DB: s.db}
+	if err := attachmentRepo.Create(ctx, att); err != nil {
+		return nil, err
+	}
+	return att, nil
+}
+
+func (s *Server) handleListFavoriteGIFs(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	gifRepo := &database.FavoriteGIFRepo{DB: s.db}
+	gifs, err := gifRepo.ListByUser(r.Context(), user.ID)
+	if err != nil {
+		jsonError(w, "failed to list gifs", http.StatusInternalServerError)
+		return
+	}
+	if gifs == nil {
+		gifs = []models.FavoriteGIF{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(gifs)
+}
+
+// handleSaveFavoriteGIF adds a GIF to the user's library, either from an
+// attachment they can see, given as JSON {"attachment_id"}, or uploaded as
+// the "file" part of a multipart form.
+func (s *Server) handleSaveFavoriteGIF(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	gifRepo := &database.FavoriteGIFRepo{DB: s.db}
+	n, err := gifRepo.Count(r.Context(), user.ID)
+	if err != nil {
+		jsonError(w, "failed to count gifs", http.StatusInternalServerError)
+		return
+	}
+	if n >= maxFavoriteGIFs {
+		jsonError(w, fmt.Sprintf("you can save at most %d gifs", maxFavoriteGIFs), http.StatusBadRequest)
+		return
+	}
+
+	g := &models.FavoriteGIF{UserID: user.ID}
+	uploaded := false
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var input struct {
+			AttachmentID uuid.UUID `json:"attachment_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		att := s.visibleAttachment(w, r, user.ID, input.AttachmentID)
+		if att == nil {
+			return
+		}
+		if att.MimeType == nil || !isGIF(*att.MimeType) {
+			jsonError(w, "attachment is not a gif", http.StatusBadRequest)
+			return
+		}
+		g.FilePath, g.MimeType, g.Width, g.Height = att.FilePath, *att.MimeType, att.Width, att.Height
+		if att.FileSize != nil {
+			g.FileSize = *att.FileSize
+		}
+	} else {
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			jsonError(w, "invalid multipart form", http.StatusBadRequest)
+			return
+		}
+		files := r.MultipartForm.File["file"]
+		if len(files) != 1 {
+			jsonError(w, "exactly one file is required", http.StatusBadRequest)
+			return
+		}
+		if !isGIF(upload.MediaType(files[0])) {
+			jsonError(w, "file is not a gif", http.StatusBadRequest)
+			return
+		}
+		result, err := upload.ProcessFile(s.uploadDir, files[0])
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		g.FilePath, g.MimeType, g.FileSize, g.Width, g.Height = result.FilePath, result.MimeType, result.FileSize, result.Width, result.Height
+		uploaded = true
+	}
+
+	if err := gifRepo.Create(r.Context(), g); err != nil {
+		if uploaded {
+			s.removeUpload(g.FilePath)
+		}
+		jsonError(w, "failed to save gif", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(g)
+}
+
+// visibleAttachment loads an attachment of a message the user can read. It
+// writes the error response and returns nil otherwise.
+func (s *Server) visibleAttachment(w http.ResponseWriter, r *http.Request, userID, attachmentID uuid.UUID) *models.Attachment {
+	attachmentRepo := &database.AttachmentRepo{DB: s.db}
+	att, err := attachmentRepo.GetByID(r.Context(), attachmentID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "attachment not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		jsonError(w, "failed to get attachment", http.StatusInternalServerError)
+		return nil
+	}
+
+	msgRepo := &database.MessageRepo{DB: s.db}
+	msg, err := msgRepo.GetByID(r.Context(), att.MessageID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "attachment not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		jsonError(w, "failed to get message", http.StatusInternalServerError)
+		return nil
+	}
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(r.Context(), msg.ChannelID)
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return nil
+	}
+	ok, err := s.canViewChannel(r.Context(), userID, ch)
+	if err != nil {
+		jsonError(w, "failed to check channel access", http.StatusInternalServerError)
+		return nil
+	}
+	if !ok {
+		jsonError(w, "attachment not found", http.StatusNotFound)
+		return nil
+	}
+	return att
+}
+
+func (s *Server) handleDeleteFavoriteGIF(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid gif id", http.StatusBadRequest)
+		return
+	}
+
+	gifRepo := &database.FavoriteGIFRepo{DB: s.db}
+	orphaned, err := gifRepo.Delete(r.Context(), id, user.ID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "gif not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to delete gif", http.StatusInternalServerError)
+		return
+	}
+	if orphaned != "" {
+		s.removeUpload(orphaned)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
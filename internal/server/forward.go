@@ -0,0 +1,148 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+)
+
+func (s *Server) handleForwardMessage(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	messageID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	var input struct {
+		ChannelID uuid.UUID `json:"channel_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if input.ChannelID == uuid.Nil {
+		jsonError(w, "channel_id is required", http.StatusBadRequest)
+		return
+	}
+
+	msgRepo := &database.MessageRepo{DB: s.db}
+	original, err := msgRepo.GetByID(r.Context(), messageID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get message", http.StatusInternalServerError)
+		return
+	}
+
+	// The forwarder must be able to read the message. Answer as if it did
+	// not exist otherwise, so IDs can't be probed.
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	source, err := channelRepo.GetChannelByID(r.Context(), original.ChannelID)
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return
+	}
+	ok, err := s.canViewChannel(r.Context(), user.ID, source)
+	if err != nil {
+		jsonError(w, "failed to check channel access", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		jsonError(w, "message not found", http.StatusNotFound)
+		return
+	}
+
+	dest, err := channelRepo.GetChannelByID(r.Context(), input.ChannelID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return
+	}
+	if err := s.checkSendAccess(r.Context(), user.ID, dest); err != nil {
+		writeAccessError(w, err)
+		return
+	}
+
+	embeds := richEmbeds(original.Embeds)
+	attachmentRepo := &database.AttachmentRepo{DB: s.db}
+	atts, err := attachmentRepo.ListByMessage(r.Context(), original.ID)
+	if err != nil {
+		jsonError(w, "failed to get attachments", http.StatusInternalServerError)
+		return
+	}
+	if original.Content == "" && len(embeds) == 0 && len(atts) == 0 {
+		jsonError(w, "message has nothing to forward", http.StatusBadRequest)
+		return
+	}
+
+	// Forwarding a forward points at the message it came from in the first
+	// place.
+	from := original.ForwardedFrom
+	if from == nil {
+		from = &models.MessageForward{
+			MessageID: original.ID,
+			ChannelID: source.ID,
+			ServerID:  source.ServerID,
+			AuthorID:  original.AuthorID,
+			CreatedAt: original.CreatedAt,
+		}
+		if source.ServerID != nil && source.Name != nil {
+			from.ChannelName = *source.Name
+		}
+	}
+
+	release, err := s.checkSlowmode(r.Context(), user.ID, dest)
+	if err != nil {
+		writeAccessError(w, err)
+		return
+	}
+
+	msg := &models.Message{
+		ChannelID:     dest.ID,
+		AuthorID:      user.ID,
+		Content:       original.Content,
+		Embeds:        embeds,
+		ForwardedFrom: from,
+	}
+	if err := msgRepo.Create(r.Context(), msg); err != nil {
+		release()
+		jsonError(w, "failed to forward message", http.StatusInternalServerError)
+		return
+	}
+	if len(atts) > 0 {
+		msg.Attachments, err = attachmentRepo.Copy(r.Context(), original.ID, msg.ID)
+		if err != nil {
+			// Don't leave a forward that is missing its files.
+			if _, err := msgRepo.DeleteMessages(r.Context(), dest.ID, []uuid.UUID{msg.ID}, user.ID); err != nil {
+				log.Printf("failed to delete message %s after attachment error: %v", msg.ID, err)
+			} else {
+				release()
+			}
+			jsonError(w, "failed to forward attachments", http.StatusInternalServerError)
+			return
+		}
+	}
+	s.announceMessage(r.Context(), dest, msg)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(msg)
+}
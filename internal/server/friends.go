@@ -0,0 +1,296 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+func userSummary(u *models.User) models.UserSummary {
+	return models.UserSummary{
+		ID:          u.ID,
+		Username:    u.Username,
+		DisplayName: u.DisplayName,
+		AvatarURL:   u.AvatarPath,
+	}
+}
+
+// notifyRelationship sends eventType to both users, each describing the other
+// user from their own side. aType/bType are the relationship types as seen by
+// a and b ("friend", "incoming", "outgoing", "blocked" or "none").
+func (s *Server) notifyRelationship(eventType string, id uuid.UUID, a, b *models.User, aType, bType string, dmChannelID *uuid.UUID) {
+	var idPtr *uuid.UUID
+	if id != uuid.Nil {
+		idPtr = &id
+	}
+	now := time.Now()
+	for _, side := range []struct {
+		to, other *models.User
+		relType   string
+	}{{a, b, aType}, {b, a, bType}} {
+		s.sendToUsers([]uuid.UUID{side.to.ID}, map[string]any{
+			"type": eventType,
+			"relationship": models.Relationship{
+				ID:          idPtr,
+				Type:        side.relType,
+				User:        userSummary(side.other),
+				Online:      s.hub.Presence().IsOnline(side.other.ID),
+				DMChannelID: dmChannelID,
+				Since:       now,
+			},
+		})
+	}
+}
+
+// loadFriendRequest fetches the pending request {id} and checks the user is
+// part of it. On failure it writes the error response and returns nil.
+func (s *Server) loadFriendRequest(w http.ResponseWriter, r *http.Request, userID uuid.UUID) *models.Friendship {
+	friendshipID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid friendship id", http.StatusBadRequest)
+		return nil
+	}
+
+	friendRepo := &database.FriendshipRepo{DB: s.db}
+	f, err := friendRepo.GetByID(r.Context(), friendshipID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "friend request not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		jsonError(w, "failed to get friend request", http.StatusInternalServerError)
+		return nil
+	}
+	if f.UserA != userID && f.UserB != userID {
+		jsonError(w, "forbidden", http.StatusForbidden)
+		return nil
+	}
+	if f.Status != "pending" {
+		jsonError(w, "friend request is not pending", http.StatusConflict)
+		return nil
+	}
+	return f
+}
+
+// deleteRelationship removes a friendship row and tells both users it is gone.
+func (s *Server) deleteRelationship(w http.ResponseWriter, r *http.Request, user *models.User, f *models.Friendship) {
+	friendRepo := &database.FriendshipRepo{DB: s.db}
+	if err := friendRepo.Delete(r.Context(), f.ID); err != nil && err != sql.ErrNoRows {
+		jsonError(w, "failed to update friendship", http.StatusInternalServerError)
+		return
+	}
+
+	otherID := f.UserA
+	if otherID == user.ID {
+		otherID = f.UserB
+	}
+	userRepo := &database.UserRepo{DB: s.db}
+	if other, err := userRepo.GetByID(r.Context(), otherID); err == nil {
+		s.notifyRelationship("relationship_update", uuid.Nil, user, other, "none", "none", nil)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDeclineFriend(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	f := s.loadFriendRequest(w, r, user.ID)
+	if f == nil {
+		return
+	}
+	if f.InitiatedBy == user.ID {
+		jsonError(w, "cannot decline your own friend request", http.StatusForbidden)
+		return
+	}
+
+	s.deleteRelationship(w, r, user, f)
+}
+
+func (s *Server) handleCancelFriendRequest(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	f := s.loadFriendRequest(w, r, user.ID)
+	if f == nil {
+		return
+	}
+	if f.InitiatedBy != user.ID {
+		jsonError(w, "only the sender can cancel a friend request", http.StatusForbidden)
+		return
+	}
+
+	s.deleteRelationship(w, r, user, f)
+}
+
+func (s *Server) handleListFriendRequests(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	friendRepo := &database.FriendshipRepo{DB: s.db}
+	pending, err := friendRepo.ListRelationships(r.Context(), user.ID, "pending")
+	if err != nil {
+		jsonError(w, "failed to list friend requests", http.StatusInternalServerError)
+		return
+	}
+
+	incoming := []models.Relationship{}
+	outgoing := []models.Relationship{}
+	for _, rel := range pending {
+		rel.Online = s.hub.Presence().IsOnline(rel.User.ID)
+		if rel.Type == "incoming" {
+			incoming = append(incoming, rel)
+		} else {
+			outgoing = append(outgoing, rel)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]models.Relationship{
+		"incoming": incoming,
+		"outgoing": outgoing,
+	})
+}
+
+func (s *Server) handleRemoveFriend(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	otherID, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		jsonError(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	friendRepo := &database.FriendshipRepo{DB: s.db}
+	f, err := friendRepo.GetFriendship(r.Context(), user.ID, otherID)
+	if err == sql.ErrNoRows || (err == nil && f.Status != "accepted") {
+		jsonError(w, "not friends with this user", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get friendship", http.StatusInternalServerError)
+		return
+	}
+
+	s.deleteRelationship(w, r, user, f)
+}
+
+func (s *Server) handleBlockUser(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	targetID, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		jsonError(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+	if targetID == user.ID {
+		jsonError(w, "cannot block yourself", http.StatusBadRequest)
+		return
+	}
+
+	userRepo := &database.UserRepo{DB: s.db}
+	target, err := userRepo.GetByID(r.Context(), targetID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to look up user", http.StatusInternalServerError)
+		return
+	}
+
+	blockRepo := &database.BlockRepo{DB: s.db}
+	if err := blockRepo.Block(r.Context(), user.ID, targetID); err != nil {
+		jsonError(w, "failed to block user", http.StatusInternalServerError)
+		return
+	}
+
+	// The blocked user only learns the friendship is gone.
+	s.notifyRelationship("relationship_update", uuid.Nil, user, target, "blocked", "none", nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleUnblockUser(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	targetID, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		jsonError(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	blockRepo := &database.BlockRepo{DB: s.db}
+	err = blockRepo.Unblock(r.Context(), user.ID, targetID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "user is not blocked", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to unblock user", http.StatusInternalServerError)
+		return
+	}
+
+	out, err := json.Marshal(map[string]any{
+		"type": "relationship_update",
+		"relationship": models.Relationship{
+			Type:  "none",
+			User:  models.UserSummary{ID: targetID},
+			Since: time.Now(),
+		},
+	})
+	if err == nil {
+		s.hub.SendToUser(user.ID, out)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListBlocks(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	blockRepo := &database.BlockRepo{DB: s.db}
+	blocked, err := blockRepo.ListBlocked(r.Context(), user.ID)
+	if err != nil {
+		jsonError(w, "failed to list blocked users", http.StatusInternalServerError)
+		return
+	}
+	if blocked == nil {
+		blocked = []models.Relationship{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blocked)
+}
@@ -1,11 +1,12 @@
 package server
 
 import (
-	"crypto/rand"
+	"context"
 	"database/sql"
-	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"strconv"
 	"strings"
@@ -23,8 +24,21 @@ func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
+
+	// The client loads its initial state from here, so include the settings
+	// every device needs to agree on.
+	notifRepo := &database.NotificationRepo{DB: s.db}
+	settings, err := notifRepo.GetSettings(r.Context(), user.ID)
+	if err != nil {
+		jsonError(w, "failed to get notification settings", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(struct {
+		*models.User
+		NotificationSettings *models.NotificationSettings `json:"notification_settings"`
+	}{user, settings})
 }
 
 func (s *Server) handleUpdateMe(w http.ResponseWriter, r *http.Request) {
@@ -103,10 +117,8 @@ func (s *Server) handleCreateServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate invite code.
-	codeBytes := make([]byte, 8)
-	rand.Read(codeBytes)
-	inviteCode := hex.EncodeToString(codeBytes)
+	// Generate the server's default, permanent invite code.
+	inviteCode := generateInviteCode()
 
 	srv := &models.Server{
 		Name:       input.Name,
@@ -130,6 +142,16 @@ func (s *Server) handleCreateServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	inviteRepo := &database.InviteRepo{DB: s.db}
+	if err := inviteRepo.Create(r.Context(), &models.Invite{
+		Code:      inviteCode,
+		ServerID:  srv.ID,
+		CreatorID: user.ID,
+	}); err != nil {
+		jsonError(w, "failed to create invite", http.StatusInternalServerError)
+		return
+	}
+
 	// Create default "general" text channel.
 	channelName := "general"
 	ch := &models.Channel{
@@ -288,6 +310,15 @@ func (s *Server) handleUpdateServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.audit(r, auditEvent{
+		ServerID:   serverID,
+		Action:     auditServerUpdate,
+		TargetType: auditTargetServer,
+		TargetID:   &serverID,
+		Before:     srv,
+		After:      updated,
+	})
+
 	// Broadcast server update to all clients so sidebars refresh.
 	out, err := json.Marshal(map[string]any{
 		"type":   "server_update",
@@ -379,8 +410,9 @@ func (s *Server) handleCreateChannel(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var input struct {
-		Name string `json:"name"`
-		Type string `json:"type"`
+		Name     string     `json:"name"`
+		Type     string     `json:"type"`
+		ParentID *uuid.UUID `json:"parent_id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
@@ -397,18 +429,54 @@ func (s *Server) handleCreateChannel(w http.ResponseWriter, r *http.Request) {
 	if input.Type == "" {
 		input.Type = "text"
 	}
+	if !serverChannelTypes[input.Type] {
+		jsonError(w, "type must be text, voice, category or announcement", http.StatusBadRequest)
+		return
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	if input.ParentID != nil {
+		if input.Type == "category" {
+			jsonError(w, "categories cannot be nested", http.StatusBadRequest)
+			return
+		}
+		parent, err := channelRepo.GetChannelByID(r.Context(), *input.ParentID)
+		if err != nil && err != sql.ErrNoRows {
+			jsonError(w, "failed to get category", http.StatusInternalServerError)
+			return
+		}
+		if err == sql.ErrNoRows || parent.ServerID == nil || *parent.ServerID != serverID || parent.Type != "category" {
+			jsonError(w, "parent must be a category in this server", http.StatusBadRequest)
+			return
+		}
+	}
+	position, err := channelRepo.NextPosition(r.Context(), serverID, input.ParentID)
+	if err != nil {
+		jsonError(w, "failed to place channel", http.StatusInternalServerError)
+		return
+	}
 
 	ch := &models.Channel{
 		ServerID: &serverID,
 		Name:     &input.Name,
 		Type:     input.Type,
+		Position: position,
+		ParentID: input.ParentID,
 	}
-	channelRepo := &database.ChannelRepo{DB: s.db}
 	if err := channelRepo.CreateChannel(r.Context(), ch); err != nil {
 		jsonError(w, "failed to create channel", http.StatusInternalServerError)
 		return
 	}
 
+	s.audit(r, auditEvent{
+		ServerID:   serverID,
+		Action:     auditChannelCreate,
+		TargetType: auditTargetChannel,
+		TargetID:   &ch.ID,
+		After:      ch,
+	})
+	s.emitChannelWebhookEvent(r.Context(), ch, hookChannelCreate, ch)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(ch)
@@ -471,7 +539,7 @@ func (s *Server) handleUpdateChannel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Only server owner can rename channels.
+	// Only server owner can change channel settings.
 	serverRepo := &database.ServerRepo{DB: s.db}
 	srv, err := serverRepo.GetServerByID(r.Context(), serverID)
 	if err == sql.ErrNoRows {
@@ -483,26 +551,22 @@ func (s *Server) handleUpdateChannel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if srv.OwnerID != user.ID {
-		jsonError(w, "only the server owner can rename channels", http.StatusForbidden)
+		jsonError(w, "only the server owner can edit channels", http.StatusForbidden)
 		return
 	}
 
+	// Every field is optional; only the ones sent are changed.
 	var input struct {
-		Name string `json:"name"`
+		Name               *string `json:"name"`
+		Topic              *string `json:"topic"`
+		NSFW               *bool   `json:"nsfw"`
+		SlowmodeSeconds    *int    `json:"slowmode_seconds"`
+		DefaultAutoArchive *int    `json:"default_auto_archive_duration"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
-	input.Name = strings.TrimSpace(input.Name)
-	if input.Name == "" {
-		jsonError(w, "name is required", http.StatusBadRequest)
-		return
-	}
-	if len(input.Name) > 100 {
-		jsonError(w, "channel name must be 100 characters or less", http.StatusBadRequest)
-		return
-	}
 
 	// Verify channel belongs to this server.
 	channelRepo := &database.ChannelRepo{DB: s.db}
@@ -520,12 +584,65 @@ func (s *Server) handleUpdateChannel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updated, err := channelRepo.UpdateChannel(r.Context(), channelID, input.Name)
+	settings := *ch
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" {
+			jsonError(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		if len(name) > 100 {
+			jsonError(w, "channel name must be 100 characters or less", http.StatusBadRequest)
+			return
+		}
+		settings.Name = &name
+	}
+	if input.Topic != nil {
+		topic := strings.TrimSpace(*input.Topic)
+		if len(topic) > maxChannelTopicLength {
+			jsonError(w, fmt.Sprintf("topic must be %d characters or less", maxChannelTopicLength), http.StatusBadRequest)
+			return
+		}
+		// An empty topic clears it.
+		settings.Topic = nil
+		if topic != "" {
+			settings.Topic = &topic
+		}
+	}
+	if input.NSFW != nil {
+		settings.NSFW = *input.NSFW
+	}
+	if input.SlowmodeSeconds != nil {
+		if *input.SlowmodeSeconds < 0 || *input.SlowmodeSeconds > maxSlowmodeSeconds {
+			jsonError(w, fmt.Sprintf("slowmode must be between 0 and %d seconds", maxSlowmodeSeconds), http.StatusBadRequest)
+			return
+		}
+		settings.SlowmodeSeconds = *input.SlowmodeSeconds
+	}
+	if input.DefaultAutoArchive != nil {
+		if !autoArchiveDurations[*input.DefaultAutoArchive] {
+			jsonError(w, "auto-archive duration must be 60, 1440, 4320 or 10080 minutes", http.StatusBadRequest)
+			return
+		}
+		settings.DefaultAutoArchive = *input.DefaultAutoArchive
+	}
+
+	updated, err := channelRepo.UpdateChannel(r.Context(), &settings)
 	if err != nil {
 		jsonError(w, "failed to update channel", http.StatusInternalServerError)
 		return
 	}
 
+	s.audit(r, auditEvent{
+		ServerID:   serverID,
+		Action:     auditChannelUpdate,
+		TargetType: auditTargetChannel,
+		TargetID:   &channelID,
+		Before:     ch,
+		After:      updated,
+	})
+	s.emitChannelWebhookEvent(r.Context(), updated, hookChannelUpdate, updated)
+
 	// Broadcast channel update to all connected clients.
 	out, err := json.Marshal(map[string]any{
 		"type":    "channel_update",
@@ -595,6 +712,15 @@ func (s *Server) handleDeleteChannel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.audit(r, auditEvent{
+		ServerID:   serverID,
+		Action:     auditChannelDelete,
+		TargetType: auditTargetChannel,
+		TargetID:   &channelID,
+		Before:     ch,
+	})
+	s.emitChannelWebhookEvent(r.Context(), ch, hookChannelDelete, ch)
+
 	// Broadcast channel deletion to all connected clients.
 	out, err := json.Marshal(map[string]string{
 		"type":       "channel_delete",
@@ -666,8 +792,8 @@ func (s *Server) handleJoinServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	serverRepo := &database.ServerRepo{DB: s.db}
-	srv, err := serverRepo.GetServerByInviteCode(r.Context(), input.InviteCode)
+	inviteRepo := &database.InviteRepo{DB: s.db}
+	inv, err := inviteRepo.GetByCode(r.Context(), input.InviteCode)
 	if err == sql.ErrNoRows {
 		jsonError(w, "invalid invite code", http.StatusNotFound)
 		return
@@ -677,26 +803,52 @@ func (s *Server) handleJoinServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if already a member.
-	memberRepo := &database.ServerMemberRepo{DB: s.db}
-	isMember, err := memberRepo.IsMember(r.Context(), user.ID, srv.ID)
+	banRepo := &database.BanRepo{DB: s.db}
+	banned, err := banRepo.IsBanned(r.Context(), inv.ServerID, user.ID, user.TailscaleID)
 	if err != nil {
-		jsonError(w, "failed to check membership", http.StatusInternalServerError)
+		jsonError(w, "failed to check ban list", http.StatusInternalServerError)
 		return
 	}
-	if isMember {
-		jsonError(w, "already a member", http.StatusConflict)
+	if banned {
+		jsonError(w, "you are banned from this server", http.StatusForbidden)
 		return
 	}
 
-	if err := memberRepo.AddMember(r.Context(), &models.ServerMember{
-		UserID:   user.ID,
-		ServerID: srv.ID,
-	}); err != nil {
+	// Consume a use and add the membership in one transaction, so
+	// concurrent joins can't exceed max_uses.
+	inv, err = inviteRepo.Redeem(r.Context(), input.InviteCode, user.ID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "invalid invite code", http.StatusNotFound)
+		return
+	}
+	if err == database.ErrAlreadyMember {
+		jsonError(w, "already a member", http.StatusConflict)
+		return
+	}
+	if err != nil {
 		jsonError(w, "failed to join server", http.StatusInternalServerError)
 		return
 	}
 
+	s.audit(r, auditEvent{
+		ServerID:   inv.ServerID,
+		Action:     auditMemberJoin,
+		TargetType: auditTargetInvite,
+		After:      map[string]any{"invite_code": inv.Code, "uses": inv.Uses, "temporary": inv.Temporary},
+	})
+	s.emitWebhookEvent(r.Context(), inv.ServerID, nil, hookMemberJoin, map[string]any{
+		"user_id":     user.ID,
+		"username":    user.Username,
+		"invite_code": inv.Code,
+	})
+
+	serverRepo := &database.ServerRepo{DB: s.db}
+	srv, err := serverRepo.GetServerByID(r.Context(), inv.ServerID)
+	if err != nil {
+		jsonError(w, "failed to get server", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(srv)
@@ -737,6 +889,11 @@ func (s *Server) handleLeaveServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.emitWebhookEvent(r.Context(), serverID, nil, hookMemberLeave, map[string]any{
+		"user_id": user.ID,
+		"reason":  "leave",
+	})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -782,7 +939,55 @@ func (s *Server) handleSendFriendRequest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	blockRepo := &database.BlockRepo{DB: s.db}
+	iBlocked, err := blockRepo.HasBlocked(r.Context(), user.ID, target.ID)
+	if err != nil {
+		jsonError(w, "failed to check blocks", http.StatusInternalServerError)
+		return
+	}
+	if iBlocked {
+		jsonError(w, "unblock this user before sending a friend request", http.StatusConflict)
+		return
+	}
+	theyBlocked, err := blockRepo.HasBlocked(r.Context(), target.ID, user.ID)
+	if err != nil {
+		jsonError(w, "failed to check blocks", http.StatusInternalServerError)
+		return
+	}
+	if theyBlocked {
+		jsonError(w, "cannot send a friend request to this user", http.StatusForbidden)
+		return
+	}
+
 	friendRepo := &database.FriendshipRepo{DB: s.db}
+	existing, err := friendRepo.GetFriendship(r.Context(), user.ID, target.ID)
+	if err != nil && err != sql.ErrNoRows {
+		jsonError(w, "failed to check existing friendship", http.StatusInternalServerError)
+		return
+	}
+	if existing != nil {
+		switch {
+		case existing.Status == "accepted":
+			jsonError(w, "already friends", http.StatusConflict)
+		case existing.InitiatedBy == user.ID:
+			jsonError(w, "friend request already sent", http.StatusConflict)
+		default:
+			jsonError(w, "this user has already sent you a friend request", http.StatusConflict)
+		}
+		return
+	}
+
+	allowed, err := s.canSendFriendRequest(r.Context(), user.ID, target.ID)
+	if err != nil {
+		jsonError(w, "failed to check privacy settings", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		jsonError(w, "this user is not accepting friend requests from you", http.StatusForbidden)
+		return
+	}
+
+	// CreateFriendRequest orders the pair to satisfy CHECK (user_a < user_b).
 	f := &models.Friendship{
 		UserA:       user.ID,
 		UserB:       target.ID,
@@ -793,6 +998,8 @@ func (s *Server) handleSendFriendRequest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	s.notifyRelationship("friend_request", f.ID, user, target, "outgoing", "incoming", nil)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(f)
@@ -843,35 +1050,60 @@ func (s *Server) handleAcceptFriend(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create a DM channel between the two users.
-	dmChannel := &models.Channel{
-		Type: "dm",
-	}
-	channelRepo := &database.ChannelRepo{DB: s.db}
-	if err := channelRepo.CreateChannel(r.Context(), dmChannel); err != nil {
-		jsonError(w, "failed to create DM channel", http.StatusInternalServerError)
-		return
-	}
-
-	// Add both users to the DM channel.
+	// Reuse the DM channel from an earlier friendship if there is one,
+	// otherwise create a DM channel between the two users.
 	dmMemberRepo := &database.DMMemberRepo{DB: s.db}
-	if err := dmMemberRepo.AddMember(r.Context(), dmChannel.ID, f.UserA); err != nil {
-		jsonError(w, "failed to add DM member", http.StatusInternalServerError)
+	dmChannelID, err := dmMemberRepo.FindDirectChannel(r.Context(), f.UserA, f.UserB)
+	if err != nil {
+		jsonError(w, "failed to look up DM channel", http.StatusInternalServerError)
 		return
 	}
-	if err := dmMemberRepo.AddMember(r.Context(), dmChannel.ID, f.UserB); err != nil {
-		jsonError(w, "failed to add DM member", http.StatusInternalServerError)
-		return
+	if dmChannelID == nil {
+		dmChannel := &models.Channel{
+			Type: "dm",
+		}
+		channelRepo := &database.ChannelRepo{DB: s.db}
+		if err := channelRepo.CreateChannel(r.Context(), dmChannel); err != nil {
+			jsonError(w, "failed to create DM channel", http.StatusInternalServerError)
+			return
+		}
+
+		// Add both users to the DM channel.
+		if err := dmMemberRepo.AddMember(r.Context(), dmChannel.ID, f.UserA); err != nil {
+			jsonError(w, "failed to add DM member", http.StatusInternalServerError)
+			return
+		}
+		if err := dmMemberRepo.AddMember(r.Context(), dmChannel.ID, f.UserB); err != nil {
+			jsonError(w, "failed to add DM member", http.StatusInternalServerError)
+			return
+		}
+		dmChannelID = &dmChannel.ID
+	} else {
+		// Now that they are friends, an earlier message request is moot.
+		for _, id := range []uuid.UUID{f.UserA, f.UserB} {
+			if err := dmMemberRepo.SetMessageRequest(r.Context(), *dmChannelID, id, false); err != nil {
+				log.Printf("failed to clear message request on %s: %v", *dmChannelID, err)
+			}
+		}
 	}
 
 	// Link the DM channel to the friendship.
-	if err := friendRepo.SetDMChannelID(r.Context(), friendshipID, dmChannel.ID); err != nil {
+	if err := friendRepo.SetDMChannelID(r.Context(), friendshipID, *dmChannelID); err != nil {
 		jsonError(w, "failed to link DM channel", http.StatusInternalServerError)
 		return
 	}
 
 	f.Status = "accepted"
-	f.DMChannelID = &dmChannel.ID
+	f.DMChannelID = dmChannelID
+
+	otherID := f.UserA
+	if otherID == user.ID {
+		otherID = f.UserB
+	}
+	userRepo := &database.UserRepo{DB: s.db}
+	if other, err := userRepo.GetByID(r.Context(), otherID); err == nil {
+		s.notifyRelationship("relationship_update", f.ID, user, other, "friend", "friend", dmChannelID)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(f)
@@ -885,13 +1117,16 @@ func (s *Server) handleListFriends(w http.ResponseWriter, r *http.Request) {
 	}
 
 	friendRepo := &database.FriendshipRepo{DB: s.db}
-	friends, err := friendRepo.ListFriends(r.Context(), user.ID)
+	friends, err := friendRepo.ListRelationships(r.Context(), user.ID, "accepted")
 	if err != nil {
 		jsonError(w, "failed to list friends", http.StatusInternalServerError)
 		return
 	}
 	if friends == nil {
-		friends = []models.Friendship{}
+		friends = []models.Relationship{}
+	}
+	for i := range friends {
+		friends[i].Online = s.hub.Presence().IsOnline(friends[i].User.ID)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -949,6 +1184,10 @@ func (s *Server) handleListMessages(w http.ResponseWriter, r *http.Request) {
 			jsonError(w, "forbidden", http.StatusForbidden)
 			return
 		}
+		if err := s.checkNSFW(r.Context(), user.ID, ch); err != nil {
+			writeAccessError(w, err)
+			return
+		}
 	} else {
 		// DM channel: check dm_members.
 		dmRepo := &database.DMMemberRepo{DB: s.db}
@@ -994,9 +1233,13 @@ func (s *Server) handleListMessages(w http.ResponseWriter, r *http.Request) {
 		messages = []models.Message{}
 	}
 
-	// Load attachments for each message.
+	// Load attachments and mentions for each message.
 	attachmentRepo := &database.AttachmentRepo{DB: s.db}
+	mentionRepo := &database.MentionRepo{DB: s.db}
 	for i := range messages {
+		if err := mentionRepo.Load(r.Context(), &messages[i]); err != nil {
+			log.Printf("failed to load mentions for message %s: %v", messages[i].ID, err)
+		}
 		atts, err := attachmentRepo.ListByMessage(r.Context(), messages[i].ID)
 		if err != nil {
 			log.Printf("failed to load attachments for message %s: %v", messages[i].ID, err)
@@ -1005,6 +1248,20 @@ func (s *Server) handleListMessages(w http.ResponseWriter, r *http.Request) {
 		messages[i].Attachments = atts
 	}
 
+	ids := make([]uuid.UUID, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+	}
+	pollRepo := &database.PollRepo{DB: s.db}
+	polls, err := pollRepo.ListByMessages(r.Context(), ids, user.ID)
+	if err != nil {
+		log.Printf("failed to load polls for channel %s: %v", channelID, err)
+	}
+	for i := range messages {
+		messages[i].Poll = polls[messages[i].ID]
+	}
+	s.loadStickers(r.Context(), messages)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(messages)
 }
@@ -1024,7 +1281,7 @@ func (s *Server) handleCreateMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Access check (same logic as handleListMessages).
+	// Access check: membership plus any restriction on posting (e.g. timeouts).
 	channelRepo := &database.ChannelRepo{DB: s.db}
 	ch, err := channelRepo.GetChannelByID(r.Context(), channelID)
 	if err == sql.ErrNoRows {
@@ -1036,61 +1293,167 @@ func (s *Server) handleCreateMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if ch.ServerID != nil {
-		memberRepo := &database.ServerMemberRepo{DB: s.db}
-		isMember, err := memberRepo.IsMember(r.Context(), user.ID, *ch.ServerID)
-		if err != nil {
-			jsonError(w, "failed to check membership", http.StatusInternalServerError)
-			return
+	if err := s.checkSendAccess(r.Context(), user.ID, ch); err != nil {
+		writeAccessError(w, err)
+		return
+	}
+
+	// A JSON body carries content, embeds, a poll, a sticker or a GIF from
+	// the sender's library; a multipart form (10 MB max memory) adds file
+	// attachments, with embeds and the poll as JSON "embeds" and "poll"
+	// parts.
+	var content string
+	var embeds []models.Embed
+	var poll *pollInput
+	var stickerID, gifID *uuid.UUID
+	var files []*multipart.FileHeader
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var input struct {
+			Content   string         `json:"content"`
+			Embeds    []models.Embed `json:"embeds"`
+			Poll      *pollInput     `json:"poll"`
+			StickerID *uuid.UUID     `json:"sticker_id"`
+			GIFID     *uuid.UUID     `json:"gif_id"`
 		}
-		if !isMember {
-			jsonError(w, "forbidden", http.StatusForbidden)
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
 			return
 		}
+		content, embeds, poll = input.Content, input.Embeds, input.Poll
+		stickerID, gifID = input.StickerID, input.GIFID
 	} else {
-		dmRepo := &database.DMMemberRepo{DB: s.db}
-		isMember, err := dmRepo.IsMember(r.Context(), channelID, user.ID)
-		if err != nil {
-			jsonError(w, "failed to check DM membership", http.StatusInternalServerError)
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			jsonError(w, "invalid multipart form", http.StatusBadRequest)
 			return
 		}
-		if !isMember {
-			jsonError(w, "forbidden", http.StatusForbidden)
-			return
+		content = r.FormValue("content")
+		files = r.MultipartForm.File["files"]
+		if raw := r.FormValue("embeds"); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &embeds); err != nil {
+				jsonError(w, "invalid embeds", http.StatusBadRequest)
+				return
+			}
+		}
+		if raw := r.FormValue("poll"); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &poll); err != nil {
+				jsonError(w, "invalid poll", http.StatusBadRequest)
+				return
+			}
 		}
 	}
 
-	// Parse multipart form — 10 MB max memory.
-	if err := r.ParseMultipartForm(10 << 20); err != nil {
-		jsonError(w, "invalid multipart form", http.StatusBadRequest)
-		return
-	}
-
-	content := r.FormValue("content")
-	files := r.MultipartForm.File["files"]
-
-	if content == "" && len(files) == 0 {
-		jsonError(w, "message must have content or attachments", http.StatusBadRequest)
+	if content == "" && len(files) == 0 && len(embeds) == 0 && poll == nil && stickerID == nil && gifID == nil {
+		jsonError(w, "message must have content, embeds, attachments, a poll, a sticker or a gif", http.StatusBadRequest)
 		return
 	}
 	if len(content) > 4000 {
 		jsonError(w, "message content must be 4000 characters or less", http.StatusBadRequest)
 		return
 	}
+	if err := validateEmbeds(embeds); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if poll != nil {
+		if err := poll.validate(); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// A sticker is a message of its own.
+	var sticker *models.Sticker
+	if stickerID != nil {
+		if content != "" || len(embeds) > 0 || poll != nil || gifID != nil {
+			jsonError(w, "a sticker must be sent on its own", http.StatusBadRequest)
+			return
+		}
+		if sticker = s.sendableSticker(w, r, user.ID, *stickerID); sticker == nil {
+			return
+		}
+	}
+	var gif *models.FavoriteGIF
+	if gifID != nil {
+		gifRepo := &database.FavoriteGIFRepo{DB: s.db}
+		gif, err = gifRepo.Get(r.Context(), *gifID, user.ID)
+		if err == sql.ErrNoRows {
+			jsonError(w, "gif not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			jsonError(w, "failed to get gif", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	release, err := s.checkSlowmode(r.Context(), user.ID, ch)
+	if err != nil {
+		writeAccessError(w, err)
+		return
+	}
 
 	// Create the message.
 	msg := &models.Message{
 		ChannelID: channelID,
 		AuthorID:  user.ID,
 		Content:   content,
+		Embeds:    embeds,
+		StickerID: stickerID,
+		Sticker:   sticker,
 	}
 	msgRepo := &database.MessageRepo{DB: s.db}
 	if err := msgRepo.Create(r.Context(), msg); err != nil {
+		release()
 		jsonError(w, "failed to create message", http.StatusInternalServerError)
 		return
 	}
 
-	// Process file uploads.
+	if poll != nil {
+		if err := s.attachPoll(r.Context(), msg, poll, user.ID); err != nil {
+			// Don't leave a message without the poll it was sent for.
+			if _, err := msgRepo.DeleteMessages(r.Context(), channelID, []uuid.UUID{msg.ID}, user.ID); err != nil {
+				log.Printf("failed to delete message %s after poll error: %v", msg.ID, err)
+			} else {
+				release()
+			}
+			jsonError(w, "failed to create poll", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	msg.Attachments = s.saveAttachments(r.Context(), msg.ID, files)
+	if gif != nil {
+		att, err := s.attachFavoriteGIF(r.Context(), msg.ID, gif)
+		if err != nil {
+			log.Printf("failed to attach gif %s to message %s: %v", gif.ID, msg.ID, err)
+		} else {
+			msg.Attachments = append(msg.Attachments, *att)
+		}
+	}
+
+	if err := s.deliverNotifications(r.Context(), ch, msg); err != nil {
+		log.Printf("failed to deliver notifications for message %s: %v", msg.ID, err)
+	}
+	s.emitChannelWebhookEvent(r.Context(), ch, hookMessageCreate, msg)
+	s.unfurlAsync(msg)
+
+	// Broadcast via WebSocket so other clients see it in real-time.
+	out, err := json.Marshal(map[string]any{
+		"type":    "message",
+		"message": msg,
+	})
+	if err == nil {
+		s.hub.BroadcastToChannel(channelID, out)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(msg)
+}
+
+// saveAttachments stores uploaded files and records them against a message.
+// Files that fail to process are logged and skipped.
+func (s *Server) saveAttachments(ctx context.Context, messageID uuid.UUID, files []*multipart.FileHeader) []models.Attachment {
 	attachmentRepo := &database.AttachmentRepo{DB: s.db}
 	var attachments []models.Attachment
 
@@ -1102,7 +1465,7 @@ func (s *Server) handleCreateMessage(w http.ResponseWriter, r *http.Request) {
 		}
 
 		att := models.Attachment{
-			MessageID:    msg.ID,
+			MessageID:    messageID,
 			FilePath:     result.FilePath,
 			OriginalName: result.OriginalName,
 			MimeType:     &result.MimeType,
@@ -1110,27 +1473,13 @@ func (s *Server) handleCreateMessage(w http.ResponseWriter, r *http.Request) {
 			Width:        result.Width,
 			Height:       result.Height,
 		}
-		if err := attachmentRepo.Create(r.Context(), &att); err != nil {
+		if err := attachmentRepo.Create(ctx, &att); err != nil {
 			log.Printf("attachment db error for %q: %v", fh.Filename, err)
 			continue
 		}
 		attachments = append(attachments, att)
 	}
-
-	msg.Attachments = attachments
-
-	// Broadcast via WebSocket so other clients see it in real-time.
-	out, err := json.Marshal(map[string]any{
-		"type":    "message",
-		"message": msg,
-	})
-	if err == nil {
-		s.hub.BroadcastToChannel(channelID, out)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(msg)
+	return attachments
 }
 
 // --- Edit / Delete Messages ---
@@ -1148,15 +1497,18 @@ func (s *Server) handleEditMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Embeds, when present, replace the message's own embeds; link
+	// previews are kept.
 	var input struct {
-		Content string `json:"content"`
+		Content string          `json:"content"`
+		Embeds  *[]models.Embed `json:"embeds"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 	input.Content = strings.TrimSpace(input.Content)
-	if input.Content == "" {
+	if input.Content == "" && (input.Embeds == nil || len(*input.Embeds) == 0) {
 		jsonError(w, "content is required", http.StatusBadRequest)
 		return
 	}
@@ -1164,9 +1516,15 @@ func (s *Server) handleEditMessage(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "message content must be 4000 characters or less", http.StatusBadRequest)
 		return
 	}
+	if input.Embeds != nil {
+		if err := validateEmbeds(*input.Embeds); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	msgRepo := &database.MessageRepo{DB: s.db}
-	updated, err := msgRepo.Update(r.Context(), messageID, user.ID, input.Content)
+	updated, err := msgRepo.Update(r.Context(), messageID, user.ID, input.Content, input.Embeds)
 	if err == sql.ErrNoRows {
 		jsonError(w, "message not found or not yours", http.StatusNotFound)
 		return
@@ -1176,6 +1534,16 @@ func (s *Server) handleEditMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	if ch, err := channelRepo.GetChannelByID(r.Context(), updated.ChannelID); err == nil {
+		if err := s.deliverNotifications(r.Context(), ch, updated); err != nil {
+			log.Printf("failed to deliver notifications for message %s: %v", updated.ID, err)
+		}
+		s.emitChannelWebhookEvent(r.Context(), ch, hookMessageUpdate, updated)
+	} else {
+		log.Printf("failed to get channel %s for notifications: %v", updated.ChannelID, err)
+	}
+
 	// Broadcast edit via WebSocket.
 	out, err := json.Marshal(map[string]any{
 		"type":    "message_edit",
@@ -1189,6 +1557,65 @@ func (s *Server) handleEditMessage(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(updated)
 }
 
+// handleMessageHistory returns a message with its earlier versions, oldest
+// first. It is visible to the author and, in servers, to members with the
+// Manage Messages permission.
+func (s *Server) handleMessageHistory(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	messageID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	msgRepo := &database.MessageRepo{DB: s.db}
+	msg, err := msgRepo.GetByID(r.Context(), messageID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get message", http.StatusInternalServerError)
+		return
+	}
+
+	if msg.AuthorID != user.ID || msg.WebhookID != nil {
+		channelRepo := &database.ChannelRepo{DB: s.db}
+		ch, err := channelRepo.GetChannelByID(r.Context(), msg.ChannelID)
+		if err != nil {
+			jsonError(w, "failed to get channel", http.StatusInternalServerError)
+			return
+		}
+		if ch.ServerID == nil {
+			jsonError(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if s.requireChannelPermission(w, r, ch, user.ID, models.PermManageMessages) == nil {
+			return
+		}
+	}
+
+	revisions, err := msgRepo.ListRevisions(r.Context(), messageID)
+	if err != nil {
+		jsonError(w, "failed to list revisions", http.StatusInternalServerError)
+		return
+	}
+	if revisions == nil {
+		revisions = []models.MessageRevision{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":   msg,
+		"revisions": revisions,
+	})
+}
+
 func (s *Server) handleDeleteMessage(w http.ResponseWriter, r *http.Request) {
 	user := auth.UserFromContext(r.Context())
 	if user == nil {
@@ -1215,16 +1642,54 @@ func (s *Server) handleDeleteMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Only the author can delete their own message.
-	if msg.AuthorID != user.ID {
-		jsonError(w, "forbidden", http.StatusForbidden)
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(r.Context(), msg.ChannelID)
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
 		return
 	}
 
-	if err := msgRepo.Delete(r.Context(), messageID, user.ID); err != nil {
+	// Authors delete their own messages; in servers, members with Manage
+	// Messages delete anyone's.
+	moderated := msg.AuthorID != user.ID || msg.WebhookID != nil
+	if moderated {
+		if ch.ServerID == nil {
+			jsonError(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if s.requireChannelPermission(w, r, ch, user.ID, models.PermManageMessages) == nil {
+			return
+		}
+	}
+
+	deleted, err := s.deleteMessages(r.Context(), ch.ID, []uuid.UUID{messageID}, user.ID)
+	if err != nil {
 		jsonError(w, "failed to delete message", http.StatusInternalServerError)
 		return
 	}
+	if len(deleted) == 0 {
+		jsonError(w, "message not found", http.StatusNotFound)
+		return
+	}
+
+	if moderated {
+		s.audit(r, auditEvent{
+			ServerID:   *ch.ServerID,
+			Action:     auditMessageDelete,
+			TargetType: auditTargetUser,
+			TargetID:   &msg.AuthorID,
+			After: map[string]any{
+				"message_id": messageID,
+				"channel_id": msg.ChannelID,
+			},
+		})
+	}
+
+	s.emitChannelWebhookEvent(r.Context(), ch, hookMessageDelete, map[string]any{
+		"message_id": messageID,
+		"channel_id": msg.ChannelID,
+		"author_id":  msg.AuthorID,
+	})
 
 	// Broadcast delete via WebSocket.
 	out, err := json.Marshal(map[string]any{
@@ -0,0 +1,490 @@
+package server
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// maxWebhookName is the longest name a webhook, or a username override in a
+// webhook message, may have.
+const maxWebhookName = 80
+
+// issueWebhookToken gives the webhook a new token, setting Token and URL for
+// the response; only the hash is stored.
+func issueWebhookToken(h *models.IncomingWebhook) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	h.Token = token
+	h.TokenHash = auth.HashToken(token)
+	h.URL = "/api/webhooks/" + h.ID.String() + "/" + token
+	return nil
+}
+
+// webhookChannel checks that channel {id} is a server channel the user may
+// manage webhooks in. On failure it writes the error response and returns nil.
+func (s *Server) webhookChannel(w http.ResponseWriter, r *http.Request, userID uuid.UUID) *models.Channel {
+	channelID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid channel id", http.StatusBadRequest)
+		return nil
+	}
+	return s.loadWebhookChannel(w, r, channelID, userID)
+}
+
+// loadWebhookChannel fetches a server channel that can hold messages and
+// that the user may manage webhooks in. On failure it writes the error
+// response and returns nil.
+func (s *Server) loadWebhookChannel(w http.ResponseWriter, r *http.Request, channelID, userID uuid.UUID) *models.Channel {
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(r.Context(), channelID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "channel not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return nil
+	}
+	if ch.ServerID == nil {
+		jsonError(w, "webhooks are only available in server channels", http.StatusBadRequest)
+		return nil
+	}
+	if ch.Type == "category" {
+		jsonError(w, "categories cannot hold messages", http.StatusBadRequest)
+		return nil
+	}
+	if s.requireChannelPermission(w, r, ch, userID, models.PermManageWebhooks) == nil {
+		return nil
+	}
+	return ch
+}
+
+// loadIncomingWebhook fetches webhook {webhookId} of channel {id} for a user
+// who may manage it. On failure it writes the error response and returns nil.
+func (s *Server) loadIncomingWebhook(w http.ResponseWriter, r *http.Request, userID uuid.UUID) *models.IncomingWebhook {
+	ch := s.webhookChannel(w, r, userID)
+	if ch == nil {
+		return nil
+	}
+	hookID, err := uuid.Parse(r.PathValue("webhookId"))
+	if err != nil {
+		jsonError(w, "invalid webhook id", http.StatusBadRequest)
+		return nil
+	}
+
+	hookRepo := &database.IncomingWebhookRepo{DB: s.db}
+	h, err := hookRepo.GetByID(r.Context(), hookID)
+	if err == sql.ErrNoRows || (err == nil && h.ChannelID != ch.ID) {
+		jsonError(w, "webhook not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		jsonError(w, "failed to get webhook", http.StatusInternalServerError)
+		return nil
+	}
+	return h
+}
+
+func (s *Server) handleListIncomingWebhooks(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ch := s.webhookChannel(w, r, user.ID)
+	if ch == nil {
+		return
+	}
+
+	hookRepo := &database.IncomingWebhookRepo{DB: s.db}
+	hooks, err := hookRepo.ListByChannel(r.Context(), ch.ID)
+	if err != nil {
+		jsonError(w, "failed to list webhooks", http.StatusInternalServerError)
+		return
+	}
+	if hooks == nil {
+		hooks = []models.IncomingWebhook{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hooks)
+}
+
+func (s *Server) handleCreateIncomingWebhook(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ch := s.webhookChannel(w, r, user.ID)
+	if ch == nil {
+		return
+	}
+
+	var input struct {
+		Name      string  `json:"name"`
+		AvatarURL *string `json:"avatar_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" || utf8.RuneCountInString(input.Name) > maxWebhookName {
+		jsonError(w, "name is required and must be 80 characters or less", http.StatusBadRequest)
+		return
+	}
+	if input.AvatarURL != nil && !isHTTPURL(*input.AvatarURL) {
+		jsonError(w, "avatar_url must be an http or https URL", http.StatusBadRequest)
+		return
+	}
+
+	h := &models.IncomingWebhook{
+		ID:        uuid.New(),
+		ServerID:  *ch.ServerID,
+		ChannelID: ch.ID,
+		Name:      input.Name,
+		AvatarURL: input.AvatarURL,
+		CreatedBy: user.ID,
+	}
+	if err := issueWebhookToken(h); err != nil {
+		jsonError(w, "failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	hookRepo := &database.IncomingWebhookRepo{DB: s.db}
+	if err := hookRepo.Create(r.Context(), h); err != nil {
+		jsonError(w, "failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   h.ServerID,
+		Action:     auditWebhookCreate,
+		TargetType: auditTargetWebhook,
+		TargetID:   &h.ID,
+		After:      map[string]any{"name": h.Name, "channel_id": h.ChannelID, "incoming": true},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(h)
+}
+
+// handleUpdateIncomingWebhook renames a webhook, changes its avatar (null
+// clears it), moves it to another channel of the server, or with
+// regenerate_token invalidates its URL and returns a new one.
+func (s *Server) handleUpdateIncomingWebhook(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h := s.loadIncomingWebhook(w, r, user.ID)
+	if h == nil {
+		return
+	}
+
+	var input struct {
+		Name            *string         `json:"name"`
+		AvatarURL       json.RawMessage `json:"avatar_url"`
+		ChannelID       *uuid.UUID      `json:"channel_id"`
+		RegenerateToken bool            `json:"regenerate_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// A follow's webhook belongs to its channel and is never handed a token;
+	// unfollowing and following again is the way to move it.
+	if h.SourceChannelID != nil {
+		if input.RegenerateToken {
+			jsonError(w, "channel follow webhooks have no token", http.StatusBadRequest)
+			return
+		}
+		if input.ChannelID != nil && *input.ChannelID != h.ChannelID {
+			jsonError(w, "channel follow webhooks cannot be moved to another channel", http.StatusBadRequest)
+			return
+		}
+	}
+
+	before := map[string]any{"name": h.Name, "avatar_url": h.AvatarURL, "channel_id": h.ChannelID}
+
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" || utf8.RuneCountInString(name) > maxWebhookName {
+			jsonError(w, "name is required and must be 80 characters or less", http.StatusBadRequest)
+			return
+		}
+		h.Name = name
+	}
+	if len(input.AvatarURL) > 0 {
+		if string(input.AvatarURL) == "null" {
+			h.AvatarURL = nil
+		} else {
+			var avatar string
+			if err := json.Unmarshal(input.AvatarURL, &avatar); err != nil || !isHTTPURL(avatar) {
+				jsonError(w, "avatar_url must be an http or https URL", http.StatusBadRequest)
+				return
+			}
+			h.AvatarURL = &avatar
+		}
+	}
+	if input.ChannelID != nil && *input.ChannelID != h.ChannelID {
+		// Moving needs the same rights in the destination as in the source.
+		dest := s.loadWebhookChannel(w, r, *input.ChannelID, user.ID)
+		if dest == nil {
+			return
+		}
+		if *dest.ServerID != h.ServerID {
+			jsonError(w, "channel does not belong to this server", http.StatusBadRequest)
+			return
+		}
+		h.ChannelID = dest.ID
+	}
+	if input.RegenerateToken {
+		if err := issueWebhookToken(h); err != nil {
+			jsonError(w, "failed to generate token", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	hookRepo := &database.IncomingWebhookRepo{DB: s.db}
+	if err := hookRepo.Update(r.Context(), h); err != nil {
+		jsonError(w, "failed to update webhook", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   h.ServerID,
+		Action:     auditWebhookUpdate,
+		TargetType: auditTargetWebhook,
+		TargetID:   &h.ID,
+		Before:     before,
+		After:      map[string]any{"name": h.Name, "avatar_url": h.AvatarURL, "channel_id": h.ChannelID, "token_regenerated": input.RegenerateToken},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h)
+}
+
+func (s *Server) handleDeleteIncomingWebhook(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h := s.loadIncomingWebhook(w, r, user.ID)
+	if h == nil {
+		return
+	}
+
+	hookRepo := &database.IncomingWebhookRepo{DB: s.db}
+	if err := hookRepo.Delete(r.Context(), h.ID); err != nil && err != sql.ErrNoRows {
+		jsonError(w, "failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   h.ServerID,
+		Action:     auditWebhookDelete,
+		TargetType: auditTargetWebhook,
+		TargetID:   &h.ID,
+		Before:     map[string]any{"name": h.Name, "channel_id": h.ChannelID, "incoming": true},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// webhookMessage is the body of a webhook execution. Its fields are a subset
+// of Discord's execute-webhook payload; anything else Discord accepts, such as
+// tts or allowed_mentions, is ignored.
+type webhookMessage struct {
+	Content   string         `json:"content"`
+	Username  string         `json:"username"`
+	AvatarURL string         `json:"avatar_url"`
+	Embeds    []models.Embed `json:"embeds"`
+}
+
+// parseWebhookMessage reads a JSON body, or a multipart form whose
+// payload_json part (or content, username and avatar_url parts) carries the
+// message and whose file parts, under any name, are attachments.
+func parseWebhookMessage(r *http.Request) (*webhookMessage, []*multipart.FileHeader, error) {
+	var msg webhookMessage
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			return nil, nil, err
+		}
+		return &msg, nil, nil
+	}
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		return nil, nil, err
+	}
+	if payload := r.FormValue("payload_json"); payload != "" {
+		if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		msg.Content = r.FormValue("content")
+		msg.Username = r.FormValue("username")
+		msg.AvatarURL = r.FormValue("avatar_url")
+	}
+
+	var files []*multipart.FileHeader
+	for _, fhs := range r.MultipartForm.File {
+		files = append(files, fhs...)
+	}
+	return &msg, files, nil
+}
+
+// handleExecuteWebhook posts a message through an incoming webhook. It is
+// unauthenticated; the token in the URL is the credential. It replies 201
+// with the message.
+func (s *Server) handleExecuteWebhook(w http.ResponseWriter, r *http.Request) {
+	msg := s.executeWebhook(w, r)
+	if msg == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(msg)
+}
+
+// handleExecuteDiscordWebhook is the Discord-compatible form of
+// handleExecuteWebhook, so integrations that target Discord work by swapping
+// the URL. Like Discord it replies 204 unless ?wait=true asks for the message.
+func (s *Server) handleExecuteDiscordWebhook(w http.ResponseWriter, r *http.Request) {
+	msg := s.executeWebhook(w, r)
+	if msg == nil {
+		return
+	}
+	if r.URL.Query().Get("wait") != "true" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
+
+// executeWebhook authenticates the webhook in the URL, creates its message
+// and broadcasts it. On failure it writes the error response and returns nil.
+func (s *Server) executeWebhook(w http.ResponseWriter, r *http.Request) *models.Message {
+	hookID, err := uuid.Parse(r.PathValue("webhookId"))
+	if err != nil {
+		jsonError(w, "unknown webhook", http.StatusNotFound)
+		return nil
+	}
+
+	hookRepo := &database.IncomingWebhookRepo{DB: s.db}
+	h, err := hookRepo.GetByID(r.Context(), hookID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "unknown webhook", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		jsonError(w, "failed to get webhook", http.StatusInternalServerError)
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashToken(r.PathValue("token"))), []byte(h.TokenHash)) != 1 {
+		jsonError(w, "unknown webhook", http.StatusNotFound)
+		return nil
+	}
+
+	input, files, err := parseWebhookMessage(r)
+	if err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return nil
+	}
+	if strings.TrimSpace(input.Content) == "" && len(input.Embeds) == 0 && len(files) == 0 {
+		jsonError(w, "message must have content, embeds or attachments", http.StatusBadRequest)
+		return nil
+	}
+	if len(input.Content) > 4000 {
+		jsonError(w, "message content must be 4000 characters or less", http.StatusBadRequest)
+		return nil
+	}
+	if err := validateEmbeds(input.Embeds); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	username := h.Name
+	if name := strings.TrimSpace(input.Username); name != "" {
+		if utf8.RuneCountInString(name) > maxWebhookName {
+			jsonError(w, "username must be 80 characters or less", http.StatusBadRequest)
+			return nil
+		}
+		username = name
+	}
+	avatarURL := h.AvatarURL
+	if input.AvatarURL != "" {
+		if !isHTTPURL(input.AvatarURL) {
+			jsonError(w, "avatar_url must be an http or https URL", http.StatusBadRequest)
+			return nil
+		}
+		avatarURL = &input.AvatarURL
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(r.Context(), h.ChannelID)
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return nil
+	}
+	if ch.Type == "category" {
+		jsonError(w, "categories cannot hold messages", http.StatusBadRequest)
+		return nil
+	}
+
+	msg := &models.Message{
+		ChannelID:       ch.ID,
+		AuthorID:        h.CreatedBy,
+		Content:         input.Content,
+		Embeds:          input.Embeds,
+		WebhookID:       &h.ID,
+		AuthorUsername:  username,
+		AuthorAvatarURL: avatarURL,
+	}
+	msgRepo := &database.MessageRepo{DB: s.db}
+	if err := msgRepo.Create(r.Context(), msg); err != nil {
+		jsonError(w, "failed to create message", http.StatusInternalServerError)
+		return nil
+	}
+	if err := hookRepo.MarkUsed(r.Context(), h.ID); err != nil {
+		log.Printf("failed to mark webhook %s used: %v", h.ID, err)
+	}
+
+	msg.Attachments = s.saveAttachments(r.Context(), msg.ID, files)
+
+	if err := s.deliverNotifications(r.Context(), ch, msg); err != nil {
+		log.Printf("failed to deliver notifications for message %s: %v", msg.ID, err)
+	}
+	s.emitChannelWebhookEvent(r.Context(), ch, hookMessageCreate, msg)
+
+	out, err := json.Marshal(map[string]any{
+		"type":    "message",
+		"message": msg,
+	})
+	if err == nil {
+		s.hub.BroadcastToChannel(ch.ID, out)
+	}
+	return msg
+}
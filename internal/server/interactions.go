@@ -0,0 +1,575 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/markdown"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/Stocist/discard/internal/netguard"
+	"github.com/google/uuid"
+)
+
+// Interaction response types.
+const (
+	responseMessage   = "message"
+	responseEphemeral = "ephemeral"
+	responseDeferred  = "deferred"
+)
+
+// Interaction statuses.
+const (
+	interactionPending   = "pending"
+	interactionDeferred  = "deferred"
+	interactionResponded = "responded"
+)
+
+// A bot has interactionTTL to answer an interaction and send followups, and
+// interactionDeliveryTimeout to answer an HTTP delivery.
+const (
+	interactionTTL             = 15 * time.Minute
+	interactionDeliveryTimeout = 3 * time.Second
+)
+
+// newCallbackClient returns the client for requests to URLs that users
+// register: bot interaction endpoints and outgoing webhooks. It only dials
+// addresses guard allows and doesn't follow redirects, so a 3xx is a failed
+// delivery rather than a way around the guard's checks of the registered
+// URL.
+func newCallbackClient(guard *netguard.Guard) *http.Client {
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: guard.Transport(),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// checkCallbackURL returns an error unless raw is an http or https URL on a
+// host the callback client may reach.
+func (s *Server) checkCallbackURL(ctx context.Context, raw string) error {
+	if !isHTTPURL(raw) {
+		return errors.New("not an http or https URL")
+	}
+	u, _ := url.Parse(raw)
+	return s.callbackGuard.CheckHost(ctx, u.Hostname())
+}
+
+// interactionResponse is how a bot (or built-in command) answers an
+// interaction: a message in the channel, a message only the invoker sees, or
+// a deferral promising a followup.
+type interactionResponse struct {
+	Type    string         `json:"type"`
+	Content string         `json:"content"`
+	Embeds  []models.Embed `json:"embeds"`
+	Poll    *pollInput     `json:"poll,omitempty"`
+}
+
+// validateInteractionResponse checks a response's type and content. Its
+// errors are safe to return to the client.
+func validateInteractionResponse(resp *interactionResponse) error {
+	switch resp.Type {
+	case responseDeferred:
+		return nil
+	case responseMessage, responseEphemeral:
+	default:
+		return errors.New("type must be message, ephemeral or deferred")
+	}
+	if resp.Content == "" && len(resp.Embeds) == 0 && resp.Poll == nil {
+		return errors.New("response must have content, embeds or a poll")
+	}
+	if len(resp.Content) > 4000 {
+		return errors.New("message content must be 4000 characters or less")
+	}
+	if resp.Poll != nil {
+		if resp.Type == responseEphemeral {
+			return errors.New("ephemeral responses cannot have polls")
+		}
+		if err := resp.Poll.validate(); err != nil {
+			return err
+		}
+	}
+	return validateEmbeds(resp.Embeds)
+}
+
+// handleInvokeCommand runs a slash command in a channel. The body is either
+// {"text": "/roll sides:20"} as typed, or {"name": ..., "options": {...}}.
+// Built-in commands answer immediately; bot commands are delivered to the
+// bot, which answers through the interaction callback.
+func (s *Server) handleInvokeCommand(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channelID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid channel id", http.StatusBadRequest)
+		return
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(r.Context(), channelID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return
+	}
+	if err := s.checkSendAccess(r.Context(), user.ID, ch); err != nil {
+		writeAccessError(w, err)
+		return
+	}
+
+	var input struct {
+		Text    string         `json:"text"`
+		Name    string         `json:"name"`
+		Options map[string]any `json:"options"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Commands belong to servers; there are none in DMs.
+	if ch.ServerID == nil {
+		jsonError(w, "unknown command", http.StatusNotFound)
+		return
+	}
+	serverID := *ch.ServerID
+
+	var cmd *models.SlashCommand
+	raw := input.Options
+	if input.Text != "" {
+		var lookupErr error
+		cmd, raw, err = parseCommandText(input.Text, func(name string) *models.SlashCommand {
+			c, err := s.lookupCommand(r.Context(), serverID, name)
+			if err != nil {
+				lookupErr = err
+			}
+			return c
+		})
+		if lookupErr != nil {
+			jsonError(w, "failed to get command", http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		cmd, err = s.lookupCommand(r.Context(), serverID, input.Name)
+		if err != nil {
+			jsonError(w, "failed to get command", http.StatusInternalServerError)
+			return
+		}
+	}
+	if cmd == nil {
+		jsonError(w, "unknown command", http.StatusNotFound)
+		return
+	}
+
+	options, err := s.resolveOptions(r.Context(), cmd, serverID, raw)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	in := &models.Interaction{
+		ID:          uuid.New(),
+		BotID:       cmd.BotID,
+		CommandName: cmd.Name,
+		ServerID:    serverID,
+		ChannelID:   ch.ID,
+		UserID:      user.ID,
+		Options:     options,
+		Status:      interactionPending,
+	}
+
+	if b, ok := builtinCommands[cmd.Name]; ok {
+		resp, err := b.run(r.Context(), s, &invocation{Command: cmd, Channel: ch, UserID: user.ID, Options: options})
+		if err != nil {
+			log.Printf("built-in command /%s failed: %v", cmd.Name, err)
+			jsonError(w, "command failed", http.StatusInternalServerError)
+			return
+		}
+		if _, err := s.sendInteractionResponse(r.Context(), in, resp); err != nil {
+			jsonError(w, "failed to send command response", http.StatusInternalServerError)
+			return
+		}
+		in.Status = interactionResponded
+		writeInteractionStatus(w, http.StatusOK, in)
+		return
+	}
+
+	s.dispatchInteraction(w, r, cmd, in)
+}
+
+// dispatchInteraction stores a bot command's interaction and hands it to the
+// bot: over HTTP if it has an interactions URL, else over its gateway
+// connection.
+func (s *Server) dispatchInteraction(w http.ResponseWriter, r *http.Request, cmd *models.SlashCommand, in *models.Interaction) {
+	botRepo := &database.BotRepo{DB: s.db}
+	b, err := botRepo.Get(r.Context(), cmd.BotID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "unknown command", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get bot", http.StatusInternalServerError)
+		return
+	}
+	if b.InteractionsURL == nil && !s.hub.Presence().IsOnline(b.User.ID) {
+		jsonError(w, "the bot is offline", http.StatusServiceUnavailable)
+		return
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		jsonError(w, "failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	in.CommandID = &cmd.ID
+	in.TokenHash = auth.HashToken(token)
+	in.ExpiresAt = time.Now().Add(interactionTTL)
+
+	interactionRepo := &database.InteractionRepo{DB: s.db}
+	if err := interactionRepo.Create(r.Context(), in); err != nil {
+		jsonError(w, "failed to create interaction", http.StatusInternalServerError)
+		return
+	}
+
+	userRepo := &database.UserRepo{DB: s.db}
+	invoker, err := userRepo.GetByID(r.Context(), in.UserID)
+	if err != nil {
+		jsonError(w, "failed to get user", http.StatusInternalServerError)
+		return
+	}
+	payload := map[string]any{
+		"type": "interaction_create",
+		"interaction": map[string]any{
+			"id":           in.ID,
+			"token":        token,
+			"command_id":   in.CommandID,
+			"command_name": in.CommandName,
+			"server_id":    in.ServerID,
+			"channel_id":   in.ChannelID,
+			"user": models.UserSummary{
+				ID:          invoker.ID,
+				Username:    invoker.Username,
+				DisplayName: invoker.DisplayName,
+				AvatarURL:   invoker.AvatarPath,
+			},
+			"options":    in.Options,
+			"expires_at": in.ExpiresAt,
+		},
+	}
+
+	if b.InteractionsURL == nil {
+		s.sendToUsers([]uuid.UUID{b.User.ID}, payload)
+		writeInteractionStatus(w, http.StatusAccepted, in)
+		return
+	}
+
+	resp, err := s.postInteraction(r.Context(), b, payload)
+	if err != nil {
+		log.Printf("interaction delivery to bot %s failed: %v", b.User.ID, err)
+		jsonError(w, "the bot did not respond", http.StatusBadGateway)
+		return
+	}
+	if resp == nil {
+		writeInteractionStatus(w, http.StatusAccepted, in)
+		return
+	}
+	if err := validateInteractionResponse(resp); err != nil {
+		log.Printf("bot %s sent an invalid interaction response: %v", b.User.ID, err)
+		jsonError(w, "the bot sent an invalid response", http.StatusBadGateway)
+		return
+	}
+	if err := s.applyInitialResponse(r.Context(), in, resp); err != nil && err != sql.ErrNoRows {
+		jsonError(w, "failed to send command response", http.StatusInternalServerError)
+		return
+	}
+	writeInteractionStatus(w, http.StatusOK, in)
+}
+
+// postInteraction delivers an interaction to the bot's interactions URL,
+// signed with its interactions secret like outgoing webhooks. A 200 with a
+// JSON body is the bot's initial response; 202 or 204 means it will answer
+// through the callback.
+func (s *Server) postInteraction(ctx context.Context, b *models.Bot, payload any) (*interactionResponse, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, interactionDeliveryTimeout)
+	defer cancel()
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *b.InteractionsURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Discard-Interactions/1.0")
+	req.Header.Set("X-Discard-Timestamp", timestamp)
+	req.Header.Set("X-Discard-Signature", signWebhook(b.InteractionsSecret, timestamp, body))
+
+	res, err := s.callbackHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusAccepted, http.StatusNoContent:
+		return nil, nil
+	case http.StatusOK:
+		var resp interactionResponse
+		if err := json.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(&resp); err != nil {
+			return nil, fmt.Errorf("invalid response body: %w", err)
+		}
+		return &resp, nil
+	}
+	return nil, fmt.Errorf("endpoint returned %d", res.StatusCode)
+}
+
+func writeInteractionStatus(w http.ResponseWriter, code int, in *models.Interaction) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"interaction_id": in.ID,
+		"status":         in.Status,
+	})
+}
+
+// applyInitialResponse records the bot's first answer to an interaction and
+// sends it. It returns sql.ErrNoRows if the interaction was already answered.
+func (s *Server) applyInitialResponse(ctx context.Context, in *models.Interaction, resp *interactionResponse) error {
+	status := interactionResponded
+	if resp.Type == responseDeferred {
+		status = interactionDeferred
+	}
+	interactionRepo := &database.InteractionRepo{DB: s.db}
+	if err := interactionRepo.SetStatus(ctx, in.ID, interactionPending, status); err != nil {
+		return err
+	}
+	in.Status = status
+	_, err := s.sendInteractionResponse(ctx, in, resp)
+	return err
+}
+
+// sendInteractionResponse carries out a response: a message posted by the
+// bot, an ephemeral message sent only to the invoker, or a deferral notice.
+// It returns the message, if any.
+func (s *Server) sendInteractionResponse(ctx context.Context, in *models.Interaction, resp *interactionResponse) (*models.Message, error) {
+	if resp.Type == responseDeferred {
+		s.sendToUsers([]uuid.UUID{in.UserID}, map[string]any{
+			"type":           "interaction_deferred",
+			"interaction_id": in.ID,
+			"channel_id":     in.ChannelID,
+			"command_name":   in.CommandName,
+			"bot_id":         in.BotID,
+		})
+		return nil, nil
+	}
+
+	msg := &models.Message{
+		ChannelID: in.ChannelID,
+		AuthorID:  in.BotID,
+		Content:   resp.Content,
+		Embeds:    resp.Embeds,
+		Interaction: &models.MessageInteraction{
+			ID:     in.ID,
+			Name:   in.CommandName,
+			UserID: in.UserID,
+		},
+	}
+
+	if resp.Type == responseEphemeral {
+		userRepo := &database.UserRepo{DB: s.db}
+		bot, err := userRepo.GetByID(ctx, in.BotID)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		msg.ID = uuid.New()
+		msg.CreatedAt = now
+		msg.UpdatedAt = now
+		msg.AuthorUsername = bot.Username
+		msg.AuthorDisplayName = bot.DisplayName
+		msg.AuthorAvatarURL = bot.AvatarPath
+		msg.AuthorBot = true
+		msg.Ephemeral = true
+		// Ephemeral messages are never stored, so nothing else parses them.
+		msg.AST = markdown.Encode(msg.Content)
+		s.sendToUsers([]uuid.UUID{in.UserID}, map[string]any{
+			"type":    "message_ephemeral",
+			"message": msg,
+		})
+		return msg, nil
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(ctx, in.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+	msgRepo := &database.MessageRepo{DB: s.db}
+	if err := msgRepo.Create(ctx, msg); err != nil {
+		return nil, err
+	}
+	// The poll belongs to whoever ran the command, so they can close it.
+	if resp.Poll != nil {
+		if err := s.attachPoll(ctx, msg, resp.Poll, in.UserID); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.deliverNotifications(ctx, ch, msg); err != nil {
+		log.Printf("failed to deliver notifications for message %s: %v", msg.ID, err)
+	}
+	s.emitChannelWebhookEvent(ctx, ch, hookMessageCreate, msg)
+
+	out, err := json.Marshal(map[string]any{
+		"type":    "message",
+		"message": msg,
+	})
+	if err == nil {
+		s.hub.BroadcastToChannel(ch.ID, out)
+	}
+	return msg, nil
+}
+
+// loadTokenInteraction authenticates a callback by interaction ID and token.
+// The bot must still be in the server. On failure it writes the error
+// response and returns nil.
+func (s *Server) loadTokenInteraction(w http.ResponseWriter, r *http.Request) *models.Interaction {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "unknown interaction", http.StatusNotFound)
+		return nil
+	}
+
+	interactionRepo := &database.InteractionRepo{DB: s.db}
+	in, err := interactionRepo.Get(r.Context(), id)
+	if err == sql.ErrNoRows {
+		jsonError(w, "unknown interaction", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		jsonError(w, "failed to get interaction", http.StatusInternalServerError)
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashToken(r.PathValue("token"))), []byte(in.TokenHash)) != 1 {
+		jsonError(w, "unknown interaction", http.StatusNotFound)
+		return nil
+	}
+
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	isMember, err := memberRepo.IsMember(r.Context(), in.BotID, in.ServerID)
+	if err != nil {
+		jsonError(w, "failed to check membership", http.StatusInternalServerError)
+		return nil
+	}
+	if !isMember {
+		jsonError(w, "the bot is no longer in this server", http.StatusForbidden)
+		return nil
+	}
+	return in
+}
+
+// handleInteractionCallback takes the bot's initial response to an
+// interaction. It can only be sent once.
+func (s *Server) handleInteractionCallback(w http.ResponseWriter, r *http.Request) {
+	in := s.loadTokenInteraction(w, r)
+	if in == nil {
+		return
+	}
+
+	var resp interactionResponse
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateInteractionResponse(&resp); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.applyInitialResponse(r.Context(), in, &resp); err != nil {
+		if err == sql.ErrNoRows {
+			jsonError(w, "interaction has already been responded to", http.StatusConflict)
+			return
+		}
+		jsonError(w, "failed to send response", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleInteractionFollowup sends a further message for an interaction
+// after its initial response, and completes a deferred one.
+func (s *Server) handleInteractionFollowup(w http.ResponseWriter, r *http.Request) {
+	in := s.loadTokenInteraction(w, r)
+	if in == nil {
+		return
+	}
+	if in.Status == interactionPending {
+		jsonError(w, "respond to the interaction before sending followups", http.StatusConflict)
+		return
+	}
+
+	var input struct {
+		Content   string         `json:"content"`
+		Embeds    []models.Embed `json:"embeds"`
+		Ephemeral bool           `json:"ephemeral"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	resp := &interactionResponse{Type: responseMessage, Content: strings.TrimSpace(input.Content), Embeds: input.Embeds}
+	if input.Ephemeral {
+		resp.Type = responseEphemeral
+	}
+	if err := validateInteractionResponse(resp); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if in.Status == interactionDeferred {
+		interactionRepo := &database.InteractionRepo{DB: s.db}
+		if err := interactionRepo.SetStatus(r.Context(), in.ID, interactionDeferred, interactionResponded); err != nil && err != sql.ErrNoRows {
+			jsonError(w, "failed to update interaction", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	msg, err := s.sendInteractionResponse(r.Context(), in, resp)
+	if err != nil {
+		jsonError(w, "failed to send followup", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
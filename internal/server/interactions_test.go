@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Stocist/discard/internal/netguard"
+)
+
+func TestCheckCallbackURL(t *testing.T) {
+	s := &Server{callbackGuard: netguard.New(nil)}
+	ctx := context.Background()
+	for _, raw := range []string{
+		"ftp://93.184.216.34/",
+		"http://",
+		"http://127.0.0.1:8080/interactions",
+		"http://[::1]/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://192.168.1.10/hook",
+		"http://localhost/hook",
+	} {
+		if err := s.checkCallbackURL(ctx, raw); err == nil {
+			t.Errorf("checkCallbackURL(%q) accepted", raw)
+		}
+	}
+	if err := s.checkCallbackURL(ctx, "https://93.184.216.34/interactions"); err != nil {
+		t.Errorf("public URL refused: %v", err)
+	}
+
+	s.callbackGuard = netguard.New([]netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")})
+	if err := s.checkCallbackURL(ctx, "http://192.168.1.10/hook"); err != nil {
+		t.Errorf("allowlisted URL refused: %v", err)
+	}
+}
+
+func TestCallbackClient(t *testing.T) {
+	var hits atomic.Int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer internal.Close()
+
+	c := newCallbackClient(netguard.New(nil))
+	resp, err := c.Post(internal.URL, "application/json", nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if !errors.Is(err, netguard.ErrBlocked) {
+		t.Errorf("err = %v, want ErrBlocked", err)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("loopback endpoint was reached %d times", n)
+	}
+
+	// With the endpoint allowed, a redirect is returned rather than
+	// followed.
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusTemporaryRedirect)
+	}))
+	defer redirect.Close()
+	c = newCallbackClient(netguard.New([]netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}))
+	resp, err = c.Post(redirect.URL, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("status %d, want the redirect itself", resp.StatusCode)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("redirect was followed %d times", n)
+	}
+}
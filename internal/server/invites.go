@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// maxInviteAge is the longest an expiring invite may live.
+const maxInviteAge = 30 * 24 * time.Hour
+
+// generateInviteCode returns a random 16-character hex invite code.
+func generateInviteCode() string {
+	codeBytes := make([]byte, 8)
+	rand.Read(codeBytes)
+	return hex.EncodeToString(codeBytes)
+}
+
+func (s *Server) handleListInvites(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	if s.requirePermission(w, r, serverID, user.ID, models.PermManageServer) == nil {
+		return
+	}
+
+	inviteRepo := &database.InviteRepo{DB: s.db}
+	invites, err := inviteRepo.ListByServer(r.Context(), serverID)
+	if err != nil {
+		jsonError(w, "failed to list invites", http.StatusInternalServerError)
+		return
+	}
+	if invites == nil {
+		invites = []models.Invite{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+func (s *Server) handleCreateInvite(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	if s.requirePermission(w, r, serverID, user.ID, models.PermCreateInvites) == nil {
+		return
+	}
+
+	var input struct {
+		MaxAgeSeconds int  `json:"max_age_seconds"`
+		MaxUses       int  `json:"max_uses"`
+		Temporary     bool `json:"temporary"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	maxAge := time.Duration(input.MaxAgeSeconds) * time.Second
+	if maxAge < 0 || maxAge > maxInviteAge {
+		jsonError(w, "max_age_seconds must be between 0 (never) and 2592000", http.StatusBadRequest)
+		return
+	}
+	if input.MaxUses < 0 || input.MaxUses > 1000 {
+		jsonError(w, "max_uses must be between 0 (unlimited) and 1000", http.StatusBadRequest)
+		return
+	}
+
+	inv := &models.Invite{
+		Code:      generateInviteCode(),
+		ServerID:  serverID,
+		CreatorID: user.ID,
+		MaxUses:   input.MaxUses,
+		Temporary: input.Temporary,
+	}
+	if maxAge > 0 {
+		expires := time.Now().Add(maxAge)
+		inv.ExpiresAt = &expires
+	}
+
+	inviteRepo := &database.InviteRepo{DB: s.db}
+	if err := inviteRepo.Create(r.Context(), inv); err != nil {
+		jsonError(w, "failed to create invite", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   serverID,
+		Action:     auditInviteCreate,
+		TargetType: auditTargetInvite,
+		After:      inv,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(inv)
+}
+
+// handlePreviewInvite shows which server an invite leads to. It does not
+// require membership so users can decide whether to join.
+func (s *Server) handlePreviewInvite(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	inviteRepo := &database.InviteRepo{DB: s.db}
+	preview, err := inviteRepo.Preview(r.Context(), r.PathValue("code"))
+	if err == sql.ErrNoRows {
+		jsonError(w, "invalid invite code", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to look up invite code", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
+func (s *Server) handleRevokeInvite(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	code := r.PathValue("code")
+	inviteRepo := &database.InviteRepo{DB: s.db}
+	inv, err := inviteRepo.GetByCode(r.Context(), code)
+	if err == sql.ErrNoRows {
+		jsonError(w, "invite not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get invite", http.StatusInternalServerError)
+		return
+	}
+
+	// Creators may revoke their own invites; anyone else needs Manage Server.
+	if inv.CreatorID != user.ID {
+		if s.requirePermission(w, r, inv.ServerID, user.ID, models.PermManageServer) == nil {
+			return
+		}
+	}
+
+	if err := inviteRepo.Delete(r.Context(), code); err != nil && err != sql.ErrNoRows {
+		jsonError(w, "failed to revoke invite", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   inv.ServerID,
+		Action:     auditInviteDelete,
+		TargetType: auditTargetInvite,
+		Before:     inv,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// dropTemporaryMemberships removes a user from every server they joined
+// through a temporary invite. Registered as the hub's offline callback;
+// RunTemporaryMemberWorker catches the disconnects this instance never sees.
+func (s *Server) dropTemporaryMemberships(userID uuid.UUID) {
+	ctx := context.Background()
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	serverIDs, err := memberRepo.RemoveTemporary(ctx, userID)
+	if err != nil {
+		log.Printf("failed to remove temporary memberships of %s: %v", userID, err)
+		return
+	}
+	for _, serverID := range serverIDs {
+		s.evictMember(ctx, serverID, userID, "temporary")
+	}
+}
+
+const (
+	// temporaryMemberInterval is how often each instance records which users
+	// are connected to it and sweeps stale temporary memberships.
+	temporaryMemberInterval = 30 * time.Second
+	// temporaryMemberGrace is how long a temporary member may go unseen
+	// before they are removed. It spans a few sweeps, so users connected to
+	// another instance are refreshed in between, and gives a member who
+	// joined over REST time to connect.
+	temporaryMemberGrace = 2 * time.Minute
+)
+
+// RunTemporaryMemberWorker removes temporary members who no longer have a
+// live session anywhere: those who joined without connecting, and those
+// whose disconnect was missed because an instance restarted or crashed or
+// they were connected to another one. It should be called in its own
+// goroutine, and sweeps once at startup.
+func (s *Server) RunTemporaryMemberWorker() {
+	ticker := time.NewTicker(temporaryMemberInterval)
+	defer ticker.Stop()
+
+	for {
+		s.sweepTemporaryMembers(context.Background())
+		<-ticker.C
+	}
+}
+
+func (s *Server) sweepTemporaryMembers(ctx context.Context) {
+	userRepo := &database.UserRepo{DB: s.db}
+	if online := s.hub.Presence().OnlineUserIDs(); len(online) > 0 {
+		if err := userRepo.TouchLastSeen(ctx, online); err != nil {
+			log.Printf("failed to record connected users: %v", err)
+			return
+		}
+	}
+
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	removed, err := memberRepo.RemoveStaleTemporary(ctx, time.Now().Add(-temporaryMemberGrace))
+	if err != nil {
+		log.Printf("failed to remove stale temporary memberships: %v", err)
+		return
+	}
+	for _, m := range removed {
+		s.evictMember(ctx, m.ServerID, m.UserID, "temporary")
+	}
+}
@@ -0,0 +1,350 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/markdown"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// maxMentions caps how many distinct users and roles one message can mention;
+// any beyond it are left as plain text.
+const maxMentions = 50
+
+// parsedMentions is what message content asks to mention, before checking
+// any of it against the channel.
+type parsedMentions struct {
+	users    []uuid.UUID
+	roles    []uuid.UUID
+	everyone bool
+	here     bool
+}
+
+// messageNodes returns the message's parsed content, falling back to
+// parsing it again if the stored AST can't be read.
+func messageNodes(msg *models.Message) []*markdown.Node {
+	nodes, err := markdown.Decode(msg.AST)
+	if err != nil {
+		log.Printf("failed to decode AST of message %s: %v", msg.ID, err)
+		return markdown.Parse(msg.Content)
+	}
+	return nodes
+}
+
+// parseMentions collects the user, role, @everyone and @here mentions in a
+// message's parsed content. Code is parsed as text, so mentions in it are
+// not collected.
+func parseMentions(nodes []*markdown.Node) parsedMentions {
+	var p parsedMentions
+	seen := make(map[uuid.UUID]bool)
+	collect := func(raw string, dst *[]uuid.UUID) {
+		id, err := uuid.Parse(raw)
+		if err != nil || seen[id] || len(seen) >= maxMentions {
+			return
+		}
+		seen[id] = true
+		*dst = append(*dst, id)
+	}
+	markdown.Walk(nodes, func(n *markdown.Node) bool {
+		switch n.Type {
+		case markdown.KindUserMention:
+			collect(n.ID, &p.users)
+		case markdown.KindRoleMention:
+			collect(n.ID, &p.roles)
+		case markdown.KindEveryone:
+			p.everyone = true
+		case markdown.KindHere:
+			p.here = true
+		}
+		return true
+	})
+	return p
+}
+
+// resolveMentions checks parsed mentions against the channel, sets the
+// message's mention fields to the valid ones and returns every user they
+// notify, excluding the author. Users must be able to see the channel, which
+// in an NSFW channel means having confirmed their age; roles must belong to
+// its server, and @everyone/@here in a server channel needs
+// PermMentionEveryone. Each notified user maps to how they were reached.
+func (s *Server) resolveMentions(ctx context.Context, ch *models.Channel, msg *models.Message, p parsedMentions) (map[uuid.UUID]notifyKind, error) {
+	msg.Mentions, msg.MentionRoles, msg.MentionEveryone = nil, nil, false
+
+	notified := make(map[uuid.UUID]notifyKind)
+	notify := func(id uuid.UUID, kind notifyKind) {
+		if id != msg.AuthorID && notified[id] < kind {
+			notified[id] = kind
+		}
+	}
+	presence := s.hub.Presence()
+
+	if ch.ServerID == nil {
+		dmRepo := &database.DMMemberRepo{DB: s.db}
+		members, err := dmRepo.ListMembers(ctx, ch.ID)
+		if err != nil {
+			return nil, err
+		}
+		inDM := make(map[uuid.UUID]bool, len(members))
+		for _, m := range members {
+			inDM[m.ID] = true
+		}
+		for _, id := range p.users {
+			if inDM[id] {
+				msg.Mentions = append(msg.Mentions, id)
+				notify(id, notifyDirect)
+			}
+		}
+		if p.everyone || p.here {
+			msg.MentionEveryone = true
+			for _, m := range members {
+				if p.everyone || presence.IsOnline(m.ID) {
+					notify(m.ID, notifyEveryone)
+				}
+			}
+		}
+		return notified, nil
+	}
+
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	for _, id := range p.users {
+		ok, err := s.canViewChannel(ctx, id, ch)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			msg.Mentions = append(msg.Mentions, id)
+			notify(id, notifyDirect)
+		}
+	}
+
+	roleRepo := &database.RoleRepo{DB: s.db}
+	for _, id := range p.roles {
+		role, err := roleRepo.GetByID(ctx, id)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if role.ServerID != *ch.ServerID {
+			continue
+		}
+		msg.MentionRoles = append(msg.MentionRoles, id)
+		holders, err := roleRepo.ListRoleMembers(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, uid := range holders {
+			notify(uid, notifyDirect)
+		}
+	}
+
+	if p.everyone || p.here {
+		serverRepo := &database.ServerRepo{DB: s.db}
+		srv, err := serverRepo.GetServerByID(ctx, *ch.ServerID)
+		if err != nil {
+			return nil, err
+		}
+		perms, err := s.channelPermissions(ctx, srv, ch, msg.AuthorID)
+		if err != nil {
+			return nil, err
+		}
+		if perms&models.PermMentionEveryone != 0 {
+			msg.MentionEveryone = true
+			members, err := memberRepo.ListMembers(ctx, *ch.ServerID)
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range members {
+				if p.everyone || presence.IsOnline(m.UserID) {
+					notify(m.UserID, notifyEveryone)
+				}
+			}
+		}
+	}
+
+	// Role holders and @everyone targets are all members, so of what
+	// canViewChannel checks only the age gate is left, done here for all of
+	// them at once.
+	if ch.NSFW && len(notified) > 0 {
+		ids := make([]uuid.UUID, 0, len(notified))
+		for id := range notified {
+			ids = append(ids, id)
+		}
+		userRepo := &database.UserRepo{DB: s.db}
+		acknowledged, err := userRepo.NSFWAcknowledgedAmong(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for id := range notified {
+			if !acknowledged[id] {
+				delete(notified, id)
+			}
+		}
+	}
+
+	return notified, nil
+}
+
+// applyMentions parses the message's mentions and stores them, returning the
+// users it newly notifies: on an edit, users the previous version already
+// notified are left out.
+func (s *Server) applyMentions(ctx context.Context, ch *models.Channel, msg *models.Message) (map[uuid.UUID]notifyKind, error) {
+	mentionRepo := &database.MentionRepo{DB: s.db}
+
+	already := make(map[uuid.UUID]bool)
+	if msg.Edited {
+		previous, err := mentionRepo.ListNotified(ctx, msg.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range previous {
+			already[id] = true
+		}
+	}
+
+	// A forward quotes someone else's message, so its mentions ping no one.
+	var parsed parsedMentions
+	if msg.ForwardedFrom == nil {
+		parsed = parseMentions(messageNodes(msg))
+	}
+	notified, err := s.resolveMentions(ctx, ch, msg, parsed)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uuid.UUID, 0, len(notified))
+	for id := range notified {
+		ids = append(ids, id)
+	}
+	if err := mentionRepo.Replace(ctx, msg, ids); err != nil {
+		return nil, err
+	}
+
+	for id := range already {
+		delete(notified, id)
+	}
+	return notified, nil
+}
+
+// deliverNotifications is called after a message is created or edited, from
+// both the REST and WebSocket paths. It applies the message's mentions, then,
+// for each user whose notification settings allow it, sends a mention event
+// to their connected clients and, if they have none, a Web Push for new
+// mentions and new DM messages.
+func (s *Server) deliverNotifications(ctx context.Context, ch *models.Channel, msg *models.Message) error {
+	targets, err := s.applyMentions(ctx, ch, msg)
+	if err != nil {
+		return err
+	}
+
+	if ch.ServerID == nil && !msg.Edited {
+		dmRepo := &database.DMMemberRepo{DB: s.db}
+		members, err := dmRepo.ListMembers(ctx, ch.ID)
+		if err != nil {
+			return err
+		}
+		for _, m := range members {
+			if _, ok := targets[m.ID]; !ok && m.ID != msg.AuthorID {
+				targets[m.ID] = notifyMessage
+			}
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, 0, len(targets))
+	for id := range targets {
+		ids = append(ids, id)
+	}
+	prefs, err := s.loadNotificationPrefs(ctx, ch, ids)
+	if err != nil {
+		return err
+	}
+
+	presence := s.hub.Presence()
+	now := time.Now()
+	var mentioned []uuid.UUID
+	for id, kind := range targets {
+		if !wantsNotification(prefs[id], ch.ServerID == nil, kind, now) {
+			continue
+		}
+		if kind != notifyMessage {
+			mentioned = append(mentioned, id)
+		}
+		if !presence.IsOnline(id) {
+			s.pushToUser(id, newPushPayload(ch, msg, kind))
+		}
+	}
+
+	if len(mentioned) > 0 {
+		s.sendToUsers(mentioned, map[string]any{
+			"type":      "mention",
+			"server_id": ch.ServerID,
+			"message":   msg,
+		})
+	}
+	return nil
+}
+
+func (s *Server) handleListMentions(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := 25
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	var before *uuid.UUID
+	if b := r.URL.Query().Get("before"); b != "" {
+		parsed, err := uuid.Parse(b)
+		if err != nil {
+			jsonError(w, "invalid before cursor", http.StatusBadRequest)
+			return
+		}
+		before = &parsed
+	}
+
+	mentionRepo := &database.MentionRepo{DB: s.db}
+	entries, err := mentionRepo.ListForUser(r.Context(), user.ID, before, limit)
+	if err != nil {
+		jsonError(w, "failed to list mentions", http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []models.MentionEntry{}
+	}
+
+	attachmentRepo := &database.AttachmentRepo{DB: s.db}
+	for i := range entries {
+		m := &entries[i].Message
+		if atts, err := attachmentRepo.ListByMessage(r.Context(), m.ID); err == nil {
+			m.Attachments = atts
+		}
+		if err := mentionRepo.Load(r.Context(), m); err != nil {
+			jsonError(w, "failed to load mentions", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Stocist/discard/internal/markdown"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestParseMentions(t *testing.T) {
+	u1, u2, r1 := uuid.New(), uuid.New(), uuid.New()
+	tests := []struct {
+		name, in string
+		want     parsedMentions
+	}{
+		{"none", "hello", parsedMentions{}},
+		{"users and roles", fmt.Sprintf("<@%s> <@!%s> <@&%s>", u1, u2, r1),
+			parsedMentions{users: []uuid.UUID{u1, u2}, roles: []uuid.UUID{r1}}},
+		{"duplicates", fmt.Sprintf("<@%s> <@%s>", u1, u1), parsedMentions{users: []uuid.UUID{u1}}},
+		{"everyone", "hey @everyone", parsedMentions{everyone: true}},
+		{"here", "@here, look", parsedMentions{here: true}},
+		{"email addresses", "mail bob@everyone.net or me@here.io", parsedMentions{}},
+		{"code span", fmt.Sprintf("`@everyone <@%s>`", u1), parsedMentions{}},
+		{"code block", fmt.Sprintf("```\n@here\n<@&%s>\n```", r1), parsedMentions{}},
+		{"spoiler", fmt.Sprintf("||<@%s>||", u1), parsedMentions{users: []uuid.UUID{u1}}},
+		{"quote", "> @everyone", parsedMentions{everyone: true}},
+		{"escaped", `\<@` + u1.String() + `>`, parsedMentions{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseMentions(markdown.Parse(tt.in)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMentions(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMentionsLimit(t *testing.T) {
+	var b strings.Builder
+	for range maxMentions + 10 {
+		fmt.Fprintf(&b, "<@%s> ", uuid.New())
+	}
+	if got := parseMentions(markdown.Parse(b.String())); len(got.users) != maxMentions {
+		t.Errorf("%d users mentioned, want %d", len(got.users), maxMentions)
+	}
+}
+
+func TestMessageNodes(t *testing.T) {
+	msg := &models.Message{Content: "**a** @here"}
+	msg.AST = markdown.Encode(msg.Content)
+	if p := parseMentions(messageNodes(msg)); !p.here {
+		t.Error("stored AST not used")
+	}
+	msg.AST = []byte("not json")
+	if p := parseMentions(messageNodes(msg)); !p.here {
+		t.Error("unreadable AST did not fall back to the content")
+	}
+}
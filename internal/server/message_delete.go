@@ -0,0 +1,362 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/google/uuid"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+)
+
+const (
+	// maxBulkDelete caps how many messages one bulk delete may name.
+	maxBulkDelete = 100
+	// maxPurgeScan caps how many recent messages a purge looks through.
+	maxPurgeScan = 1000
+	// maxPurgePattern caps the length of a purge content pattern.
+	maxPurgePattern = 256
+)
+
+// deleteMessages moves messages of a channel to the trash, returning the IDs
+// that were deleted; telling clients about them is left to the caller. The
+// trash worker removes them, and their attachment files, for good once
+// trashRetention has passed.
+func (s *Server) deleteMessages(ctx context.Context, channelID uuid.UUID, ids []uuid.UUID, deletedBy uuid.UUID) ([]uuid.UUID, error) {
+	msgRepo := &database.MessageRepo{DB: s.db}
+	return msgRepo.DeleteMessages(ctx, channelID, ids, deletedBy)
+}
+
+// announceBulkDelete tells a channel's clients and webhooks that messages
+// were deleted, in one event rather than one per message.
+func (s *Server) announceBulkDelete(ctx context.Context, ch *models.Channel, ids []uuid.UUID) {
+	s.emitChannelWebhookEvent(ctx, ch, hookMessageBulk, map[string]any{
+		"channel_id":  ch.ID,
+		"message_ids": ids,
+	})
+
+	out, err := json.Marshal(map[string]any{
+		"type":        "message_delete_bulk",
+		"channel_id":  ch.ID,
+		"message_ids": ids,
+	})
+	if err == nil {
+		s.hub.BroadcastToChannel(ch.ID, out)
+	}
+}
+
+// moderatedChannel loads a server channel for a moderation request and
+// checks the user may manage its messages. It writes the error response and
+// returns nil otherwise.
+func (s *Server) moderatedChannel(w http.ResponseWriter, r *http.Request, userID uuid.UUID) *models.Channel {
+	channelID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid channel id", http.StatusBadRequest)
+		return nil
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(r.Context(), channelID)
+	if err != nil {
+		jsonError(w, "channel not found", http.StatusNotFound)
+		return nil
+	}
+	// DMs have no moderators; each side deletes their own messages.
+	if ch.ServerID == nil {
+		jsonError(w, "forbidden", http.StatusForbidden)
+		return nil
+	}
+	if s.requireChannelPermission(w, r, ch, userID, models.PermManageMessages) == nil {
+		return nil
+	}
+	return ch
+}
+
+func (s *Server) handleBulkDeleteMessages(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ch := s.moderatedChannel(w, r, user.ID)
+	if ch == nil {
+		return
+	}
+
+	var input struct {
+		MessageIDs []uuid.UUID `json:"message_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(input.MessageIDs) == 0 {
+		jsonError(w, "message_ids is required", http.StatusBadRequest)
+		return
+	}
+	if len(input.MessageIDs) > maxBulkDelete {
+		jsonError(w, "too many messages (max 100)", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := s.deleteMessages(r.Context(), ch.ID, input.MessageIDs, user.ID)
+	if err != nil {
+		jsonError(w, "failed to delete messages", http.StatusInternalServerError)
+		return
+	}
+	s.finishBulkDelete(w, r, ch, deleted, map[string]any{"count": len(deleted)})
+}
+
+func (s *Server) handlePurgeMessages(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ch := s.moderatedChannel(w, r, user.ID)
+	if ch == nil {
+		return
+	}
+
+	var input struct {
+		Limit          int        `json:"limit"`
+		AuthorID       *uuid.UUID `json:"author_id"`
+		Pattern        string     `json:"pattern"`
+		HasAttachments *bool      `json:"has_attachments"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if input.Limit < 1 || input.Limit > maxPurgeScan {
+		jsonError(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+		return
+	}
+	// Patterns are RE2, so a hostile one can't make matching blow up.
+	var re *regexp.Regexp
+	if input.Pattern != "" {
+		if len(input.Pattern) > maxPurgePattern {
+			jsonError(w, "pattern is too long", http.StatusBadRequest)
+			return
+		}
+		var err error
+		re, err = regexp.Compile(input.Pattern)
+		if err != nil {
+			jsonError(w, "invalid pattern", http.StatusBadRequest)
+			return
+		}
+	}
+
+	msgRepo := &database.MessageRepo{DB: s.db}
+	candidates, err := msgRepo.ListPurgeCandidates(r.Context(), ch.ID, input.Limit)
+	if err != nil {
+		jsonError(w, "failed to list messages", http.StatusInternalServerError)
+		return
+	}
+
+	var ids []uuid.UUID
+	for _, c := range candidates {
+		if input.AuthorID != nil && c.AuthorID != *input.AuthorID {
+			continue
+		}
+		if input.HasAttachments != nil && c.HasAttachments != *input.HasAttachments {
+			continue
+		}
+		if re != nil && !re.MatchString(c.Content) {
+			continue
+		}
+		ids = append(ids, c.ID)
+	}
+
+	var deleted []uuid.UUID
+	if len(ids) > 0 {
+		deleted, err = s.deleteMessages(r.Context(), ch.ID, ids, user.ID)
+		if err != nil {
+			jsonError(w, "failed to delete messages", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	after := map[string]any{"count": len(deleted), "limit": input.Limit}
+	if input.AuthorID != nil {
+		after["author_id"] = *input.AuthorID
+	}
+	if input.Pattern != "" {
+		after["pattern"] = input.Pattern
+	}
+	if input.HasAttachments != nil {
+		after["has_attachments"] = *input.HasAttachments
+	}
+	s.finishBulkDelete(w, r, ch, deleted, after)
+}
+
+// finishBulkDelete announces and audits a bulk delete and writes the list of
+// deleted IDs as the response.
+func (s *Server) finishBulkDelete(w http.ResponseWriter, r *http.Request, ch *models.Channel, deleted []uuid.UUID, after map[string]any) {
+	if deleted == nil {
+		deleted = []uuid.UUID{}
+	}
+	if len(deleted) > 0 {
+		s.announceBulkDelete(r.Context(), ch, deleted)
+		s.audit(r, auditEvent{
+			ServerID:   *ch.ServerID,
+			Action:     auditMessageBulk,
+			TargetType: auditTargetChannel,
+			TargetID:   &ch.ID,
+			After:      after,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"deleted": deleted})
+}
+
+func (s *Server) handleListDeletedMessages(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ch := s.moderatedChannel(w, r, user.ID)
+	if ch == nil {
+		return
+	}
+
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	var before *uuid.UUID
+	if b := r.URL.Query().Get("before"); b != "" {
+		parsed, err := uuid.Parse(b)
+		if err != nil {
+			jsonError(w, "invalid before cursor", http.StatusBadRequest)
+			return
+		}
+		before = &parsed
+	}
+
+	msgRepo := &database.MessageRepo{DB: s.db}
+	messages, err := msgRepo.ListDeleted(r.Context(), ch.ID, before, limit)
+	if err != nil {
+		jsonError(w, "failed to list deleted messages", http.StatusInternalServerError)
+		return
+	}
+	if messages == nil {
+		messages = []models.Message{}
+	}
+
+	attachmentRepo := &database.AttachmentRepo{DB: s.db}
+	for i := range messages {
+		atts, err := attachmentRepo.ListByMessage(r.Context(), messages[i].ID)
+		if err != nil {
+			log.Printf("failed to load attachments for message %s: %v", messages[i].ID, err)
+			continue
+		}
+		messages[i].Attachments = atts
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+func (s *Server) handleRestoreMessage(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	messageID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	msgRepo := &database.MessageRepo{DB: s.db}
+	deleted, err := msgRepo.GetDeleted(r.Context(), messageID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get message", http.StatusInternalServerError)
+		return
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(r.Context(), deleted.ChannelID)
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return
+	}
+	if ch.ServerID == nil {
+		jsonError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if s.requireChannelPermission(w, r, ch, user.ID, models.PermManageMessages) == nil {
+		return
+	}
+
+	msg, err := msgRepo.Restore(r.Context(), messageID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to restore message", http.StatusInternalServerError)
+		return
+	}
+
+	attachmentRepo := &database.AttachmentRepo{DB: s.db}
+	if atts, err := attachmentRepo.ListByMessage(r.Context(), msg.ID); err == nil {
+		msg.Attachments = atts
+	}
+	mentionRepo := &database.MentionRepo{DB: s.db}
+	if err := mentionRepo.Load(r.Context(), msg); err != nil {
+		log.Printf("failed to load mentions for message %s: %v", msg.ID, err)
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   *ch.ServerID,
+		Action:     auditMessageRestore,
+		TargetType: auditTargetUser,
+		TargetID:   &msg.AuthorID,
+		Before: map[string]any{
+			"deleted_at": deleted.DeletedAt,
+			"deleted_by": deleted.DeletedBy,
+		},
+		After: map[string]any{
+			"message_id": msg.ID,
+			"channel_id": msg.ChannelID,
+		},
+	})
+
+	// Clients put the message back in place by its created_at.
+	out, err := json.Marshal(map[string]any{
+		"type":    "message_restore",
+		"message": msg,
+	})
+	if err == nil {
+		s.hub.BroadcastToChannel(msg.ChannelID, out)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
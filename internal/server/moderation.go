@@ -0,0 +1,329 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// maxTimeout is the longest a member can be timed out for.
+const maxTimeout = 28 * 24 * time.Hour
+
+// moderationTarget parses the {id} and {userId} path values and checks that
+// the acting user holds perm and that the target can be moderated at all.
+// Only the owner can moderate an administrator or a member holding
+// permissions the actor lacks. On failure it writes the error response and
+// returns a nil server.
+func (s *Server) moderationTarget(w http.ResponseWriter, r *http.Request, perm int64) (*models.Server, *models.User) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return nil, nil
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return nil, nil
+	}
+	targetID, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		jsonError(w, "invalid user id", http.StatusBadRequest)
+		return nil, nil
+	}
+
+	srv := s.requirePermission(w, r, serverID, user.ID, perm)
+	if srv == nil {
+		return nil, nil
+	}
+	if targetID == user.ID {
+		jsonError(w, "cannot moderate yourself", http.StatusBadRequest)
+		return nil, nil
+	}
+	if targetID == srv.OwnerID {
+		jsonError(w, "cannot moderate the server owner", http.StatusForbidden)
+		return nil, nil
+	}
+	if user.ID != srv.OwnerID {
+		perms, err := s.memberPermissions(r.Context(), srv, user.ID)
+		if err != nil {
+			jsonError(w, "failed to check permissions", http.StatusInternalServerError)
+			return nil, nil
+		}
+		targetPerms, err := s.memberPermissions(r.Context(), srv, targetID)
+		if err != nil {
+			jsonError(w, "failed to check permissions", http.StatusInternalServerError)
+			return nil, nil
+		}
+		if targetPerms&models.PermAdministrator != 0 || targetPerms&^perms != 0 {
+			jsonError(w, "cannot moderate a member with permissions you do not have", http.StatusForbidden)
+			return nil, nil
+		}
+	}
+
+	userRepo := &database.UserRepo{DB: s.db}
+	target, err := userRepo.GetByID(r.Context(), targetID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "user not found", http.StatusNotFound)
+		return nil, nil
+	}
+	if err != nil {
+		jsonError(w, "failed to look up user", http.StatusInternalServerError)
+		return nil, nil
+	}
+	return srv, target
+}
+
+// evictMember drops a removed member's subscriptions to the server's channels
+// and tells every client, and the server's webhooks, the member is gone.
+// reason is "kick", "ban" or "temporary".
+func (s *Server) evictMember(ctx context.Context, serverID, userID uuid.UUID, reason string) {
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	channels, err := channelRepo.ListServerChannels(ctx, serverID)
+	if err != nil {
+		log.Printf("failed to list channels of server %s: %v", serverID, err)
+	}
+	ids := make([]uuid.UUID, len(channels))
+	for i, ch := range channels {
+		ids[i] = ch.ID
+	}
+	s.hub.UnsubscribeUser(userID, ids)
+
+	out, err := json.Marshal(map[string]string{
+		"type":      "member_remove",
+		"server_id": serverID.String(),
+		"user_id":   userID.String(),
+	})
+	if err == nil {
+		s.hub.BroadcastAll(out)
+	}
+
+	s.emitWebhookEvent(ctx, serverID, nil, hookMemberLeave, map[string]any{
+		"user_id": userID,
+		"reason":  reason,
+	})
+}
+
+func (s *Server) handleKickMember(w http.ResponseWriter, r *http.Request) {
+	srv, target := s.moderationTarget(w, r, models.PermKickMembers)
+	if srv == nil {
+		return
+	}
+
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	isMember, err := memberRepo.IsMember(r.Context(), target.ID, srv.ID)
+	if err != nil {
+		jsonError(w, "failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		jsonError(w, "user is not a member", http.StatusNotFound)
+		return
+	}
+
+	if err := memberRepo.RemoveMember(r.Context(), target.ID, srv.ID); err != nil {
+		jsonError(w, "failed to kick member", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   srv.ID,
+		Action:     auditMemberKick,
+		TargetType: auditTargetUser,
+		TargetID:   &target.ID,
+	})
+
+	s.evictMember(r.Context(), srv.ID, target.ID, "kick")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleBanMember(w http.ResponseWriter, r *http.Request) {
+	srv, target := s.moderationTarget(w, r, models.PermBanMembers)
+	if srv == nil {
+		return
+	}
+	user := auth.UserFromContext(r.Context())
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	input.Reason = strings.TrimSpace(input.Reason)
+	if len(input.Reason) > 512 {
+		jsonError(w, "reason must be 512 characters or less", http.StatusBadRequest)
+		return
+	}
+
+	ban := &models.ServerBan{
+		ServerID:    srv.ID,
+		UserID:      target.ID,
+		TailscaleID: target.TailscaleID,
+		BannedBy:    user.ID,
+		Username:    target.Username,
+	}
+	if input.Reason != "" {
+		ban.Reason = &input.Reason
+	}
+
+	banRepo := &database.BanRepo{DB: s.db}
+	if err := banRepo.Create(r.Context(), ban); err != nil {
+		jsonError(w, "failed to ban member", http.StatusInternalServerError)
+		return
+	}
+
+	// The ban's own reason doubles as the audit reason unless the header overrides it.
+	reason := auditReason(r)
+	if reason == nil {
+		reason = ban.Reason
+	}
+	s.auditAs(r.Context(), user.ID, reason, auditEvent{
+		ServerID:   srv.ID,
+		Action:     auditMemberBan,
+		TargetType: auditTargetUser,
+		TargetID:   &target.ID,
+	})
+
+	s.evictMember(r.Context(), srv.ID, target.ID, "ban")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ban)
+}
+
+func (s *Server) handleUnbanMember(w http.ResponseWriter, r *http.Request) {
+	srv, target := s.moderationTarget(w, r, models.PermBanMembers)
+	if srv == nil {
+		return
+	}
+
+	banRepo := &database.BanRepo{DB: s.db}
+	err := banRepo.Delete(r.Context(), srv.ID, target.ID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "user is not banned", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to unban member", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   srv.ID,
+		Action:     auditMemberUnban,
+		TargetType: auditTargetUser,
+		TargetID:   &target.ID,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListBans(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	if s.requirePermission(w, r, serverID, user.ID, models.PermBanMembers) == nil {
+		return
+	}
+
+	banRepo := &database.BanRepo{DB: s.db}
+	bans, err := banRepo.ListByServer(r.Context(), serverID)
+	if err != nil {
+		jsonError(w, "failed to list bans", http.StatusInternalServerError)
+		return
+	}
+	if bans == nil {
+		bans = []models.ServerBan{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bans)
+}
+
+func (s *Server) handleTimeoutMember(w http.ResponseWriter, r *http.Request) {
+	srv, target := s.moderationTarget(w, r, models.PermModerateMembers)
+	if srv == nil {
+		return
+	}
+
+	var input struct {
+		DurationSeconds int `json:"duration_seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	duration := time.Duration(input.DurationSeconds) * time.Second
+	if duration <= 0 || duration > maxTimeout {
+		jsonError(w, "duration_seconds must be between 1 and 2419200", http.StatusBadRequest)
+		return
+	}
+
+	until := time.Now().Add(duration)
+	s.setMemberTimeout(w, r, srv.ID, target.ID, &until)
+}
+
+func (s *Server) handleClearTimeout(w http.ResponseWriter, r *http.Request) {
+	srv, target := s.moderationTarget(w, r, models.PermModerateMembers)
+	if srv == nil {
+		return
+	}
+	s.setMemberTimeout(w, r, srv.ID, target.ID, nil)
+}
+
+// setMemberTimeout persists a timeout change, broadcasts member_update and
+// writes the response.
+func (s *Server) setMemberTimeout(w http.ResponseWriter, r *http.Request, serverID, userID uuid.UUID, until *time.Time) {
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	err := memberRepo.SetTimeout(r.Context(), userID, serverID, until)
+	if err == sql.ErrNoRows {
+		jsonError(w, "user is not a member", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to update timeout", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   serverID,
+		Action:     auditMemberTimeout,
+		TargetType: auditTargetUser,
+		TargetID:   &userID,
+		After:      map[string]*time.Time{"timeout_until": until},
+	})
+
+	out, err := json.Marshal(map[string]any{
+		"type":          "member_update",
+		"server_id":     serverID.String(),
+		"user_id":       userID.String(),
+		"timeout_until": until,
+	})
+	if err == nil {
+		s.hub.BroadcastAll(out)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+var validNotificationLevels = map[string]bool{
+	"all":      true,
+	"mentions": true,
+	"nothing":  true,
+}
+
+// notifyKind is why a message might notify a user.
+type notifyKind int
+
+const (
+	// notifyMessage is a message that does not mention the user.
+	notifyMessage notifyKind = iota
+	// notifyEveryone is a message that reaches the user only through @everyone or @here.
+	notifyEveryone
+	// notifyDirect is a message that mentions the user or one of their roles.
+	notifyDirect
+)
+
+// mutedNow reports whether an override is an active mute.
+func mutedNow(o *models.NotificationOverride, now time.Time) bool {
+	return o != nil && o.Muted && (o.MutedUntil == nil || o.MutedUntil.After(now))
+}
+
+// notificationPrefs are a user's notification settings that apply to one
+// channel.
+type notificationPrefs struct {
+	channel *models.NotificationOverride
+	server  *models.NotificationOverride
+	muteDMs bool
+}
+
+// loadNotificationPrefs loads the settings that apply to ch for each of the
+// users, with one query per kind of setting rather than per user.
+func (s *Server) loadNotificationPrefs(ctx context.Context, ch *models.Channel, userIDs []uuid.UUID) (map[uuid.UUID]notificationPrefs, error) {
+	notifRepo := &database.NotificationRepo{DB: s.db}
+
+	channelOvs, err := notifRepo.ListOverridesFor(ctx, userIDs, ch.ID)
+	if err != nil {
+		return nil, err
+	}
+	var serverOvs map[uuid.UUID]*models.NotificationOverride
+	var muteDMs map[uuid.UUID]bool
+	if ch.ServerID == nil {
+		muteDMs, err = notifRepo.ListMutingDMs(ctx, userIDs)
+	} else {
+		serverOvs, err = notifRepo.ListOverridesFor(ctx, userIDs, *ch.ServerID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	prefs := make(map[uuid.UUID]notificationPrefs, len(userIDs))
+	for _, id := range userIDs {
+		prefs[id] = notificationPrefs{channel: channelOvs[id], server: serverOvs[id], muteDMs: muteDMs[id]}
+	}
+	return prefs, nil
+}
+
+// wantsNotification applies a user's notification settings to a message of
+// the given kind. A channel's level overrides its server's; a mute on either
+// silences everything, as does muting DMs for DM channels.
+func wantsNotification(p notificationPrefs, dm bool, kind notifyKind, now time.Time) bool {
+	if mutedNow(p.channel, now) || mutedNow(p.server, now) || (dm && p.muteDMs) {
+		return false
+	}
+
+	level := "all"
+	suppressEveryone := false
+	for _, o := range []*models.NotificationOverride{p.server, p.channel} {
+		if o == nil {
+			continue
+		}
+		if o.Level != nil {
+			level = *o.Level
+		}
+		suppressEveryone = suppressEveryone || o.SuppressEveryone
+	}
+	if kind == notifyEveryone && suppressEveryone {
+		kind = notifyMessage
+	}
+
+	switch level {
+	case "nothing":
+		return false
+	case "mentions":
+		return kind != notifyMessage
+	default:
+		return true
+	}
+}
+
+func (s *Server) handleGetNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	notifRepo := &database.NotificationRepo{DB: s.db}
+	settings, err := notifRepo.GetSettings(r.Context(), user.ID)
+	if err != nil {
+		jsonError(w, "failed to get notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// notifySettingsChanged sends the user's current notification settings to all
+// of their devices and writes them as the response.
+func (s *Server) notifySettingsChanged(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	notifRepo := &database.NotificationRepo{DB: s.db}
+	settings, err := notifRepo.GetSettings(r.Context(), userID)
+	if err != nil {
+		jsonError(w, "failed to get notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	s.sendToUsers([]uuid.UUID{userID}, map[string]any{
+		"type":                  "notification_settings_update",
+		"notification_settings": settings,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (s *Server) handleUpdateNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		MuteDMs *bool `json:"mute_dms"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if input.MuteDMs != nil {
+		notifRepo := &database.NotificationRepo{DB: s.db}
+		if err := notifRepo.SetMuteDMs(r.Context(), user.ID, *input.MuteDMs); err != nil {
+			jsonError(w, "failed to update notification settings", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.notifySettingsChanged(w, r, user.ID)
+}
+
+// loadNotificationTarget parses {id} as a server or channel the user can see.
+// On failure it writes the error response and returns uuid.Nil.
+func (s *Server) loadNotificationTarget(w http.ResponseWriter, r *http.Request, userID uuid.UUID, targetType string) uuid.UUID {
+	targetID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid "+targetType+" id", http.StatusBadRequest)
+		return uuid.Nil
+	}
+
+	var visible bool
+	if targetType == "server" {
+		memberRepo := &database.ServerMemberRepo{DB: s.db}
+		visible, err = memberRepo.IsMember(r.Context(), userID, targetID)
+	} else {
+		channelRepo := &database.ChannelRepo{DB: s.db}
+		ch, chErr := channelRepo.GetChannelByID(r.Context(), targetID)
+		if chErr == sql.ErrNoRows {
+			jsonError(w, "channel not found", http.StatusNotFound)
+			return uuid.Nil
+		}
+		if chErr != nil {
+			jsonError(w, "failed to get channel", http.StatusInternalServerError)
+			return uuid.Nil
+		}
+		if ch.ServerID != nil {
+			memberRepo := &database.ServerMemberRepo{DB: s.db}
+			visible, err = memberRepo.IsMember(r.Context(), userID, *ch.ServerID)
+		} else {
+			dmRepo := &database.DMMemberRepo{DB: s.db}
+			visible, err = dmRepo.IsMember(r.Context(), targetID, userID)
+		}
+	}
+	if err != nil {
+		jsonError(w, "failed to check membership", http.StatusInternalServerError)
+		return uuid.Nil
+	}
+	if !visible {
+		jsonError(w, "forbidden", http.StatusForbidden)
+		return uuid.Nil
+	}
+	return targetID
+}
+
+func (s *Server) handleSetServerNotifications(w http.ResponseWriter, r *http.Request) {
+	s.setNotificationOverride(w, r, "server")
+}
+
+func (s *Server) handleSetChannelNotifications(w http.ResponseWriter, r *http.Request) {
+	s.setNotificationOverride(w, r, "channel")
+}
+
+// setNotificationOverride replaces the user's override for a server or channel.
+func (s *Server) setNotificationOverride(w http.ResponseWriter, r *http.Request, targetType string) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	targetID := s.loadNotificationTarget(w, r, user.ID, targetType)
+	if targetID == uuid.Nil {
+		return
+	}
+
+	var input struct {
+		Level            *string    `json:"level"`
+		Muted            bool       `json:"muted"`
+		MutedUntil       *time.Time `json:"muted_until"`
+		SuppressEveryone bool       `json:"suppress_everyone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if input.Level != nil && !validNotificationLevels[*input.Level] {
+		jsonError(w, "level must be all, mentions or nothing", http.StatusBadRequest)
+		return
+	}
+	if input.MutedUntil != nil {
+		if !input.Muted {
+			jsonError(w, "muted_until requires muted", http.StatusBadRequest)
+			return
+		}
+		if !input.MutedUntil.After(time.Now()) {
+			jsonError(w, "muted_until must be in the future", http.StatusBadRequest)
+			return
+		}
+	}
+
+	o := &models.NotificationOverride{
+		TargetID:         targetID,
+		TargetType:       targetType,
+		Level:            input.Level,
+		Muted:            input.Muted,
+		MutedUntil:       input.MutedUntil,
+		SuppressEveryone: input.SuppressEveryone,
+	}
+	notifRepo := &database.NotificationRepo{DB: s.db}
+	if err := notifRepo.UpsertOverride(r.Context(), user.ID, o); err != nil {
+		jsonError(w, "failed to update notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	s.notifySettingsChanged(w, r, user.ID)
+}
+
+// handleResetNotifications removes the user's override for a server or
+// channel, returning it to the inherited settings.
+func (s *Server) handleResetNotifications(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	targetID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	notifRepo := &database.NotificationRepo{DB: s.db}
+	err = notifRepo.DeleteOverride(r.Context(), user.ID, targetID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "no notification settings for this target", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to reset notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	s.notifySettingsChanged(w, r, user.ID)
+}
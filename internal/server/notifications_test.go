@@ -0,0 +1,43 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Stocist/discard/internal/models"
+)
+
+func TestWantsNotification(t *testing.T) {
+	now := time.Now()
+	level := func(l string) *models.NotificationOverride { return &models.NotificationOverride{Level: &l} }
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	tests := []struct {
+		name  string
+		prefs notificationPrefs
+		dm    bool
+		kind  notifyKind
+		want  bool
+	}{
+		{"defaults", notificationPrefs{}, false, notifyMessage, true},
+		{"muted channel", notificationPrefs{channel: &models.NotificationOverride{Muted: true}}, false, notifyDirect, false},
+		{"muted server", notificationPrefs{server: &models.NotificationOverride{Muted: true}}, false, notifyDirect, false},
+		{"mute expired", notificationPrefs{channel: &models.NotificationOverride{Muted: true, MutedUntil: &past}}, false, notifyMessage, true},
+		{"mute pending", notificationPrefs{channel: &models.NotificationOverride{Muted: true, MutedUntil: &future}}, false, notifyDirect, false},
+		{"muted DMs", notificationPrefs{muteDMs: true}, true, notifyMessage, false},
+		{"muted DMs in a server", notificationPrefs{muteDMs: true}, false, notifyMessage, true},
+		{"mentions only", notificationPrefs{server: level("mentions")}, false, notifyMessage, false},
+		{"mentions only, mentioned", notificationPrefs{server: level("mentions")}, false, notifyDirect, true},
+		{"channel overrides server", notificationPrefs{server: level("nothing"), channel: level("all")}, false, notifyMessage, true},
+		{"nothing", notificationPrefs{channel: level("nothing")}, false, notifyDirect, false},
+		{"everyone suppressed", notificationPrefs{
+			server: &models.NotificationOverride{Level: level("mentions").Level, SuppressEveryone: true},
+		}, false, notifyEveryone, false},
+		{"everyone", notificationPrefs{server: level("mentions")}, false, notifyEveryone, true},
+	}
+	for _, tt := range tests {
+		if got := wantsNotification(tt.prefs, tt.dm, tt.kind, now); got != tt.want {
+			t.Errorf("%s: wantsNotification = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// Outgoing webhook event types.
+const (
+	hookMessageCreate = "message_create"
+	hookMessageUpdate = "message_update"
+	hookMessageDelete = "message_delete"
+	hookMessageBulk   = "message_delete_bulk"
+	hookMemberJoin    = "member_join"
+	hookMemberLeave   = "member_leave"
+	hookChannelCreate = "channel_create"
+	hookChannelUpdate = "channel_update"
+	hookChannelDelete = "channel_delete"
+)
+
+var validHookEvents = map[string]bool{
+	hookMessageCreate: true,
+	hookMessageUpdate: true,
+	hookMessageDelete: true,
+	hookMessageBulk:   true,
+	hookMemberJoin:    true,
+	hookMemberLeave:   true,
+	hookChannelCreate: true,
+	hookChannelUpdate: true,
+	hookChannelDelete: true,
+}
+
+// emitWebhookEvent queues event for the server's outgoing webhooks. Channel
+// events pass the channel so channel-scoped webhooks can match. Failures are
+// logged rather than surfaced: the event has already happened.
+func (s *Server) emitWebhookEvent(ctx context.Context, serverID uuid.UUID, channelID *uuid.UUID, event string, data any) {
+	now := time.Now()
+	hookRepo := &database.OutgoingWebhookRepo{DB: s.db}
+	n, err := hookRepo.Enqueue(ctx, serverID, channelID, event, func(id uuid.UUID) ([]byte, error) {
+		return json.Marshal(map[string]any{
+			"id":         id,
+			"event":      event,
+			"server_id":  serverID,
+			"channel_id": channelID,
+			"timestamp":  now,
+			"data":       data,
+		})
+	})
+	if err != nil {
+		log.Printf("failed to queue %s webhooks for server %s: %v", event, serverID, err)
+		return
+	}
+	if n > 0 {
+		s.wakeWebhooks()
+	}
+}
+
+// emitChannelWebhookEvent queues event for a channel's server. DM channels
+// have no server and are skipped.
+func (s *Server) emitChannelWebhookEvent(ctx context.Context, ch *models.Channel, event string, data any) {
+	if ch.ServerID == nil {
+		return
+	}
+	s.emitWebhookEvent(ctx, *ch.ServerID, &ch.ID, event, data)
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// normalizeHookEvents validates and de-duplicates an event list, returning
+// false if it is empty or names an unknown event.
+func normalizeHookEvents(events []string) ([]string, bool) {
+	seen := make(map[string]bool)
+	var out []string
+	for _, e := range events {
+		if !validHookEvents[e] {
+			return nil, false
+		}
+		if !seen[e] {
+			seen[e] = true
+			out = append(out, e)
+		}
+	}
+	return out, len(out) > 0
+}
+
+// checkServerChannel verifies channelID is a channel of serverID. On failure
+// it writes the error response and returns false.
+func (s *Server) checkServerChannel(w http.ResponseWriter, r *http.Request, serverID, channelID uuid.UUID) bool {
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(r.Context(), channelID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "channel not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return false
+	}
+	if ch.ServerID == nil || *ch.ServerID != serverID {
+		jsonError(w, "channel does not belong to this server", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// loadOutgoingWebhook checks the user may manage webhooks in server {id} and
+// fetches webhook {webhookId} from it. On failure it writes the error
+// response and returns nil.
+func (s *Server) loadOutgoingWebhook(w http.ResponseWriter, r *http.Request, userID uuid.UUID) *models.OutgoingWebhook {
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return nil
+	}
+	hookID, err := uuid.Parse(r.PathValue("webhookId"))
+	if err != nil {
+		jsonError(w, "invalid webhook id", http.StatusBadRequest)
+		return nil
+	}
+	if s.requirePermission(w, r, serverID, userID, models.PermManageWebhooks) == nil {
+		return nil
+	}
+
+	hookRepo := &database.OutgoingWebhookRepo{DB: s.db}
+	h, err := hookRepo.GetByID(r.Context(), hookID)
+	if err == sql.ErrNoRows || (err == nil && h.ServerID != serverID) {
+		jsonError(w, "webhook not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		jsonError(w, "failed to get webhook", http.StatusInternalServerError)
+		return nil
+	}
+	return h
+}
+
+func (s *Server) handleListOutgoingWebhooks(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+	if s.requirePermission(w, r, serverID, user.ID, models.PermManageWebhooks) == nil {
+		return
+	}
+
+	hookRepo := &database.OutgoingWebhookRepo{DB: s.db}
+	hooks, err := hookRepo.ListByServer(r.Context(), serverID)
+	if err != nil {
+		jsonError(w, "failed to list webhooks", http.StatusInternalServerError)
+		return
+	}
+	if hooks == nil {
+		hooks = []models.OutgoingWebhook{}
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hooks)
+}
+
+func (s *Server) handleCreateOutgoingWebhook(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+	if s.requirePermission(w, r, serverID, user.ID, models.PermManageWebhooks) == nil {
+		return
+	}
+
+	var input struct {
+		Name      string     `json:"name"`
+		URL       string     `json:"url"`
+		ChannelID *uuid.UUID `json:"channel_id"`
+		Events    []string   `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" || len(input.Name) > 100 {
+		jsonError(w, "name is required and must be 100 characters or less", http.StatusBadRequest)
+		return
+	}
+	if s.checkCallbackURL(r.Context(), input.URL) != nil {
+		jsonError(w, "url must be an http or https URL on a public host", http.StatusBadRequest)
+		return
+	}
+	events, ok := normalizeHookEvents(input.Events)
+	if !ok {
+		jsonError(w, "events must list at least one known event", http.StatusBadRequest)
+		return
+	}
+	if input.ChannelID != nil && !s.checkServerChannel(w, r, serverID, *input.ChannelID) {
+		return
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		jsonError(w, "failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	h := &models.OutgoingWebhook{
+		ServerID:  serverID,
+		ChannelID: input.ChannelID,
+		Name:      input.Name,
+		URL:       input.URL,
+		Secret:    secret,
+		Events:    events,
+		CreatedBy: user.ID,
+	}
+	hookRepo := &database.OutgoingWebhookRepo{DB: s.db}
+	if err := hookRepo.Create(r.Context(), h); err != nil {
+		jsonError(w, "failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   serverID,
+		Action:     auditWebhookCreate,
+		TargetType: auditTargetWebhook,
+		TargetID:   &h.ID,
+		After:      map[string]any{"name": h.Name, "url": h.URL, "channel_id": h.ChannelID, "events": h.Events},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(h)
+}
+
+// handleUpdateOutgoingWebhook edits a webhook. channel_id may be null to
+// make it server-wide, enabled re-enables one that was disabled after
+// failures, and rotate_secret issues a new secret, returned once.
+func (s *Server) handleUpdateOutgoingWebhook(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h := s.loadOutgoingWebhook(w, r, user.ID)
+	if h == nil {
+		return
+	}
+
+	var input struct {
+		Name         *string         `json:"name"`
+		URL          *string         `json:"url"`
+		ChannelID    json.RawMessage `json:"channel_id"`
+		Events       []string        `json:"events"`
+		Enabled      *bool           `json:"enabled"`
+		RotateSecret bool            `json:"rotate_secret"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	before := map[string]any{"name": h.Name, "url": h.URL, "channel_id": h.ChannelID, "events": h.Events, "enabled": h.Enabled}
+
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" || len(name) > 100 {
+			jsonError(w, "name is required and must be 100 characters or less", http.StatusBadRequest)
+			return
+		}
+		h.Name = name
+	}
+	if input.URL != nil {
+		if s.checkCallbackURL(r.Context(), *input.URL) != nil {
+			jsonError(w, "url must be an http or https URL on a public host", http.StatusBadRequest)
+			return
+		}
+		h.URL = *input.URL
+	}
+	if len(input.ChannelID) > 0 {
+		if string(input.ChannelID) == "null" {
+			h.ChannelID = nil
+		} else {
+			var channelID uuid.UUID
+			if err := json.Unmarshal(input.ChannelID, &channelID); err != nil {
+				jsonError(w, "invalid channel id", http.StatusBadRequest)
+				return
+			}
+			if !s.checkServerChannel(w, r, h.ServerID, channelID) {
+				return
+			}
+			h.ChannelID = &channelID
+		}
+	}
+	if input.Events != nil {
+		events, ok := normalizeHookEvents(input.Events)
+		if !ok {
+			jsonError(w, "events must list at least one known event", http.StatusBadRequest)
+			return
+		}
+		h.Events = events
+	}
+	if input.Enabled != nil {
+		h.Enabled = *input.Enabled
+	}
+	if input.RotateSecret {
+		secret, err := randomToken()
+		if err != nil {
+			jsonError(w, "failed to generate secret", http.StatusInternalServerError)
+			return
+		}
+		h.Secret = secret
+	}
+
+	hookRepo := &database.OutgoingWebhookRepo{DB: s.db}
+	if err := hookRepo.Update(r.Context(), h); err != nil {
+		jsonError(w, "failed to update webhook", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   h.ServerID,
+		Action:     auditWebhookUpdate,
+		TargetType: auditTargetWebhook,
+		TargetID:   &h.ID,
+		Before:     before,
+		After:      map[string]any{"name": h.Name, "url": h.URL, "channel_id": h.ChannelID, "events": h.Events, "enabled": h.Enabled},
+	})
+
+	if !input.RotateSecret {
+		h.Secret = ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h)
+}
+
+func (s *Server) handleDeleteOutgoingWebhook(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h := s.loadOutgoingWebhook(w, r, user.ID)
+	if h == nil {
+		return
+	}
+
+	hookRepo := &database.OutgoingWebhookRepo{DB: s.db}
+	if err := hookRepo.Delete(r.Context(), h.ID); err != nil && err != sql.ErrNoRows {
+		jsonError(w, "failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   h.ServerID,
+		Action:     auditWebhookDelete,
+		TargetType: auditTargetWebhook,
+		TargetID:   &h.ID,
+		Before:     map[string]any{"name": h.Name, "url": h.URL},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h := s.loadOutgoingWebhook(w, r, user.ID)
+	if h == nil {
+		return
+	}
+
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	hookRepo := &database.OutgoingWebhookRepo{DB: s.db}
+	deliveries, err := hookRepo.ListDeliveries(r.Context(), h.ID, limit)
+	if err != nil {
+		jsonError(w, "failed to list deliveries", http.StatusInternalServerError)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
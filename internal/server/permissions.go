@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+// memberPermissions returns the effective permission bits a user holds in a server.
+// The owner and anyone holding PermAdministrator get PermAll. Non-members get 0.
+func (s *Server) memberPermissions(ctx context.Context, srv *models.Server, userID uuid.UUID) (int64, error) {
+	if srv.OwnerID == userID {
+		return models.PermAll, nil
+	}
+
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	isMember, err := memberRepo.IsMember(ctx, userID, srv.ID)
+	if err != nil {
+		return 0, err
+	}
+	if !isMember {
+		return 0, nil
+	}
+
+	roleRepo := &database.RoleRepo{DB: s.db}
+	perms, err := roleRepo.GetMemberPermissions(ctx, userID, srv.ID)
+	if err != nil {
+		return 0, err
+	}
+	if perms&models.PermAdministrator != 0 {
+		return models.PermAll, nil
+	}
+	return perms, nil
+}
+
+// channelPermissionMask is the permissions that channel overwrites can
+// change; the rest only apply server-wide.
+const channelPermissionMask = models.PermManageMessages | models.PermMentionEveryone | models.PermManageWebhooks |
+	models.PermSendAnnouncements
+
+// channelPermissions returns the permission bits a user holds in a server
+// channel: their server permissions with the channel's overwrites, or its
+// category's, applied. Owners and administrators are not affected.
+func (s *Server) channelPermissions(ctx context.Context, srv *models.Server, ch *models.Channel, userID uuid.UUID) (int64, error) {
+	perms, err := s.memberPermissions(ctx, srv, userID)
+	if err != nil || perms == models.PermAll || perms == 0 {
+		return perms, err
+	}
+	overwriteRepo := &database.ChannelOverwriteRepo{DB: s.db}
+	allow, deny, err := overwriteRepo.MemberOverwrites(ctx, userID, ch)
+	if err != nil {
+		return 0, err
+	}
+	return perms&^(deny&channelPermissionMask) | allow&channelPermissionMask, nil
+}
+
+// requirePermission loads the server and checks the user holds perm in it.
+// On failure it writes the error response and returns nil.
+func (s *Server) requirePermission(w http.ResponseWriter, r *http.Request, serverID, userID uuid.UUID, perm int64) *models.Server {
+	return s.checkPermission(w, r, serverID, nil, userID, perm)
+}
+
+// requireChannelPermission is requirePermission for an action in a server
+// channel, where the channel's overwrites apply.
+func (s *Server) requireChannelPermission(w http.ResponseWriter, r *http.Request, ch *models.Channel, userID uuid.UUID, perm int64) *models.Server {
+	return s.checkPermission(w, r, *ch.ServerID, ch, userID, perm)
+}
+
+func (s *Server) checkPermission(w http.ResponseWriter, r *http.Request, serverID uuid.UUID, ch *models.Channel, userID uuid.UUID, perm int64) *models.Server {
+	serverRepo := &database.ServerRepo{DB: s.db}
+	srv, err := serverRepo.GetServerByID(r.Context(), serverID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "server not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		jsonError(w, "failed to get server", http.StatusInternalServerError)
+		return nil
+	}
+
+	var perms int64
+	if ch != nil {
+		perms, err = s.channelPermissions(r.Context(), srv, ch, userID)
+	} else {
+		perms, err = s.memberPermissions(r.Context(), srv, userID)
+	}
+	if err != nil {
+		jsonError(w, "failed to check permissions", http.StatusInternalServerError)
+		return nil
+	}
+	if perms&perm != perm {
+		jsonError(w, "missing permission", http.StatusForbidden)
+		return nil
+	}
+	return srv
+}
+
+// accessError is returned by checkSendAccess when a user may not post in a channel.
+type accessError struct {
+	status  int
+	message string
+}
+
+func (e *accessError) Error() string {
+	return e.message
+}
+
+// writeAccessError writes the response for an error returned by
+// checkSendAccess or checkSlowmode.
+func writeAccessError(w http.ResponseWriter, err error) {
+	if ae, ok := err.(*accessError); ok {
+		jsonError(w, ae.message, ae.status)
+		return
+	}
+	if se, ok := err.(*slowmodeError); ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(se.seconds()))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]any{"error": se.Error(), "retry_after": se.seconds()})
+		return
+	}
+	jsonError(w, "failed to check channel access", http.StatusInternalServerError)
+}
+
+// checkSendAccess verifies the user may post in the channel: they must be a
+// member of its server (or DM), must not be timed out, can't post in a
+// category, need PermSendAnnouncements in an announcement channel and must
+// have confirmed their age to post in an NSFW channel. In a 1:1 DM they must
+// have accepted any message request, must not be in a block with the other
+// side and must be allowed by their privacy settings. It is shared by the
+// REST and WebSocket send paths so both enforce the same rules.
+func (s *Server) checkSendAccess(ctx context.Context, userID uuid.UUID, ch *models.Channel) error {
+	if ch.ServerID == nil {
+		dmRepo := &database.DMMemberRepo{DB: s.db}
+		isMember, err := dmRepo.IsMember(ctx, ch.ID, userID)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return &accessError{http.StatusForbidden, "forbidden"}
+		}
+		if ch.Type == "dm" {
+			blockRepo := &database.BlockRepo{DB: s.db}
+			blocked, err := blockRepo.IsBlockedInDM(ctx, ch.ID, userID)
+			if err != nil {
+				return err
+			}
+			if blocked {
+				return &accessError{http.StatusForbidden, "you cannot message this user"}
+			}
+			pending, err := dmRepo.IsMessageRequest(ctx, ch.ID, userID)
+			if err != nil {
+				return err
+			}
+			if pending {
+				return &accessError{http.StatusForbidden, "accept this message request before replying"}
+			}
+			otherID, err := dmRepo.OtherMember(ctx, ch.ID, userID)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			if err == nil {
+				allowed, err := s.canMessage(ctx, userID, otherID)
+				if err != nil {
+					return err
+				}
+				if !allowed {
+					return &accessError{http.StatusForbidden, "this user is not accepting direct messages from you"}
+				}
+			}
+		}
+		return nil
+	}
+
+	if ch.Type == "category" {
+		return &accessError{http.StatusBadRequest, "categories cannot hold messages"}
+	}
+
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	until, err := memberRepo.GetTimeout(ctx, userID, *ch.ServerID)
+	if err == sql.ErrNoRows {
+		return &accessError{http.StatusForbidden, "forbidden"}
+	}
+	if err != nil {
+		return err
+	}
+	if until != nil && until.After(time.Now()) {
+		return &accessError{http.StatusForbidden, fmt.Sprintf("you are timed out until %s", until.UTC().Format(time.RFC3339))}
+	}
+	if ch.Type == "announcement" {
+		serverRepo := &database.ServerRepo{DB: s.db}
+		srv, err := serverRepo.GetServerByID(ctx, *ch.ServerID)
+		if err != nil {
+			return err
+		}
+		perms, err := s.channelPermissions(ctx, srv, ch, userID)
+		if err != nil {
+			return err
+		}
+		if perms&models.PermSendAnnouncements == 0 {
+			return &accessError{http.StatusForbidden, "you cannot post in this announcement channel"}
+		}
+	}
+	return s.checkNSFW(ctx, userID, ch)
+}
+
+// canViewChannel reports whether the user can read the channel: they must be
+// a member of its server, or of the DM, and have confirmed their age if the
+// channel is NSFW.
+func (s *Server) canViewChannel(ctx context.Context, userID uuid.UUID, ch *models.Channel) (bool, error) {
+	if ch.ServerID == nil {
+		dmRepo := &database.DMMemberRepo{DB: s.db}
+		return dmRepo.IsMember(ctx, ch.ID, userID)
+	}
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	isMember, err := memberRepo.IsMember(ctx, userID, *ch.ServerID)
+	if err != nil || !isMember {
+		return false, err
+	}
+	if err := s.checkNSFW(ctx, userID, ch); err != nil {
+		if err == errNSFWNotAcknowledged {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,394 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+)
+
+// Poll limits.
+const (
+	minPollOptions   = 2
+	maxPollOptions   = 10
+	maxPollQuestion  = 300
+	maxPollOption    = 100
+	maxPollDuration  = 30 * 24 * time.Hour
+	pollCloseBatch   = 20
+	pollResultsWidth = 10
+)
+
+// pollInput is a poll as submitted with a new message.
+type pollInput struct {
+	Question  string     `json:"question"`
+	Options   []string   `json:"options"`
+	Multiple  bool       `json:"multiple"`
+	Anonymous bool       `json:"anonymous"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// validate trims and checks a submitted poll. Its errors are safe to return
+// to the client.
+func (p *pollInput) validate() error {
+	p.Question = strings.TrimSpace(p.Question)
+	if p.Question == "" || utf8.RuneCountInString(p.Question) > maxPollQuestion {
+		return fmt.Errorf("poll question is required and must be %d characters or less", maxPollQuestion)
+	}
+	if len(p.Options) < minPollOptions || len(p.Options) > maxPollOptions {
+		return fmt.Errorf("a poll needs %d to %d options", minPollOptions, maxPollOptions)
+	}
+	seen := make(map[string]bool, len(p.Options))
+	for i, o := range p.Options {
+		o = strings.TrimSpace(o)
+		if o == "" || utf8.RuneCountInString(o) > maxPollOption {
+			return fmt.Errorf("poll options are required and must be %d characters or less", maxPollOption)
+		}
+		key := strings.ToLower(o)
+		if seen[key] {
+			return fmt.Errorf("duplicate poll option %q", o)
+		}
+		seen[key] = true
+		p.Options[i] = o
+	}
+	if p.ExpiresAt != nil {
+		d := time.Until(*p.ExpiresAt)
+		if d <= 0 {
+			return errors.New("poll expiry must be in the future")
+		}
+		if d > maxPollDuration {
+			return errors.New("polls can run for at most 30 days")
+		}
+	}
+	return nil
+}
+
+// attachPoll stores a validated poll on a new message and sets msg.Poll.
+func (s *Server) attachPoll(ctx context.Context, msg *models.Message, in *pollInput, createdBy uuid.UUID) error {
+	p := &models.Poll{
+		Question:  in.Question,
+		Options:   make([]models.PollOption, len(in.Options)),
+		Multiple:  in.Multiple,
+		Anonymous: in.Anonymous,
+		CreatedBy: createdBy,
+		ExpiresAt: in.ExpiresAt,
+	}
+	for i, o := range in.Options {
+		p.Options[i] = models.PollOption{Text: o}
+	}
+	pollRepo := &database.PollRepo{DB: s.db}
+	if err := pollRepo.Create(ctx, msg.ID, p); err != nil {
+		return err
+	}
+	msg.Poll = p
+	return nil
+}
+
+// broadcastPoll sends a poll's current tallies to the channel. Per-user
+// votes are left out; each client learns its own from its vote request.
+func (s *Server) broadcastPoll(ctx context.Context, channelID, messageID uuid.UUID) (*models.Poll, error) {
+	pollRepo := &database.PollRepo{DB: s.db}
+	p, err := pollRepo.Get(ctx, messageID, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+	out, err := json.Marshal(map[string]any{
+		"type":       "poll_update",
+		"message_id": messageID,
+		"channel_id": channelID,
+		"poll":       p,
+	})
+	if err == nil {
+		s.hub.BroadcastToChannel(channelID, out)
+	}
+	return p, nil
+}
+
+// finishPoll announces a poll that has just closed: the final tallies go out
+// as a poll_update, and the results are posted in the channel by the system
+// user.
+func (s *Server) finishPoll(ctx context.Context, messageID uuid.UUID) error {
+	msgRepo := &database.MessageRepo{DB: s.db}
+	msg, err := msgRepo.GetByID(ctx, messageID)
+	if err == sql.ErrNoRows {
+		// Deleted while open; nobody is left to tell.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	p, err := s.broadcastPoll(ctx, msg.ChannelID, messageID)
+	if err != nil {
+		return err
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(ctx, msg.ChannelID)
+	if err != nil {
+		return err
+	}
+	results := &models.Message{
+		ChannelID: ch.ID,
+		AuthorID:  database.SystemUserID,
+		Content:   pollResults(p),
+	}
+	return s.publishMessage(ctx, ch, results)
+}
+
+// pollResults formats a closed poll's results as a message.
+func pollResults(p *models.Poll) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📊 **Poll closed:** %s\n", p.Question)
+
+	total, best := 0, 0
+	for _, o := range p.Options {
+		total += o.Votes
+		best = max(best, o.Votes)
+	}
+	for _, o := range p.Options {
+		pct := 0
+		if total > 0 {
+			pct = o.Votes * 100 / total
+		}
+		bar := strings.Repeat("█", pct/pollResultsWidth) + strings.Repeat("░", pollResultsWidth-pct/pollResultsWidth)
+		noun := "votes"
+		if o.Votes == 1 {
+			noun = "vote"
+		}
+		fmt.Fprintf(&b, "`%s` **%s** — %d %s (%d%%)\n", bar, o.Text, o.Votes, noun, pct)
+	}
+
+	if total == 0 {
+		b.WriteString("No votes were cast.")
+		return b.String()
+	}
+	var winners []string
+	for _, o := range p.Options {
+		if o.Votes == best {
+			winners = append(winners, "**"+o.Text+"**")
+		}
+	}
+	if len(winners) == 1 {
+		fmt.Fprintf(&b, "Winner: %s", winners[0])
+	} else {
+		fmt.Fprintf(&b, "Tie between %s", strings.Join(winners, ", "))
+	}
+	return b.String()
+}
+
+// closeExpiredPolls closes and announces polls whose time is up. The
+// scheduler calls it on every tick.
+func (s *Server) closeExpiredPolls(ctx context.Context) {
+	pollRepo := &database.PollRepo{DB: s.db}
+	for {
+		ids, err := pollRepo.CloseExpired(ctx, pollCloseBatch)
+		if err != nil {
+			log.Printf("failed to close expired polls: %v", err)
+			return
+		}
+		for _, id := range ids {
+			if err := s.finishPoll(ctx, id); err != nil {
+				log.Printf("failed to announce results of poll %s: %v", id, err)
+			}
+		}
+		if len(ids) < pollCloseBatch {
+			return
+		}
+	}
+}
+
+// loadPollMessage parses {id} and loads the message, its channel and its
+// poll for the user, who must be able to post in the channel. On failure it
+// writes the error response and returns nils.
+func (s *Server) loadPollMessage(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (*models.Message, *models.Channel, *models.Poll) {
+	messageID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid message id", http.StatusBadRequest)
+		return nil, nil, nil
+	}
+
+	msgRepo := &database.MessageRepo{DB: s.db}
+	msg, err := msgRepo.GetByID(r.Context(), messageID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "message not found", http.StatusNotFound)
+		return nil, nil, nil
+	}
+	if err != nil {
+		jsonError(w, "failed to get message", http.StatusInternalServerError)
+		return nil, nil, nil
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(r.Context(), msg.ChannelID)
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return nil, nil, nil
+	}
+	if err := s.checkSendAccess(r.Context(), userID, ch); err != nil {
+		writeAccessError(w, err)
+		return nil, nil, nil
+	}
+
+	pollRepo := &database.PollRepo{DB: s.db}
+	p, err := pollRepo.Get(r.Context(), messageID, userID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "message has no poll", http.StatusNotFound)
+		return nil, nil, nil
+	}
+	if err != nil {
+		jsonError(w, "failed to get poll", http.StatusInternalServerError)
+		return nil, nil, nil
+	}
+	return msg, ch, p
+}
+
+// handleVotePoll sets the user's votes on a poll, replacing any earlier
+// ones. An empty list retracts them.
+func (s *Server) handleVotePoll(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	msg, _, p := s.loadPollMessage(w, r, user.ID)
+	if msg == nil {
+		return
+	}
+
+	var input struct {
+		OptionIDs []int `json:"option_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(input.OptionIDs) > 1 && !p.Multiple {
+		jsonError(w, "this poll allows only one choice", http.StatusBadRequest)
+		return
+	}
+	seen := make(map[int]bool, len(input.OptionIDs))
+	for _, id := range input.OptionIDs {
+		if id < 1 || id > len(p.Options) || seen[id] {
+			jsonError(w, "invalid option", http.StatusBadRequest)
+			return
+		}
+		seen[id] = true
+	}
+
+	pollRepo := &database.PollRepo{DB: s.db}
+	err := pollRepo.SetVotes(r.Context(), msg.ID, user.ID, input.OptionIDs)
+	if err == database.ErrPollClosed {
+		jsonError(w, "poll is closed", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to vote", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := s.broadcastPoll(r.Context(), msg.ChannelID, msg.ID); err != nil {
+		log.Printf("failed to broadcast poll %s: %v", msg.ID, err)
+	}
+	p, err = pollRepo.Get(r.Context(), msg.ID, user.ID)
+	if err != nil {
+		jsonError(w, "failed to get poll", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// handleClosePoll ends a poll early. Its creator can close it, as can
+// members with Manage Messages in server channels.
+func (s *Server) handleClosePoll(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	msg, ch, p := s.loadPollMessage(w, r, user.ID)
+	if msg == nil {
+		return
+	}
+	if p.CreatedBy != user.ID {
+		if ch.ServerID == nil {
+			jsonError(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if s.requireChannelPermission(w, r, ch, user.ID, models.PermManageMessages) == nil {
+			return
+		}
+	}
+
+	pollRepo := &database.PollRepo{DB: s.db}
+	if err := pollRepo.Close(r.Context(), msg.ID); err == sql.ErrNoRows {
+		jsonError(w, "poll is already closed", http.StatusConflict)
+		return
+	} else if err != nil {
+		jsonError(w, "failed to close poll", http.StatusInternalServerError)
+		return
+	}
+	if err := s.finishPoll(r.Context(), msg.ID); err != nil {
+		log.Printf("failed to announce results of poll %s: %v", msg.ID, err)
+	}
+
+	p, err := pollRepo.Get(r.Context(), msg.ID, user.ID)
+	if err != nil {
+		jsonError(w, "failed to get poll", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+var pollCommand = &builtinCommand{
+	SlashCommand: models.SlashCommand{
+		BotID:       database.SystemUserID,
+		Name:        "poll",
+		Description: "Start a poll",
+		Options: []models.CommandOption{
+			{Name: "question", Description: "What to ask", Type: "string", Required: true, MaxValue: floatPtr(maxPollQuestion)},
+			{Name: "choices", Description: "Choices separated by |, like \"Fri | Sat | Sun\"", Type: "string", Required: true, MaxValue: floatPtr(1100)},
+			{Name: "multiple", Description: "Allow picking more than one choice", Type: "boolean"},
+			{Name: "anonymous", Description: "Hide who voted for what", Type: "boolean"},
+			{Name: "duration", Description: "Close the poll after, like 1h or 2d", Type: "string", MaxValue: floatPtr(50)},
+		},
+		Builtin: true,
+	},
+	run: func(ctx context.Context, s *Server, inv *invocation) (*interactionResponse, error) {
+		question, _ := inv.Options["question"].(string)
+		choices, _ := inv.Options["choices"].(string)
+		in := &pollInput{
+			Question: question,
+			Options:  strings.Split(choices, "|"),
+		}
+		in.Multiple, _ = inv.Options["multiple"].(bool)
+		in.Anonymous, _ = inv.Options["anonymous"].(bool)
+		if duration, ok := inv.Options["duration"].(string); ok && duration != "" {
+			d, err := parseDelay(duration)
+			if err != nil {
+				return &interactionResponse{Type: responseEphemeral, Content: err.Error()}, nil
+			}
+			expires := time.Now().Add(d)
+			in.ExpiresAt = &expires
+		}
+		if err := in.validate(); err != nil {
+			return &interactionResponse{Type: responseEphemeral, Content: err.Error()}, nil
+		}
+		return &interactionResponse{Type: responseMessage, Poll: in}, nil
+	},
+}
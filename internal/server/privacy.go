@@ -0,0 +1,294 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+var validDMPolicies = map[string]bool{
+	"server_members": true,
+	"friends":        true,
+	"nobody":         true,
+}
+
+var validFriendRequestPolicies = map[string]bool{
+	"everyone":       true,
+	"mutual_servers": true,
+	"nobody":         true,
+}
+
+// canStartDM reports whether sender may open a new 1:1 DM with recipient
+// under the recipient's privacy settings, and whether it should land in the
+// recipient's message request inbox because the two are not friends.
+func (s *Server) canStartDM(ctx context.Context, sender, recipient uuid.UUID) (allowed, request bool, err error) {
+	privacyRepo := &database.PrivacyRepo{DB: s.db}
+	settings, err := privacyRepo.Get(ctx, recipient)
+	if err != nil {
+		return false, false, err
+	}
+	if settings.DMPolicy == "nobody" {
+		return false, false, nil
+	}
+
+	friends, err := s.areFriends(ctx, sender, recipient)
+	if err != nil {
+		return false, false, err
+	}
+	if friends {
+		return true, false, nil
+	}
+	if settings.DMPolicy != "server_members" {
+		return false, false, nil
+	}
+
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	shared, err := memberRepo.ShareServer(ctx, sender, recipient)
+	if err != nil {
+		return false, false, err
+	}
+	return shared, shared, nil
+}
+
+// canMessage reports whether sender may keep posting in an existing 1:1 DM
+// with other. Friends always can; otherwise other must still allow DMs from
+// server members and the two must still share a server.
+func (s *Server) canMessage(ctx context.Context, sender, other uuid.UUID) (bool, error) {
+	friends, err := s.areFriends(ctx, sender, other)
+	if err != nil {
+		return false, err
+	}
+	if friends {
+		return true, nil
+	}
+
+	privacyRepo := &database.PrivacyRepo{DB: s.db}
+	settings, err := privacyRepo.Get(ctx, other)
+	if err != nil {
+		return false, err
+	}
+	if settings.DMPolicy != "server_members" {
+		return false, nil
+	}
+
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	return memberRepo.ShareServer(ctx, sender, other)
+}
+
+// canSendFriendRequest reports whether target's privacy settings accept a
+// friend request from sender.
+func (s *Server) canSendFriendRequest(ctx context.Context, sender, target uuid.UUID) (bool, error) {
+	privacyRepo := &database.PrivacyRepo{DB: s.db}
+	settings, err := privacyRepo.Get(ctx, target)
+	if err != nil {
+		return false, err
+	}
+	switch settings.FriendRequestPolicy {
+	case "everyone":
+		return true, nil
+	case "mutual_servers":
+		memberRepo := &database.ServerMemberRepo{DB: s.db}
+		return memberRepo.ShareServer(ctx, sender, target)
+	default:
+		return false, nil
+	}
+}
+
+func (s *Server) handleGetPrivacy(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	privacyRepo := &database.PrivacyRepo{DB: s.db}
+	settings, err := privacyRepo.Get(r.Context(), user.ID)
+	if err != nil {
+		jsonError(w, "failed to get privacy settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (s *Server) handleUpdatePrivacy(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		DMPolicy            *string `json:"dm_policy"`
+		FriendRequestPolicy *string `json:"friend_request_policy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	privacyRepo := &database.PrivacyRepo{DB: s.db}
+	settings, err := privacyRepo.Get(r.Context(), user.ID)
+	if err != nil {
+		jsonError(w, "failed to get privacy settings", http.StatusInternalServerError)
+		return
+	}
+	if input.DMPolicy != nil {
+		if !validDMPolicies[*input.DMPolicy] {
+			jsonError(w, "dm_policy must be server_members, friends or nobody", http.StatusBadRequest)
+			return
+		}
+		settings.DMPolicy = *input.DMPolicy
+	}
+	if input.FriendRequestPolicy != nil {
+		if !validFriendRequestPolicies[*input.FriendRequestPolicy] {
+			jsonError(w, "friend_request_policy must be everyone, mutual_servers or nobody", http.StatusBadRequest)
+			return
+		}
+		settings.FriendRequestPolicy = *input.FriendRequestPolicy
+	}
+
+	if err := privacyRepo.Upsert(r.Context(), user.ID, settings); err != nil {
+		jsonError(w, "failed to update privacy settings", http.StatusInternalServerError)
+		return
+	}
+
+	s.sendToUsers([]uuid.UUID{user.ID}, map[string]any{
+		"type":    "privacy_update",
+		"privacy": settings,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (s *Server) handleListMessageRequests(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	dmRepo := &database.DMMemberRepo{DB: s.db}
+	channels, err := dmRepo.ListUserChannels(r.Context(), user.ID, true)
+	if err != nil {
+		jsonError(w, "failed to list message requests", http.StatusInternalServerError)
+		return
+	}
+	if channels == nil {
+		channels = []models.DMChannel{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channels)
+}
+
+// loadMessageRequest fetches the DM {id} and checks it is a pending message
+// request for the user. On failure it writes the error response and returns nil.
+func (s *Server) loadMessageRequest(w http.ResponseWriter, r *http.Request, userID uuid.UUID) *models.Channel {
+	channelID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid channel id", http.StatusBadRequest)
+		return nil
+	}
+
+	dmRepo := &database.DMMemberRepo{DB: s.db}
+	pending, err := dmRepo.IsMessageRequest(r.Context(), channelID, userID)
+	if err == sql.ErrNoRows || (err == nil && !pending) {
+		jsonError(w, "message request not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		jsonError(w, "failed to get message request", http.StatusInternalServerError)
+		return nil
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(r.Context(), channelID)
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return nil
+	}
+	return ch
+}
+
+// handleAcceptMessageRequest moves a message request into the user's DM list.
+func (s *Server) handleAcceptMessageRequest(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ch := s.loadMessageRequest(w, r, user.ID)
+	if ch == nil {
+		return
+	}
+
+	dmRepo := &database.DMMemberRepo{DB: s.db}
+	if err := dmRepo.SetMessageRequest(r.Context(), ch.ID, user.ID, false); err != nil {
+		jsonError(w, "failed to accept message request", http.StatusInternalServerError)
+		return
+	}
+
+	members, err := dmRepo.ListMembers(r.Context(), ch.ID)
+	if err != nil {
+		jsonError(w, "failed to list DM members", http.StatusInternalServerError)
+		return
+	}
+	dc := models.DMChannel{Channel: *ch, Recipients: members}
+
+	s.sendToUsers([]uuid.UUID{user.ID}, map[string]any{
+		"type":    "channel_create",
+		"channel": dc,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dc)
+}
+
+// handleDeclineMessageRequest deletes the DM along with its messages. The
+// sender may ask again later; blocking them is how to stop that.
+func (s *Server) handleDeclineMessageRequest(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ch := s.loadMessageRequest(w, r, user.ID)
+	if ch == nil {
+		return
+	}
+
+	dmRepo := &database.DMMemberRepo{DB: s.db}
+	members, err := dmRepo.ListMembers(r.Context(), ch.ID)
+	if err != nil {
+		jsonError(w, "failed to list DM members", http.StatusInternalServerError)
+		return
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	if err := channelRepo.DeleteChannel(r.Context(), ch.ID); err != nil && err != sql.ErrNoRows {
+		jsonError(w, "failed to decline message request", http.StatusInternalServerError)
+		return
+	}
+
+	ids := recipientIDs(members)
+	for _, id := range ids {
+		s.hub.UnsubscribeUser(id, []uuid.UUID{ch.ID})
+	}
+	s.sendToUsers(ids, map[string]string{
+		"type":       "channel_delete",
+		"channel_id": ch.ID.String(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
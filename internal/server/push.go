@@ -0,0 +1,287 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/markdown"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/Stocist/discard/internal/netguard"
+	"github.com/Stocist/discard/internal/push"
+	"github.com/google/uuid"
+)
+
+const (
+	// pushTTL is how long a push service holds a notification for an offline device.
+	pushTTL = 24 * time.Hour
+	// pushTimeout bounds delivery to all of one user's devices, retries included.
+	pushTimeout = 2 * time.Minute
+	// pushPreviewLength is how much message content a notification shows.
+	pushPreviewLength = 200
+)
+
+// newPushClient configures Web Push from VAPID_PRIVATE_KEY and VAPID_SUBJECT.
+// It returns nil, disabling push, if no key is set or the key is invalid.
+// Endpoints come from users, so delivery dials through guard rather than
+// letting a subscription aim the server's requests at its own network.
+func newPushClient(guard *netguard.Guard) *push.Client {
+	key := os.Getenv("VAPID_PRIVATE_KEY")
+	if key == "" {
+		return nil
+	}
+	subject := os.Getenv("VAPID_SUBJECT")
+	if subject == "" {
+		subject = "mailto:admin@localhost"
+	}
+	v, err := push.NewVAPID(key, subject)
+	if err != nil {
+		log.Printf("web push disabled: %v", err)
+		return nil
+	}
+	c := push.NewClient(v)
+	c.HTTP.Transport = guard.Transport()
+	return c
+}
+
+// checkPushEndpoint returns an error unless endpoint is an https URL on a
+// host push delivery may reach.
+func (s *Server) checkPushEndpoint(ctx context.Context, endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || u.Hostname() == "" || u.User != nil {
+		return errors.New("not an https URL")
+	}
+	return s.pushGuard.CheckHost(ctx, u.Hostname())
+}
+
+// pushPayload is the JSON the service worker receives.
+type pushPayload struct {
+	Type      string     `json:"type"`
+	Title     string     `json:"title"`
+	Body      string     `json:"body"`
+	ChannelID uuid.UUID  `json:"channel_id"`
+	ServerID  *uuid.UUID `json:"server_id,omitempty"`
+	MessageID uuid.UUID  `json:"message_id"`
+}
+
+func newPushPayload(ch *models.Channel, msg *models.Message, kind notifyKind) pushPayload {
+	title := msg.AuthorUsername
+	if msg.AuthorDisplayName != nil && *msg.AuthorDisplayName != "" {
+		title = *msg.AuthorDisplayName
+	}
+	if ch.ServerID != nil && ch.Name != nil {
+		title += " in #" + *ch.Name
+	}
+
+	body := markdown.Preview(messageNodes(msg))
+	if utf8.RuneCountInString(body) > pushPreviewLength {
+		body = string([]rune(body)[:pushPreviewLength]) + "…"
+	}
+	if strings.TrimSpace(body) == "" && len(msg.Attachments) > 0 {
+		body = "sent an attachment"
+	}
+
+	typ := "message"
+	if kind != notifyMessage {
+		typ = "mention"
+	}
+	return pushPayload{
+		Type:      typ,
+		Title:     title,
+		Body:      body,
+		ChannelID: ch.ID,
+		ServerID:  ch.ServerID,
+		MessageID: msg.ID,
+	}
+}
+
+// pushToUser delivers payload to every push subscription of a user, dropping
+// subscriptions that have expired, that the push service reports gone, or
+// whose endpoint is not a public address. It runs in the background so
+// message creation never waits on push services.
+func (s *Server) pushToUser(userID uuid.UUID, payload pushPayload) {
+	if s.push == nil {
+		return
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("push marshal error: %v", err)
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+		defer cancel()
+
+		subRepo := &database.PushSubscriptionRepo{DB: s.db}
+		subs, err := subRepo.ListByUser(ctx, userID)
+		if err != nil {
+			log.Printf("failed to list push subscriptions for %s: %v", userID, err)
+			return
+		}
+
+		now := time.Now()
+		for _, sub := range subs {
+			if sub.ExpiresAt != nil && sub.ExpiresAt.Before(now) {
+				if err := subRepo.DeleteByID(ctx, sub.ID); err != nil {
+					log.Printf("failed to delete expired push subscription %s: %v", sub.ID, err)
+				}
+				continue
+			}
+
+			err := s.push.Send(ctx, push.Subscription{
+				Endpoint: sub.Endpoint,
+				P256dh:   sub.P256dh,
+				Auth:     sub.Auth,
+			}, data, pushTTL)
+			switch {
+			case err == nil:
+				if err := subRepo.MarkUsed(ctx, sub.ID); err != nil {
+					log.Printf("failed to mark push subscription %s used: %v", sub.ID, err)
+				}
+			case err == push.ErrGone || errors.Is(err, netguard.ErrBlocked):
+				// A blocked endpoint was either saved before endpoints were
+				// checked or has since moved; it will never be delivered to.
+				if err := subRepo.DeleteByID(ctx, sub.ID); err != nil {
+					log.Printf("failed to delete gone push subscription %s: %v", sub.ID, err)
+				}
+			default:
+				log.Printf("push to subscription %s failed: %v", sub.ID, err)
+			}
+		}
+	}()
+}
+
+func (s *Server) handleGetVAPIDKey(w http.ResponseWriter, r *http.Request) {
+	if s.push == nil {
+		jsonError(w, "push notifications are not configured", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"public_key": s.push.VAPID.PublicKey})
+}
+
+func (s *Server) handleListPushSubscriptions(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	subRepo := &database.PushSubscriptionRepo{DB: s.db}
+	subs, err := subRepo.ListByUser(r.Context(), user.ID)
+	if err != nil {
+		jsonError(w, "failed to list push subscriptions", http.StatusInternalServerError)
+		return
+	}
+	if subs == nil {
+		subs = []models.PushSubscription{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subs)
+}
+
+// handleCreatePushSubscription registers the browser's PushSubscription,
+// accepting the shape of PushSubscription.toJSON().
+func (s *Server) handleCreatePushSubscription(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.push == nil {
+		jsonError(w, "push notifications are not configured", http.StatusNotFound)
+		return
+	}
+
+	var input struct {
+		Endpoint       string `json:"endpoint"`
+		ExpirationTime *int64 `json:"expirationTime"`
+		Keys           struct {
+			P256dh string `json:"p256dh"`
+			Auth   string `json:"auth"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := s.checkPushEndpoint(r.Context(), input.Endpoint); err != nil {
+		jsonError(w, "endpoint must be an https URL on a public host", http.StatusBadRequest)
+		return
+	}
+	if input.Keys.P256dh == "" || input.Keys.Auth == "" {
+		jsonError(w, "keys.p256dh and keys.auth are required", http.StatusBadRequest)
+		return
+	}
+	// Reject keys we could never encrypt to now rather than on first send.
+	if _, err := push.Encrypt(push.Subscription{P256dh: input.Keys.P256dh, Auth: input.Keys.Auth}, nil); err != nil {
+		jsonError(w, "invalid subscription keys", http.StatusBadRequest)
+		return
+	}
+
+	sub := &models.PushSubscription{
+		UserID:   user.ID,
+		Endpoint: input.Endpoint,
+		P256dh:   input.Keys.P256dh,
+		Auth:     input.Keys.Auth,
+	}
+	if ua := r.UserAgent(); ua != "" {
+		sub.UserAgent = &ua
+	}
+	if input.ExpirationTime != nil {
+		t := time.UnixMilli(*input.ExpirationTime)
+		sub.ExpiresAt = &t
+	}
+
+	subRepo := &database.PushSubscriptionRepo{DB: s.db}
+	if err := subRepo.Upsert(r.Context(), sub); err != nil {
+		jsonError(w, "failed to save push subscription", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sub)
+}
+
+func (s *Server) handleDeletePushSubscription(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid subscription id", http.StatusBadRequest)
+		return
+	}
+
+	subRepo := &database.PushSubscriptionRepo{DB: s.db}
+	err = subRepo.Delete(r.Context(), id, user.ID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "push subscription not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to delete push subscription", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
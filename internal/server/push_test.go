@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Stocist/discard/internal/netguard"
+)
+
+func TestCheckPushEndpoint(t *testing.T) {
+	s := &Server{pushGuard: netguard.New(nil)}
+	ctx := context.Background()
+	for _, endpoint := range []string{
+		"http://93.184.216.34/push",
+		"https://",
+		"https:///push",
+		"https://user@93.184.216.34/push",
+		"ftp://93.184.216.34/push",
+		"https://127.0.0.1/push",
+		"https://[::1]:8443/push",
+		"https://169.254.169.254/latest/meta-data/",
+		"https://10.0.0.5/push",
+		"https://100.101.102.103/push",
+		"https://localhost/push",
+		"not a url",
+	} {
+		if err := s.checkPushEndpoint(ctx, endpoint); err == nil {
+			t.Errorf("checkPushEndpoint(%q) accepted", endpoint)
+		}
+	}
+	if err := s.checkPushEndpoint(ctx, "https://93.184.216.34/push/abc"); err != nil {
+		t.Errorf("public endpoint refused: %v", err)
+	}
+}
+
+// TestPushClientGuard checks that delivery itself refuses non-public
+// addresses, for endpoints whose DNS changes after they were checked.
+func TestPushClientGuard(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := key.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VAPID_PRIVATE_KEY", base64.RawURLEncoding.EncodeToString(raw))
+	c := newPushClient(netguard.New(nil))
+	if c == nil {
+		t.Fatal("push not configured")
+	}
+
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer srv.Close()
+
+	resp, err := c.HTTP.Post(srv.URL, "application/octet-stream", nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if !errors.Is(err, netguard.ErrBlocked) {
+		t.Errorf("err = %v, want ErrBlocked", err)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("loopback push service was reached %d times", n)
+	}
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/google/uuid"
+)
+
+var roleColorRe = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+func (s *Server) handleListRoles(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	isMember, err := memberRepo.IsMember(r.Context(), user.ID, serverID)
+	if err != nil {
+		jsonError(w, "failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		jsonError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	roleRepo := &database.RoleRepo{DB: s.db}
+	roles, err := roleRepo.ListByServer(r.Context(), serverID)
+	if err != nil {
+		jsonError(w, "failed to list roles", http.StatusInternalServerError)
+		return
+	}
+	if roles == nil {
+		roles = []models.Role{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roles)
+}
+
+func (s *Server) handleCreateRole(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	srv := s.requirePermission(w, r, serverID, user.ID, models.PermManageRoles)
+	if srv == nil {
+		return
+	}
+
+	var input struct {
+		Name        string  `json:"name"`
+		Color       *string `json:"color"`
+		Permissions int64   `json:"permissions"`
+		Position    int     `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		jsonError(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if len(input.Name) > 64 {
+		jsonError(w, "role name must be 64 characters or less", http.StatusBadRequest)
+		return
+	}
+	if input.Color != nil && !roleColorRe.MatchString(*input.Color) {
+		jsonError(w, "color must be a hex value like #5865f2", http.StatusBadRequest)
+		return
+	}
+
+	// Nobody can hand out permissions they don't hold themselves.
+	perms, err := s.memberPermissions(r.Context(), srv, user.ID)
+	if err != nil {
+		jsonError(w, "failed to check permissions", http.StatusInternalServerError)
+		return
+	}
+	if input.Permissions&^perms != 0 {
+		jsonError(w, "cannot grant permissions you do not have", http.StatusForbidden)
+		return
+	}
+
+	role := &models.Role{
+		ServerID:    serverID,
+		Name:        input.Name,
+		Color:       input.Color,
+		Permissions: input.Permissions,
+		Position:    input.Position,
+	}
+	roleRepo := &database.RoleRepo{DB: s.db}
+	if err := roleRepo.Create(r.Context(), role); err != nil {
+		jsonError(w, "failed to create role", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   serverID,
+		Action:     auditRoleCreate,
+		TargetType: auditTargetRole,
+		TargetID:   &role.ID,
+		After:      role,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(role)
+}
+
+func (s *Server) handleDeleteRole(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+	roleID, err := uuid.Parse(r.PathValue("roleId"))
+	if err != nil {
+		jsonError(w, "invalid role id", http.StatusBadRequest)
+		return
+	}
+
+	srv := s.requirePermission(w, r, serverID, user.ID, models.PermManageRoles)
+	if srv == nil {
+		return
+	}
+
+	roleRepo := &database.RoleRepo{DB: s.db}
+	role, err := roleRepo.GetByID(r.Context(), roleID)
+	if err == sql.ErrNoRows || (err == nil && role.ServerID != serverID) {
+		jsonError(w, "role not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get role", http.StatusInternalServerError)
+		return
+	}
+
+	perms, err := s.memberPermissions(r.Context(), srv, user.ID)
+	if err != nil {
+		jsonError(w, "failed to check permissions", http.StatusInternalServerError)
+		return
+	}
+	if role.Permissions&^perms != 0 {
+		jsonError(w, "cannot manage a role with permissions you do not have", http.StatusForbidden)
+		return
+	}
+
+	if err := roleRepo.Delete(r.Context(), roleID); err != nil {
+		jsonError(w, "failed to delete role", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   serverID,
+		Action:     auditRoleDelete,
+		TargetType: auditTargetRole,
+		TargetID:   &roleID,
+		Before:     role,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAssignRole(w http.ResponseWriter, r *http.Request) {
+	s.changeMemberRole(w, r, true)
+}
+
+func (s *Server) handleUnassignRole(w http.ResponseWriter, r *http.Request) {
+	s.changeMemberRole(w, r, false)
+}
+
+// changeMemberRole adds or removes a role from a member and broadcasts member_update.
+func (s *Server) changeMemberRole(w http.ResponseWriter, r *http.Request, assign bool) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+	targetID, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		jsonError(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+	roleID, err := uuid.Parse(r.PathValue("roleId"))
+	if err != nil {
+		jsonError(w, "invalid role id", http.StatusBadRequest)
+		return
+	}
+
+	srv := s.requirePermission(w, r, serverID, user.ID, models.PermManageRoles)
+	if srv == nil {
+		return
+	}
+
+	roleRepo := &database.RoleRepo{DB: s.db}
+	role, err := roleRepo.GetByID(r.Context(), roleID)
+	if err == sql.ErrNoRows || (err == nil && role.ServerID != serverID) {
+		jsonError(w, "role not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get role", http.StatusInternalServerError)
+		return
+	}
+
+	perms, err := s.memberPermissions(r.Context(), srv, user.ID)
+	if err != nil {
+		jsonError(w, "failed to check permissions", http.StatusInternalServerError)
+		return
+	}
+	if role.Permissions&^perms != 0 {
+		jsonError(w, "cannot manage a role with permissions you do not have", http.StatusForbidden)
+		return
+	}
+
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	isMember, err := memberRepo.IsMember(r.Context(), targetID, serverID)
+	if err != nil {
+		jsonError(w, "failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		jsonError(w, "user is not a member", http.StatusNotFound)
+		return
+	}
+
+	if assign {
+		err = roleRepo.AssignRole(r.Context(), targetID, serverID, roleID)
+		if err == nil {
+			// Being given a role makes a temporary membership permanent.
+			err = memberRepo.ClearTemporary(r.Context(), targetID, serverID)
+		}
+	} else {
+		err = roleRepo.RemoveRole(r.Context(), targetID, serverID, roleID)
+	}
+	if err != nil {
+		jsonError(w, "failed to update member roles", http.StatusInternalServerError)
+		return
+	}
+
+	action := auditMemberRoleAdd
+	if !assign {
+		action = auditMemberRoleDel
+	}
+	s.audit(r, auditEvent{
+		ServerID:   serverID,
+		Action:     action,
+		TargetType: auditTargetUser,
+		TargetID:   &targetID,
+		After:      map[string]string{"role_id": roleID.String(), "role_name": role.Name},
+	})
+
+	out, err := json.Marshal(map[string]any{
+		"type":      "member_update",
+		"server_id": serverID.String(),
+		"user_id":   targetID.String(),
+		"role_id":   roleID.String(),
+		"assigned":  assign,
+	})
+	if err == nil {
+		s.hub.BroadcastAll(out)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,685 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+)
+
+// Scheduled job kinds.
+const (
+	jobMessage  = "message"
+	jobReminder = "reminder"
+)
+
+const (
+	// schedulerPollInterval is how often the scheduler looks for due jobs.
+	schedulerPollInterval = 10 * time.Second
+	// schedulerBatchSize is how many jobs the scheduler claims at once.
+	schedulerBatchSize = 20
+	// schedulerLease keeps a claimed job from being claimed again while it
+	// runs; a job that errors is retried once it runs out.
+	schedulerLease = time.Minute
+	// schedulerMaxAttempts is how many times a job is tried before it fails.
+	schedulerMaxAttempts = 5
+	// maxScheduledJobs caps how many pending jobs a user may have.
+	maxScheduledJobs = 100
+	// maxScheduleAhead is how far in the future a job may be scheduled.
+	maxScheduleAhead = 365 * 24 * time.Hour
+	// maxReminderNote caps the length of a reminder's note.
+	maxReminderNote = 2000
+)
+
+// RunScheduler posts scheduled messages, sends reminders and closes polls
+// when they are due. It should be called in its own goroutine. Jobs live in
+// Postgres, so they survive restarts, and claiming them locks their rows, so
+// several instances can share them.
+func (s *Server) RunScheduler() {
+	ticker := time.NewTicker(schedulerPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx := context.Background()
+		jobRepo := &database.ScheduledJobRepo{DB: s.db}
+
+		s.closeExpiredPolls(ctx)
+
+		for {
+			due, err := jobRepo.ClaimDue(ctx, schedulerBatchSize, schedulerLease)
+			if err != nil {
+				log.Printf("failed to claim scheduled jobs: %v", err)
+				break
+			}
+			for i := range due {
+				s.runJob(ctx, &due[i])
+			}
+			if len(due) < schedulerBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// runJob runs a claimed job and records the outcome. A job that can never
+// run, such as a message to a channel its author has left, fails at once;
+// other errors are retried.
+func (s *Server) runJob(ctx context.Context, j *models.ScheduledJob) {
+	jobRepo := &database.ScheduledJobRepo{DB: s.db}
+
+	var err error
+	switch j.Kind {
+	case jobMessage:
+		err = s.postScheduledMessage(ctx, j)
+	case jobReminder:
+		err = s.sendReminder(ctx, j)
+	default:
+		err = &accessError{http.StatusBadRequest, fmt.Sprintf("unknown job kind %q", j.Kind)}
+	}
+
+	var ae *accessError
+	switch {
+	case err == nil:
+		if err := jobRepo.Finish(ctx, j.ID); err != nil {
+			log.Printf("failed to finish scheduled job %s: %v", j.ID, err)
+		}
+	case errors.As(err, &ae) || j.Attempts >= schedulerMaxAttempts:
+		if err := jobRepo.MarkFailed(ctx, j.ID, err.Error()); err != nil {
+			log.Printf("failed to mark scheduled job %s failed: %v", j.ID, err)
+		}
+		s.sendToUsers([]uuid.UUID{j.UserID}, map[string]any{
+			"type":   "scheduled_job_failed",
+			"job_id": j.ID,
+			"error":  err.Error(),
+		})
+	default:
+		log.Printf("scheduled job %s failed (attempt %d): %v", j.ID, j.Attempts, err)
+		if err := jobRepo.MarkRetry(ctx, j.ID, err.Error()); err != nil {
+			log.Printf("failed to record scheduled job %s error: %v", j.ID, err)
+		}
+	}
+}
+
+// postScheduledMessage posts a scheduled message as its author, who must
+// still be allowed to post in the channel.
+func (s *Server) postScheduledMessage(ctx context.Context, j *models.ScheduledJob) error {
+	if j.ChannelID == nil {
+		return &accessError{http.StatusNotFound, "channel not found"}
+	}
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(ctx, *j.ChannelID)
+	if err == sql.ErrNoRows {
+		return &accessError{http.StatusNotFound, "channel not found"}
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.checkSendAccess(ctx, j.UserID, ch); err != nil {
+		return err
+	}
+
+	msg := &models.Message{
+		ChannelID: ch.ID,
+		AuthorID:  j.UserID,
+		Content:   j.Content,
+	}
+	return s.publishMessage(ctx, ch, msg)
+}
+
+// sendReminder sends a reminder to its owner as a DM from the system user,
+// quoting the message it is about if they can still see it.
+func (s *Server) sendReminder(ctx context.Context, j *models.ScheduledJob) error {
+	var b strings.Builder
+	b.WriteString("⏰ **Reminder**")
+	if j.Content != "" {
+		b.WriteString(": ")
+		b.WriteString(j.Content)
+	}
+
+	if j.MessageID != nil {
+		quoted, err := s.reminderQuote(ctx, j.UserID, *j.MessageID)
+		if err != nil {
+			return err
+		}
+		if quoted != "" {
+			b.WriteString("\n")
+			b.WriteString(quoted)
+		} else {
+			b.WriteString("\n*The message this reminder was about is gone.*")
+		}
+	}
+
+	ch, err := s.systemDM(ctx, j.UserID)
+	if err != nil {
+		return err
+	}
+	msg := &models.Message{
+		ChannelID: ch.ID,
+		AuthorID:  database.SystemUserID,
+		Content:   b.String(),
+	}
+	return s.publishMessage(ctx, ch, msg)
+}
+
+// reminderQuote returns a quote of the message a reminder is about, or ""
+// if it has been deleted or the user can no longer see it.
+func (s *Server) reminderQuote(ctx context.Context, userID, messageID uuid.UUID) (string, error) {
+	msgRepo := &database.MessageRepo{DB: s.db}
+	msg, err := msgRepo.GetByID(ctx, messageID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(ctx, msg.ChannelID)
+	if err != nil {
+		return "", err
+	}
+	ok, err := s.canViewChannel(ctx, userID, ch)
+	if err != nil || !ok {
+		return "", err
+	}
+
+	excerpt := msg.Content
+	if r := []rune(excerpt); len(r) > 300 {
+		excerpt = string(r[:300]) + "…"
+	}
+	var b strings.Builder
+	for _, line := range strings.Split(excerpt, "\n") {
+		b.WriteString("> ")
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	author := msg.AuthorUsername
+	if msg.AuthorDisplayName != nil {
+		author = *msg.AuthorDisplayName
+	}
+	where := "a DM"
+	if ch.Name != nil {
+		where = "#" + *ch.Name
+	}
+	fmt.Fprintf(&b, "— %s in %s", author, where)
+	return b.String(), nil
+}
+
+// systemDM returns the user's DM with the system user, creating it the
+// first time a reminder is sent.
+func (s *Server) systemDM(ctx context.Context, userID uuid.UUID) (*models.Channel, error) {
+	dmRepo := &database.DMMemberRepo{DB: s.db}
+	id, created, err := dmRepo.GetOrCreateSystemDM(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return ch, nil
+	}
+
+	members, err := dmRepo.ListMembers(ctx, ch.ID)
+	if err != nil {
+		return nil, err
+	}
+	s.sendToUsers([]uuid.UUID{userID}, map[string]any{
+		"type":    "channel_create",
+		"channel": models.DMChannel{Channel: *ch, Recipients: members},
+	})
+	return ch, nil
+}
+
+// publishMessage saves a message posted by the server on someone's behalf
+// and delivers it like one sent over REST.
+func (s *Server) publishMessage(ctx context.Context, ch *models.Channel, msg *models.Message) error {
+	msgRepo := &database.MessageRepo{DB: s.db}
+	if err := msgRepo.Create(ctx, msg); err != nil {
+		return err
+	}
+	s.announceMessage(ctx, ch, msg)
+	return nil
+}
+
+// announceMessage delivers a saved message: notifications, webhooks, link
+// previews and the broadcast to the channel.
+func (s *Server) announceMessage(ctx context.Context, ch *models.Channel, msg *models.Message) {
+	if err := s.deliverNotifications(ctx, ch, msg); err != nil {
+		log.Printf("failed to deliver notifications for message %s: %v", msg.ID, err)
+	}
+	s.emitChannelWebhookEvent(ctx, ch, hookMessageCreate, msg)
+	s.unfurlAsync(msg)
+
+	out, err := json.Marshal(map[string]any{
+		"type":    "message",
+		"message": msg,
+	})
+	if err == nil {
+		s.hub.BroadcastToChannel(ch.ID, out)
+	}
+}
+
+// checkRunAt validates when a job should run. Its errors are safe to return
+// to the client.
+func checkRunAt(t time.Time) error {
+	now := time.Now()
+	if !t.After(now) {
+		return errors.New("time must be in the future")
+	}
+	if t.Sub(now) > maxScheduleAhead {
+		return errors.New("time must be within a year")
+	}
+	return nil
+}
+
+// createJob stores a new job after checking the user's quota. It writes the
+// error response and returns false on failure.
+func (s *Server) createJob(w http.ResponseWriter, r *http.Request, j *models.ScheduledJob) bool {
+	jobRepo := &database.ScheduledJobRepo{DB: s.db}
+	n, err := jobRepo.CountPending(r.Context(), j.UserID)
+	if err != nil {
+		jsonError(w, "failed to count scheduled jobs", http.StatusInternalServerError)
+		return false
+	}
+	if n >= maxScheduledJobs {
+		jsonError(w, "too many scheduled messages and reminders (max 100)", http.StatusBadRequest)
+		return false
+	}
+	if err := jobRepo.Create(r.Context(), j); err != nil {
+		jsonError(w, "failed to schedule", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleScheduleMessage(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channelID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid channel id", http.StatusBadRequest)
+		return
+	}
+
+	channelRepo := &database.ChannelRepo{DB: s.db}
+	ch, err := channelRepo.GetChannelByID(r.Context(), channelID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get channel", http.StatusInternalServerError)
+		return
+	}
+	// Access is checked again when the message is posted.
+	if err := s.checkSendAccess(r.Context(), user.ID, ch); err != nil {
+		writeAccessError(w, err)
+		return
+	}
+
+	var input struct {
+		Content string    `json:"content"`
+		SendAt  time.Time `json:"send_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	input.Content = strings.TrimSpace(input.Content)
+	if input.Content == "" {
+		jsonError(w, "content is required", http.StatusBadRequest)
+		return
+	}
+	if len(input.Content) > 4000 {
+		jsonError(w, "message content must be 4000 characters or less", http.StatusBadRequest)
+		return
+	}
+	if err := checkRunAt(input.SendAt); err != nil {
+		jsonError(w, "send_at: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j := &models.ScheduledJob{
+		Kind:      jobMessage,
+		UserID:    user.ID,
+		ChannelID: &ch.ID,
+		Content:   input.Content,
+		RunAt:     input.SendAt,
+	}
+	if !s.createJob(w, r, j) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(j)
+}
+
+func (s *Server) handleCreateReminder(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		Content   string     `json:"content"`
+		MessageID *uuid.UUID `json:"message_id"`
+		RemindAt  time.Time  `json:"remind_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	input.Content = strings.TrimSpace(input.Content)
+	if input.Content == "" && input.MessageID == nil {
+		jsonError(w, "content or message_id is required", http.StatusBadRequest)
+		return
+	}
+	if len(input.Content) > maxReminderNote {
+		jsonError(w, "reminder must be 2000 characters or less", http.StatusBadRequest)
+		return
+	}
+	if err := checkRunAt(input.RemindAt); err != nil {
+		jsonError(w, "remind_at: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j := &models.ScheduledJob{
+		Kind:      jobReminder,
+		UserID:    user.ID,
+		MessageID: input.MessageID,
+		Content:   input.Content,
+		RunAt:     input.RemindAt,
+	}
+	if input.MessageID != nil {
+		msgRepo := &database.MessageRepo{DB: s.db}
+		msg, err := msgRepo.GetByID(r.Context(), *input.MessageID)
+		if err == sql.ErrNoRows {
+			jsonError(w, "message not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			jsonError(w, "failed to get message", http.StatusInternalServerError)
+			return
+		}
+		channelRepo := &database.ChannelRepo{DB: s.db}
+		ch, err := channelRepo.GetChannelByID(r.Context(), msg.ChannelID)
+		if err != nil {
+			jsonError(w, "failed to get channel", http.StatusInternalServerError)
+			return
+		}
+		ok, err := s.canViewChannel(r.Context(), user.ID, ch)
+		if err != nil {
+			jsonError(w, "failed to check membership", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			jsonError(w, "message not found", http.StatusNotFound)
+			return
+		}
+		j.ChannelID = &ch.ID
+	}
+	if !s.createJob(w, r, j) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(j)
+}
+
+func (s *Server) handleListScheduledJobs(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && kind != jobMessage && kind != jobReminder {
+		jsonError(w, "kind must be message or reminder", http.StatusBadRequest)
+		return
+	}
+
+	jobRepo := &database.ScheduledJobRepo{DB: s.db}
+	jobs, err := jobRepo.ListByUser(r.Context(), user.ID, kind)
+	if err != nil {
+		jsonError(w, "failed to list scheduled jobs", http.StatusInternalServerError)
+		return
+	}
+	if jobs == nil {
+		jobs = []models.ScheduledJob{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+func (s *Server) handleUpdateScheduledJob(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	jobID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	jobRepo := &database.ScheduledJobRepo{DB: s.db}
+	j, err := jobRepo.Get(r.Context(), jobID, user.ID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "scheduled job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get scheduled job", http.StatusInternalServerError)
+		return
+	}
+
+	var input struct {
+		Content *string    `json:"content"`
+		RunAt   *time.Time `json:"run_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	content, runAt := j.Content, j.RunAt
+	if input.Content != nil {
+		content = strings.TrimSpace(*input.Content)
+		limit := 4000
+		if j.Kind == jobReminder {
+			limit = maxReminderNote
+		}
+		if content == "" && (j.Kind == jobMessage || j.MessageID == nil) {
+			jsonError(w, "content is required", http.StatusBadRequest)
+			return
+		}
+		if len(content) > limit {
+			jsonError(w, "content must be "+strconv.Itoa(limit)+" characters or less", http.StatusBadRequest)
+			return
+		}
+	}
+	if input.RunAt != nil {
+		if err := checkRunAt(*input.RunAt); err != nil {
+			jsonError(w, "run_at: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		runAt = *input.RunAt
+	}
+
+	updated, err := jobRepo.Update(r.Context(), jobID, user.ID, content, runAt)
+	if err == sql.ErrNoRows {
+		jsonError(w, "scheduled job is running or gone", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to update scheduled job", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+func (s *Server) handleCancelScheduledJob(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	jobID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	jobRepo := &database.ScheduledJobRepo{DB: s.db}
+	if err := jobRepo.Delete(r.Context(), jobID, user.ID); err == sql.ErrNoRows {
+		jsonError(w, "scheduled job not found or running", http.StatusNotFound)
+		return
+	} else if err != nil {
+		jsonError(w, "failed to cancel scheduled job", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// delayPartRe matches one "<number><unit>" part of a delay like "1h30m" or
+// "2 days".
+var delayPartRe = regexp.MustCompile(`^(\d+)\s*(weeks?|w|days?|d|hours?|hrs?|h|minutes?|mins?|m)`)
+
+var delayUnits = map[byte]time.Duration{
+	'w': 7 * 24 * time.Hour,
+	'd': 24 * time.Hour,
+	'h': time.Hour,
+	'm': time.Minute,
+}
+
+// parseDelay parses a human delay such as "in 3 hours", "90m" or
+// "1d 12h".
+func parseDelay(text string) (time.Duration, error) {
+	rest := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(text)), "in ")
+	var total time.Duration
+	for {
+		rest = strings.TrimLeft(rest, " ,")
+		rest = strings.TrimPrefix(rest, "and ")
+		if rest == "" {
+			break
+		}
+		m := delayPartRe.FindStringSubmatch(rest)
+		if m == nil {
+			return 0, fmt.Errorf("can't understand %q; try something like 30m, 3h or 2d", text)
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n > 1000 {
+			return 0, fmt.Errorf("%s is too long", m[0])
+		}
+		total += time.Duration(n) * delayUnits[m[2][0]]
+		rest = rest[len(m[0]):]
+	}
+	if total <= 0 {
+		return 0, errors.New("say when, like 30m, 3h or 2d")
+	}
+	return total, nil
+}
+
+// formatDelay describes a delay in the largest two units, e.g. "1 day 3 hours".
+func formatDelay(d time.Duration) string {
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"week", 7 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+	var parts []string
+	for _, u := range units {
+		if n := d / u.size; n > 0 {
+			part := fmt.Sprintf("%d %s", n, u.name)
+			if n > 1 {
+				part += "s"
+			}
+			parts = append(parts, part)
+			d -= n * u.size
+		}
+		if len(parts) == 2 {
+			break
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+var remindCommand = &builtinCommand{
+	SlashCommand: models.SlashCommand{
+		BotID:       database.SystemUserID,
+		Name:        "remind",
+		Description: "Remind yourself about something later",
+		Options: []models.CommandOption{
+			{Name: "when", Description: "How long from now, like 30m, 3h or 2d", Type: "string", Required: true, MaxValue: floatPtr(50)},
+			{Name: "what", Description: "What to remind you about", Type: "string", Required: true, MaxValue: floatPtr(maxReminderNote)},
+		},
+		Builtin: true,
+	},
+	run: func(ctx context.Context, s *Server, inv *invocation) (*interactionResponse, error) {
+		when, _ := inv.Options["when"].(string)
+		what, _ := inv.Options["what"].(string)
+		delay, err := parseDelay(when)
+		if err != nil {
+			return &interactionResponse{Type: responseEphemeral, Content: err.Error()}, nil
+		}
+		runAt := time.Now().Add(delay)
+		if err := checkRunAt(runAt); err != nil {
+			return &interactionResponse{Type: responseEphemeral, Content: "Reminder " + err.Error() + "."}, nil
+		}
+
+		jobRepo := &database.ScheduledJobRepo{DB: s.db}
+		n, err := jobRepo.CountPending(ctx, inv.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if n >= maxScheduledJobs {
+			return &interactionResponse{Type: responseEphemeral, Content: "You have too many reminders and scheduled messages already."}, nil
+		}
+		j := &models.ScheduledJob{
+			Kind:    jobReminder,
+			UserID:  inv.UserID,
+			Content: strings.TrimSpace(what),
+			RunAt:   runAt,
+		}
+		if err := jobRepo.Create(ctx, j); err != nil {
+			return nil, err
+		}
+		return &interactionResponse{
+			Type:    responseEphemeral,
+			Content: fmt.Sprintf("Okay, I'll remind you in %s.", formatDelay(delay)),
+		}, nil
+	},
+}
@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
+	"strings"
 
 	"github.com/google/uuid"
 	gorillaWs "github.com/gorilla/websocket"
@@ -14,6 +16,9 @@ import (
 	"github.com/Stocist/discard/internal/auth"
 	"github.com/Stocist/discard/internal/database"
 	"github.com/Stocist/discard/internal/models"
+	"github.com/Stocist/discard/internal/netguard"
+	"github.com/Stocist/discard/internal/push"
+	"github.com/Stocist/discard/internal/unfurl"
 	ws "github.com/Stocist/discard/internal/websocket"
 )
 
@@ -28,6 +33,18 @@ type Server struct {
 	hub       *ws.Hub
 	router    *http.ServeMux
 	uploadDir string
+	push      *push.Client
+	pushGuard *netguard.Guard
+
+	webhookWake chan struct{}
+
+	callbackGuard *netguard.Guard
+	callbackHTTP  *http.Client
+
+	crosspostWake chan struct{}
+
+	unfurler  *unfurl.Client
+	unfurlSem chan struct{}
 }
 
 func NewServer(db *sql.DB, hub *ws.Hub) *Server {
@@ -35,12 +52,48 @@ func NewServer(db *sql.DB, hub *ws.Hub) *Server {
 	if uploadDir == "" {
 		uploadDir = "./uploads"
 	}
-	return &Server{
+	pushGuard := netguard.New(allowedNetworks("PUSH_ALLOWED_NETWORKS"))
+	callbackGuard := netguard.New(allowedNetworks("WEBHOOK_ALLOWED_NETWORKS"))
+	s := &Server{
 		db:        db,
 		hub:       hub,
 		router:    http.NewServeMux(),
 		uploadDir: uploadDir,
+		push:      newPushClient(pushGuard),
+		pushGuard: pushGuard,
+
+		webhookWake: make(chan struct{}, 1),
+
+		callbackGuard: callbackGuard,
+		callbackHTTP:  newCallbackClient(callbackGuard),
+
+		crosspostWake: make(chan struct{}, 1),
+
+		unfurler:  newUnfurler(),
+		unfurlSem: make(chan struct{}, maxConcurrentUnfurls),
+	}
+	hub.OnOffline(s.dropTemporaryMemberships)
+	return s
+}
+
+// allowedNetworks reads the comma-separated CIDR ranges in the environment
+// variable name: private networks that a guarded client may reach anyway.
+// Invalid entries are logged and skipped.
+func allowedNetworks(name string) []netip.Prefix {
+	var allow []netip.Prefix
+	for _, entry := range strings.Split(os.Getenv(name), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(entry)
+		if err != nil {
+			log.Printf("ignoring %s entry %q: %v", name, entry, err)
+			continue
+		}
+		allow = append(allow, p)
 	}
+	return allow
 }
 
 func (s *Server) Router() *http.ServeMux {
@@ -55,10 +108,36 @@ func (s *Server) SetupRoutes() {
 
 	// Public
 	s.router.HandleFunc("GET /api/health", s.handleHealth)
+	s.router.HandleFunc("GET /api/push/vapid-key", s.handleGetVAPIDKey)
+	s.router.HandleFunc("POST /api/webhooks/{webhookId}/{token}", s.handleExecuteWebhook)
+	s.router.HandleFunc("POST /api/webhooks/{webhookId}/{token}/discord", s.handleExecuteDiscordWebhook)
+	s.router.HandleFunc("POST /api/interactions/{id}/{token}/callback", s.handleInteractionCallback)
+	s.router.HandleFunc("POST /api/interactions/{id}/{token}/followup", s.handleInteractionFollowup)
 
 	// Me
 	a("GET /api/me", s.handleMe)
 	a("PUT /api/me", s.handleUpdateMe)
+	a("POST /api/me/nsfw-acknowledgement", s.handleAcknowledgeNSFW)
+	a("GET /api/me/channels", s.handleListMyChannels)
+	a("POST /api/me/channels", s.handleCreateDM)
+	a("GET /api/me/privacy", s.handleGetPrivacy)
+	a("PUT /api/me/privacy", s.handleUpdatePrivacy)
+	a("GET /api/me/message-requests", s.handleListMessageRequests)
+	a("POST /api/me/message-requests/{id}/accept", s.handleAcceptMessageRequest)
+	a("DELETE /api/me/message-requests/{id}", s.handleDeclineMessageRequest)
+	a("GET /api/me/mentions", s.handleListMentions)
+	a("GET /api/me/notification-settings", s.handleGetNotificationSettings)
+	a("PUT /api/me/notification-settings", s.handleUpdateNotificationSettings)
+	a("PUT /api/me/notification-settings/servers/{id}", s.handleSetServerNotifications)
+	a("DELETE /api/me/notification-settings/servers/{id}", s.handleResetNotifications)
+	a("PUT /api/me/notification-settings/channels/{id}", s.handleSetChannelNotifications)
+	a("DELETE /api/me/notification-settings/channels/{id}", s.handleResetNotifications)
+	a("GET /api/me/push-subscriptions", s.handleListPushSubscriptions)
+	a("POST /api/me/push-subscriptions", s.handleCreatePushSubscription)
+	a("DELETE /api/me/push-subscriptions/{id}", s.handleDeletePushSubscription)
+	a("GET /api/me/gifs", s.handleListFavoriteGIFs)
+	a("POST /api/me/gifs", s.handleSaveFavoriteGIF)
+	a("DELETE /api/me/gifs/{id}", s.handleDeleteFavoriteGIF)
 
 	// Servers
 	a("POST /api/servers", s.handleCreateServer)
@@ -73,21 +152,112 @@ func (s *Server) SetupRoutes() {
 	a("GET /api/servers/{id}/channels", s.handleListChannels)
 	a("PUT /api/servers/{id}/channels/{channelId}", s.handleUpdateChannel)
 	a("DELETE /api/servers/{id}/channels/{channelId}", s.handleDeleteChannel)
+	a("PATCH /api/servers/{id}/channels/positions", s.handleSetChannelPositions)
+	a("GET /api/channels/{id}/overwrites", s.handleListChannelOverwrites)
+	a("PUT /api/channels/{id}/overwrites/{roleId}", s.handleSetChannelOverwrite)
+	a("DELETE /api/channels/{id}/overwrites/{roleId}", s.handleDeleteChannelOverwrite)
 
 	// Members
 	a("GET /api/servers/{id}/members", s.handleListMembers)
 	a("DELETE /api/servers/{id}/members/me", s.handleLeaveServer)
 
+	// Roles
+	a("GET /api/servers/{id}/roles", s.handleListRoles)
+	a("POST /api/servers/{id}/roles", s.handleCreateRole)
+	a("DELETE /api/servers/{id}/roles/{roleId}", s.handleDeleteRole)
+	a("PUT /api/servers/{id}/members/{userId}/roles/{roleId}", s.handleAssignRole)
+	a("DELETE /api/servers/{id}/members/{userId}/roles/{roleId}", s.handleUnassignRole)
+
+	// Moderation
+	a("DELETE /api/servers/{id}/members/{userId}", s.handleKickMember)
+	a("PUT /api/servers/{id}/members/{userId}/timeout", s.handleTimeoutMember)
+	a("DELETE /api/servers/{id}/members/{userId}/timeout", s.handleClearTimeout)
+	a("GET /api/servers/{id}/bans", s.handleListBans)
+	a("PUT /api/servers/{id}/bans/{userId}", s.handleBanMember)
+	a("DELETE /api/servers/{id}/bans/{userId}", s.handleUnbanMember)
+
+	// Invites
+	a("GET /api/servers/{id}/invites", s.handleListInvites)
+	a("POST /api/servers/{id}/invites", s.handleCreateInvite)
+	a("GET /api/invites/{code}", s.handlePreviewInvite)
+	a("DELETE /api/invites/{code}", s.handleRevokeInvite)
+
+	// Stickers
+	a("GET /api/servers/{id}/stickers", s.handleListStickers)
+	a("POST /api/servers/{id}/stickers", s.handleCreateSticker)
+	a("PUT /api/servers/{id}/stickers/{stickerId}", s.handleUpdateSticker)
+	a("DELETE /api/servers/{id}/stickers/{stickerId}", s.handleDeleteSticker)
+
+	// Audit log
+	a("GET /api/servers/{id}/audit-log", s.handleListAuditLog)
+
+	// Outgoing webhooks
+	a("GET /api/servers/{id}/outgoing-webhooks", s.handleListOutgoingWebhooks)
+	a("POST /api/servers/{id}/outgoing-webhooks", s.handleCreateOutgoingWebhook)
+	a("PUT /api/servers/{id}/outgoing-webhooks/{webhookId}", s.handleUpdateOutgoingWebhook)
+	a("DELETE /api/servers/{id}/outgoing-webhooks/{webhookId}", s.handleDeleteOutgoingWebhook)
+	a("GET /api/servers/{id}/outgoing-webhooks/{webhookId}/deliveries", s.handleListWebhookDeliveries)
+
+	// Incoming webhooks
+	a("GET /api/channels/{id}/webhooks", s.handleListIncomingWebhooks)
+	a("POST /api/channels/{id}/webhooks", s.handleCreateIncomingWebhook)
+	a("PUT /api/channels/{id}/webhooks/{webhookId}", s.handleUpdateIncomingWebhook)
+	a("DELETE /api/channels/{id}/webhooks/{webhookId}", s.handleDeleteIncomingWebhook)
+	a("POST /api/channels/{id}/followers", s.handleFollowChannel)
+	a("DELETE /api/channels/{id}/followers/{channelId}", s.handleUnfollowChannel)
+
+	// Bots and slash commands
+	a("GET /api/bots", s.handleListBots)
+	a("POST /api/bots", s.handleCreateBot)
+	a("PUT /api/bots/{id}", s.handleUpdateBot)
+	a("DELETE /api/bots/{id}", s.handleDeleteBot)
+	a("PUT /api/servers/{id}/bots/{botId}", s.handleAddBotToServer)
+	a("GET /api/servers/{id}/commands", s.handleListCommands)
+	a("PUT /api/servers/{id}/commands", s.handleRegisterCommands)
+	a("POST /api/channels/{id}/interactions", s.handleInvokeCommand)
+
 	// Friends
 	a("POST /api/friends/requests", s.handleSendFriendRequest)
+	a("GET /api/friends/requests", s.handleListFriendRequests)
 	a("POST /api/friends/requests/{id}/accept", s.handleAcceptFriend)
+	a("POST /api/friends/requests/{id}/decline", s.handleDeclineFriend)
+	a("DELETE /api/friends/requests/{id}", s.handleCancelFriendRequest)
 	a("GET /api/friends", s.handleListFriends)
+	a("DELETE /api/friends/{userId}", s.handleRemoveFriend)
+
+	// Blocks
+	a("GET /api/blocks", s.handleListBlocks)
+	a("PUT /api/blocks/{userId}", s.handleBlockUser)
+	a("DELETE /api/blocks/{userId}", s.handleUnblockUser)
 
 	// Messages
 	a("GET /api/channels/{id}/messages", s.handleListMessages)
 	a("POST /api/channels/{id}/messages", s.handleCreateMessage)
+	a("POST /api/channels/{id}/messages/bulk-delete", s.handleBulkDeleteMessages)
+	a("POST /api/channels/{id}/messages/purge", s.handlePurgeMessages)
+	a("GET /api/channels/{id}/messages/deleted", s.handleListDeletedMessages)
+	a("POST /api/channels/{id}/scheduled-messages", s.handleScheduleMessage)
 	a("PUT /api/messages/{id}", s.handleEditMessage)
+	a("GET /api/messages/{id}/history", s.handleMessageHistory)
 	a("DELETE /api/messages/{id}", s.handleDeleteMessage)
+	a("POST /api/messages/{id}/restore", s.handleRestoreMessage)
+	a("POST /api/messages/{id}/forward", s.handleForwardMessage)
+	a("POST /api/messages/{id}/publish", s.handlePublishMessage)
+	a("PUT /api/messages/{id}/poll/votes", s.handleVotePoll)
+	a("POST /api/messages/{id}/poll/close", s.handleClosePoll)
+
+	// Scheduled messages and reminders
+	a("POST /api/reminders", s.handleCreateReminder)
+	a("GET /api/scheduled-jobs", s.handleListScheduledJobs)
+	a("PUT /api/scheduled-jobs/{id}", s.handleUpdateScheduledJob)
+	a("DELETE /api/scheduled-jobs/{id}", s.handleCancelScheduledJob)
+
+	// Group DMs
+	a("PUT /api/channels/{id}", s.handleUpdateGroupDM)
+	a("PUT /api/channels/{id}/owner", s.handleTransferGroupDMOwner)
+	a("PUT /api/channels/{id}/recipients/{userId}", s.handleAddRecipient)
+	a("DELETE /api/channels/{id}/recipients/{userId}", s.handleRemoveRecipient)
+	a("DELETE /api/channels/{id}/recipients/me", s.handleLeaveGroupDM)
 
 	// Read state / unread
 	a("PUT /api/channels/{id}/read", s.handleMarkRead)
@@ -122,30 +292,52 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	msgRepo := &database.MessageRepo{DB: s.db}
+	channelRepo := &database.ChannelRepo{DB: s.db}
 	handler := func(ctx context.Context, channelID, authorID uuid.UUID, content string) (*models.Message, error) {
+		ch, err := channelRepo.GetChannelByID(ctx, channelID)
+		if err == sql.ErrNoRows {
+			return nil, &ws.ClientError{Message: "channel not found"}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := s.checkSendAccess(ctx, authorID, ch); err != nil {
+			if ae, ok := err.(*accessError); ok {
+				return nil, &ws.ClientError{Message: ae.message}
+			}
+			return nil, err
+		}
+		release, err := s.checkSlowmode(ctx, authorID, ch)
+		if err != nil {
+			if se, ok := err.(*slowmodeError); ok {
+				return nil, &ws.ClientError{Message: se.Error(), RetryAfter: float64(se.seconds())}
+			}
+			return nil, err
+		}
+
 		msg := &models.Message{
 			ChannelID: channelID,
 			AuthorID:  authorID,
 			Content:   content,
 		}
 		if err := msgRepo.Create(ctx, msg); err != nil {
+			release()
 			return nil, err
 		}
+		if err := s.deliverNotifications(ctx, ch, msg); err != nil {
+			log.Printf("failed to deliver notifications for message %s: %v", msg.ID, err)
+		}
+		s.emitChannelWebhookEvent(ctx, ch, hookMessageCreate, msg)
+		s.unfurlAsync(msg)
 		return msg, nil
 	}
 
-	channelRepo := &database.ChannelRepo{DB: s.db}
-	memberRepo := &database.ServerMemberRepo{DB: s.db}
-	dmRepo := &database.DMMemberRepo{DB: s.db}
 	checker := func(ctx context.Context, userID, channelID uuid.UUID) (bool, error) {
 		ch, err := channelRepo.GetChannelByID(ctx, channelID)
 		if err != nil {
 			return false, err
 		}
-		if ch.ServerID != nil {
-			return memberRepo.IsMember(ctx, userID, *ch.ServerID)
-		}
-		return dmRepo.IsMember(ctx, channelID, userID)
+		return s.canViewChannel(ctx, userID, ch)
 	}
 
 	client := ws.NewClient(conn, user.ID, handler, checker)
@@ -0,0 +1,365 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/Stocist/discard/internal/auth"
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/Stocist/discard/internal/upload"
+)
+
+const (
+	// maxStickersPerServer caps how many stickers a server's pack holds.
+	maxStickersPerServer = 60
+	// maxStickerStorage caps the total size of a server's sticker files.
+	maxStickerStorage = 16 << 20
+)
+
+// stickerFormats maps the MIME types upload.ProcessSticker accepts to the
+// format clients render them with.
+var stickerFormats = map[string]string{
+	"image/png":        "png",
+	"image/apng":       "apng",
+	"image/gif":        "gif",
+	"image/webp":       "webp",
+	"application/json": "lottie",
+}
+
+// validateStickerName trims a sticker's name and checks it and the
+// description. Its errors are safe to return to the client.
+func validateStickerName(name string, description *string) (string, error) {
+	name = strings.TrimSpace(name)
+	if len(name) < 2 || len(name) > 32 {
+		return "", errors.New("name must be between 2 and 32 characters")
+	}
+	if description != nil && len(*description) > 100 {
+		return "", errors.New("description must be 100 characters or less")
+	}
+	return name, nil
+}
+
+// loadStickers fills in the stickers of messages that sent one.
+func (s *Server) loadStickers(ctx context.Context, messages []models.Message) {
+	var ids []uuid.UUID
+	for i := range messages {
+		if messages[i].StickerID != nil {
+			ids = append(ids, *messages[i].StickerID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	stickerRepo := &database.StickerRepo{DB: s.db}
+	stickers, err := stickerRepo.ListByIDs(ctx, ids)
+	if err != nil {
+		log.Printf("failed to load stickers: %v", err)
+		return
+	}
+	for i := range messages {
+		if id := messages[i].StickerID; id != nil {
+			messages[i].Sticker = stickers[*id]
+		}
+	}
+}
+
+// sendableSticker loads a sticker for the user to send. They must be a
+// member of the server whose pack it is in. It writes the error response
+// and returns nil otherwise.
+func (s *Server) sendableSticker(w http.ResponseWriter, r *http.Request, userID, stickerID uuid.UUID) *models.Sticker {
+	stickerRepo := &database.StickerRepo{DB: s.db}
+	sticker, err := stickerRepo.GetByID(r.Context(), stickerID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "sticker not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		jsonError(w, "failed to get sticker", http.StatusInternalServerError)
+		return nil
+	}
+
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	isMember, err := memberRepo.IsMember(r.Context(), userID, sticker.ServerID)
+	if err != nil {
+		jsonError(w, "failed to check membership", http.StatusInternalServerError)
+		return nil
+	}
+	if !isMember {
+		jsonError(w, "sticker not found", http.StatusNotFound)
+		return nil
+	}
+	return sticker
+}
+
+func (s *Server) handleListStickers(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	memberRepo := &database.ServerMemberRepo{DB: s.db}
+	isMember, err := memberRepo.IsMember(r.Context(), user.ID, serverID)
+	if err != nil {
+		jsonError(w, "failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		jsonError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	stickerRepo := &database.StickerRepo{DB: s.db}
+	stickers, err := stickerRepo.ListByServer(r.Context(), serverID)
+	if err != nil {
+		jsonError(w, "failed to list stickers", http.StatusInternalServerError)
+		return
+	}
+	if stickers == nil {
+		stickers = []models.Sticker{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stickers)
+}
+
+func (s *Server) handleCreateSticker(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+
+	if s.requirePermission(w, r, serverID, user.ID, models.PermManageStickers) == nil {
+		return
+	}
+
+	// Multipart form with a "file" part and "name" and "description" fields.
+	if err := r.ParseMultipartForm(upload.MaxStickerSize + 1<<16); err != nil {
+		jsonError(w, "invalid multipart form", http.StatusBadRequest)
+		return
+	}
+	var description *string
+	if d := strings.TrimSpace(r.FormValue("description")); d != "" {
+		description = &d
+	}
+	name, err := validateStickerName(r.FormValue("name"), description)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	files := r.MultipartForm.File["file"]
+	if len(files) != 1 {
+		jsonError(w, "exactly one file is required", http.StatusBadRequest)
+		return
+	}
+
+	stickerRepo := &database.StickerRepo{DB: s.db}
+	taken, err := stickerRepo.NameTaken(r.Context(), serverID, name, uuid.Nil)
+	if err != nil {
+		jsonError(w, "failed to check sticker name", http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		jsonError(w, "a sticker with that name already exists", http.StatusConflict)
+		return
+	}
+	count, size, err := stickerRepo.Usage(r.Context(), serverID)
+	if err != nil {
+		jsonError(w, "failed to count stickers", http.StatusInternalServerError)
+		return
+	}
+	if count >= maxStickersPerServer {
+		jsonError(w, fmt.Sprintf("servers can have at most %d stickers", maxStickersPerServer), http.StatusBadRequest)
+		return
+	}
+	if size+files[0].Size > maxStickerStorage {
+		jsonError(w, "not enough sticker storage left in this server", http.StatusBadRequest)
+		return
+	}
+
+	result, err := upload.ProcessSticker(s.uploadDir, files[0])
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sticker := &models.Sticker{
+		ServerID:    serverID,
+		Name:        name,
+		Description: description,
+		Format:      stickerFormats[result.MimeType],
+		FilePath:    result.FilePath,
+		FileSize:    result.FileSize,
+		CreatedBy:   &user.ID,
+	}
+	if err := stickerRepo.Create(r.Context(), sticker); err != nil {
+		s.removeUpload(result.FilePath)
+		jsonError(w, "failed to create sticker", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   serverID,
+		Action:     auditStickerCreate,
+		TargetType: auditTargetSticker,
+		TargetID:   &sticker.ID,
+		After:      map[string]any{"name": sticker.Name, "format": sticker.Format},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sticker)
+}
+
+func (s *Server) handleUpdateSticker(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+	stickerID, err := uuid.Parse(r.PathValue("stickerId"))
+	if err != nil {
+		jsonError(w, "invalid sticker id", http.StatusBadRequest)
+		return
+	}
+
+	if s.requirePermission(w, r, serverID, user.ID, models.PermManageStickers) == nil {
+		return
+	}
+
+	var input struct {
+		Name        string  `json:"name"`
+		Description *string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if input.Description != nil {
+		if d := strings.TrimSpace(*input.Description); d != "" {
+			input.Description = &d
+		} else {
+			input.Description = nil
+		}
+	}
+	name, err := validateStickerName(input.Name, input.Description)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stickerRepo := &database.StickerRepo{DB: s.db}
+	before, err := stickerRepo.GetByID(r.Context(), stickerID)
+	if err == sql.ErrNoRows || (err == nil && before.ServerID != serverID) {
+		jsonError(w, "sticker not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to get sticker", http.StatusInternalServerError)
+		return
+	}
+	taken, err := stickerRepo.NameTaken(r.Context(), serverID, name, stickerID)
+	if err != nil {
+		jsonError(w, "failed to check sticker name", http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		jsonError(w, "a sticker with that name already exists", http.StatusConflict)
+		return
+	}
+
+	sticker, err := stickerRepo.Update(r.Context(), serverID, stickerID, name, input.Description)
+	if err == sql.ErrNoRows {
+		jsonError(w, "sticker not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to update sticker", http.StatusInternalServerError)
+		return
+	}
+
+	s.audit(r, auditEvent{
+		ServerID:   serverID,
+		Action:     auditStickerUpdate,
+		TargetType: auditTargetSticker,
+		TargetID:   &sticker.ID,
+		Before:     map[string]any{"name": before.Name, "description": before.Description},
+		After:      map[string]any{"name": sticker.Name, "description": sticker.Description},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sticker)
+}
+
+func (s *Server) handleDeleteSticker(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	serverID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "invalid server id", http.StatusBadRequest)
+		return
+	}
+	stickerID, err := uuid.Parse(r.PathValue("stickerId"))
+	if err != nil {
+		jsonError(w, "invalid sticker id", http.StatusBadRequest)
+		return
+	}
+
+	if s.requirePermission(w, r, serverID, user.ID, models.PermManageStickers) == nil {
+		return
+	}
+
+	stickerRepo := &database.StickerRepo{DB: s.db}
+	sticker, err := stickerRepo.Delete(r.Context(), serverID, stickerID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "sticker not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		jsonError(w, "failed to delete sticker", http.StatusInternalServerError)
+		return
+	}
+	s.removeUpload(sticker.FilePath)
+
+	s.audit(r, auditEvent{
+		ServerID:   serverID,
+		Action:     auditStickerDelete,
+		TargetType: auditTargetSticker,
+		TargetID:   &sticker.ID,
+		Before:     map[string]any{"name": sticker.Name},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
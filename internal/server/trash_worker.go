@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Stocist/discard/internal/database"
+)
+
+const (
+	// trashRetention is how long deleted messages can be restored before
+	// they are removed for good.
+	trashRetention = 14 * 24 * time.Hour
+	// trashPollInterval is how often the trash worker looks for expired
+	// messages.
+	trashPollInterval = 10 * time.Minute
+	// trashBatchSize is how many messages the worker removes per query.
+	trashBatchSize = 500
+)
+
+// RunTrashWorker permanently removes messages that have been in the trash
+// longer than trashRetention, along with their attachment files. It should
+// be called in its own goroutine. Batches are claimed with SKIP LOCKED, so
+// several instances can run it at once.
+func (s *Server) RunTrashWorker() {
+	ticker := time.NewTicker(trashPollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.emptyTrash(context.Background())
+		<-ticker.C
+	}
+}
+
+func (s *Server) emptyTrash(ctx context.Context) {
+	msgRepo := &database.MessageRepo{DB: s.db}
+	cutoff := time.Now().Add(-trashRetention)
+	for {
+		n, files, err := msgRepo.PurgeTrash(ctx, cutoff, trashBatchSize)
+		if err != nil {
+			log.Printf("failed to empty message trash: %v", err)
+			return
+		}
+		for _, p := range files {
+			s.removeUpload(p)
+		}
+		if n < trashBatchSize {
+			return
+		}
+	}
+}
+
+// removeUpload deletes a file from the upload directory, logging failures.
+func (s *Server) removeUpload(path string) {
+	if err := os.Remove(filepath.Join(s.uploadDir, path)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("failed to remove upload %s: %v", path, err)
+	}
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Stocist/discard/internal/database"
+	"github.com/Stocist/discard/internal/markdown"
+	"github.com/Stocist/discard/internal/models"
+	"github.com/Stocist/discard/internal/unfurl"
+)
+
+const (
+	// maxUnfurlsPerMessage caps how many links in one message get previews.
+	maxUnfurlsPerMessage = 3
+	// maxConcurrentUnfurls caps how many messages are unfurled at once.
+	maxConcurrentUnfurls = 4
+	// unfurlTimeout bounds all the fetching for one message.
+	unfurlTimeout = 20 * time.Second
+	// previewCacheTTL is how long a preview is reused; previewMissTTL is
+	// how long a URL without one is left alone.
+	previewCacheTTL = 24 * time.Hour
+	previewMissTTL  = time.Hour
+)
+
+// newUnfurler configures link previews. UNFURL_ALLOWED_NETWORKS lists CIDR
+// ranges that may be fetched even though they are private, such as an
+// intranet wiki; everything else non-public is refused.
+func newUnfurler() *unfurl.Client {
+	return unfurl.NewClient(allowedNetworks("UNFURL_ALLOWED_NETWORKS"))
+}
+
+// extractLinks returns the distinct links in a message that should get
+// previews, in order. Links in spoilers are skipped, as are those the sender
+// wrapped in <...>; code never contains links.
+func extractLinks(nodes []*markdown.Node) []string {
+	var links []string
+	seen := make(map[string]bool)
+	markdown.Walk(nodes, func(n *markdown.Node) bool {
+		if len(links) == maxUnfurlsPerMessage || n.Type == markdown.KindSpoiler {
+			return false
+		}
+		if n.Type == markdown.KindLink && !n.NoEmbed && !seen[n.URL] {
+			seen[n.URL] = true
+			links = append(links, n.URL)
+		}
+		return true
+	})
+	return links
+}
+
+// unfurlMessage adds previews for the links in a new message and tells the
+// channel with a message_update. It runs in the background after the
+// message has been sent.
+func (s *Server) unfurlMessage(msg *models.Message) {
+	links := extractLinks(messageNodes(msg))
+	if len(links) == 0 {
+		return
+	}
+
+	s.unfurlSem <- struct{}{}
+	defer func() { <-s.unfurlSem }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), unfurlTimeout)
+	defer cancel()
+
+	var embeds []models.Embed
+	for _, link := range links {
+		e, err := s.linkPreview(ctx, link)
+		if err != nil {
+			log.Printf("failed to unfurl %s: %v", link, err)
+			continue
+		}
+		if e != nil {
+			embeds = append(embeds, *e)
+		}
+	}
+	if len(embeds) == 0 {
+		return
+	}
+
+	msgRepo := &database.MessageRepo{DB: s.db}
+	all, err := msgRepo.AppendEmbeds(ctx, msg.ID, embeds)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to save previews for message %s: %v", msg.ID, err)
+		return
+	}
+
+	out, err := json.Marshal(map[string]any{
+		"type":       "message_update",
+		"message_id": msg.ID,
+		"channel_id": msg.ChannelID,
+		"embeds":     all,
+	})
+	if err == nil {
+		s.hub.BroadcastToChannel(msg.ChannelID, out)
+	}
+}
+
+// linkPreview returns the preview embed for a link, from the cache when it
+// is fresh. It returns nil if the link has no preview.
+func (s *Server) linkPreview(ctx context.Context, link string) (*models.Embed, error) {
+	previewRepo := &database.LinkPreviewRepo{DB: s.db}
+	cached, fetchedAt, err := previewRepo.Get(ctx, link)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == nil {
+		ttl := previewCacheTTL
+		if cached == nil {
+			ttl = previewMissTTL
+		}
+		if time.Since(fetchedAt) < ttl {
+			return cached, nil
+		}
+	}
+
+	p, err := s.unfurler.Fetch(ctx, link)
+	if err != nil {
+		// Remember the miss; transient failures are retried after
+		// previewMissTTL like pages without metadata.
+		if err := previewRepo.Put(ctx, link, nil); err != nil {
+			log.Printf("failed to cache preview miss for %s: %v", link, err)
+		}
+		if errors.Is(err, unfurl.ErrNoPreview) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	e := previewEmbed(p)
+	if p.Image != nil {
+		path, err := s.cachePreviewImage(ctx, p.Image.URL)
+		if err != nil {
+			log.Printf("failed to fetch preview image %s: %v", p.Image.URL, err)
+		} else {
+			media := &models.EmbedMedia{URL: "/uploads/" + filepath.ToSlash(path)}
+			if p.Image.Width > 0 && p.Image.Height > 0 {
+				media.Width, media.Height = &p.Image.Width, &p.Image.Height
+			}
+			if p.Type == "image" || p.LargeImage {
+				e.Image = media
+			} else {
+				e.Thumbnail = media
+			}
+		}
+	}
+	if p.Type == "image" && e.Image == nil {
+		return nil, nil
+	}
+
+	if err := previewRepo.Put(ctx, link, e); err != nil {
+		log.Printf("failed to cache preview for %s: %v", link, err)
+	}
+	return e, nil
+}
+
+func previewEmbed(p *unfurl.Preview) *models.Embed {
+	// "rich" marks embeds sent with a message; a page claiming it would be
+	// replaced when the message is edited.
+	if p.Type == "rich" {
+		p.Type = "link"
+	}
+	e := &models.Embed{
+		Type:        p.Type,
+		Title:       p.Title,
+		Description: p.Description,
+		URL:         p.URL,
+	}
+	if p.SiteName != "" {
+		e.Provider = &models.EmbedProvider{Name: p.SiteName}
+	}
+	if p.AuthorName != "" {
+		e.Author = &models.EmbedAuthor{Name: p.AuthorName, URL: p.AuthorURL}
+	}
+	if rgb := strings.TrimPrefix(p.ThemeColor, "#"); len(rgb) == 6 {
+		if c, err := strconv.ParseUint(rgb, 16, 32); err == nil {
+			color := int(c)
+			e.Color = &color
+		}
+	}
+	return e
+}
+
+// cachePreviewImage stores a preview image under the upload directory, so
+// clients load it from here rather than from the linked site. Images are
+// named by a hash of their URL, so each is only fetched once. It returns the
+// path relative to the upload directory.
+func (s *Server) cachePreviewImage(ctx context.Context, imageURL string) (string, error) {
+	sum := sha256.Sum256([]byte(imageURL))
+	name := hex.EncodeToString(sum[:])
+	dir := filepath.Join(s.uploadDir, "previews")
+	if existing, _ := filepath.Glob(filepath.Join(dir, name+".*")); len(existing) > 0 {
+		return filepath.Rel(s.uploadDir, existing[0])
+	}
+
+	data, ext, err := s.unfurler.FetchImage(ctx, imageURL)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, ".preview-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name+ext)); err != nil {
+		return "", err
+	}
+	return filepath.Join("previews", name+ext), nil
+}
+
+// unfurlAsync starts unfurling a message unless it has nothing to preview.
+func (s *Server) unfurlAsync(msg *models.Message) {
+	if msg.Content == "" || !strings.Contains(msg.Content, "http") {
+		return
+	}
+	m := *msg
+	go s.unfurlMessage(&m)
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Stocist/discard/internal/markdown"
+)
+
+func TestExtractLinks(t *testing.T) {
+	tests := []struct {
+		name, in string
+		want     []string
+	}{
+		{"none", "no links here", nil},
+		{"one", "see https://a.example/x", []string{"https://a.example/x"}},
+		{"http", "http://a.example", []string{"http://a.example"}},
+		{"order and duplicates", "https://b.example https://a.example https://b.example",
+			[]string{"https://b.example", "https://a.example"}},
+		{"at most three", "https://a.example https://b.example https://c.example https://d.example",
+			[]string{"https://a.example", "https://b.example", "https://c.example"}},
+		{"punctuation", "is it https://a.example/x? yes, https://b.example.",
+			[]string{"https://a.example/x", "https://b.example"}},
+		{"suppressed", "<https://a.example> https://b.example", []string{"https://b.example"}},
+		{"masked", "[a](https://a.example) [b](<https://b.example>)", []string{"https://a.example"}},
+		{"suppressed only", "<https://a.example/x?y=1>", nil},
+		{"half bracketed", "<https://a.example and https://b.example>",
+			[]string{"https://a.example", "https://b.example"}},
+		{"code span", "`https://a.example` https://b.example", []string{"https://b.example"}},
+		{"code block", "```\nhttps://a.example\n```\nhttps://b.example", []string{"https://b.example"}},
+		{"spoiler", "||https://a.example|| https://b.example", []string{"https://b.example"}},
+		{"spoiler across lines", "||a\nhttps://a.example||", nil},
+		{"unclosed code", "`https://a.example", []string{"https://a.example"}},
+		{"bold", "**https://a.example**", []string{"https://a.example"}},
+		{"parenthesised", "(see https://a.example/wiki/Go_(language))",
+			[]string{"https://a.example/wiki/Go_(language)"}},
+		{"in parentheses", "(https://a.example/x).", []string{"https://a.example/x"}},
+		{"trailing punctuation", `"https://a.example/x",`, []string{"https://a.example/x"}},
+		{"not a link", "ftp://a.example mailto:a@b.example", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractLinks(markdown.Parse(tt.in)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractLinks(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
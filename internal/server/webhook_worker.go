@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Stocist/discard/internal/database"
+)
+
+const (
+	// webhookPollInterval is how often the worker looks for due deliveries
+	// when nothing wakes it sooner.
+	webhookPollInterval = 5 * time.Second
+	// webhookBatchSize is how many deliveries the worker claims, and sends
+	// concurrently, at once.
+	webhookBatchSize = 20
+	// webhookLease keeps a claimed delivery from being claimed again while it
+	// is in flight. It is renewed just before each attempt, and must outlast
+	// one attempt, which callbackHTTP limits to 10 seconds.
+	webhookLease = time.Minute
+	// webhookMaxAttempts is how many times a delivery is tried before it fails.
+	webhookMaxAttempts = 8
+	// webhookBaseBackoff doubles after every failed attempt, up to webhookMaxBackoff.
+	webhookBaseBackoff = 10 * time.Second
+	webhookMaxBackoff  = time.Hour
+	// webhookDisableAfter is how many deliveries in a row may fail before the
+	// webhook is disabled.
+	webhookDisableAfter = 5
+	// webhookRetention is how long finished deliveries stay in the delivery log.
+	webhookRetention = 30 * 24 * time.Hour
+)
+
+// webhookBackoff returns the wait before the next attempt after the given
+// number of failed attempts.
+func webhookBackoff(attempts int) time.Duration {
+	d := webhookBaseBackoff
+	for i := 1; i < attempts && d < webhookMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > webhookMaxBackoff {
+		d = webhookMaxBackoff
+	}
+	return d
+}
+
+// signWebhook returns the X-Discard-Signature value for a delivery: the hex
+// HMAC-SHA256, keyed with the webhook secret, of "<timestamp>.<body>".
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// wakeWebhooks tells the delivery worker there is new work without waiting
+// for its next poll.
+func (s *Server) wakeWebhooks() {
+	select {
+	case s.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunWebhookWorker delivers queued outgoing webhook events. It should be
+// called in its own goroutine. The queue lives in Postgres, so deliveries
+// survive restarts and several instances can share it.
+func (s *Server) RunWebhookWorker() {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.webhookWake:
+		}
+
+		ctx := context.Background()
+		hookRepo := &database.OutgoingWebhookRepo{DB: s.db}
+
+		if time.Since(lastPrune) > time.Hour {
+			if err := hookRepo.PruneDeliveries(ctx, time.Now().Add(-webhookRetention)); err != nil {
+				log.Printf("failed to prune webhook deliveries: %v", err)
+			}
+			lastPrune = time.Now()
+		}
+
+		for {
+			due, err := hookRepo.ClaimDue(ctx, webhookBatchSize, webhookLease)
+			if err != nil {
+				log.Printf("failed to claim webhook deliveries: %v", err)
+				break
+			}
+			var wg sync.WaitGroup
+			for i := range due {
+				wg.Add(1)
+				go func(d *database.DueDelivery) {
+					defer wg.Done()
+					s.deliverWebhook(ctx, hookRepo, d)
+				}(&due[i])
+			}
+			wg.Wait()
+			if len(due) < webhookBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// deliverWebhook makes one attempt at a delivery and records the outcome.
+func (s *Server) deliverWebhook(ctx context.Context, hookRepo *database.OutgoingWebhookRepo, d *database.DueDelivery) {
+	// The claim's lease started when the batch was claimed; start it afresh
+	// so it covers this attempt.
+	if err := hookRepo.ExtendLease(ctx, d, webhookLease); err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("failed to extend lease on webhook delivery %s: %v", d.ID, err)
+		}
+		return
+	}
+
+	status, err := s.postWebhook(ctx, d)
+	if err == nil {
+		if err := hookRepo.MarkSucceeded(ctx, d, *status); err != nil {
+			log.Printf("failed to record webhook delivery %s: %v", d.ID, err)
+		}
+		return
+	}
+
+	attempts := d.Attempts + 1
+	if attempts < webhookMaxAttempts {
+		next := time.Now().Add(webhookBackoff(attempts))
+		if err := hookRepo.MarkRetry(ctx, d, status, err.Error(), next); err != nil {
+			log.Printf("failed to record webhook delivery %s: %v", d.ID, err)
+		}
+		return
+	}
+
+	disabled, dbErr := hookRepo.MarkFailed(ctx, d, status, err.Error(), webhookDisableAfter)
+	if dbErr != nil {
+		log.Printf("failed to record webhook delivery %s: %v", d.ID, dbErr)
+		return
+	}
+	if disabled {
+		log.Printf("outgoing webhook %s disabled after %d failed deliveries", d.WebhookID, webhookDisableAfter)
+	}
+}
+
+// postWebhook sends a delivery. It returns the response status when there
+// was a response, and an error unless the status was 2xx.
+func (s *Server) postWebhook(ctx context.Context, d *database.DueDelivery) (*int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Discard-Webhooks/1.0")
+	req.Header.Set("X-Discard-Event", d.Event)
+	req.Header.Set("X-Discard-Delivery", d.ID.String())
+	req.Header.Set("X-Discard-Timestamp", timestamp)
+	req.Header.Set("X-Discard-Signature", signWebhook(d.Secret, timestamp, d.Payload))
+
+	resp, err := s.callbackHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	status := resp.StatusCode
+	if status < 200 || status >= 300 {
+		return &status, fmt.Errorf("endpoint returned %d", status)
+	}
+	return &status, nil
+}
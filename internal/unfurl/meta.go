@@ -0,0 +1,169 @@
+package unfurl
+
+import (
+	"bytes"
+	"html"
+	"strings"
+)
+
+// meta is the link preview metadata found in an HTML document's head.
+type meta struct {
+	// props holds <meta> content keyed by lowercased property or name; the
+	// first value for a key wins.
+	props  map[string]string
+	title  string
+	oembed string
+}
+
+func (m *meta) get(keys ...string) string {
+	for _, k := range keys {
+		if v := strings.TrimSpace(m.props[k]); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseMeta scans an HTML document for <title>, <meta> and oEmbed <link>
+// tags, stopping at the start of the body. It is a tolerant scanner rather
+// than a full HTML parser: it only needs to understand tags and attributes.
+func parseMeta(doc []byte) *meta {
+	m := &meta{props: make(map[string]string)}
+	for i := 0; i < len(doc); {
+		lt := bytes.IndexByte(doc[i:], '<')
+		if lt < 0 {
+			break
+		}
+		i += lt + 1
+		if bytes.HasPrefix(doc[i:], []byte("!--")) {
+			end := bytes.Index(doc[i:], []byte("-->"))
+			if end < 0 {
+				break
+			}
+			i += end + 3
+			continue
+		}
+
+		name, n := tagName(doc[i:])
+		i += n
+		switch name {
+		case "body", "/head":
+			return m
+		case "script", "style", "title":
+			end := indexFold(doc[i:], "</"+name)
+			if end < 0 {
+				return m
+			}
+			if name == "title" && m.title == "" {
+				if gt := bytes.IndexByte(doc[i:i+end], '>'); gt >= 0 {
+					m.title = strings.TrimSpace(html.UnescapeString(string(doc[i+gt+1 : i+end])))
+				}
+			}
+			i += end
+		case "meta", "link":
+			attrs, n := parseAttrs(doc[i:])
+			i += n
+			if name == "meta" {
+				key := attrs["property"]
+				if key == "" {
+					key = attrs["name"]
+				}
+				key = strings.ToLower(key)
+				if _, seen := m.props[key]; key != "" && !seen {
+					m.props[key] = attrs["content"]
+				}
+			} else if m.oembed == "" && strings.EqualFold(attrs["type"], "application/json+oembed") &&
+				strings.Contains(strings.ToLower(attrs["rel"]), "alternate") {
+				m.oembed = attrs["href"]
+			}
+		}
+	}
+	return m
+}
+
+// tagName reads the lowercased tag name at the start of b, including a
+// leading slash for closing tags.
+func tagName(b []byte) (string, int) {
+	n := 0
+	if n < len(b) && b[n] == '/' {
+		n++
+	}
+	for n < len(b) && isNameByte(b[n]) {
+		n++
+	}
+	return strings.ToLower(string(b[:n])), n
+}
+
+func isNameByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == ':' || c == '_'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// parseAttrs reads attributes up to the end of the tag, returning them with
+// lowercased names and unescaped values, and the bytes consumed.
+func parseAttrs(b []byte) (map[string]string, int) {
+	attrs := make(map[string]string)
+	i := 0
+	for i < len(b) {
+		for i < len(b) && (isSpace(b[i]) || b[i] == '/') {
+			i++
+		}
+		if i >= len(b) || b[i] == '>' {
+			return attrs, i + 1
+		}
+
+		start := i
+		for i < len(b) && !isSpace(b[i]) && b[i] != '=' && b[i] != '>' && b[i] != '/' {
+			i++
+		}
+		name := strings.ToLower(string(b[start:i]))
+		for i < len(b) && isSpace(b[i]) {
+			i++
+		}
+		if i >= len(b) || b[i] != '=' {
+			if name != "" {
+				attrs[name] = ""
+			}
+			continue
+		}
+		i++
+		for i < len(b) && isSpace(b[i]) {
+			i++
+		}
+
+		var value []byte
+		if i < len(b) && (b[i] == '"' || b[i] == '\'') {
+			q := b[i]
+			end := bytes.IndexByte(b[i+1:], q)
+			if end < 0 {
+				return attrs, len(b)
+			}
+			value = b[i+1 : i+1+end]
+			i += end + 2
+		} else {
+			start := i
+			for i < len(b) && !isSpace(b[i]) && b[i] != '>' {
+				i++
+			}
+			value = b[start:i]
+		}
+		if _, seen := attrs[name]; name != "" && !seen {
+			attrs[name] = html.UnescapeString(string(value))
+		}
+	}
+	return attrs, len(b)
+}
+
+// indexFold is bytes.Index, ignoring ASCII case in sep.
+func indexFold(b []byte, sep string) int {
+	s := []byte(sep)
+	for i := 0; i+len(s) <= len(b); i++ {
+		if bytes.EqualFold(b[i:i+len(s)], s) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,78 @@
+package unfurl
+
+import "testing"
+
+func TestParseMeta(t *testing.T) {
+	doc := `<!DOCTYPE html>
+<html><head>
+<!-- <meta property="og:title" content="commented out"> -->
+<meta charset=utf-8>
+<title> Page &amp; Title </title>
+<script>var s = "<meta property='og:title' content='in a script'>";</script>
+<META PROPERTY="OG:TITLE" CONTENT="First &quot;title&quot;">
+<meta property="og:title" content="Second title">
+<meta name=description content=unquoted>
+<meta name='twitter:card' content='summary_large_image' />
+<link rel="alternate" type="application/json+oembed" href="/oembed?url=x">
+</head>
+<body><meta property="og:description" content="in the body"></body>
+</html>`
+
+	m := parseMeta([]byte(doc))
+	if m.title != "Page & Title" {
+		t.Errorf("title = %q", m.title)
+	}
+	for key, want := range map[string]string{
+		"og:title":       `First "title"`,
+		"description":    "unquoted",
+		"twitter:card":   "summary_large_image",
+		"og:description": "",
+	} {
+		if got := m.props[key]; got != want {
+			t.Errorf("props[%q] = %q, want %q", key, got, want)
+		}
+	}
+	if m.oembed != "/oembed?url=x" {
+		t.Errorf("oembed = %q", m.oembed)
+	}
+	if got := m.get("og:description", "description"); got != "unquoted" {
+		t.Errorf("get fell back to %q", got)
+	}
+}
+
+func TestParseMetaMalformed(t *testing.T) {
+	for _, doc := range []string{
+		"",
+		"<",
+		"<meta",
+		`<meta property="og:title" content="unterminated`,
+		"<!-- unterminated",
+		"<title>unterminated",
+		"<script>",
+		"<meta ===>",
+		"<<<>>>",
+	} {
+		m := parseMeta([]byte(doc))
+		if m.title != "" || m.props["og:title"] != "" {
+			t.Errorf("parseMeta(%q) = %+v", doc, m)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"  short  ", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"too long", 5, "too…"},
+		{"ééééé", 3, "éé…"},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.in, tt.n); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
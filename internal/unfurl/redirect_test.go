@@ -0,0 +1,104 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Stocist/discard/internal/netguard"
+)
+
+// listenOn starts a test server on addr. Loopback addresses other than
+// 127.0.0.1 let a test allow the server without allowing 127.0.0.1 itself.
+func listenOn(t *testing.T, addr string, h http.Handler) *httptest.Server {
+	t.Helper()
+	l, err := net.Listen("tcp", addr+":0")
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", addr, err)
+	}
+	srv := httptest.NewUnstartedServer(h)
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testClient returns a client that may only reach 127.0.0.2, where the
+// servers under test listen.
+func testClient() *Client {
+	return NewClient([]netip.Prefix{netip.MustParsePrefix("127.0.0.2/32")})
+}
+
+func TestFetchBlocksPrivateAddresses(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<title>secret</title>`))
+	}))
+	defer srv.Close()
+
+	_, err := NewClient(nil).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, netguard.ErrBlocked) {
+		t.Errorf("Fetch of loopback URL: err = %v, want netguard.ErrBlocked", err)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("blocked server was reached %d times", n)
+	}
+}
+
+func TestFetchBlocksRedirects(t *testing.T) {
+	var hits atomic.Int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<title>secret</title>`))
+	}))
+	defer internal.Close()
+
+	targets := map[string]string{
+		"/loopback": internal.URL + "/",
+		"/metadata": "http://169.254.169.254/latest/meta-data/",
+		"/ipv6":     "http://[::1]:1/",
+		"/file":     "file:///etc/passwd",
+	}
+	public := listenOn(t, "127.0.0.2", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, targets[r.URL.Path], http.StatusFound)
+	}))
+
+	c := testClient()
+	for path := range targets {
+		t.Run(path, func(t *testing.T) {
+			p, err := c.Fetch(context.Background(), public.URL+path)
+			if err == nil {
+				t.Fatalf("Fetch followed redirect to %s: %+v", targets[path], p)
+			}
+			if path != "/file" && !errors.Is(err, netguard.ErrBlocked) {
+				t.Errorf("err = %v, want netguard.ErrBlocked", err)
+			}
+		})
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("internal server was reached %d times", n)
+	}
+
+	if _, _, err := c.FetchImage(context.Background(), public.URL+"/metadata"); !errors.Is(err, netguard.ErrBlocked) {
+		t.Errorf("FetchImage: err = %v, want netguard.ErrBlocked", err)
+	}
+}
+
+func TestFetchRedirectLimit(t *testing.T) {
+	var srv *httptest.Server
+	srv = listenOn(t, "127.0.0.2", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, srv.URL+r.URL.Path+"x", http.StatusFound)
+	}))
+	if _, err := testClient().Fetch(context.Background(), srv.URL+"/"); err == nil {
+		t.Error("endless redirects were followed")
+	}
+}
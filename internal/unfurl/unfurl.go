@@ -0,0 +1,277 @@
+// Package unfurl fetches web pages and extracts link preview metadata from
+// their OpenGraph, Twitter card and oEmbed tags.
+package unfurl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Stocist/discard/internal/netguard"
+)
+
+const (
+	// MaxPageSize is how much of a page is read looking for metadata.
+	MaxPageSize = 1 << 20
+	// MaxImageSize is the largest preview image fetched.
+	MaxImageSize  = 5 << 20
+	maxOEmbedSize = 64 << 10
+	maxRedirects  = 5
+
+	maxTitle       = 256
+	maxDescription = 350
+)
+
+// ErrNoPreview is returned when a page has nothing worth previewing.
+var ErrNoPreview = errors.New("no preview metadata")
+
+// imageTypes are the image formats previews are made from, by sniffed
+// content type, with the file extension to store them under.
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Client fetches previews. It refuses to connect to private, loopback and
+// other non-public addresses except those in its allowlist.
+type Client struct {
+	HTTP *http.Client
+}
+
+// NewClient returns a Client that may also connect to addresses in allow.
+func NewClient(allow []netip.Prefix) *Client {
+	c := &Client{}
+	c.HTTP = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: netguard.New(allow).Transport(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to %s URL", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+	return c
+}
+
+// Preview is the metadata for one link.
+type Preview struct {
+	// URL is the page's canonical URL, or where the link ended up after
+	// redirects.
+	URL string
+	// Type is "image" for a link straight to an image, otherwise the
+	// page's og:type, defaulting to "link".
+	Type        string
+	SiteName    string
+	Title       string
+	Description string
+	AuthorName  string
+	AuthorURL   string
+	// ThemeColor is the page's theme-color, as written.
+	ThemeColor string
+	Image      *Image
+	// LargeImage is set when the page asks for its image to be shown
+	// full width rather than as a thumbnail.
+	LargeImage bool
+}
+
+type Image struct {
+	URL    string
+	Width  int
+	Height int
+}
+
+func (c *Client) get(ctx context.Context, rawURL, accept string) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", rawURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Discard-LinkPreview/1.0)")
+	req.Header.Set("Accept", accept)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned %d", u.Host, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// Fetch loads a page and builds its preview. It returns ErrNoPreview if the
+// page has no title, description or image.
+func (c *Client) Fetch(ctx context.Context, rawURL string) (*Preview, error) {
+	resp, err := c.get(ctx, rawURL, "text/html,application/xhtml+xml;q=0.9,image/*;q=0.8")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	final := resp.Request.URL
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if _, ok := imageTypes[mediaType]; ok {
+		return &Preview{
+			URL:   final.String(),
+			Type:  "image",
+			Image: &Image{URL: final.String()},
+		}, nil
+	}
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNoPreview
+	}
+
+	doc, err := io.ReadAll(io.LimitReader(resp.Body, MaxPageSize))
+	if err != nil {
+		return nil, err
+	}
+	m := parseMeta(doc)
+
+	p := &Preview{
+		URL:         final.String(),
+		Type:        m.get("og:type"),
+		SiteName:    m.get("og:site_name", "application-name"),
+		Title:       m.get("og:title", "twitter:title"),
+		Description: m.get("og:description", "twitter:description", "description"),
+		ThemeColor:  m.get("theme-color"),
+		LargeImage:  m.get("twitter:card") == "summary_large_image",
+	}
+	if p.Title == "" {
+		p.Title = m.title
+	}
+	if canonical := resolve(final, m.get("og:url")); canonical != "" {
+		p.URL = canonical
+	}
+	if img := resolve(final, m.get("og:image:secure_url", "og:image", "og:image:url", "twitter:image", "twitter:image:src")); img != "" {
+		p.Image = &Image{URL: img}
+		p.Image.Width, _ = strconv.Atoi(m.get("og:image:width"))
+		p.Image.Height, _ = strconv.Atoi(m.get("og:image:height"))
+	}
+
+	if href := resolve(final, m.oembed); href != "" {
+		// oEmbed only fills gaps; a failure leaves the preview as it is.
+		if o, err := c.fetchOEmbed(ctx, href); err == nil {
+			o.fill(p, final)
+		}
+	}
+
+	if p.Type == "" {
+		p.Type = "link"
+	}
+	p.Title = truncate(p.Title, maxTitle)
+	p.Description = truncate(p.Description, maxDescription)
+	if p.Title == "" && p.Description == "" && p.Image == nil {
+		return nil, ErrNoPreview
+	}
+	return p, nil
+}
+
+// oEmbed is the subset of an oEmbed response used for previews.
+type oEmbed struct {
+	Title           string `json:"title"`
+	AuthorName      string `json:"author_name"`
+	AuthorURL       string `json:"author_url"`
+	ProviderName    string `json:"provider_name"`
+	ThumbnailURL    string `json:"thumbnail_url"`
+	ThumbnailWidth  int    `json:"thumbnail_width"`
+	ThumbnailHeight int    `json:"thumbnail_height"`
+}
+
+func (c *Client) fetchOEmbed(ctx context.Context, href string) (*oEmbed, error) {
+	resp, err := c.get(ctx, href, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var o oEmbed
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOEmbedSize)).Decode(&o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (o *oEmbed) fill(p *Preview, base *url.URL) {
+	if p.Title == "" {
+		p.Title = strings.TrimSpace(o.Title)
+	}
+	if p.SiteName == "" {
+		p.SiteName = strings.TrimSpace(o.ProviderName)
+	}
+	if p.AuthorName == "" {
+		p.AuthorName = strings.TrimSpace(o.AuthorName)
+		p.AuthorURL = resolve(base, o.AuthorURL)
+	}
+	if p.Image == nil {
+		if thumb := resolve(base, o.ThumbnailURL); thumb != "" {
+			p.Image = &Image{URL: thumb, Width: o.ThumbnailWidth, Height: o.ThumbnailHeight}
+		}
+	}
+}
+
+// FetchImage downloads a preview image, returning its bytes and the file
+// extension for its format. Formats are sniffed rather than trusted from
+// the response headers.
+func (c *Client) FetchImage(ctx context.Context, rawURL string) ([]byte, string, error) {
+	resp, err := c.get(ctx, rawURL, "image/*")
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxImageSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > MaxImageSize {
+		return nil, "", fmt.Errorf("image exceeds %d bytes", MaxImageSize)
+	}
+	ext, ok := imageTypes[http.DetectContentType(data)]
+	if !ok {
+		return nil, "", errors.New("unsupported image format")
+	}
+	return data, ext, nil
+}
+
+// resolve makes ref absolute against base, returning "" unless the result
+// is an http or https URL.
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
+
+func truncate(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return strings.TrimSpace(string(r[:n-1])) + "…"
+}
@@ -0,0 +1,131 @@
+package unfurl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// pngHeader is enough of a PNG for content sniffing.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestFetch(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := listenOn(t, "127.0.0.2", mux)
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head>
+<title>Fallback</title>
+<meta property="og:title" content="Article">
+<meta property="og:description" content="` + strings.Repeat("word ", 100) + `">
+<meta property="og:site_name" content="Example">
+<meta property="og:type" content="article">
+<meta property="og:image" content="/img.png">
+<meta property="og:image:width" content="640">
+<meta property="og:image:height" content="480">
+<meta name="theme-color" content="#336699">
+<link rel="alternate" type="application/json+oembed" href="/oembed">
+</head></html>`))
+	})
+	mux.HandleFunc("/oembed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"title": "ignored", "author_name": "Ann", "author_url": "/ann"}`))
+	})
+	mux.HandleFunc("/titled", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<title>Just a title</title>`))
+	})
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body>nothing</body></html>`))
+	})
+	mux.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(`<title>not html</title>`))
+	})
+	mux.HandleFunc("/img.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(pngHeader)
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+
+	c := testClient()
+	ctx := context.Background()
+
+	p, err := c.Fetch(ctx, srv.URL+"/article")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Title != "Article" || p.SiteName != "Example" || p.Type != "article" || p.ThemeColor != "#336699" {
+		t.Errorf("preview = %+v", p)
+	}
+	if n := len([]rune(p.Description)); n != maxDescription || !strings.HasSuffix(p.Description, "…") {
+		t.Errorf("description not truncated to %d: %d runes", maxDescription, n)
+	}
+	if p.Image == nil || p.Image.URL != srv.URL+"/img.png" || p.Image.Width != 640 || p.Image.Height != 480 {
+		t.Errorf("image = %+v", p.Image)
+	}
+	if p.AuthorName != "Ann" || p.AuthorURL != srv.URL+"/ann" {
+		t.Errorf("oEmbed author = %q %q", p.AuthorName, p.AuthorURL)
+	}
+
+	if p, err := c.Fetch(ctx, srv.URL+"/titled"); err != nil || p.Title != "Just a title" || p.Type != "link" {
+		t.Errorf("title fallback: %+v, %v", p, err)
+	}
+	if p, err := c.Fetch(ctx, srv.URL+"/img.png"); err != nil || p.Type != "image" || p.Image.URL != srv.URL+"/img.png" {
+		t.Errorf("direct image: %+v, %v", p, err)
+	}
+	for _, path := range []string{"/empty", "/text"} {
+		if _, err := c.Fetch(ctx, srv.URL+path); !errors.Is(err, ErrNoPreview) {
+			t.Errorf("Fetch(%s): err = %v, want ErrNoPreview", path, err)
+		}
+	}
+	if _, err := c.Fetch(ctx, srv.URL+"/missing"); err == nil {
+		t.Error("Fetch of a 404 succeeded")
+	}
+	if _, err := c.Fetch(ctx, "ftp://127.0.0.2/"); err == nil {
+		t.Error("Fetch of an ftp URL succeeded")
+	}
+}
+
+func TestSizeLimits(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := listenOn(t, "127.0.0.2", mux)
+	// The metadata starts just past MaxPageSize, so it is never read.
+	mux.HandleFunc("/long", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head>"))
+		w.Write(bytes.Repeat([]byte(" "), MaxPageSize))
+		w.Write([]byte(`<title>too late</title></head></html>`))
+	})
+	mux.HandleFunc("/max.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(append(pngHeader, make([]byte, MaxImageSize-len(pngHeader))...))
+	})
+	mux.HandleFunc("/big.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(append(pngHeader, make([]byte, MaxImageSize+1-len(pngHeader))...))
+	})
+	mux.HandleFunc("/fake.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("<svg></svg>"))
+	})
+
+	c := testClient()
+	ctx := context.Background()
+
+	if _, err := c.Fetch(ctx, srv.URL+"/long"); !errors.Is(err, ErrNoPreview) {
+		t.Errorf("metadata past MaxPageSize: err = %v, want ErrNoPreview", err)
+	}
+	data, ext, err := c.FetchImage(ctx, srv.URL+"/max.png")
+	if err != nil || len(data) != MaxImageSize || ext != ".png" {
+		t.Errorf("image of MaxImageSize: %d bytes, %q, %v", len(data), ext, err)
+	}
+	if _, _, err := c.FetchImage(ctx, srv.URL+"/big.png"); err == nil {
+		t.Error("image over MaxImageSize was accepted")
+	}
+	if _, _, err := c.FetchImage(ctx, srv.URL+"/fake.png"); err == nil {
+		t.Error("image format was taken from Content-Type rather than sniffed")
+	}
+}
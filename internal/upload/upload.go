@@ -1,6 +1,8 @@
 package upload
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -24,6 +26,16 @@ var allowedTypes = map[string]string{
 	"application/zip": ".zip",
 }
 
+// stickerTypes are the MIME types stickers may use; application/json is a
+// Lottie animation.
+var stickerTypes = map[string]string{
+	"image/png":        ".png",
+	"image/apng":       ".png",
+	"image/gif":        ".gif",
+	"image/webp":       ".webp",
+	"application/json": ".json",
+}
+
 // convertibleImages are MIME types we convert to WebP via cwebp.
 var convertibleImages = map[string]bool{
 	"image/jpeg": true,
@@ -48,16 +60,14 @@ type Result struct {
 // MaxFileSize is the per-file upload limit.
 const MaxFileSize = 10 << 20 // 10 MB
 
+// MaxStickerSize is the per-file limit for stickers.
+const MaxStickerSize = 512 << 10 // 512 KB
+
 // ProcessFile saves an uploaded file to disk and optionally converts images to WebP.
 // uploadDir is the root upload directory (e.g. "./uploads").
 func ProcessFile(uploadDir string, fh *multipart.FileHeader) (*Result, error) {
 	// Validate MIME type from the header.
-	contentType := fh.Header.Get("Content-Type")
-	// Normalize: take only the media type, strip parameters.
-	if idx := strings.Index(contentType, ";"); idx != -1 {
-		contentType = strings.TrimSpace(contentType[:idx])
-	}
-
+	contentType := MediaType(fh)
 	ext, ok := allowedTypes[contentType]
 	if !ok {
 		return nil, fmt.Errorf("file type %q is not allowed", contentType)
@@ -67,63 +77,125 @@ func ProcessFile(uploadDir string, fh *multipart.FileHeader) (*Result, error) {
 		return nil, fmt.Errorf("file exceeds maximum size of %d bytes", MaxFileSize)
 	}
 
+	result, diskPath, err := save(uploadDir, "attachments", fh, ext)
+	if err != nil {
+		return nil, err
+	}
+	result.MimeType = contentType
+
+	// Convert to WebP if applicable.
+	if convertibleImages[contentType] {
+		webpPath := strings.TrimSuffix(diskPath, ext) + ".webp"
+
+		cmd := exec.Command("cwebp", diskPath, "-o", webpPath)
+		if err := cmd.Run(); err == nil {
+			// Conversion succeeded — remove original, use WebP.
+			os.Remove(diskPath)
+			result.FilePath = strings.TrimSuffix(result.FilePath, ext) + ".webp"
+			result.MimeType = "image/webp"
+			if info, err := os.Stat(webpPath); err == nil {
+				result.FileSize = info.Size()
+			}
+		}
+		// Otherwise cwebp is not available or failed — keep original.
+	}
+
+	return result, nil
+}
+
+// ProcessSticker saves an uploaded sticker exactly as sent: converting an
+// animated PNG to WebP would keep only its first frame. Lottie animations
+// are JSON, which must look like one.
+func ProcessSticker(uploadDir string, fh *multipart.FileHeader) (*Result, error) {
+	contentType := MediaType(fh)
+	ext, ok := stickerTypes[contentType]
+	if !ok {
+		return nil, fmt.Errorf("sticker type %q is not allowed", contentType)
+	}
+
+	if fh.Size > MaxStickerSize {
+		return nil, fmt.Errorf("sticker exceeds maximum size of %d bytes", MaxStickerSize)
+	}
+
+	result, diskPath, err := save(uploadDir, "stickers", fh, ext)
+	if err != nil {
+		return nil, err
+	}
+	result.MimeType = contentType
+
+	if contentType == "application/json" {
+		if err := checkLottie(diskPath); err != nil {
+			os.Remove(diskPath)
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// MediaType returns the uploaded file's MIME type, without parameters.
+func MediaType(fh *multipart.FileHeader) string {
+	contentType := fh.Header.Get("Content-Type")
+	if idx := strings.Index(contentType, ";"); idx != -1 {
+		contentType = strings.TrimSpace(contentType[:idx])
+	}
+	return contentType
+}
+
+// save writes an uploaded file with a random name under a dated directory
+// of kind, e.g. "attachments". It returns the result, with FilePath relative
+// to uploadDir, and the path on disk.
+func save(uploadDir, kind string, fh *multipart.FileHeader, ext string) (*Result, string, error) {
 	now := time.Now()
-	subDir := filepath.Join("attachments", fmt.Sprintf("%d", now.Year()), fmt.Sprintf("%02d", now.Month()))
+	subDir := filepath.Join(kind, fmt.Sprintf("%d", now.Year()), fmt.Sprintf("%02d", now.Month()))
 	fullDir := filepath.Join(uploadDir, subDir)
 	if err := os.MkdirAll(fullDir, 0o755); err != nil {
-		return nil, fmt.Errorf("create upload dir: %w", err)
+		return nil, "", fmt.Errorf("create upload dir: %w", err)
 	}
 
-	fileID := uuid.New().String()
-	diskName := fileID + ext
+	diskName := uuid.New().String() + ext
 	diskPath := filepath.Join(fullDir, diskName)
 
 	src, err := fh.Open()
 	if err != nil {
-		return nil, fmt.Errorf("open uploaded file: %w", err)
+		return nil, "", fmt.Errorf("open uploaded file: %w", err)
 	}
 	defer src.Close()
 
 	dst, err := os.Create(diskPath)
 	if err != nil {
-		return nil, fmt.Errorf("create dest file: %w", err)
+		return nil, "", fmt.Errorf("create dest file: %w", err)
 	}
 	defer dst.Close()
 
 	written, err := io.Copy(dst, src)
 	if err != nil {
 		os.Remove(diskPath)
-		return nil, fmt.Errorf("write file: %w", err)
+		return nil, "", fmt.Errorf("write file: %w", err)
 	}
 	dst.Close()
 
-	result := &Result{
+	return &Result{
+		FilePath:     filepath.Join(subDir, diskName),
 		OriginalName: fh.Filename,
-		MimeType:     contentType,
 		FileSize:     written,
-	}
-
-	// Convert to WebP if applicable.
-	if convertibleImages[contentType] {
-		webpName := fileID + ".webp"
-		webpPath := filepath.Join(fullDir, webpName)
+	}, diskPath, nil
+}
 
-		cmd := exec.Command("cwebp", diskPath, "-o", webpPath)
-		if err := cmd.Run(); err == nil {
-			// Conversion succeeded — remove original, use WebP.
-			os.Remove(diskPath)
-			result.FilePath = filepath.Join(subDir, webpName)
-			result.MimeType = "image/webp"
-			if info, err := os.Stat(webpPath); err == nil {
-				result.FileSize = info.Size()
-			}
-		} else {
-			// cwebp not available or failed — keep original.
-			result.FilePath = filepath.Join(subDir, diskName)
-		}
-	} else {
-		result.FilePath = filepath.Join(subDir, diskName)
+// checkLottie checks that a JSON file is a Lottie animation: it must have a
+// version, a size and layers.
+func checkLottie(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read sticker: %w", err)
 	}
-
-	return result, nil
+	var anim struct {
+		Version string            `json:"v"`
+		Width   int               `json:"w"`
+		Height  int               `json:"h"`
+		Layers  []json.RawMessage `json:"layers"`
+	}
+	if err := json.Unmarshal(data, &anim); err != nil || anim.Version == "" || anim.Width <= 0 || anim.Height <= 0 || anim.Layers == nil {
+		return errors.New("sticker is not a Lottie animation")
+	}
+	return nil
 }
@@ -3,6 +3,7 @@ package websocket
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"sync"
 	"time"
@@ -27,6 +28,18 @@ type MessageHandler func(ctx context.Context, channelID uuid.UUID, authorID uuid
 // MembershipChecker verifies a user belongs to a channel before subscribing.
 type MembershipChecker func(ctx context.Context, userID uuid.UUID, channelID uuid.UUID) (bool, error)
 
+// ClientError is returned by a MessageHandler when a message is rejected for a
+// reason the sender should see, such as being timed out. RetryAfter, when
+// set, is how many seconds the sender must wait before trying again.
+type ClientError struct {
+	Message    string
+	RetryAfter float64
+}
+
+func (e *ClientError) Error() string {
+	return e.Message
+}
+
 // Client is a middleman between a WebSocket connection and the Hub.
 type Client struct {
 	hub      *Hub
@@ -175,7 +188,13 @@ func (c *Client) WritePump() {
 
 // sendError writes a JSON error message to the client's WebSocket.
 func (c *Client) sendError(message string) {
-	out, err := json.Marshal(map[string]string{"type": "error", "message": message})
+	c.sendJSON(map[string]string{"type": "error", "message": message})
+}
+
+// sendJSON writes v to the client's WebSocket, dropping it if the send
+// buffer is full.
+func (c *Client) sendJSON(v any) {
+	out, err := json.Marshal(v)
 	if err != nil {
 		log.Printf("ws marshal error: %v", err)
 		return
@@ -183,7 +202,7 @@ func (c *Client) sendError(message string) {
 	select {
 	case c.send <- out:
 	default:
-		log.Printf("ws send buffer full, dropping error message")
+		log.Printf("ws send buffer full, dropping message")
 	}
 }
 
@@ -220,6 +239,15 @@ func (c *Client) handleChatMessage(msg incomingMessage) {
 
 	saved, err := c.OnMessage(context.Background(), channelID, c.UserID, msg.Content)
 	if err != nil {
+		var ce *ClientError
+		if errors.As(err, &ce) {
+			if ce.RetryAfter > 0 {
+				c.sendJSON(map[string]any{"type": "error", "message": ce.Message, "retry_after": ce.RetryAfter})
+				return
+			}
+			c.sendError(ce.Message)
+			return
+		}
 		log.Printf("ws message handler error: %v", err)
 		return
 	}
@@ -27,6 +27,9 @@ type Hub struct {
 
 	broadcast chan broadcastRequest
 
+	// onOffline is called in its own goroutine when a user's last connection closes.
+	onOffline func(userID uuid.UUID)
+
 	mu sync.RWMutex
 }
 
@@ -58,6 +61,13 @@ func (h *Hub) Presence() *PresenceTracker {
 	return h.presence
 }
 
+// OnOffline registers a callback for when a user's last connection closes.
+func (h *Hub) OnOffline(fn func(userID uuid.UUID)) {
+	h.mu.Lock()
+	h.onOffline = fn
+	h.mu.Unlock()
+}
+
 // Run starts the hub event loop. Should be called in its own goroutine.
 func (h *Hub) Run() {
 	for {
@@ -75,9 +85,13 @@ func (h *Hub) Run() {
 			h.mu.Lock()
 			h.removeClientLocked(client)
 			delete(h.allClients, client)
+			onOffline := h.onOffline
 			h.mu.Unlock()
 			if h.presence.SetOffline(client.UserID, client) {
 				h.broadcastPresence(client.UserID, "offline")
+				if onOffline != nil {
+					go onOffline(client.UserID)
+				}
 			}
 
 		case req := <-h.subscribe:
@@ -133,6 +147,29 @@ func (h *Hub) UnsubscribeAll(client *Client) {
 	h.unregister <- client
 }
 
+// UnsubscribeUser removes every connection of a user from the given channels,
+// e.g. after they are kicked or banned from the server owning them.
+func (h *Hub) UnsubscribeUser(userID uuid.UUID, channelIDs []uuid.UUID) {
+	clients := h.presence.Clients(userID)
+	if len(clients) == 0 {
+		return
+	}
+	h.mu.Lock()
+	for _, chID := range channelIDs {
+		subs, ok := h.channels[chID]
+		if !ok {
+			continue
+		}
+		for _, c := range clients {
+			delete(subs, c)
+		}
+		if len(subs) == 0 {
+			delete(h.channels, chID)
+		}
+	}
+	h.mu.Unlock()
+}
+
 // BroadcastToChannel sends data to all clients subscribed to the given channel.
 func (h *Hub) BroadcastToChannel(channelID uuid.UUID, data []byte) {
 	h.broadcast <- broadcastRequest{channelID: channelID, data: data}
@@ -176,6 +213,24 @@ func (h *Hub) BroadcastAll(data []byte) {
 	h.mu.RUnlock()
 }
 
+// SendToUser sends raw JSON data to every connection of a user, regardless of
+// which channels they are subscribed to.
+func (h *Hub) SendToUser(userID uuid.UUID, data []byte) {
+	h.mu.RLock()
+	for _, client := range h.presence.Clients(userID) {
+		// Skip clients already unregistered (and their send buffer closed)
+		// but not yet dropped from the presence tracker.
+		if _, ok := h.allClients[client]; !ok {
+			continue
+		}
+		select {
+		case client.send <- data:
+		default:
+		}
+	}
+	h.mu.RUnlock()
+}
+
 // SendToClient sends raw JSON data to a single client.
 func (h *Hub) SendToClient(client *Client, data []byte) {
 	select {
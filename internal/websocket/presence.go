@@ -69,3 +69,15 @@ func (p *PresenceTracker) OnlineUserIDs() []uuid.UUID {
 	}
 	return ids
 }
+
+// Clients returns the active connections for a user.
+func (p *PresenceTracker) Clients(userID uuid.UUID) []*Client {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	clients := make([]*Client, 0, len(p.users[userID]))
+	for c := range p.users[userID] {
+		clients = append(clients, c)
+	}
+	return clients
+}
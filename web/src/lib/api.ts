@@ -1,6 +1,6 @@
-import type { Server, Channel, Message, ServerMember, Friendship, User } from './types';
+import type { Server, Channel, Message, MessageRevision, ServerMember, Friendship, User, UnreadCount, SlashCommand, PurgeFilter, ScheduledJob, Poll, Sticker, FavoriteGIF, ChannelPosition, ChannelOverwrite, ChannelSettings } from './types';
 
-class ApiError extends Error {
+export class ApiError extends Error {
 	constructor(
 		public status: number,
 		message: string
@@ -45,6 +45,11 @@ export async function updateMe(displayName?: string, avatar?: File): Promise<Use
 	return res.json();
 }
 
+// acknowledgeNSFW records that the user is old enough to view NSFW channels.
+export function acknowledgeNSFW(): Promise<{ nsfw_acknowledged_at: string }> {
+	return apiFetch('/me/nsfw-acknowledgement', { method: 'POST' });
+}
+
 // Servers
 export function createServer(name: string): Promise<Server> {
 	return apiFetch('/servers', {
@@ -98,10 +103,15 @@ export function leaveServer(serverId: string): Promise<void> {
 }
 
 // Channels
-export function createChannel(serverId: string, name: string, type?: string): Promise<Channel> {
+export function createChannel(
+	serverId: string,
+	name: string,
+	type?: string,
+	parentId?: string | null
+): Promise<Channel> {
 	return apiFetch(`/servers/${serverId}/channels`, {
 		method: 'POST',
-		body: JSON.stringify({ name, type })
+		body: JSON.stringify({ name, type, parent_id: parentId ?? null })
 	});
 }
 
@@ -109,10 +119,11 @@ export function listChannels(serverId: string): Promise<Channel[]> {
 	return apiFetch(`/servers/${serverId}/channels`);
 }
 
-export function updateChannel(serverId: string, channelId: string, name: string): Promise<Channel> {
+// updateChannel changes only the settings given; an empty topic clears it.
+export function updateChannel(serverId: string, channelId: string, settings: ChannelSettings): Promise<Channel> {
 	return apiFetch(`/servers/${serverId}/channels/${channelId}`, {
 		method: 'PUT',
-		body: JSON.stringify({ name })
+		body: JSON.stringify(settings)
 	});
 }
 
@@ -120,6 +131,35 @@ export function deleteChannel(serverId: string, channelId: string): Promise<void
 	return apiFetch(`/servers/${serverId}/channels/${channelId}`, { method: 'DELETE' });
 }
 
+// setChannelPositions moves channels in one go and returns the server's
+// channels as they now stand.
+export function setChannelPositions(serverId: string, positions: ChannelPosition[]): Promise<Channel[]> {
+	return apiFetch(`/servers/${serverId}/channels/positions`, {
+		method: 'PATCH',
+		body: JSON.stringify(positions)
+	});
+}
+
+export function listChannelOverwrites(channelId: string): Promise<ChannelOverwrite[]> {
+	return apiFetch(`/channels/${channelId}/overwrites`);
+}
+
+export function setChannelOverwrite(
+	channelId: string,
+	roleId: string,
+	allow: number,
+	deny: number
+): Promise<ChannelOverwrite> {
+	return apiFetch(`/channels/${channelId}/overwrites/${roleId}`, {
+		method: 'PUT',
+		body: JSON.stringify({ allow, deny })
+	});
+}
+
+export function deleteChannelOverwrite(channelId: string, roleId: string): Promise<void> {
+	return apiFetch(`/channels/${channelId}/overwrites/${roleId}`, { method: 'DELETE' });
+}
+
 // Members
 export function listMembers(serverId: string): Promise<ServerMember[]> {
 	return apiFetch(`/servers/${serverId}/members`);
@@ -164,6 +204,21 @@ export async function createMessage(
 	return res.json();
 }
 
+// A sticker or a GIF from the user's library is sent as a message of its own.
+export function sendSticker(channelId: string, stickerId: string): Promise<Message> {
+	return apiFetch(`/channels/${channelId}/messages`, {
+		method: 'POST',
+		body: JSON.stringify({ sticker_id: stickerId })
+	});
+}
+
+export function sendGIF(channelId: string, gifId: string, content = ''): Promise<Message> {
+	return apiFetch(`/channels/${channelId}/messages`, {
+		method: 'POST',
+		body: JSON.stringify({ content, gif_id: gifId })
+	});
+}
+
 // Edit / Delete messages
 export function editMessage(messageId: string, content: string): Promise<Message> {
 	return apiFetch(`/messages/${messageId}`, {
@@ -172,10 +227,127 @@ export function editMessage(messageId: string, content: string): Promise<Message
 	});
 }
 
+export function getMessageHistory(
+	messageId: string
+): Promise<{ message: Message; revisions: MessageRevision[] }> {
+	return apiFetch(`/messages/${messageId}/history`);
+}
+
 export function deleteMessage(messageId: string): Promise<void> {
 	return apiFetch(`/messages/${messageId}`, { method: 'DELETE' });
 }
 
+export function bulkDeleteMessages(
+	channelId: string,
+	messageIds: string[]
+): Promise<{ deleted: string[] }> {
+	return apiFetch(`/channels/${channelId}/messages/bulk-delete`, {
+		method: 'POST',
+		body: JSON.stringify({ message_ids: messageIds })
+	});
+}
+
+export function listDeletedMessages(channelId: string, before?: string): Promise<Message[]> {
+	const qs = before ? `?before=${encodeURIComponent(before)}` : '';
+	return apiFetch(`/channels/${channelId}/messages/deleted${qs}`);
+}
+
+export function restoreMessage(messageId: string): Promise<Message> {
+	return apiFetch(`/messages/${messageId}/restore`, { method: 'POST' });
+}
+
+export function forwardMessage(messageId: string, channelId: string): Promise<Message> {
+	return apiFetch(`/messages/${messageId}/forward`, {
+		method: 'POST',
+		body: JSON.stringify({ channel_id: channelId })
+	});
+}
+
+// publishMessage cross-posts an announcement to the channels following it.
+export function publishMessage(messageId: string): Promise<Message> {
+	return apiFetch(`/messages/${messageId}/publish`, { method: 'POST' });
+}
+
+// Announcement follows. channelId is the following channel.
+export function followChannel(announcementId: string, channelId: string): Promise<void> {
+	return apiFetch(`/channels/${announcementId}/followers`, {
+		method: 'POST',
+		body: JSON.stringify({ channel_id: channelId })
+	});
+}
+
+export function unfollowChannel(announcementId: string, channelId: string): Promise<void> {
+	return apiFetch(`/channels/${announcementId}/followers/${channelId}`, { method: 'DELETE' });
+}
+
+export function purgeMessages(channelId: string, filter: PurgeFilter): Promise<{ deleted: string[] }> {
+	return apiFetch(`/channels/${channelId}/messages/purge`, {
+		method: 'POST',
+		body: JSON.stringify(filter)
+	});
+}
+
+// Polls
+export function votePoll(messageId: string, optionIds: number[]): Promise<Poll> {
+	return apiFetch(`/messages/${messageId}/poll/votes`, {
+		method: 'PUT',
+		body: JSON.stringify({ option_ids: optionIds })
+	});
+}
+
+export function closePoll(messageId: string): Promise<Poll> {
+	return apiFetch(`/messages/${messageId}/poll/close`, { method: 'POST' });
+}
+
+// Scheduled messages and reminders
+export function scheduleMessage(channelId: string, content: string, sendAt: Date): Promise<ScheduledJob> {
+	return apiFetch(`/channels/${channelId}/scheduled-messages`, {
+		method: 'POST',
+		body: JSON.stringify({ content, send_at: sendAt.toISOString() })
+	});
+}
+
+export function createReminder(remindAt: Date, content?: string, messageId?: string): Promise<ScheduledJob> {
+	return apiFetch('/reminders', {
+		method: 'POST',
+		body: JSON.stringify({ content: content ?? '', message_id: messageId, remind_at: remindAt.toISOString() })
+	});
+}
+
+export function listScheduledJobs(kind?: 'message' | 'reminder'): Promise<ScheduledJob[]> {
+	return apiFetch(`/scheduled-jobs${kind ? `?kind=${kind}` : ''}`);
+}
+
+export function updateScheduledJob(
+	jobId: string,
+	changes: { content?: string; run_at?: string }
+): Promise<ScheduledJob> {
+	return apiFetch(`/scheduled-jobs/${jobId}`, {
+		method: 'PUT',
+		body: JSON.stringify(changes)
+	});
+}
+
+export function cancelScheduledJob(jobId: string): Promise<void> {
+	return apiFetch(`/scheduled-jobs/${jobId}`, { method: 'DELETE' });
+}
+
+// Slash commands
+export function listCommands(serverId: string, q?: string): Promise<SlashCommand[]> {
+	const qs = q ? `?q=${encodeURIComponent(q)}` : '';
+	return apiFetch(`/servers/${serverId}/commands${qs}`);
+}
+
+export function invokeCommand(
+	channelId: string,
+	text: string
+): Promise<{ interaction_id: string; status: string }> {
+	return apiFetch(`/channels/${channelId}/interactions`, {
+		method: 'POST',
+		body: JSON.stringify({ text })
+	});
+}
+
 // Friends
 export function sendFriendRequest(username: string): Promise<Friendship> {
 	return apiFetch('/friends/requests', {
@@ -197,6 +369,79 @@ export function markChannelRead(channelId: string): Promise<void> {
 	return apiFetch(`/channels/${channelId}/read`, { method: 'PUT' });
 }
 
-export function getUnreadCounts(serverId: string): Promise<Record<string, number>> {
-	return apiFetch(`/servers/${serverId}/unread`);
+export async function getUnreadCounts(serverId: string): Promise<Record<string, number>> {
+	const counts = await apiFetch<Record<string, UnreadCount>>(`/servers/${serverId}/unread`);
+	return Object.fromEntries(Object.entries(counts).map(([id, c]) => [id, c.count]));
+}
+
+// Stickers
+export function listStickers(serverId: string): Promise<Sticker[]> {
+	return apiFetch(`/servers/${serverId}/stickers`);
+}
+
+export async function createSticker(
+	serverId: string,
+	file: File,
+	name: string,
+	description?: string
+): Promise<Sticker> {
+	const form = new FormData();
+	form.append('file', file);
+	form.append('name', name);
+	if (description) form.append('description', description);
+	const res = await fetch(`/api/servers/${serverId}/stickers`, {
+		method: 'POST',
+		body: form
+	});
+	if (!res.ok) {
+		const text = await res.text().catch(() => res.statusText);
+		throw new ApiError(res.status, text);
+	}
+	return res.json();
+}
+
+export function updateSticker(
+	serverId: string,
+	stickerId: string,
+	name: string,
+	description: string | null
+): Promise<Sticker> {
+	return apiFetch(`/servers/${serverId}/stickers/${stickerId}`, {
+		method: 'PUT',
+		body: JSON.stringify({ name, description })
+	});
+}
+
+export function deleteSticker(serverId: string, stickerId: string): Promise<void> {
+	return apiFetch(`/servers/${serverId}/stickers/${stickerId}`, { method: 'DELETE' });
+}
+
+// Favorite GIFs
+export function listFavoriteGIFs(): Promise<FavoriteGIF[]> {
+	return apiFetch('/me/gifs');
+}
+
+// saveFavoriteGIF saves an attachment the user can see, or uploads a file.
+export async function saveFavoriteGIF(from: string | File): Promise<FavoriteGIF> {
+	if (typeof from === 'string') {
+		return apiFetch('/me/gifs', {
+			method: 'POST',
+			body: JSON.stringify({ attachment_id: from })
+		});
+	}
+	const form = new FormData();
+	form.append('file', from);
+	const res = await fetch('/api/me/gifs', {
+		method: 'POST',
+		body: form
+	});
+	if (!res.ok) {
+		const text = await res.text().catch(() => res.statusText);
+		throw new ApiError(res.status, text);
+	}
+	return res.json();
+}
+
+export function deleteFavoriteGIF(id: string): Promise<void> {
+	return apiFetch(`/me/gifs/${id}`, { method: 'DELETE' });
 }
@@ -1,5 +1,6 @@
 import { marked } from 'marked';
 import DOMPurify from 'dompurify';
+import type { MarkdownNode } from './types';
 
 marked.setOptions({
 	gfm: true,
@@ -25,3 +26,105 @@ export function renderMarkdown(content: string): string {
 		ALLOWED_ATTR: ['href', 'target', 'rel', 'class'],
 	});
 }
+
+// MentionNames looks up what mentions show; anything it doesn't know is
+// shown generically.
+export interface MentionNames {
+	user?: (id: string) => string | undefined;
+	role?: (id: string) => string | undefined;
+	channel?: (id: string) => string | undefined;
+}
+
+function escapeHTML(s: string): string {
+	return s
+		.replace(/&/g, '&amp;')
+		.replace(/</g, '&lt;')
+		.replace(/>/g, '&gt;')
+		.replace(/"/g, '&quot;');
+}
+
+const TIMESTAMP_FORMATS: Record<string, Intl.DateTimeFormatOptions> = {
+	t: { timeStyle: 'short' },
+	T: { timeStyle: 'medium' },
+	d: { dateStyle: 'short' },
+	D: { dateStyle: 'long' },
+	f: { dateStyle: 'long', timeStyle: 'short' },
+	F: { dateStyle: 'full', timeStyle: 'short' },
+};
+
+function formatTimestamp(seconds: number, format: string): string {
+	const date = new Date(seconds * 1000);
+	if (format === 'R') {
+		const diff = (date.getTime() - Date.now()) / 1000;
+		const units: [Intl.RelativeTimeFormatUnit, number][] = [
+			['year', 31536000], ['month', 2592000], ['day', 86400],
+			['hour', 3600], ['minute', 60], ['second', 1],
+		];
+		const rtf = new Intl.RelativeTimeFormat(undefined, { numeric: 'auto' });
+		for (const [unit, size] of units) {
+			if (Math.abs(diff) >= size || unit === 'second') {
+				return rtf.format(Math.round(diff / size), unit);
+			}
+		}
+	}
+	return date.toLocaleString(undefined, TIMESTAMP_FORMATS[format] ?? TIMESTAMP_FORMATS.f);
+}
+
+function renderNodes(nodes: MarkdownNode[] | undefined, names: MentionNames): string {
+	return (nodes ?? []).map(n => renderNode(n, names)).join('');
+}
+
+function renderNode(n: MarkdownNode, names: MentionNames): string {
+	const inner = () => renderNodes(n.children, names);
+	switch (n.type) {
+		case 'paragraph': return `<p>${inner()}</p>`;
+		case 'heading': return `<h${n.level}>${inner()}</h${n.level}>`;
+		case 'block_quote': return `<blockquote>${inner()}</blockquote>`;
+		case 'code_block': {
+			const lang = n.lang ? ` class="language-${escapeHTML(n.lang)}"` : '';
+			return `<pre><code${lang}>${escapeHTML(n.text ?? '')}</code></pre>`;
+		}
+		case 'list': {
+			const tag = n.ordered ? 'ol' : 'ul';
+			const start = n.ordered && n.start !== undefined && n.start !== 1 ? ` start="${n.start}"` : '';
+			return `<${tag}${start}>${inner()}</${tag}>`;
+		}
+		case 'list_item': return `<li>${inner()}</li>`;
+		case 'text': return escapeHTML(n.text ?? '');
+		case 'strong': return `<strong>${inner()}</strong>`;
+		case 'em': return `<em>${inner()}</em>`;
+		case 'underline': return `<u>${inner()}</u>`;
+		case 'strike': return `<del>${inner()}</del>`;
+		case 'spoiler': return `<span class="spoiler">${inner()}</span>`;
+		case 'code': return `<code>${escapeHTML(n.text ?? '')}</code>`;
+		case 'br': return '<br>';
+		case 'link':
+			return `<a href="${escapeHTML(n.url ?? '')}" target="_blank" rel="noopener noreferrer">${inner()}</a>`;
+		case 'user_mention':
+			return `<span class="mention">@${escapeHTML(names.user?.(n.id ?? '') ?? 'user')}</span>`;
+		case 'role_mention':
+			return `<span class="mention">@${escapeHTML(names.role?.(n.id ?? '') ?? 'role')}</span>`;
+		case 'channel_mention':
+			return `<span class="mention">#${escapeHTML(names.channel?.(n.id ?? '') ?? 'channel')}</span>`;
+		case 'everyone': return '<span class="mention">@everyone</span>';
+		case 'here': return '<span class="mention">@here</span>';
+		case 'emoji': return `<span class="emoji">:${escapeHTML(n.name ?? '')}:</span>`;
+		case 'timestamp': {
+			const full = new Date((n.timestamp ?? 0) * 1000).toLocaleString();
+			return `<span class="timestamp-mention" title="${escapeHTML(full)}">${escapeHTML(formatTimestamp(n.timestamp ?? 0, n.format ?? 'f'))}</span>`;
+		}
+		// Types added later render as their content.
+		default: return inner();
+	}
+}
+
+// renderAST renders a message's parsed content as sanitized HTML.
+export function renderAST(nodes: MarkdownNode[], names: MentionNames = {}): string {
+	return DOMPurify.sanitize(renderNodes(nodes, names), {
+		ALLOWED_TAGS: [
+			'p', 'br', 'strong', 'em', 'u', 'del', 'code', 'pre',
+			'a', 'ul', 'ol', 'li', 'blockquote', 'span', 'h1', 'h2', 'h3',
+		],
+		ALLOWED_ATTR: ['href', 'target', 'rel', 'class', 'start', 'title'],
+	});
+}
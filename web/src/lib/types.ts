@@ -5,6 +5,8 @@ export interface User {
 	avatar_path: string | null;
 	tailscale_id: string | null;
 	status: string;
+	bot: boolean;
+	nsfw_acknowledged_at: string | null;
 	created_at: string;
 	updated_at: string;
 }
@@ -25,9 +27,36 @@ export interface Channel {
 	topic: string | null;
 	type: string;
 	position: number;
+	parent_id: string | null;
+	nsfw: boolean;
+	slowmode_seconds: number;
+	default_auto_archive_duration: number; // minutes
 	created_at: string;
 }
 
+// ChannelSettings is the editable part of a server channel; see updateChannel.
+export interface ChannelSettings {
+	name?: string;
+	topic?: string;
+	nsfw?: boolean;
+	slowmode_seconds?: number;
+	default_auto_archive_duration?: number;
+}
+
+// ChannelPosition moves a channel in a reorder; see setChannelPositions.
+export interface ChannelPosition {
+	id: string;
+	position: number;
+	parent_id: string | null;
+}
+
+export interface ChannelOverwrite {
+	channel_id: string;
+	role_id: string;
+	allow: number;
+	deny: number;
+}
+
 export interface Attachment {
 	id: string;
 	message_id: string;
@@ -46,12 +75,176 @@ export interface Message {
 	author_id: string;
 	content: string;
 	edited: boolean;
+	edited_at: string | null;
 	created_at: string;
 	updated_at: string;
 	author_username?: string;
 	author_display_name?: string | null;
 	author_avatar_url?: string | null;
 	attachments?: Attachment[];
+	mentions?: string[];
+	mention_roles?: string[];
+	mention_everyone?: boolean;
+	embeds?: Embed[];
+	webhook_id?: string;
+	author_bot?: boolean;
+	interaction?: { id: string; name: string; user_id: string };
+	ephemeral?: boolean;
+	deleted_at?: string;
+	deleted_by?: string;
+	poll?: Poll;
+	forwarded_from?: MessageForward;
+	sticker_id?: string;
+	sticker?: Sticker;
+	published_at?: string;
+	ast?: MarkdownNode[];
+}
+
+export interface Sticker {
+	id: string;
+	server_id: string;
+	name: string;
+	description: string | null;
+	format: 'png' | 'apng' | 'gif' | 'webp' | 'lottie';
+	file_path: string;
+	file_size: number;
+	created_by: string | null;
+	created_at: string;
+}
+
+export interface FavoriteGIF {
+	id: string;
+	user_id: string;
+	file_path: string;
+	mime_type: string;
+	file_size: number;
+	width: number | null;
+	height: number | null;
+	created_at: string;
+}
+
+// MarkdownNode is one node of a message's parsed content; see the server's
+// markdown package for which fields each type carries.
+export interface MarkdownNode {
+	type: string;
+	children?: MarkdownNode[];
+	text?: string;
+	lang?: string;
+	level?: number;
+	ordered?: boolean;
+	start?: number;
+	url?: string;
+	no_embed?: boolean;
+	id?: string;
+	name?: string;
+	animated?: boolean;
+	timestamp?: number;
+	format?: string;
+}
+
+export interface MessageForward {
+	message_id: string;
+	channel_id: string;
+	server_id?: string;
+	channel_name?: string;
+	author_id: string;
+	created_at: string;
+}
+
+export interface PollOption {
+	id: number;
+	text: string;
+	votes: number;
+	voters?: string[];
+}
+
+export interface Poll {
+	question: string;
+	options: PollOption[];
+	multiple: boolean;
+	anonymous: boolean;
+	created_by: string;
+	expires_at: string | null;
+	closed_at: string | null;
+	voter_count: number;
+	my_votes?: number[];
+}
+
+export interface MessageRevision {
+	id: string;
+	message_id: string;
+	content: string;
+	embeds?: Embed[];
+	created_at: string;
+	replaced_at: string;
+}
+
+export interface ScheduledJob {
+	id: string;
+	kind: 'message' | 'reminder';
+	user_id: string;
+	channel_id: string | null;
+	message_id: string | null;
+	content: string;
+	run_at: string;
+	status: 'pending' | 'failed';
+	attempts: number;
+	last_error: string | null;
+	created_at: string;
+}
+
+export interface PurgeFilter {
+	limit: number;
+	author_id?: string;
+	pattern?: string;
+	has_attachments?: boolean;
+}
+
+export interface Embed {
+	type?: string;
+	title?: string;
+	description?: string;
+	url?: string;
+	color?: number;
+	timestamp?: string;
+	author?: { name: string; url?: string; icon_url?: string };
+	footer?: { text: string; icon_url?: string };
+	image?: EmbedMedia;
+	thumbnail?: EmbedMedia;
+	fields?: { name: string; value: string; inline?: boolean }[];
+	provider?: { name?: string; url?: string };
+}
+
+export interface EmbedMedia {
+	url: string;
+	width?: number;
+	height?: number;
+}
+
+export interface SlashCommand {
+	id: string;
+	server_id: string | null;
+	bot_id: string;
+	name: string;
+	description: string;
+	options: CommandOption[];
+	builtin?: boolean;
+	created_at: string;
+}
+
+export interface CommandOption {
+	name: string;
+	description: string;
+	type: 'string' | 'integer' | 'number' | 'boolean' | 'user' | 'channel' | 'role';
+	required?: boolean;
+	choices?: { name: string; value: string | number }[];
+	min_value?: number;
+	max_value?: number;
+}
+
+export interface UnreadCount {
+	count: number;
+	mentions: number;
 }
 
 export interface ServerMember {
@@ -21,7 +21,7 @@ export function isUserOnline(userId: string): boolean {
 }
 
 // Server event callbacks (update/delete).
-import type { Server } from './types';
+import type { Server, Channel } from './types';
 
 type ServerEventHandler = (event: { type: 'server_update'; server: Server } | { type: 'server_delete'; server_id: string }) => void;
 let serverListeners = new Set<ServerEventHandler>();
@@ -39,6 +39,15 @@ function handleServerMessage(data: { type: string; server?: Server; server_id?:
 	}
 }
 
+// Channel list callbacks — notified with a server's channels after a reorder.
+type ChannelsReorderedHandler = (serverId: string, channels: Channel[]) => void;
+let reorderListeners = new Set<ChannelsReorderedHandler>();
+
+export function subscribeChannelsReordered(fn: ChannelsReorderedHandler): () => void {
+	reorderListeners.add(fn);
+	return () => reorderListeners.delete(fn);
+}
+
 function handlePresenceMessage(data: { type: string; user_id?: string; status?: string; user_ids?: string[] }) {
 	if (data.type === 'presence_update' && data.user_id && data.status) {
 		if (data.status === 'online') {
@@ -77,6 +86,8 @@ export function createWSConnection(): WebSocket {
 				handlePresenceMessage(data);
 			} else if (data.type === 'server_update' || data.type === 'server_delete') {
 				handleServerMessage(data);
+			} else if (data.type === 'channels_reordered' && data.server_id && data.channels) {
+				for (const fn of reorderListeners) fn(data.server_id, data.channels);
 			} else if (data.type === 'message' && data.message?.channel_id) {
 				for (const fn of unreadListeners) fn(data.message.channel_id);
 			}